@@ -0,0 +1,180 @@
+package raft
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Admission control for client RPCs. Every client request, sync request,
+client ID request and witness record request that would start work on a
+server must first be admitted. A request is admitted only if a token is
+available in its client's bucket and in the global bucket, and fewer than
+the configured maximum number of admitted requests are still in progress.
+Requests that are not admitted are answered with ErrOverloaded, which
+Session retries with backoff.
+
+*/
+
+// maxIdleClientBuckets is the number of per-client buckets kept before
+// buckets that have refilled completely are discarded.
+const maxIdleClientBuckets = 1024
+
+// tokenBucket is a token bucket rate limiter. Not safe for concurrent use.
+type tokenBucket struct {
+	// Tokens added per second.
+	rate float64
+	// Maximum number of tokens.
+	burst float64
+	// Tokens currently available.
+	tokens float64
+	// Time tokens were last added.
+	last time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil if rate is zero, meaning
+// unlimited.
+// Params:
+//   - rate: Tokens added per second.
+//   - burst: Maximum number of tokens. Defaults to rate, at least 1.
+//   - now: Current time.
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(rate, 1)
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   now,
+	}
+}
+
+// refill adds the tokens accumulated since the bucket was last refilled.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// available reports whether a token can be taken. A nil bucket always has
+// tokens available.
+func (b *tokenBucket) available(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// take removes a token, which must be available.
+func (b *tokenBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// admissionControl limits the rate and concurrency of client RPCs handled
+// by a server.
+type admissionControl struct {
+	lock sync.Mutex
+
+	// Bucket shared by all clients, nil if unlimited.
+	global *tokenBucket
+
+	// Bucket of each client, created on first use. Not used if clientRate
+	// is zero.
+	clients     map[uint64]*tokenBucket
+	clientRate  float64
+	clientBurst int
+
+	// Number of admitted requests still in progress, and the maximum
+	// allowed, zero if unlimited.
+	inFlight    int
+	maxInFlight int
+}
+
+// newAdmissionControl returns admission control configured by conf.
+func newAdmissionControl(conf *Config) *admissionControl {
+	return &admissionControl{
+		global:      newTokenBucket(conf.GlobalRateLimit, conf.GlobalRateBurst, time.Now()),
+		clients:     make(map[uint64]*tokenBucket),
+		clientRate:  conf.ClientRateLimit,
+		clientBurst: conf.ClientRateBurst,
+		maxInFlight: conf.MaxInFlightClientRPCs,
+	}
+}
+
+// admit admits a request that does not belong to a known client, such as a
+// client ID request. Subject only to the global limits. If true is
+// returned, release must be called once the request completes.
+func (a *admissionControl) admit() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.admitLocked(nil, time.Now())
+}
+
+// admitClient admits a request from a client. If true is returned, release
+// must be called once the request completes.
+// Params:
+//   - clientID: ID of the client that sent the request.
+func (a *admissionControl) admitClient(clientID uint64) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	return a.admitLocked(a.clientBucket(clientID, now), now)
+}
+
+// admitLocked admits a request if tokens are available in bucket and the
+// global bucket and the in-flight limit is not reached. Must be called
+// with lock held.
+func (a *admissionControl) admitLocked(bucket *tokenBucket, now time.Time) bool {
+	if (a.maxInFlight > 0 && a.inFlight >= a.maxInFlight) ||
+		!bucket.available(now) || !a.global.available(now) {
+		metrics.IncrCounter([]string{"raft", "client", "overloaded"}, 1)
+		return false
+	}
+	bucket.take()
+	a.global.take()
+	a.inFlight++
+	return true
+}
+
+// release marks an admitted request as completed.
+func (a *admissionControl) release() {
+	a.lock.Lock()
+	a.inFlight--
+	a.lock.Unlock()
+}
+
+// clientBucket returns the bucket of a client, creating it if needed, or
+// nil if clients are not rate limited. Must be called with lock held.
+func (a *admissionControl) clientBucket(clientID uint64, now time.Time) *tokenBucket {
+	if a.clientRate <= 0 {
+		return nil
+	}
+	if bucket, ok := a.clients[clientID]; ok {
+		return bucket
+	}
+	// Full buckets behave like new ones, so they can be discarded.
+	if len(a.clients) >= maxIdleClientBuckets {
+		for id, bucket := range a.clients {
+			bucket.refill(now)
+			if bucket.tokens >= bucket.burst {
+				delete(a.clients, id)
+			}
+		}
+	}
+	bucket := newTokenBucket(a.clientRate, a.clientBurst, now)
+	a.clients[clientID] = bucket
+	return bucket
+}
@@ -0,0 +1,281 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	if b := newTokenBucket(0, 5, now); b != nil {
+		t.Fatalf("zero rate should be unlimited")
+	}
+
+	b := newTokenBucket(10, 2, now)
+	for i := 0; i < 2; i++ {
+		if !b.available(now) {
+			t.Fatalf("burst token %d should be available", i)
+		}
+		b.take()
+	}
+	if b.available(now) {
+		t.Fatalf("bucket should be empty")
+	}
+
+	// One token is added every 100ms, up to the burst
+	if !b.available(now.Add(100 * time.Millisecond)) {
+		t.Fatalf("token should be refilled")
+	}
+	b.take()
+	if b.available(now.Add(150 * time.Millisecond)) {
+		t.Fatalf("token should not be refilled yet")
+	}
+	b.refill(now.Add(time.Hour))
+	if b.tokens != 2 {
+		t.Fatalf("bucket should refill to burst, got %v", b.tokens)
+	}
+
+	// Burst defaults to the rate
+	if b := newTokenBucket(0.5, 0, now); b.burst != 1 {
+		t.Fatalf("bad burst: %v", b.burst)
+	}
+}
+
+func TestAdmissionControl_Disabled(t *testing.T) {
+	a := newAdmissionControl(DefaultConfig())
+	for i := 0; i < 1000; i++ {
+		if !a.admitClient(uint64(i % 3)) {
+			t.Fatalf("request %d should be admitted", i)
+		}
+	}
+}
+
+func TestAdmissionControl_ClientRate(t *testing.T) {
+	conf := DefaultConfig()
+	conf.ClientRateLimit = 0.001
+	conf.ClientRateBurst = 2
+	a := newAdmissionControl(conf)
+
+	for i := 0; i < 2; i++ {
+		if !a.admitClient(1) {
+			t.Fatalf("request %d should be admitted", i)
+		}
+		a.release()
+	}
+	if a.admitClient(1) {
+		t.Fatalf("client 1 should be rate limited")
+	}
+
+	// Other clients have their own buckets
+	if !a.admitClient(2) {
+		t.Fatalf("client 2 should be admitted")
+	}
+	a.release()
+
+	// Requests without a client are not limited per client
+	if !a.admit() {
+		t.Fatalf("request should be admitted")
+	}
+}
+
+func TestAdmissionControl_GlobalRate(t *testing.T) {
+	conf := DefaultConfig()
+	conf.ClientRateLimit = 0.001
+	conf.ClientRateBurst = 2
+	conf.GlobalRateLimit = 0.001
+	conf.GlobalRateBurst = 3
+	a := newAdmissionControl(conf)
+
+	for i := 0; i < 3; i++ {
+		if !a.admitClient(uint64(i)) {
+			t.Fatalf("request %d should be admitted", i)
+		}
+		a.release()
+	}
+	if a.admitClient(4) || a.admit() {
+		t.Fatalf("should be rate limited globally")
+	}
+
+	// A request rejected globally does not use a client token
+	a.global.tokens = 2
+	for i := 0; i < 2; i++ {
+		if !a.admitClient(4) {
+			t.Fatalf("request %d should be admitted", i)
+		}
+		a.release()
+	}
+}
+
+func TestAdmissionControl_MaxInFlight(t *testing.T) {
+	conf := DefaultConfig()
+	conf.MaxInFlightClientRPCs = 2
+	a := newAdmissionControl(conf)
+
+	if !a.admitClient(1) || !a.admit() {
+		t.Fatalf("requests should be admitted")
+	}
+	if a.admitClient(2) {
+		t.Fatalf("should be limited by requests in flight")
+	}
+	a.release()
+	if !a.admitClient(2) {
+		t.Fatalf("request should be admitted after release")
+	}
+}
+
+func TestAdmissionControl_PruneClients(t *testing.T) {
+	conf := DefaultConfig()
+	conf.ClientRateLimit = 1000
+	a := newAdmissionControl(conf)
+
+	for i := 0; i < maxIdleClientBuckets; i++ {
+		a.clientBucket(uint64(i), time.Now())
+	}
+	a.clients[0].tokens = 0
+
+	// Full buckets are discarded when the limit is reached
+	a.clientBucket(maxIdleClientBuckets, time.Now())
+	if len(a.clients) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(a.clients))
+	}
+	if _, ok := a.clients[0]; !ok {
+		t.Fatalf("bucket in use should be kept")
+	}
+}
+
+func TestConfig_AdmissionLimits(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.ClientRateLimit = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("negative client rate should be rejected")
+	}
+	conf = inmemConfig(t)
+	conf.GlobalRateBurst = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("negative global burst should be rejected")
+	}
+	conf = inmemConfig(t)
+	conf.MaxInFlightClientRPCs = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("negative in-flight limit should be rejected")
+	}
+}
+
+func TestSession_OverloadBackoff(t *testing.T) {
+	addr1, trans1 := NewInmemTransport("")
+	_, trans2 := NewInmemTransport("")
+	trans2.Connect(addr1, trans1)
+
+	// Reject the first requests as overloaded
+	const rejections = 3
+	var requests int
+	go func() {
+		for rpc := range trans1.Consumer() {
+			switch rpc.Command.(type) {
+			case *ClientIdRequest:
+				rpc.Respond(&ClientIdResponse{ClientID: 1, LeaderAddress: addr1}, nil)
+			case *ClientRequest:
+				requests++
+				if requests <= rejections {
+					rpc.Respond(&ClientResponse{LeaderAddress: addr1}, ErrOverloaded)
+				} else {
+					rpc.Respond(&ClientResponse{Success: true, LeaderAddress: addr1}, nil)
+				}
+			}
+		}
+	}()
+
+	session, err := CreateClientSession(trans2, []ServerAddress{addr1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	start := time.Now()
+	var resp ClientResponse
+	if err := session.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success || requests != rejections+1 {
+		t.Fatalf("bad: %#v after %d requests", resp, requests)
+	}
+	// Backs off for 10ms, 10ms, then 20ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("did not back off: %v", elapsed)
+	}
+}
+
+func TestSession_OverloadBackoffUnlocked(t *testing.T) {
+	addr1, trans1 := NewInmemTransport("")
+	_, trans2 := NewInmemTransport("")
+	trans2.Connect(addr1, trans1)
+
+	// Reject the first requests as overloaded, for 160ms of backoff
+	const rejections = 5
+	var requests int
+	rejectedCh := make(chan struct{}, rejections)
+	go func() {
+		for rpc := range trans1.Consumer() {
+			switch rpc.Command.(type) {
+			case *ClientIdRequest:
+				rpc.Respond(&ClientIdResponse{ClientID: 1, LeaderAddress: addr1}, nil)
+			case *ClientRequest:
+				requests++
+				if requests <= rejections {
+					rpc.Respond(&ClientResponse{LeaderAddress: addr1}, ErrOverloaded)
+					rejectedCh <- struct{}{}
+				} else {
+					rpc.Respond(&ClientResponse{Success: true, LeaderAddress: addr1}, nil)
+				}
+			}
+		}
+	}()
+
+	session, err := CreateClientSession(trans2, []ServerAddress{addr1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		var resp ClientResponse
+		errCh <- session.SendRequest([]byte("a"), []Key{Key("a")}, &resp)
+	}()
+
+	// The leader lock is free while the session backs off
+	<-rejectedCh
+	start := time.Now()
+	session.leaderLock.Lock()
+	session.leaderLock.Unlock()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("leader lock held while backing off: %v", elapsed)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRaft_ClientRateLimit(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.ClientRateLimit = 0.001
+	conf.ClientRateBurst = 2
+	c := MakeCluster(1, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+
+	_, trans := NewInmemTransport("")
+	trans.Connect(leader.localAddr, c.trans[c.IndexOf(leader)])
+	session, err := CreateClientSession(trans, []ServerAddress{leader.localAddr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The burst is admitted, then the leader stays overloaded
+	for i := 0; i < 2; i++ {
+		var resp ClientResponse
+		if err := session.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+			t.Fatalf("request %d err: %v", i, err)
+		}
+	}
+	var resp ClientResponse
+	if err := session.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != ErrOverloaded {
+		t.Fatalf("expected overloaded, got: %v", err)
+	}
+}
@@ -79,6 +79,46 @@ var (
     // a witness using a stale term number, meaning that it is sending the
     // command to a potentially stale set of witnesses.
     ErrStaleTerm = errors.New("witness cannot accept record request with stale term")
+
+    // ErrWitnessUninitialized is returned when a client tries to record a
+    // command in a witness that the leader has not yet initialized for the
+    // current term, such as a newly added or restored server.
+    ErrWitnessUninitialized = errors.New("witness cannot accept record request until initialized for current term")
+
+    // ErrStaleLeader is returned when a client tries to record a command in
+    // a witness that follows a different leader than the client, meaning
+    // that the client's view of the cluster is stale.
+    ErrStaleLeader = errors.New("witness cannot accept record request for stale leader")
+
+    // ErrOverloaded is returned when a server rejects a client RPC because
+    // of its rate or concurrency limits, or because its log is full. The
+    // client should retry later.
+    ErrOverloaded = errors.New("server overloaded, retry later")
+
+    // ErrUnauthenticated is returned when a client presents credentials
+    // that the server's Authenticator does not accept.
+    ErrUnauthenticated = errors.New("client credentials not valid")
+
+    // ErrUnauthorized is returned when a client sends a request using a
+    // client ID that is bound to another identity.
+    ErrUnauthorized = errors.New("client not authorized to use client ID")
+
+    // ErrBadEntryType is returned when a client sends a log entry that is
+    // not a command or transaction, such as a client identity binding.
+    ErrBadEntryType = errors.New("client entry must be a command or transaction")
+
+    // ErrBadTransaction is the FSM response to a transaction entry whose
+    // data cannot be decoded. The transaction is not applied.
+    ErrBadTransaction = errors.New("transaction entry cannot be decoded")
+
+    // ErrLogChecksumMismatch is returned when a log entry is not applied
+    // to the FSM because it, or an earlier entry, failed its checksum.
+    ErrLogChecksumMismatch = errors.New("log entry checksum mismatch")
+
+    // ErrFullSnapshotRequired is returned by IncrementalFSM.SnapshotDelta
+    // when it cannot take a delta of the given base, to take a full
+    // snapshot instead.
+    ErrFullSnapshotRequired = errors.New("full snapshot required")
 )
 
 // Raft implements a Raft node.
@@ -112,10 +152,53 @@ type Raft struct {
 	// fsmSnapshotCh is used to trigger a new snapshot being taken
 	fsmSnapshotCh chan *reqSnapshotFuture
 
+	// fsmCorruptCh is signaled by the FSM when it finds a corrupted entry,
+	// so that the main thread halts the server. halted is only used by the
+	// main thread.
+	fsmCorruptCh chan struct{}
+	halted       bool
+
     // True if witness can't accept client record requests, false otherwise.
     frozen bool
+    // Protects witness state in stable storage, which is garbage collected
+    // from the heartbeat fast path concurrently with the main thread.
+    witnessLock sync.Mutex
+
+    // Term the leader last initialized this witness for. Witness only
+    // accepts records in this term. Protected by frozenLock.
+    witnessTerm uint64
     frozenLock sync.RWMutex
 
+    // Operations executed on the fast path but not yet committed. Only
+    // populated while leader.
+    unsyncedOps *unsyncedOps
+
+    // Limits the rate and concurrency of client RPCs.
+    admission *admissionControl
+
+    // RecordRequests received on the witness fast-path, handled by
+    // WitnessWorkers workers.
+    witnessCh chan RPC
+
+    // Entries the leader is writing to its log store, which replication
+    // may send before the write completes.
+    pendingLogs pendingLogs
+
+    // Snapshot being received in chunks from the leader. Only used by the
+    // main thread.
+    snapshotInstall *snapshotInstall
+
+    // Bytes appended to the log since the last snapshot.
+    logSizes logSizes
+
+    // Notified by Apply while the log is over MaxLogBytes, to trigger a
+    // snapshot.
+    logFullCh chan struct{}
+
+    // Identity each client ID is bound to, if clients are authenticated.
+    clientIdentities     map[uint64]string
+    clientIdentitiesLock sync.RWMutex
+
 	// lastContact is the last time we had contact from the
 	// leader node. This can be used to gauge staleness.
 	lastContact     time.Time
@@ -322,16 +405,9 @@ func RecoverCluster(conf *Config, fsm FSM, logs LogStore, stable StableStore,
 		return fmt.Errorf("failed to list snapshots: %v", err)
 	}
 	for _, snapshot := range snapshots {
-		_, source, err := snaps.Open(snapshot.ID)
-		if err != nil {
+		if _, err := restoreSnapshotChain(fsm, snaps, snapshot.ID, 0, 0); err != nil {
 			// Skip this one and try the next. We will detect if we
-			// couldn't open any snapshots.
-			continue
-		}
-		defer source.Close()
-
-		if err := fsm.Restore(source); err != nil {
-			// Same here, skip and try the next one.
+			// couldn't restore any snapshots.
 			continue
 		}
 
@@ -351,6 +427,9 @@ func RecoverCluster(conf *Config, fsm FSM, logs LogStore, stable StableStore,
 	lastTerm := snapshotTerm
 	lastClientId := snapshotClientId
 	lastClientResponseCache := snapshotClientResponseCache
+	if lastClientResponseCache == nil {
+		lastClientResponseCache = make(map[uint64]map[uint64]clientResponseEntry)
+	}
 
 	// Apply any Raft log entries past the snapshot.
 	lastLogIndex, err := logs.LastIndex()
@@ -362,8 +441,8 @@ func RecoverCluster(conf *Config, fsm FSM, logs LogStore, stable StableStore,
 		if err := logs.GetLog(index, &entry); err != nil {
 			return fmt.Errorf("failed to get log at index %d: %v", index, err)
 		}
-		if entry.Type == LogCommand {
-			resp := fsm.Apply(&entry)
+		if entry.Type == LogCommand || entry.Type == LogTransaction {
+			resp := applyToFSM(fsm, &entry)
 			data, err := json.Marshal(resp)
 			if err != nil {
 				return fmt.Errorf("failed to marshal response to command at index %d: %v", index, err)
@@ -394,7 +473,7 @@ func RecoverCluster(conf *Config, fsm FSM, logs LogStore, stable StableStore,
 		return fmt.Errorf("failed to snapshot FSM: %v", err)
 	}
 	version := getSnapshotVersion(conf.ProtocolVersion)
-	sink, err := snaps.Create(version, lastIndex, lastTerm, configuration, 1, lastClientId, lastClientResponseCache, trans)
+	sink, err := snaps.Create(version, lastIndex, lastTerm, configuration, 1, lastClientId, lastClientResponseCache, stableGetWitnessRecords(stable), trans)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot: %v", err)
 	}
@@ -463,6 +542,9 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 	if err := ValidateConfig(conf); err != nil {
 		return nil, err
 	}
+	if _, ok := logs.(LogDiskUsage); conf.SnapshotDiskUsage > 0 && !ok {
+		return nil, fmt.Errorf("SnapshotDiskUsage requires a LogStore implementing LogDiskUsage")
+	}
 
 	// Ensure we have a LogOutput.
 	var logger *log.Logger
@@ -482,6 +564,18 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 		return nil, fmt.Errorf("failed to load current term: %v", err)
 	}
 
+	// Try to restore the term this server was initialized as a witness for.
+	witnessTerm, err := stable.GetUint64(keyWitnessTerm)
+	if err != nil && err.Error() != "not found" {
+		return nil, fmt.Errorf("failed to load witness term: %v", err)
+	}
+
+	// Try to restore the identities client IDs are bound to.
+	clientIdentities, err := stableGetClientIdentities(stable)
+	if err != nil {
+		return nil, err
+	}
+
 	// Read the index of the last log entry.
 	lastIndex, err := logs.LastIndex()
 	if err != nil {
@@ -514,9 +608,14 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 		conf:                *conf,
 		clientResponseCache: make(map[uint64]map[uint64]clientResponseEntry),
         frozen:              false,
+        unsyncedOps:         newUnsyncedOps(),
+        admission:           newAdmissionControl(conf),
+        clientIdentities:    clientIdentities,
+        witnessTerm:         witnessTerm,
         fsm:                 fsm,
 		fsmMutateCh:         make(chan interface{}, 128),
 		fsmSnapshotCh:       make(chan *reqSnapshotFuture),
+		fsmCorruptCh:        make(chan struct{}, 1),
 		leaderCh:            make(chan bool),
 		localID:             localID,
 		localAddr:           localAddr,
@@ -536,6 +635,7 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 		configurationsCh:      make(chan *configurationsFuture, 8),
 		bootstrapCh:           make(chan *bootstrapFuture),
 		observers:             make(map[uint64]*Observer),
+		logFullCh:             make(chan struct{}, 1),
 	}
 
 	// Initialize as a follower.
@@ -557,8 +657,10 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 		return nil, err
 	}
 
-	// Scan through the log for any configuration change entries.
+	// Scan through the log for any configuration change entries, and
+	// count the bytes appended since the snapshot.
 	snapshotIndex, _ := r.getLastSnapshot()
+	var logBytes uint64
 	for index := snapshotIndex + 1; index <= lastLog.Index; index++ {
 		var entry Log
 		if err := r.logs.GetLog(index, &entry); err != nil {
@@ -566,7 +668,9 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 			panic(err)
 		}
 		r.processConfigurationLogEntry(&entry)
+		logBytes += entry.size()
 	}
+	r.logSizes.add(lastLog.Index, logBytes)
 
 	r.logger.Printf("[INFO] raft: Initial configuration (index=%d): %+v",
 		r.configurations.latestIndex, r.configurations.latest.Servers)
@@ -576,6 +680,17 @@ func NewRaft(conf *Config, fsm FSM, logs LogStore, stable StableStore, snaps Sna
 	// to be called concurrently with a blocking RPC.
 	trans.SetHeartbeatHandler(r.processHeartbeat)
 
+	// Setup a witness fast-path so that records are not delayed
+	// by the main thread. Records MUST be safe to handle
+	// concurrently with any RPC.
+	if witness, ok := trans.(WithWitnessHandler); ok && conf.WitnessWorkers > 0 {
+		r.witnessCh = make(chan RPC, conf.WitnessWorkers)
+		for i := 0; i < conf.WitnessWorkers; i++ {
+			r.goFunc(r.runWitnessWorker)
+		}
+		witness.SetWitnessHandler(r.processRecord)
+	}
+
 	// Start the background work.
 	r.goFunc(r.run)
 	r.goFunc(r.runFSM)
@@ -596,14 +711,7 @@ func (r *Raft) restoreSnapshot() error {
 
 	// Try to load in order of newest to oldest
 	for _, snapshot := range snapshots {
-		_, source, err := r.snapshots.Open(snapshot.ID)
-		if err != nil {
-			r.logger.Printf("[ERR] raft: Failed to open snapshot %v: %v", snapshot.ID, err)
-			continue
-		}
-		defer source.Close()
-
-		if err := r.fsm.Restore(source); err != nil {
+		if _, err := restoreSnapshotChain(r.fsm, r.snapshots, snapshot.ID, 0, 0); err != nil {
 			r.logger.Printf("[ERR] raft: Failed to restore snapshot %v: %v", snapshot.ID, err)
 			continue
 		}
@@ -611,6 +719,17 @@ func (r *Raft) restoreSnapshot() error {
 		// Log success
 		r.logger.Printf("[INFO] raft: Restored from snapshot %v", snapshot.ID)
 
+		// Restore witness state from the snapshot if it was lost from
+		// stable storage. The witness refuses records until the leader
+		// initializes it for the current term.
+		if !stableHasWitnessState(r.stable) && len(snapshot.WitnessRecords) > 0 {
+			r.witnessLock.Lock()
+			r.restoreWitnessRecords(snapshot.WitnessRecords)
+			r.witnessLock.Unlock()
+			r.logger.Printf("[INFO] raft: Restored %d witness records from snapshot %v",
+				len(snapshot.WitnessRecords), snapshot.ID)
+		}
+
 		// Update the lastApplied so we don't replay old logs
 		r.setLastApplied(snapshot.Index)
 
@@ -688,6 +807,11 @@ func (r *Raft) Apply(log *Log, timeout time.Duration) ApplyFuture {
 	}
 	logFuture.init()
 
+	// Wait for the log to be compacted if it is too large
+	if err := r.waitForLogSpace(timer); err != nil {
+		return errorFuture{err}
+	}
+
 	select {
 	case <-timer:
 		return errorFuture{ErrEnqueueTimeout}
@@ -1048,6 +1172,7 @@ func (r *Raft) Stats() map[string]string {
 		"commit_index":         toString(r.getCommitIndex()),
 		"applied_index":        toString(r.getLastApplied()),
 		"fsm_pending":          toString(uint64(len(r.fsmMutateCh))),
+		"unsynced_ops":         toString(uint64(r.unsyncedOps.len())),
 		"last_snapshot_index":  toString(lastSnapIndex),
 		"last_snapshot_term":   toString(lastSnapTerm),
 		"protocol_version":     toString(uint64(r.protocolVersion)),
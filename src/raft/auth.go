@@ -0,0 +1,239 @@
+package raft
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Client authentication. When Config.Authenticator is set, a client must
+present credentials with its ClientIdRequest. The leader authenticates
+them, and binds the client ID it assigns to the resulting identity with a
+LogClientIdentity entry, so that every server learns the binding. Every
+later client, sync and record request must carry credentials for the same
+identity, or it is rejected. The leader and witnesses set Log.Identity of
+each command to the identity bound to its client ID, so the FSM can use it
+for authorization. Bindings are persisted in stable storage and sent with
+InstallSnapshot, since the entries creating them may be compacted.
+
+*/
+
+var (
+	keyClientIdentities = []byte("ClientIdentities")
+)
+
+// Authenticator validates credentials presented by clients.
+type Authenticator interface {
+	// Authenticate validates credentials.
+	// Params:
+	//   - credentials: credentials sent by a client, possibly empty.
+	// Returns: identity of the client, and an error if the credentials are
+	// not valid.
+	Authenticate(credentials []byte) (string, error)
+}
+
+// clientIdentity binds a client ID to the identity of the client. Data of a
+// LogClientIdentity entry.
+type clientIdentity struct {
+	ClientID uint64
+	Identity string
+}
+
+// HMACAuthenticator authenticates tokens created by NewHMACToken with a
+// secret shared by clients and servers.
+type HMACAuthenticator struct {
+	secret []byte
+}
+
+// NewHMACAuthenticator returns an authenticator of tokens signed with
+// secret.
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secret: secret}
+}
+
+// NewHMACToken returns a token asserting identity, signed with
+// HMAC-SHA256 using secret, to use as client credentials.
+// Params:
+//   - secret: secret shared with HMACAuthenticator.
+//   - identity: identity of the client.
+//   - expires: time after which the token is not valid, zero for never.
+func NewHMACToken(secret []byte, identity string, expires time.Time) []byte {
+	var expiry int64
+	if !expires.IsZero() {
+		expiry = expires.Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(identity)) + "." + strconv.FormatInt(expiry, 10)
+	return []byte(payload + "." + base64.RawURLEncoding.EncodeToString(hmacSign(secret, payload)))
+}
+
+// Authenticate implements the Authenticator interface.
+func (a *HMACAuthenticator) Authenticate(credentials []byte) (string, error) {
+	parts := strings.Split(string(credentials), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed token signature: %v", err)
+	}
+	if !hmac.Equal(mac, hmacSign(a.secret, parts[0]+"."+parts[1])) {
+		return "", fmt.Errorf("bad token signature")
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed token expiry: %v", err)
+	}
+	if expiry != 0 && time.Now().Unix() >= expiry {
+		return "", fmt.Errorf("token expired")
+	}
+	identity, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed token identity: %v", err)
+	}
+	return string(identity), nil
+}
+
+// hmacSign returns the HMAC-SHA256 of payload.
+func hmacSign(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// authenticate validates credentials presented with a ClientIdRequest.
+// Params:
+//   - credentials: credentials sent by the client.
+// Returns: identity of the client, empty if no Authenticator is configured,
+// and ErrUnauthenticated if the credentials are not valid.
+func (r *Raft) authenticate(credentials []byte) (string, error) {
+	if r.conf.Authenticator == nil {
+		return "", nil
+	}
+	identity, err := r.conf.Authenticator.Authenticate(credentials)
+	if err != nil {
+		r.logger.Printf("[WARN] raft: Rejected client credentials: %v", err)
+		return "", ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+// authorize checks that a request from a client carries a command, and
+// that the credentials sent with it belong to the identity its client ID is
+// bound to.
+// Params:
+//   - entry: log entry sent by the client.
+//   - credentials: credentials sent with the request.
+// Returns: a copy of entry with Identity set to the identity bound to its
+// client ID, ErrBadEntryType if it is not a command or transaction,
+// ErrUnauthenticated if the credentials are not valid, and ErrUnauthorized
+// if they are for another identity.
+func (r *Raft) authorize(entry *Log, credentials []byte) (*Log, error) {
+	// Other entry types change cluster state, such as identity bindings
+	if entry.Type != LogCommand && entry.Type != LogTransaction {
+		r.logger.Printf("[WARN] raft: Rejected client %v entry of type %v", entry.ClientID, entry.Type)
+		return nil, ErrBadEntryType
+	}
+	bound, ok := r.getClientIdentity(entry.ClientID)
+	if r.conf.Authenticator != nil {
+		identity, err := r.authenticate(credentials)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || identity != bound {
+			r.logger.Printf("[WARN] raft: Client %q not authorized for client ID %v", identity, entry.ClientID)
+			return nil, ErrUnauthorized
+		}
+	}
+	// The request may be shared with other servers by in-memory transports
+	stamped := *entry
+	stamped.Identity = bound
+	return &stamped, nil
+}
+
+// getClientIdentity returns the identity a client ID is bound to, and
+// whether it is bound.
+func (r *Raft) getClientIdentity(clientID uint64) (string, bool) {
+	r.clientIdentitiesLock.RLock()
+	defer r.clientIdentitiesLock.RUnlock()
+	identity, ok := r.clientIdentities[clientID]
+	return identity, ok
+}
+
+// bindClientIdentity replicates the binding of a client ID to an identity
+// to all Raft nodes. This must be run at the leader.
+func (r *Raft) bindClientIdentity(clientID uint64, identity string) Future {
+	buf, err := encodeMsgPack(clientIdentity{ClientID: clientID, Identity: identity})
+	if err != nil {
+		panic(fmt.Errorf("failed to encode client identity: %v", err))
+	}
+
+	logFuture := &logFuture{
+		log: Log{
+			Type: LogClientIdentity,
+			Data: buf.Bytes(),
+		},
+	}
+	logFuture.init()
+
+	select {
+	case <-r.shutdownCh:
+		return errorFuture{ErrRaftShutdown}
+	case r.applyCh <- logFuture:
+		return logFuture
+	}
+}
+
+// setClientIdentities adds bindings of client IDs to identities, and
+// persists all bindings. Panics if failure.
+func (r *Raft) setClientIdentities(identities map[uint64]string) {
+	r.clientIdentitiesLock.Lock()
+	defer r.clientIdentitiesLock.Unlock()
+	for clientID, identity := range identities {
+		r.clientIdentities[clientID] = identity
+	}
+	buf, err := encodeMsgPack(r.clientIdentities)
+	if err != nil {
+		panic(fmt.Errorf("failed to encode client identities: %v", err))
+	}
+	if err := r.stable.Set(keyClientIdentities, buf.Bytes()); err != nil {
+		panic(fmt.Errorf("failed to write client identities to stable storage: %v", err))
+	}
+}
+
+// encodeClientIdentities encodes all bindings, to send with
+// InstallSnapshot.
+func (r *Raft) encodeClientIdentities() []byte {
+	r.clientIdentitiesLock.RLock()
+	defer r.clientIdentitiesLock.RUnlock()
+	if len(r.clientIdentities) == 0 {
+		return nil
+	}
+	buf, err := encodeMsgPack(r.clientIdentities)
+	if err != nil {
+		panic(fmt.Errorf("failed to encode client identities: %v", err))
+	}
+	return buf.Bytes()
+}
+
+// stableGetClientIdentities reads bindings of client IDs to identities from
+// stable storage. Returns no bindings if none have been written.
+func stableGetClientIdentities(stable StableStore) (map[uint64]string, error) {
+	identities := make(map[uint64]string)
+	buf, err := stable.Get(keyClientIdentities)
+	if err != nil && err.Error() != "not found" {
+		return nil, fmt.Errorf("failed to read client identities: %v", err)
+	}
+	if len(buf) == 0 {
+		return identities, nil
+	}
+	if err := decodeMsgPack(buf, &identities); err != nil {
+		return nil, fmt.Errorf("failed to decode client identities: %v", err)
+	}
+	return identities, nil
+}
@@ -0,0 +1,260 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	auth := NewHMACAuthenticator(secret)
+
+	// Identities may contain the token separator
+	token := NewHMACToken(secret, "tenant.alice", time.Time{})
+	identity, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if identity != "tenant.alice" {
+		t.Fatalf("bad identity: %q", identity)
+	}
+
+	token = NewHMACToken(secret, "bob", time.Now().Add(time.Hour))
+	if identity, err := auth.Authenticate(token); err != nil || identity != "bob" {
+		t.Fatalf("bad: %q %v", identity, err)
+	}
+
+	// Tampered identity
+	parts := bytes.Split(NewHMACToken(secret, "alice", time.Time{}), []byte("."))
+	parts[0] = []byte(base64.RawURLEncoding.EncodeToString([]byte("mallory")))
+	forged := bytes.Join(parts, []byte("."))
+	if _, err := auth.Authenticate(forged); err == nil {
+		t.Fatalf("forged token should be rejected")
+	}
+
+	bad := [][]byte{
+		nil,
+		[]byte("not a token"),
+		NewHMACToken([]byte("other"), "alice", time.Time{}),
+		NewHMACToken(secret, "alice", time.Now().Add(-time.Second)),
+	}
+	for _, token := range bad {
+		if _, err := auth.Authenticate(token); err == nil {
+			t.Fatalf("token %q should be rejected", token)
+		}
+	}
+}
+
+func TestRaft_ClientAuthentication(t *testing.T) {
+	secret := []byte("secret")
+	conf := inmemConfig(t)
+	conf.Authenticator = NewHMACAuthenticator(secret)
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+
+	newSession := func(credentials []byte) (*Session, error) {
+		_, trans := NewInmemTransport("")
+		addrs := make([]ServerAddress, 0, len(c.rafts))
+		for i, r := range c.rafts {
+			trans.Connect(r.localAddr, c.trans[i])
+			addrs = append(addrs, r.localAddr)
+		}
+		return CreateClientSessionWithCredentials(trans, addrs, credentials)
+	}
+
+	// Clients must authenticate
+	if _, err := newSession(nil); err != ErrUnauthenticated {
+		t.Fatalf("expected unauthenticated, got: %v", err)
+	}
+	if _, err := newSession(NewHMACToken([]byte("other"), "alice", time.Time{})); err != ErrUnauthenticated {
+		t.Fatalf("expected unauthenticated, got: %v", err)
+	}
+
+	alice, err := newSession(NewHMACToken(secret, "alice", time.Time{}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var resp ClientResponse
+	if err := alice.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := alice.SendFastRequest([]byte("b"), []Key{Key("b")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c.WaitForReplication(2)
+
+	// Every server learns the binding, and commands carry the identity
+	for i, r := range c.rafts {
+		if identity, ok := r.getClientIdentity(alice.clientID); !ok || identity != "alice" {
+			t.Fatalf("server %d: bad identity %q", i, identity)
+		}
+		identities, err := stableGetClientIdentities(c.stores[i])
+		if err != nil || identities[alice.clientID] != "alice" {
+			t.Fatalf("server %d: binding not persisted: %v %v", i, identities, err)
+		}
+		last, _ := c.stores[i].LastIndex()
+		commands := 0
+		for idx := uint64(1); idx <= last; idx++ {
+			var l Log
+			if err := c.stores[i].GetLog(idx, &l); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if l.Type != LogCommand {
+				continue
+			}
+			commands++
+			if l.Identity != "alice" {
+				t.Fatalf("server %d: bad identity %q in %#v", i, l.Identity, l)
+			}
+		}
+		// The fast request may also have been synced
+		if commands < 2 {
+			t.Fatalf("server %d: expected 2 commands, got %d", i, commands)
+		}
+	}
+
+	// Another client cannot use alice's client ID
+	bob, err := newSession(NewHMACToken(secret, "bob", time.Time{}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	bob.clientID = alice.clientID
+	if err := bob.SendRequest([]byte("c"), []Key{Key("c")}, &resp); err != ErrUnauthorized {
+		t.Fatalf("expected unauthorized, got: %v", err)
+	}
+	resp = ClientResponse{}
+	if err := bob.SendFastRequest([]byte("d"), []Key{Key("d")}, &resp); err != ErrUnauthorized {
+		t.Fatalf("expected unauthorized, got: %v", err)
+	}
+	if resp.Success || resp.ResponseData != nil {
+		t.Fatalf("fast request should be rejected: %#v", resp)
+	}
+
+	// Witnesses reject records for alice's client ID from bob
+	var recordResp RecordResponse
+	record := &RecordRequest{
+		RPCHeader:     RPCHeader{ProtocolVersion: ProtocolVersionMax},
+		Entry:         &Log{Type: LogCommand, ClientID: alice.clientID, SeqNo: 9, Keys: []Key{Key("e")}},
+		Credentials:   bob.credentials,
+		Term:          leader.getCurrentTerm(),
+		LeaderAddress: leader.localAddr,
+	}
+	follower := c.Followers()[0]
+	_, err = bob.trans.SendRecordRequest(follower.localAddr, record, &recordResp)
+	if err == nil || err.Error() != ErrUnauthorized.Error() {
+		t.Fatalf("expected unauthorized, got: %v", err)
+	}
+}
+
+func TestRaft_ClientIdentitiesInstallSnapshot(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.TrailingLogs = 10
+	conf.Authenticator = NewHMACAuthenticator([]byte("secret"))
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+
+	// Disconnect one follower
+	leader := c.Leader()
+	behind := c.Followers()[0]
+	c.Disconnect(behind.localAddr)
+
+	// Bind a client ID, then compact the entry away
+	if err := leader.bindClientIdentity(5, "alice").Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var future Future
+	for i := 0; i < 100; i++ {
+		future = leader.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i))}, 0)
+	}
+	if err := future.Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := leader.Snapshot().Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The follower learns the binding from InstallSnapshot
+	c.FullyConnect()
+	c.EnsureSame(t)
+	if identity, ok := behind.getClientIdentity(5); !ok || identity != "alice" {
+		t.Fatalf("bad identity: %q", identity)
+	}
+}
+
+func TestRaft_ClientCannotSendInternalEntries(t *testing.T) {
+	secret := []byte("secret")
+	conf := inmemConfig(t)
+	conf.Authenticator = NewHMACAuthenticator(secret)
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+
+	newSession := func(identity string) *Session {
+		_, trans := NewInmemTransport("")
+		addrs := make([]ServerAddress, 0, len(c.rafts))
+		for i, r := range c.rafts {
+			trans.Connect(r.localAddr, c.trans[i])
+			addrs = append(addrs, r.localAddr)
+		}
+		session, err := CreateClientSessionWithCredentials(trans, addrs, NewHMACToken(secret, identity, time.Time{}))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return session
+	}
+	alice := newSession("alice")
+	bob := newSession("bob")
+
+	// Bob tries to rebind alice's client ID to himself
+	buf, err := encodeMsgPack(clientIdentity{ClientID: alice.clientID, Identity: "bob"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rebind := &Log{Type: LogClientIdentity, Data: buf.Bytes(), ClientID: bob.clientID, SeqNo: 1}
+	var clientResp ClientResponse
+	_, err = bob.trans.SendClientRequest(leader.localAddr, &ClientRequest{
+		RPCHeader:   RPCHeader{ProtocolVersion: ProtocolVersionMax},
+		Entry:       rebind,
+		Credentials: bob.credentials,
+	}, &clientResp)
+	if err == nil || err.Error() != ErrBadEntryType.Error() {
+		t.Fatalf("expected bad entry type, got: %v", err)
+	}
+	var syncResp SyncResponse
+	_, err = bob.trans.SendSyncRequest(leader.localAddr, &SyncRequest{
+		RPCHeader:   RPCHeader{ProtocolVersion: ProtocolVersionMax},
+		Entry:       rebind,
+		Credentials: bob.credentials,
+	}, &syncResp)
+	if err == nil || err.Error() != ErrBadEntryType.Error() {
+		t.Fatalf("expected bad entry type, got: %v", err)
+	}
+
+	// nor can he send other internal entries to witnesses
+	var recordResp RecordResponse
+	_, err = bob.trans.SendRecordRequest(c.Followers()[0].localAddr, &RecordRequest{
+		RPCHeader:     RPCHeader{ProtocolVersion: ProtocolVersionMax},
+		Entry:         &Log{Type: LogNextClientId, ClientID: bob.clientID, SeqNo: 2, Keys: []Key{Key("a")}},
+		Credentials:   bob.credentials,
+		Term:          leader.getCurrentTerm(),
+		LeaderAddress: leader.localAddr,
+	}, &recordResp)
+	if err == nil || err.Error() != ErrBadEntryType.Error() {
+		t.Fatalf("expected bad entry type, got: %v", err)
+	}
+
+	// Alice keeps her client ID
+	var resp ClientResponse
+	if err := alice.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i, r := range c.rafts {
+		if identity, ok := r.getClientIdentity(alice.clientID); !ok || identity != "alice" {
+			t.Fatalf("server %d: bad identity %q", i, identity)
+		}
+	}
+}
@@ -14,6 +14,23 @@ type clientResponseEntry struct {
 	timestamp time.Time
 }
 
+// Copy the cache, so that a snapshot can persist it while commands are
+// applied.
+// Returns: a copy of the client response cache.
+func (r *Raft) copyClientResponseCache() map[uint64]map[uint64]clientResponseEntry {
+	r.clientResponseLock.RLock()
+	defer r.clientResponseLock.RUnlock()
+	cache := make(map[uint64]map[uint64]clientResponseEntry, len(r.clientResponseCache))
+	for clientID, clientCache := range r.clientResponseCache {
+		entries := make(map[uint64]clientResponseEntry, len(clientCache))
+		for seqNo, entry := range clientCache {
+			entries[seqNo] = entry
+		}
+		cache[clientID] = entries
+	}
+	return cache
+}
+
 // Continuously check to garbage collect the cache.
 func (r *Raft) runGcClientResponseCache() {
 	for {
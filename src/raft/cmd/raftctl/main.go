@@ -0,0 +1,391 @@
+// raftctl inspects and moves the snapshots of a FileSnapshotStore, for
+// example to restore a cluster from a backup.
+//
+// Usage:
+//
+//	raftctl snapshot list -dir <dir>
+//	raftctl snapshot verify -dir <dir> [-id <id>] [-key-file <file>]
+//	raftctl snapshot inspect -dir <dir> [-id <id>]
+//	raftctl snapshot export -dir <dir> [-id <id>] -out <archive>
+//	raftctl snapshot import -dir <dir> -in <archive>
+//
+// dir is the base directory of the store, which holds the snapshots
+// directory. Commands taking an ID default to the latest snapshot.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const usage = `Usage: raftctl snapshot <command> [options]
+
+Commands:
+  list     List the snapshots in a directory
+  verify   Check the CRC of snapshots and that their state decodes
+  inspect  Print the metadata of a snapshot
+  export   Write a snapshot and the snapshots it is based on to an archive
+  import   Add the snapshots of an archive to a directory
+
+Run "raftctl snapshot <command> -h" for the options of a command.
+`
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "snapshot" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"list":    list,
+		"verify":  verify,
+		"inspect": inspect,
+		"export":  export,
+		"import":  importArchive,
+	}
+	command, ok := commands[os.Args[2]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "raftctl: unknown command %q\n\n%s", os.Args[2], usage)
+		os.Exit(2)
+	}
+	if err := command(os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "raftctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// options are the flags shared by the commands.
+type options struct {
+	flags   *flag.FlagSet
+	dir     string
+	id      string
+	keyFile string
+}
+
+// newOptions creates the flags of a command.
+// Params:
+//   - name: name of the command.
+//   - withID: whether the command takes a snapshot ID.
+func newOptions(name string, withID bool) *options {
+	o := &options{flags: flag.NewFlagSet("raftctl snapshot "+name, flag.ExitOnError)}
+	o.flags.StringVar(&o.dir, "dir", "", "base directory of the snapshot store")
+	if withID {
+		o.flags.StringVar(&o.id, "id", "", "ID of the snapshot, defaults to the latest")
+	}
+	return o
+}
+
+// parse parses the arguments of a command.
+func (o *options) parse(args []string) error {
+	o.flags.Parse(args)
+	if o.dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if o.flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", strings.Join(o.flags.Args(), " "))
+	}
+	return nil
+}
+
+// store opens the snapshot store. The tool never reaps snapshots, so the
+// retention policy does not matter.
+func (o *options) store() (*raft.FileSnapshotStore, error) {
+	config := &raft.FileSnapshotStoreConfig{
+		Retain: 1,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+	if o.keyFile != "" {
+		key, err := readKey(o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.EncryptionKey = key
+	}
+	return raft.NewFileSnapshotStoreWithConfig(o.dir, config)
+}
+
+// snapshotID returns the snapshot the command is given, or the latest.
+func (o *options) snapshotID(store *raft.FileSnapshotStore) (string, error) {
+	if o.id != "" {
+		return o.id, nil
+	}
+	snapshots, err := store.ListInfo()
+	if err != nil {
+		return "", err
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf("no snapshots in %v", o.dir)
+	}
+	return snapshots[0].ID, nil
+}
+
+// readKey reads a hex-encoded encryption key from a file.
+func readKey(file string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key in %v: %v", file, err)
+	}
+	return key, nil
+}
+
+func list(args []string) error {
+	o := newOptions("list", false)
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	store, err := o.store()
+	if err != nil {
+		return err
+	}
+	snapshots, err := store.ListInfo()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tINDEX\tTERM\tSIZE\tSTORED\tENCODING\tBASE\tCREATED")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "%v\t%d\t%d\t%d\t%d\t%v\t%v\t%v\n", s.ID, s.Index, s.Term, s.Size,
+			s.StoredSize, encoding(s), orNone(s.Base), created(s))
+	}
+	return w.Flush()
+}
+
+func verify(args []string) error {
+	o := newOptions("verify", true)
+	o.flags.StringVar(&o.keyFile, "key-file", "", "file holding the hex-encoded encryption key, to also decode encrypted snapshots")
+	all := o.flags.Bool("all", false, "verify every snapshot rather than one")
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	store, err := o.store()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	if *all {
+		snapshots, err := store.ListInfo()
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			ids = append(ids, s.ID)
+		}
+	} else {
+		id, err := o.snapshotID(store)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	failed := 0
+	for _, id := range ids {
+		if err := verifySnapshot(store, id, o.keyFile != ""); err != nil {
+			fmt.Printf("%v: FAILED: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%v: OK\n", id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snapshots failed verification", failed, len(ids))
+	}
+	return nil
+}
+
+// verifySnapshot checks the CRC of a snapshot, then that its state decodes
+// to its recorded size unless it is encrypted and there is no key.
+func verifySnapshot(store *raft.FileSnapshotStore, id string, haveKey bool) error {
+	if err := store.Verify(id); err != nil {
+		return err
+	}
+	info, err := store.Info(id)
+	if err != nil {
+		return err
+	}
+	if info.Encryption != "" && !haveKey {
+		return nil
+	}
+	meta, source, err := store.Open(id)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	n, err := io.Copy(ioutil.Discard, source)
+	if err != nil {
+		return fmt.Errorf("failed to decode state: %v", err)
+	}
+	if n != meta.Size {
+		return fmt.Errorf("decoded %d bytes of state, expected %d", n, meta.Size)
+	}
+	return nil
+}
+
+func inspect(args []string) error {
+	o := newOptions("inspect", true)
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	store, err := o.store()
+	if err != nil {
+		return err
+	}
+	id, err := o.snapshotID(store)
+	if err != nil {
+		return err
+	}
+	s, err := store.Info(id)
+	if err != nil {
+		return err
+	}
+
+	responses := 0
+	for _, client := range s.ClientResponseCache {
+		responses += len(client)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%v\n", s.ID)
+	fmt.Fprintf(w, "Version:\t%d\n", s.Version)
+	fmt.Fprintf(w, "Index:\t%d\n", s.Index)
+	fmt.Fprintf(w, "Term:\t%d\n", s.Term)
+	fmt.Fprintf(w, "Base:\t%v\n", orNone(s.Base))
+	fmt.Fprintf(w, "Created:\t%v\n", created(s))
+	fmt.Fprintf(w, "Size:\t%d bytes\n", s.Size)
+	fmt.Fprintf(w, "Stored size:\t%d bytes\n", s.StoredSize)
+	fmt.Fprintf(w, "Encoding:\t%v\n", encoding(s))
+	fmt.Fprintf(w, "CRC:\t%x\n", s.CRC)
+	fmt.Fprintf(w, "Next client ID:\t%d\n", s.NextClientId)
+	fmt.Fprintf(w, "Client response cache:\t%d clients, %d responses\n", len(s.ClientResponseCache), responses)
+	fmt.Fprintf(w, "Witness records:\t%d\n", len(s.WitnessRecords))
+	fmt.Fprintf(w, "Configuration index:\t%d\n", s.ConfigurationIndex)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("Configuration:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  ID\tADDRESS\tSUFFRAGE")
+	for _, server := range s.Configuration.Servers {
+		fmt.Fprintf(w, "  %v\t%v\t%v\n", server.ID, server.Address, server.Suffrage)
+	}
+	return w.Flush()
+}
+
+func export(args []string) error {
+	o := newOptions("export", true)
+	out := o.flags.String("out", "", "file to write the archive to, or - for stdout")
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	store, err := o.store()
+	if err != nil {
+		return err
+	}
+	id, err := o.snapshotID(store)
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return store.Export(id, os.Stdout)
+	}
+	fh, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := store.Export(id, fh); err != nil {
+		fh.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported snapshot %v to %v\n", id, *out)
+	return nil
+}
+
+func importArchive(args []string) error {
+	o := newOptions("import", false)
+	in := o.flags.String("in", "", "file to read the archive from, or - for stdin")
+	if err := o.parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	store, err := o.store()
+	if err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		fh, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		r = fh
+	}
+	ids, err := store.Import(r)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fmt.Printf("Imported snapshot %v\n", id)
+	}
+	return nil
+}
+
+// encoding describes how a snapshot's state is stored.
+func encoding(s *raft.FileSnapshotInfo) string {
+	var parts []string
+	if s.Compression != "" {
+		parts = append(parts, string(s.Compression))
+	}
+	if s.Encryption != "" {
+		parts = append(parts, fmt.Sprintf("%v (key %v)", s.Encryption, s.KeyID))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, "+")
+}
+
+func created(s *raft.FileSnapshotInfo) string {
+	if s.Created.IsZero() {
+		return "-"
+	}
+	return s.Created.Format(time.RFC3339)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
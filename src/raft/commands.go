@@ -8,6 +8,9 @@ type RPCHeader struct {
 	// ProtocolVersion is the version of the protocol the sender is
 	// speaking.
 	ProtocolVersion ProtocolVersion
+	// AcceptCompression is the set of algorithms the sender's transport
+	// can decode, used to negotiate compression of requests to it.
+	AcceptCompression CompressionType
 }
 
 // WithRPCHeader is an interface that exposes the RPC header.
@@ -33,6 +36,10 @@ type AppendEntriesRequest struct {
 
 	// Commit index on the leader
 	LeaderCommitIndex uint64
+
+	// Client operations committed on the leader. Optional, sent with
+	// heartbeats so that witnesses can garbage collect records in bulk.
+	CommittedOps []ClientSeqNo
 }
 
 // See WithRPCHeader.
@@ -57,6 +64,10 @@ type AppendEntriesResponse struct {
 	// There are scenarios where this request didn't succeed
 	// but there's no need to wait/back-off the next attempt.
 	NoRetryBackoff bool
+
+	// Term this server was last initialized as a witness for. Used by the
+	// leader to initialize new or restarted witnesses.
+	WitnessTerm uint64
 }
 
 // See WithRPCHeader.
@@ -129,6 +140,25 @@ type InstallSnapshotRequest struct {
 
 	// Size of the snapshot
 	Size int64
+
+	// Bindings of client IDs to identities known to the leader, encoded
+	// with MsgPack. Empty if clients are not authenticated.
+	ClientIdentities []byte
+
+	// Size of the snapshot data sent with this request, when the snapshot is
+	// sent in chunks. Zero if the request carries the whole snapshot.
+	ChunkSize int64
+
+	// Offset of the data sent with this request in the snapshot.
+	Offset int64
+
+	// CRC64 of the data sent with this request. Nil if not checked.
+	Checksum []byte
+
+	// Index and term of the snapshot a delta holds the changes since. Zero
+	// if the request sends a full snapshot.
+	BaseIndex uint64
+	BaseTerm  uint64
 }
 
 // See WithRPCHeader.
@@ -136,6 +166,15 @@ func (r *InstallSnapshotRequest) GetRPCHeader() RPCHeader {
 	return r.RPCHeader
 }
 
+// DataSize returns the size of the snapshot data sent with the request,
+// which transports stream after it.
+func (r *InstallSnapshotRequest) DataSize() int64 {
+	if r.ChunkSize > 0 {
+		return r.ChunkSize
+	}
+	return r.Size
+}
+
 // InstallSnapshotResponse is the response returned from an
 // InstallSnapshotRequest.
 type InstallSnapshotResponse struct {
@@ -143,6 +182,16 @@ type InstallSnapshotResponse struct {
 
 	Term    uint64
 	Success bool
+
+	// Offset of the snapshot data the follower expects next, from which
+	// an interrupted or rejected transfer resumes.
+	NextOffset int64
+
+	// Set if the follower does not have the base of the delta sent, along
+	// with the snapshots the follower has, newest first, so that the leader
+	// sends the chain from the newest of them it shares.
+	BaseMissing bool
+	Snapshots   []SnapshotPosition
 }
 
 // See WithRPCHeader.
@@ -150,6 +199,12 @@ func (r *InstallSnapshotResponse) GetRPCHeader() RPCHeader {
 	return r.RPCHeader
 }
 
+// SnapshotPosition is the last index and term included in a snapshot.
+type SnapshotPosition struct {
+	Index uint64
+	Term  uint64
+}
+
 // Record RPCs are used to store commutative operations at witnesses.
 // Accepted if commutative with other operations at witness, rejected
 // otherwise.
@@ -158,8 +213,14 @@ type RecordRequest struct {
 
 	// Entry to commit
 	Entry *Log
+	// Credentials of the client, checked if the witness authenticates
+	// clients.
+	Credentials []byte
     // Use term to make sure witness is valid.
     Term uint64
+    // Leader the client is sending the command to. Witness rejects the
+    // record if it follows a different leader.
+    LeaderAddress ServerAddress
 }
 
 // See WithRPCHeader.
@@ -177,6 +238,9 @@ type RecordResponse struct {
 	Success bool
     // Discover term if term not correct.
     Term uint64
+    // Leader known by witness, empty if none. Used as a hint to find
+    // active leader.
+    LeaderAddress ServerAddress
 }
 
 // See WithRPCHeader.
@@ -190,6 +254,9 @@ type SyncRequest struct {
 	RPCHeader
 
 	Entry *Log
+	// Credentials of the client, checked if the leader authenticates
+	// clients.
+	Credentials []byte
 }
 
 // See WithRPCHeader.
@@ -252,6 +319,10 @@ func (r *RecoveryDataResponse) GetRPCHeader() RPCHeader {
 // Unfreeze witness to allow it to process record requests again.
 type UnfreezeRequest struct {
     RPCHeader
+
+    // Term of the leader. Witness is initialized for this term and can
+    // accept records in it.
+    Term uint64
 }
 
 // See WithRPCHeader.
@@ -275,6 +346,9 @@ type ClientRequest struct {
 
 	// New entry to commit.
 	Entry *Log
+	// Credentials of the client, checked if the leader authenticates
+	// clients.
+	Credentials []byte
 }
 
 // See WithRPCHeader.
@@ -294,6 +368,9 @@ type ClientResponse struct {
 	ResponseData []byte
 	// True if leader synced (not commutative), false otherwise.
 	Synced bool
+	// Term of the leader that executed the command. Witness records only
+	// count towards the command if they were accepted in this term.
+	Term uint64
 }
 
 // See WithRPCHeader.
@@ -310,6 +387,10 @@ func (r *ClientResponse) GetLeaderAddress() ServerAddress {
 // the leader to make requests.
 type ClientIdRequest struct {
 	RPCHeader
+
+	// Credentials of the client, checked by the leader's Authenticator if
+	// one is configured.
+	Credentials []byte
 }
 
 // See WithRPCHeader.
@@ -326,6 +407,9 @@ type ClientIdResponse struct {
 
 	// Address of active leader. Used as a hint to find active leader.
 	LeaderAddress ServerAddress
+
+	// Current term of the leader. Used to fence witnesses.
+	Term uint64
 }
 
 // See WithRPCHeader.
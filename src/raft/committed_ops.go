@@ -0,0 +1,78 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+)
+
+// Tracks client operations recently committed by the leader. Sent to
+// followers on heartbeats so that witnesses can garbage collect records in
+// bulk without waiting to apply the corresponding log entries.
+
+// committedOp is a client operation committed at a log index.
+type committedOp struct {
+	index uint64
+	op    ClientSeqNo
+}
+
+// committedOps is a bounded, index-ordered list of committed client
+// operations. Oldest operations are dropped once full; witnesses still
+// garbage collect those when applying the log.
+type committedOps struct {
+	// Committed operations in increasing index order.
+	entries []committedOp
+	// Maximum number of entries kept.
+	capacity int
+	// Lock protecting entries.
+	lock sync.Mutex
+}
+
+// Create empty list of committed operations.
+// Params:
+//   - capacity: maximum number of operations to keep.
+func newCommittedOps(capacity int) *committedOps {
+	return &committedOps{
+		entries:  make([]committedOp, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+// Add an operation committed at a log index. Indexes must be increasing.
+// Params:
+//   - index: log index operation committed at.
+//   - op: client ID and sequence number of operation.
+func (c *committedOps) add(index uint64, op ClientSeqNo) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) == c.capacity {
+		copy(c.entries, c.entries[1:])
+		c.entries = c.entries[:len(c.entries)-1]
+	}
+	c.entries = append(c.entries, committedOp{index: index, op: op})
+}
+
+// Get operations committed after a log index.
+// Params:
+//   - index: only return operations committed after this index.
+//   - limit: maximum number of operations to return.
+// Returns: operations, and index of the last operation returned (index if none).
+func (c *committedOps) since(index uint64, limit int) ([]ClientSeqNo, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	start := sort.Search(len(c.entries), func(i int) bool {
+		return c.entries[i].index > index
+	})
+	var ops []ClientSeqNo
+	last := index
+	for _, entry := range c.entries[start:] {
+		if len(ops) >= limit {
+			break
+		}
+		ops = append(ops, entry.op)
+		last = entry.index
+	}
+	return ops, last
+}
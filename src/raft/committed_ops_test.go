@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"testing"
+)
+
+func TestCommittedOps_Since(t *testing.T) {
+	c := newCommittedOps(10)
+	for i := uint64(1); i <= 5; i++ {
+		c.add(i*2, ClientSeqNo{ClientID: 1, SeqNo: i})
+	}
+
+	ops, last := c.since(0, 3)
+	if len(ops) != 3 || ops[0].SeqNo != 1 || ops[2].SeqNo != 3 {
+		t.Fatalf("bad ops: %v", ops)
+	}
+	if last != 6 {
+		t.Fatalf("expected last index 6, got %d", last)
+	}
+
+	ops, last = c.since(last, 10)
+	if len(ops) != 2 || ops[0].SeqNo != 4 || ops[1].SeqNo != 5 {
+		t.Fatalf("bad ops: %v", ops)
+	}
+	if last != 10 {
+		t.Fatalf("expected last index 10, got %d", last)
+	}
+
+	ops, last = c.since(last, 10)
+	if len(ops) != 0 || last != 10 {
+		t.Fatalf("expected no ops, got %v at %d", ops, last)
+	}
+}
+
+func TestCommittedOps_Capacity(t *testing.T) {
+	c := newCommittedOps(2)
+	for i := uint64(1); i <= 3; i++ {
+		c.add(i, ClientSeqNo{ClientID: 1, SeqNo: i})
+	}
+	ops, last := c.since(0, 10)
+	if len(ops) != 2 || ops[0].SeqNo != 2 || ops[1].SeqNo != 3 {
+		t.Fatalf("oldest op should be dropped: %v", ops)
+	}
+	if last != 3 {
+		t.Fatalf("expected last index 3, got %d", last)
+	}
+
+	disabled := newCommittedOps(0)
+	disabled.add(1, ClientSeqNo{ClientID: 1, SeqNo: 1})
+	if ops, _ := disabled.since(0, 10); len(ops) != 0 {
+		t.Fatalf("disabled list should be empty: %v", ops)
+	}
+}
@@ -201,6 +201,97 @@ type Config struct {
 	// How long a client response should be kept in the cache to prevent duplicate
 	// execution. Used with RIFL.
 	ClientResponseGcRemoveTime time.Duration
+
+	// Maximum number of committed client operations sent to a follower in a
+	// single heartbeat so that it can garbage collect witness records in bulk.
+	// Zero disables this, and witnesses only garbage collect when applying
+	// the log. Used with CURP.
+	MaxWitnessGcOps int
+
+	// Rate in requests per second at which each client may send client,
+	// sync and witness record requests, and the number of requests a client
+	// may send in a burst. Requests over the limit fail with ErrOverloaded.
+	// A zero rate disables the limit, and a zero burst defaults to the rate.
+	ClientRateLimit float64
+	ClientRateBurst int
+
+	// Rate in requests per second, and burst, of client RPCs accepted from
+	// all clients together, including client ID requests. Zero disables
+	// the limit, as with ClientRateLimit.
+	GlobalRateLimit float64
+	GlobalRateBurst int
+
+	// Maximum number of client RPCs handled concurrently. Requests over the
+	// limit fail with ErrOverloaded. Zero disables the limit.
+	MaxInFlightClientRPCs int
+
+	// Authenticator validates credentials sent by clients. If set, clients
+	// must authenticate to obtain a client ID, and every later request is
+	// checked against the identity bound to its client ID. Nil accepts all
+	// clients.
+	Authenticator Authenticator
+
+	// Number of workers handling RecordRequests as a witness, if the
+	// transport supports the witness fast-path (see WithWitnessHandler).
+	// Records are then not delayed by RPCs handled by the main thread,
+	// such as InstallSnapshot. Zero handles records on the main thread.
+	// Used with CURP.
+	WitnessWorkers int
+
+	// GroupCommitMaxDelay is how long the leader waits for more Apply
+	// calls to join an entry it received, so that they are written with
+	// a single StoreLogs. Zero writes the entries already queued without
+	// waiting.
+	GroupCommitMaxDelay time.Duration
+
+	// GroupCommitMaxBatch is the most entries the leader writes with a
+	// single StoreLogs. Zero uses MaxAppendEntries.
+	GroupCommitMaxBatch int
+
+	// LogChecksums makes the leader set a checksum on the log entries it
+	// appends. Followers verify it before storing entries, and the FSM
+	// before applying them, so that corrupted entries are detected. A
+	// server whose FSM finds a corrupted entry halts, rejecting RPCs and
+	// operations until it is shut down.
+	LogChecksums bool
+
+	// SnapshotChunkSize is the most snapshot data the leader sends to a
+	// follower in a single InstallSnapshot request. Each chunk carries a
+	// checksum, and a transfer that is interrupted resumes from the last
+	// chunk the follower acknowledged. Zero, the default, sends the whole
+	// snapshot in one request. Servers running a version without chunked
+	// transfers cannot receive chunks, so only set this once every server
+	// in the cluster has been upgraded.
+	SnapshotChunkSize int
+
+	// SnapshotBandwidth limits the rate, in bytes per second, at which the
+	// leader sends snapshot data to each follower. Zero is unlimited.
+	SnapshotBandwidth int
+
+	// SnapshotLogBytes triggers a snapshot once the entries appended to the
+	// log since the last snapshot total this many bytes, in addition to
+	// SnapshotThreshold. Zero disables it.
+	SnapshotLogBytes uint64
+
+	// SnapshotDiskUsage triggers a snapshot once the LogStore uses this many
+	// bytes on disk. The LogStore must implement LogDiskUsage. TrailingLogs
+	// must leave enough room below it for compaction to bring the usage
+	// down. Zero disables it.
+	SnapshotDiskUsage uint64
+
+	// MaxLogBytes is a hard limit on the bytes appended to the log since
+	// the last snapshot. Once it is reached, a snapshot is taken, and Apply
+	// waits for the log to be compacted, or for its timeout, before adding
+	// more entries. Client requests fail with ErrOverloaded until then.
+	// Zero disables it.
+	MaxLogBytes uint64
+
+	// MaxSnapshotChain is the most snapshots taken as deltas of the one
+	// before, when the FSM implements IncrementalFSM and the SnapshotStore
+	// implements IncrementalSnapshotStore, before the next full snapshot.
+	// Restoring replays the whole chain, so this bounds the time to
+	// restore. Zero takes full snapshots only.
+	MaxSnapshotChain int
 }
 
 // DefaultConfig returns a Config with usable defaults.
@@ -218,6 +309,9 @@ func DefaultConfig() *Config {
 		LeaderLeaseTimeout:         500 * time.Millisecond,
 		ClientResponseGcInterval:   time.Minute,
 		ClientResponseGcRemoveTime: 4 * time.Hour,
+		MaxWitnessGcOps:            256,
+		WitnessWorkers:             4,
+		MaxSnapshotChain:           8,
 	}
 }
 
@@ -264,5 +358,35 @@ func ValidateConfig(config *Config) error {
 	if config.ElectionTimeout < config.HeartbeatTimeout {
 		return fmt.Errorf("Election timeout must be equal or greater than Heartbeat Timeout")
 	}
+	if config.MaxWitnessGcOps < 0 {
+		return fmt.Errorf("MaxWitnessGcOps must not be negative")
+	}
+	if config.ClientRateLimit < 0 || config.ClientRateBurst < 0 {
+		return fmt.Errorf("Client rate limit must not be negative")
+	}
+	if config.GlobalRateLimit < 0 || config.GlobalRateBurst < 0 {
+		return fmt.Errorf("Global rate limit must not be negative")
+	}
+	if config.MaxInFlightClientRPCs < 0 {
+		return fmt.Errorf("MaxInFlightClientRPCs must not be negative")
+	}
+	if config.WitnessWorkers < 0 {
+		return fmt.Errorf("WitnessWorkers must not be negative")
+	}
+	if config.GroupCommitMaxDelay < 0 {
+		return fmt.Errorf("GroupCommitMaxDelay must not be negative")
+	}
+	if config.GroupCommitMaxBatch < 0 {
+		return fmt.Errorf("GroupCommitMaxBatch must not be negative")
+	}
+	if config.SnapshotChunkSize < 0 {
+		return fmt.Errorf("SnapshotChunkSize must not be negative")
+	}
+	if config.SnapshotBandwidth < 0 {
+		return fmt.Errorf("SnapshotBandwidth must not be negative")
+	}
+	if config.MaxSnapshotChain < 0 {
+		return fmt.Errorf("MaxSnapshotChain must not be negative")
+	}
 	return nil
 }
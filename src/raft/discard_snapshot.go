@@ -20,7 +20,7 @@ func NewDiscardSnapshotStore() *DiscardSnapshotStore {
 }
 
 func (d *DiscardSnapshotStore) Create(version SnapshotVersion, index, term uint64,
-	configuration Configuration, configurationIndex uint64, trans Transport) (SnapshotSink, error) {
+	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error) {
 	return &DiscardSnapshotSink{}, nil
 }
 
@@ -0,0 +1,437 @@
+package raft
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Fault injection for testing. FaultyTransport wraps any Transport, and
+// FaultyClientTransport any ClientTransport, and injects faults into the
+// RPCs they send according to rules that can be changed at runtime. Rules
+// count the RPCs they match, so a fault can be injected into exactly the
+// n-th RecordRequest to a witness, making witness and recovery races
+// reproducible.
+
+// ErrFaultInjected is returned for RPCs dropped by a fault rule.
+var ErrFaultInjected = errors.New("fault injected")
+
+// RPCType identifies the type of an RPC in fault rules.
+type RPCType uint8
+
+const (
+	RPCAppendEntries RPCType = iota
+	RPCRequestVote
+	RPCInstallSnapshot
+	RPCRecoverData
+	RPCUnfreezeWitness
+	RPCClientIdRequest
+	RPCClientRequest
+	RPCRecordRequest
+	RPCSyncRequest
+)
+
+// Fault is a kind of fault injected into an RPC.
+type Fault uint8
+
+const (
+	// FaultDrop drops the request, which never reaches the target.
+	FaultDrop Fault = iota
+	// FaultDropResponse delivers the request but drops the response, so the
+	// target acts on the RPC while the sender sees ErrFaultInjected.
+	FaultDropResponse
+	// FaultDelay waits for the rule's Delay before sending the request.
+	FaultDelay
+	// FaultDuplicate sends the request twice. The sender sees the response
+	// to the first. InstallSnapshot is never duplicated, since its data can
+	// only be read once.
+	FaultDuplicate
+	// FaultReorder holds the request until a later RPC matching the same
+	// rule has completed, or for at most the rule's Delay, then sends it.
+	FaultReorder
+)
+
+// defaultReorderHold is how long FaultReorder holds a request if the rule
+// has no Delay.
+const defaultReorderHold = time.Second
+
+// FaultRule describes which RPCs to inject a fault into.
+type FaultRule struct {
+	// Fault to inject.
+	Fault Fault
+
+	// RPC types the rule applies to. Applies to all types if empty.
+	RPCs []RPCType
+
+	// Target the rule applies to. Applies to all targets if empty.
+	Target ServerAddress
+
+	// Number of matching RPCs to let through before injecting the fault.
+	Skip int
+
+	// Number of matching RPCs to inject the fault into after Skip. Unlimited
+	// if zero.
+	Count int
+
+	// Delay for FaultDelay. For FaultReorder, the longest a request is held.
+	Delay time.Duration
+}
+
+// faultRuleState is an installed rule and the RPCs it has matched.
+type faultRuleState struct {
+	rule FaultRule
+	id   uint64
+	// Number of RPCs matched so far.
+	matched int
+	// Closed to release requests held by FaultReorder.
+	releaseCh chan struct{}
+}
+
+// matches checks whether an RPC matches a rule.
+func (s *faultRuleState) matches(rpc RPCType, target ServerAddress) bool {
+	if s.rule.Target != "" && s.rule.Target != target {
+		return false
+	}
+	if len(s.rule.RPCs) == 0 {
+		return true
+	}
+	for _, r := range s.rule.RPCs {
+		if r == rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// faultInjector holds fault rules and applies them to RPCs. Shared by
+// FaultyTransport and FaultyClientTransport.
+type faultInjector struct {
+	rules  []*faultRuleState
+	nextID uint64
+	// Lock protecting rules and nextID.
+	lock sync.Mutex
+}
+
+// AddRule installs a fault rule. Rules are checked in the order they were
+// added, and the first rule whose fault is due is applied.
+// Returns: ID used to remove the rule.
+func (f *faultInjector) AddRule(rule FaultRule) uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nextID++
+	f.rules = append(f.rules, &faultRuleState{
+		rule:      rule,
+		id:        f.nextID,
+		releaseCh: make(chan struct{}),
+	})
+	return f.nextID
+}
+
+// RemoveRule removes a fault rule, releasing any requests it holds.
+func (f *faultInjector) RemoveRule(id uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, state := range f.rules {
+		if state.id == id {
+			close(state.releaseCh)
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// ClearRules removes all fault rules, releasing any held requests.
+func (f *faultInjector) ClearRules() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, state := range f.rules {
+		close(state.releaseCh)
+	}
+	f.rules = nil
+}
+
+// invoke sends an RPC, injecting the fault of the first due rule.
+// Params:
+//   - rpc: type of RPC.
+//   - target: address RPC is sent to.
+//   - send: sends the RPC. If dup is true, the response must be decoded
+//           into a scratch object rather than the caller's.
+// Returns: error to return to the caller.
+func (f *faultInjector) invoke(rpc RPCType, target ServerAddress, send func(dup bool) error) error {
+	// Count the RPC against every matching rule, and find the fault to apply
+	var apply *faultRuleState
+	var matching []*faultRuleState
+	f.lock.Lock()
+	for _, state := range f.rules {
+		if !state.matches(rpc, target) {
+			continue
+		}
+		state.matched++
+		matching = append(matching, state)
+		due := state.matched > state.rule.Skip &&
+			(state.rule.Count == 0 || state.matched <= state.rule.Skip+state.rule.Count)
+		if apply == nil && due {
+			apply = state
+		}
+	}
+	f.lock.Unlock()
+
+	var err error
+	if apply == nil {
+		err = send(false)
+	} else {
+		err = f.applyFault(apply, rpc, send)
+	}
+
+	// Release requests held for reordering behind this one
+	if apply == nil || apply.rule.Fault != FaultReorder {
+		f.lock.Lock()
+		for _, state := range matching {
+			if state.rule.Fault == FaultReorder && f.installed(state) {
+				close(state.releaseCh)
+				state.releaseCh = make(chan struct{})
+			}
+		}
+		f.lock.Unlock()
+	}
+	return err
+}
+
+// installed checks whether a rule is still installed. Must be called with
+// lock held.
+func (f *faultInjector) installed(state *faultRuleState) bool {
+	for _, s := range f.rules {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFault sends an RPC with the given rule's fault injected.
+func (f *faultInjector) applyFault(state *faultRuleState, rpc RPCType, send func(dup bool) error) error {
+	switch state.rule.Fault {
+	case FaultDrop:
+		return ErrFaultInjected
+
+	case FaultDropResponse:
+		if err := send(true); err != nil {
+			return err
+		}
+		return ErrFaultInjected
+
+	case FaultDelay:
+		time.Sleep(state.rule.Delay)
+		return send(false)
+
+	case FaultDuplicate:
+		err := send(false)
+		if rpc != RPCInstallSnapshot {
+			send(true)
+		}
+		return err
+
+	case FaultReorder:
+		hold := state.rule.Delay
+		if hold == 0 {
+			hold = defaultReorderHold
+		}
+		f.lock.Lock()
+		releaseCh := state.releaseCh
+		f.lock.Unlock()
+		select {
+		case <-releaseCh:
+		case <-time.After(hold):
+		}
+		return send(false)
+	}
+	return send(false)
+}
+
+// FaultyTransport wraps a Transport and injects faults into the RPCs it
+// sends. It does not support pipelining, so that every AppendEntries goes
+// through the fault rules.
+type FaultyTransport struct {
+	faultInjector
+	trans Transport
+}
+
+// NewFaultyTransport returns a FaultyTransport wrapping trans, with no
+// fault rules installed.
+func NewFaultyTransport(trans Transport) *FaultyTransport {
+	return &FaultyTransport{trans: trans}
+}
+
+// Consumer implements the Transport interface.
+func (f *FaultyTransport) Consumer() <-chan RPC {
+	return f.trans.Consumer()
+}
+
+// LocalAddr implements the Transport interface.
+func (f *FaultyTransport) LocalAddr() ServerAddress {
+	return f.trans.LocalAddr()
+}
+
+// AppendEntriesPipeline implements the Transport interface.
+func (f *FaultyTransport) AppendEntriesPipeline(id ServerID, target ServerAddress) (AppendPipeline, error) {
+	return nil, ErrPipelineReplicationNotSupported
+}
+
+// AppendEntries implements the Transport interface.
+func (f *FaultyTransport) AppendEntries(id ServerID, target ServerAddress, args *AppendEntriesRequest, resp *AppendEntriesResponse) error {
+	return f.invoke(RPCAppendEntries, target, func(dup bool) error {
+		if dup {
+			return f.trans.AppendEntries(id, target, args, new(AppendEntriesResponse))
+		}
+		return f.trans.AppendEntries(id, target, args, resp)
+	})
+}
+
+// RequestVote implements the Transport interface.
+func (f *FaultyTransport) RequestVote(id ServerID, target ServerAddress, args *RequestVoteRequest, resp *RequestVoteResponse) error {
+	return f.invoke(RPCRequestVote, target, func(dup bool) error {
+		if dup {
+			return f.trans.RequestVote(id, target, args, new(RequestVoteResponse))
+		}
+		return f.trans.RequestVote(id, target, args, resp)
+	})
+}
+
+// RecoverData implements the Transport interface.
+func (f *FaultyTransport) RecoverData(id ServerID, target ServerAddress, args *RecoveryDataRequest, resp *RecoveryDataResponse) error {
+	return f.invoke(RPCRecoverData, target, func(dup bool) error {
+		if dup {
+			return f.trans.RecoverData(id, target, args, new(RecoveryDataResponse))
+		}
+		return f.trans.RecoverData(id, target, args, resp)
+	})
+}
+
+// UnfreezeWitness implements the Transport interface.
+func (f *FaultyTransport) UnfreezeWitness(id ServerID, target ServerAddress, args *UnfreezeRequest, resp *UnfreezeResponse) error {
+	return f.invoke(RPCUnfreezeWitness, target, func(dup bool) error {
+		if dup {
+			return f.trans.UnfreezeWitness(id, target, args, new(UnfreezeResponse))
+		}
+		return f.trans.UnfreezeWitness(id, target, args, resp)
+	})
+}
+
+// InstallSnapshot implements the Transport interface.
+func (f *FaultyTransport) InstallSnapshot(id ServerID, target ServerAddress, args *InstallSnapshotRequest, resp *InstallSnapshotResponse, data io.Reader) error {
+	return f.invoke(RPCInstallSnapshot, target, func(dup bool) error {
+		if dup {
+			return f.trans.InstallSnapshot(id, target, args, new(InstallSnapshotResponse), data)
+		}
+		return f.trans.InstallSnapshot(id, target, args, resp, data)
+	})
+}
+
+// EncodePeer implements the Transport interface.
+func (f *FaultyTransport) EncodePeer(id ServerID, addr ServerAddress) []byte {
+	return f.trans.EncodePeer(id, addr)
+}
+
+// DecodePeer implements the Transport interface.
+func (f *FaultyTransport) DecodePeer(buf []byte) ServerAddress {
+	return f.trans.DecodePeer(buf)
+}
+
+// SetHeartbeatHandler implements the Transport interface.
+func (f *FaultyTransport) SetHeartbeatHandler(cb func(rpc RPC)) {
+	f.trans.SetHeartbeatHandler(cb)
+}
+
+// SetWitnessHandler implements the WithWitnessHandler interface, setting
+// the handler of the wrapped transport if it supports it.
+func (f *FaultyTransport) SetWitnessHandler(cb func(rpc RPC)) {
+	if witness, ok := f.trans.(WithWitnessHandler); ok {
+		witness.SetWitnessHandler(cb)
+	}
+}
+
+// Close implements the WithClose interface, closing the wrapped transport
+// if it supports it.
+func (f *FaultyTransport) Close() error {
+	f.ClearRules()
+	if closer, ok := f.trans.(WithClose); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// FaultyClientTransport wraps a ClientTransport and injects faults into the
+// RPCs a client Session sends.
+type FaultyClientTransport struct {
+	faultInjector
+	trans ClientTransport
+}
+
+// NewFaultyClientTransport returns a FaultyClientTransport wrapping trans,
+// with no fault rules installed.
+func NewFaultyClientTransport(trans ClientTransport) *FaultyClientTransport {
+	return &FaultyClientTransport{trans: trans}
+}
+
+// invokeClient sends a client RPC through the fault rules. Dropped RPCs
+// are reported as not responded to.
+func (f *FaultyClientTransport) invokeClient(rpc RPCType, target ServerAddress, send func(dup bool) (bool, error)) (bool, error) {
+	var responded bool
+	err := f.invoke(rpc, target, func(dup bool) error {
+		r, err := send(dup)
+		if !dup {
+			responded = r
+		}
+		return err
+	})
+	if err == ErrFaultInjected {
+		return false, err
+	}
+	return responded, err
+}
+
+// OpenClientConn implements the ClientTransport interface.
+func (f *FaultyClientTransport) OpenClientConn(target ServerAddress) error {
+	return f.trans.OpenClientConn(target)
+}
+
+// SendClientIdRequest implements the ClientTransport interface.
+func (f *FaultyClientTransport) SendClientIdRequest(target ServerAddress, args *ClientIdRequest, resp *ClientIdResponse) (bool, error) {
+	return f.invokeClient(RPCClientIdRequest, target, func(dup bool) (bool, error) {
+		if dup {
+			return f.trans.SendClientIdRequest(target, args, new(ClientIdResponse))
+		}
+		return f.trans.SendClientIdRequest(target, args, resp)
+	})
+}
+
+// SendClientRequest implements the ClientTransport interface.
+func (f *FaultyClientTransport) SendClientRequest(target ServerAddress, args *ClientRequest, resp *ClientResponse) (bool, error) {
+	return f.invokeClient(RPCClientRequest, target, func(dup bool) (bool, error) {
+		if dup {
+			return f.trans.SendClientRequest(target, args, new(ClientResponse))
+		}
+		return f.trans.SendClientRequest(target, args, resp)
+	})
+}
+
+// SendRecordRequest implements the ClientTransport interface.
+func (f *FaultyClientTransport) SendRecordRequest(target ServerAddress, args *RecordRequest, resp *RecordResponse) (bool, error) {
+	return f.invokeClient(RPCRecordRequest, target, func(dup bool) (bool, error) {
+		if dup {
+			return f.trans.SendRecordRequest(target, args, new(RecordResponse))
+		}
+		return f.trans.SendRecordRequest(target, args, resp)
+	})
+}
+
+// SendSyncRequest implements the ClientTransport interface.
+func (f *FaultyClientTransport) SendSyncRequest(target ServerAddress, args *SyncRequest, resp *SyncResponse) (bool, error) {
+	return f.invokeClient(RPCSyncRequest, target, func(dup bool) (bool, error) {
+		if dup {
+			return f.trans.SendSyncRequest(target, args, new(SyncResponse))
+		}
+		return f.trans.SendSyncRequest(target, args, resp)
+	})
+}
@@ -0,0 +1,222 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+// Make a FaultyTransport wrapping an in-memory transport connected to a
+// consumer that answers every RPC, recording the commands it receives.
+func makeFaultyTransports(t *testing.T) (*FaultyTransport, ServerAddress, chan interface{}) {
+	addr1, trans1 := NewInmemTransport("")
+	addr2, trans2 := NewInmemTransport("")
+	trans1.Connect(addr2, trans2)
+	trans2.Connect(addr1, trans1)
+
+	receivedCh := make(chan interface{}, 16)
+	go func() {
+		for rpc := range trans2.Consumer() {
+			receivedCh <- rpc.Command
+			switch req := rpc.Command.(type) {
+			case *AppendEntriesRequest:
+				rpc.Respond(&AppendEntriesResponse{Term: req.Term, LastLog: req.PrevLogEntry, Success: true}, nil)
+			case *RequestVoteRequest:
+				rpc.Respond(&RequestVoteResponse{Term: req.Term, Granted: true}, nil)
+			default:
+				rpc.Respond(nil, nil)
+			}
+		}
+	}()
+	return NewFaultyTransport(trans1), addr2, receivedCh
+}
+
+// Count commands received within a short wait.
+func countReceived(receivedCh chan interface{}) int {
+	count := 0
+	for {
+		select {
+		case <-receivedCh:
+			count++
+		case <-time.After(50 * time.Millisecond):
+			return count
+		}
+	}
+}
+
+func TestFaultyTransport_DropSkipCount(t *testing.T) {
+	trans, target, receivedCh := makeFaultyTransports(t)
+	defer trans.Close()
+	trans.AddRule(FaultRule{
+		Fault:  FaultDrop,
+		RPCs:   []RPCType{RPCAppendEntries},
+		Target: target,
+		Skip:   1,
+		Count:  1,
+	})
+
+	for i := 0; i < 3; i++ {
+		var resp AppendEntriesResponse
+		err := trans.AppendEntries("id2", target, &AppendEntriesRequest{Term: 1}, &resp)
+		if i == 1 && err != ErrFaultInjected {
+			t.Fatalf("expected second append to be dropped, got %v", err)
+		}
+		if i != 1 && (err != nil || !resp.Success) {
+			t.Fatalf("append %d failed: %v", i, err)
+		}
+	}
+
+	// Other RPC types are not affected
+	var resp RequestVoteResponse
+	if err := trans.RequestVote("id2", target, &RequestVoteRequest{Term: 1}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := countReceived(receivedCh); n != 3 {
+		t.Fatalf("expected 3 RPCs delivered, got %d", n)
+	}
+}
+
+func TestFaultyTransport_DropResponse(t *testing.T) {
+	trans, target, receivedCh := makeFaultyTransports(t)
+	defer trans.Close()
+	trans.AddRule(FaultRule{Fault: FaultDropResponse})
+
+	var resp RequestVoteResponse
+	if err := trans.RequestVote("id2", target, &RequestVoteRequest{Term: 1}, &resp); err != ErrFaultInjected {
+		t.Fatalf("expected dropped response, got %v", err)
+	}
+	if resp.Granted {
+		t.Fatalf("response should not be delivered: %#v", resp)
+	}
+	if n := countReceived(receivedCh); n != 1 {
+		t.Fatalf("expected request to be delivered, got %d", n)
+	}
+}
+
+func TestFaultyTransport_Duplicate(t *testing.T) {
+	trans, target, receivedCh := makeFaultyTransports(t)
+	defer trans.Close()
+	trans.AddRule(FaultRule{Fault: FaultDuplicate, RPCs: []RPCType{RPCRequestVote}, Count: 1})
+
+	var resp RequestVoteResponse
+	if err := trans.RequestVote("id2", target, &RequestVoteRequest{Term: 1}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := countReceived(receivedCh); n != 2 {
+		t.Fatalf("expected request to be delivered twice, got %d", n)
+	}
+}
+
+func TestFaultyTransport_Delay(t *testing.T) {
+	trans, target, _ := makeFaultyTransports(t)
+	defer trans.Close()
+	id := trans.AddRule(FaultRule{Fault: FaultDelay, Delay: 50 * time.Millisecond})
+
+	start := time.Now()
+	var resp RequestVoteResponse
+	if err := trans.RequestVote("id2", target, &RequestVoteRequest{Term: 1}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("request was not delayed")
+	}
+
+	// Rules can be removed at runtime
+	trans.RemoveRule(id)
+	start = time.Now()
+	if err := trans.RequestVote("id2", target, &RequestVoteRequest{Term: 1}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if time.Since(start) >= 50*time.Millisecond {
+		t.Fatalf("request should not be delayed after rule removed")
+	}
+}
+
+func TestFaultyTransport_Reorder(t *testing.T) {
+	trans, target, receivedCh := makeFaultyTransports(t)
+	defer trans.Close()
+	trans.AddRule(FaultRule{
+		Fault: FaultReorder,
+		RPCs:  []RPCType{RPCAppendEntries},
+		Count: 1,
+		Delay: 5 * time.Second,
+	})
+
+	// The first append is held until the second completes
+	doneCh := make(chan error, 1)
+	go func() {
+		var resp AppendEntriesResponse
+		doneCh <- trans.AppendEntries("id2", target, &AppendEntriesRequest{Term: 1, PrevLogEntry: 1}, &resp)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	var resp AppendEntriesResponse
+	if err := trans.AppendEntries("id2", target, &AppendEntriesRequest{Term: 1, PrevLogEntry: 2}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := <-doneCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	first := (<-receivedCh).(*AppendEntriesRequest)
+	second := (<-receivedCh).(*AppendEntriesRequest)
+	if first.PrevLogEntry != 2 || second.PrevLogEntry != 1 {
+		t.Fatalf("expected appends to be reordered, got %d then %d", first.PrevLogEntry, second.PrevLogEntry)
+	}
+}
+
+func TestFaultyClientTransport_RecordDropForcesSync(t *testing.T) {
+	// Server answering client RPCs
+	trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	syncCh := make(chan struct{}, 1)
+	go func() {
+		for rpc := range trans1.Consumer() {
+			switch rpc.Command.(type) {
+			case *ClientIdRequest:
+				rpc.Respond(&ClientIdResponse{ClientID: 1, Term: 1}, nil)
+			case *ClientRequest:
+				rpc.Respond(&ClientResponse{Success: true, Term: 1}, nil)
+			case *RecordRequest:
+				rpc.Respond(&RecordResponse{Success: true, Term: 1}, nil)
+			case *SyncRequest:
+				syncCh <- struct{}{}
+				rpc.Respond(&SyncResponse{Success: true}, nil)
+			}
+		}
+	}()
+
+	clientTrans, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer clientTrans.Close()
+	faulty := NewFaultyClientTransport(clientTrans)
+	session, err := CreateClientSession(faulty, []ServerAddress{trans1.LocalAddr()})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Without faults, the fast path completes without syncing
+	var resp ClientResponse
+	if err := session.SendFastRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-syncCh:
+		t.Fatalf("unexpected sync")
+	default:
+	}
+
+	// Losing the witness record forces a sync
+	faulty.AddRule(FaultRule{Fault: FaultDrop, RPCs: []RPCType{RPCRecordRequest}, Count: 1})
+	if err := session.SendFastRequest([]byte("b"), []Key{Key("b")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-syncCh:
+	default:
+		t.Fatalf("expected sync after record dropped")
+	}
+}
@@ -0,0 +1,742 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Segmented write-ahead log. FileLogStore appends log entries to segment files
+in a directory, each named after the index of its first record and the first
+index not deleted from it. Every record is framed with its length and a
+CRC32 over the length and the encoded entry, so a record torn by a crash is
+detected when the store is opened and truncated away. A segment is rotated
+when it reaches SegmentSize, and is synced before rotation regardless of the
+fsync policy. DeleteRange deletes whole segments, renames a segment to drop
+a prefix of it, and truncates a segment to drop a suffix of it, so the log
+is never rewritten.
+
+*/
+
+const (
+	walPath            = "wal"
+	walSegmentSuffix   = ".wal"
+	walRecordHeaderLen = 8
+
+	// DefaultSegmentSize is the size a segment of a FileLogStore reaches
+	// before a new one is started.
+	DefaultSegmentSize = 64 * 1024 * 1024
+
+	// DefaultFsyncInterval is how often a FileLogStore using FsyncInterval
+	// syncs its segment.
+	DefaultFsyncInterval = 100 * time.Millisecond
+)
+
+var (
+	walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// FsyncPolicy controls when a FileLogStore syncs written entries to disk.
+type FsyncPolicy uint8
+
+const (
+	// FsyncEveryWrite syncs before every StoreLogs returns.
+	FsyncEveryWrite FsyncPolicy = iota
+
+	// FsyncGroupCommit syncs before every StoreLogs returns, but concurrent
+	// calls share a single sync.
+	FsyncGroupCommit
+
+	// FsyncInterval syncs every FsyncInterval in the background. Entries
+	// written since the last sync may be lost in a crash.
+	FsyncInterval
+)
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncEveryWrite:
+		return "FsyncEveryWrite"
+	case FsyncGroupCommit:
+		return "FsyncGroupCommit"
+	case FsyncInterval:
+		return "FsyncInterval"
+	default:
+		return fmt.Sprintf("FsyncPolicy(%d)", p)
+	}
+}
+
+// FileLogStoreConfig encapsulates configuration for a FileLogStore.
+type FileLogStoreConfig struct {
+	// SegmentSize is the size in bytes a segment reaches before a new one
+	// is started. Defaults to DefaultSegmentSize.
+	SegmentSize int64
+
+	// FsyncPolicy controls when written entries are synced to disk.
+	FsyncPolicy FsyncPolicy
+
+	// FsyncInterval is how often entries are synced with FsyncInterval.
+	// Defaults to DefaultFsyncInterval.
+	FsyncInterval time.Duration
+
+	Logger *log.Logger
+}
+
+// FileLogStore implements the LogStore interface with a segmented
+// write-ahead log on the local disk.
+type FileLogStore struct {
+	path          string
+	segmentSize   int64
+	fsyncPolicy   FsyncPolicy
+	fsyncInterval time.Duration
+	logger        *log.Logger
+
+	// lock protects segments and the records in them
+	lock     sync.RWMutex
+	segments []*walSegment
+	// written counts writes, synced the writes known to be on disk
+	written uint64
+
+	// syncLock serializes syncs with each other and with deletions, so
+	// that a segment is not closed while being synced
+	syncLock sync.Mutex
+	synced   uint64
+
+	shutdown   bool
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+// walSegment is a segment file of a FileLogStore.
+type walSegment struct {
+	// base is the index of the first record in the file, first the index of
+	// the first record not deleted
+	base  uint64
+	first uint64
+	// offsets holds the offset in the file of each record, starting at base
+	offsets []int64
+	size    int64
+	file    *os.File
+}
+
+// lastIndex returns the index of the last record in the segment.
+func (s *walSegment) lastIndex() uint64 {
+	return s.base + uint64(len(s.offsets)) - 1
+}
+
+// walBatch holds records encoded for the active segment but not yet
+// written to it, with their offsets in the segment. The offsets are added to
+// the segment only once the records are written.
+type walBatch struct {
+	buf     bytes.Buffer
+	offsets []int64
+}
+
+// NewFileLogStore opens, or creates, a segmented write-ahead log in a
+// directory under base. Torn records at the end of the log are truncated.
+// Params:
+//   - base: directory to store the log under.
+//   - config: configuration of the store, or nil for the defaults.
+// Returns: the store, and an error if the log cannot be opened or a record
+// other than the last is corrupt.
+func NewFileLogStore(base string, config *FileLogStoreConfig) (*FileLogStore, error) {
+	if config == nil {
+		config = &FileLogStoreConfig{}
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	segmentSize := config.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	fsyncInterval := config.FsyncInterval
+	if fsyncInterval <= 0 {
+		fsyncInterval = DefaultFsyncInterval
+	}
+
+	// Ensure our path exists
+	path := filepath.Join(base, walPath)
+	if err := os.MkdirAll(path, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("log path not accessible: %v", err)
+	}
+
+	store := &FileLogStore{
+		path:          path,
+		segmentSize:   segmentSize,
+		fsyncPolicy:   config.FsyncPolicy,
+		fsyncInterval: fsyncInterval,
+		logger:        logger,
+		shutdownCh:    make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+	if err := store.recover(); err != nil {
+		store.closeSegments()
+		return nil, err
+	}
+	if store.fsyncPolicy == FsyncInterval {
+		go store.runFsync()
+	} else {
+		close(store.doneCh)
+	}
+	return store, nil
+}
+
+// segmentName returns the name of the file of a segment.
+func segmentName(base, first uint64) string {
+	return fmt.Sprintf("%020d-%020d%s", base, first, walSegmentSuffix)
+}
+
+// parseSegmentName parses the name of the file of a segment.
+func parseSegmentName(name string) (base, first uint64, err error) {
+	if _, err = fmt.Sscanf(strings.TrimSuffix(name, walSegmentSuffix), "%d-%d", &base, &first); err != nil {
+		return 0, 0, fmt.Errorf("bad segment name %q: %v", name, err)
+	}
+	if first < base {
+		return 0, 0, fmt.Errorf("bad segment name %q: first index before base", name)
+	}
+	return base, first, nil
+}
+
+// recover opens every segment, validating its records, and truncates a
+// torn tail of the last segment.
+func (f *FileLogStore) recover() error {
+	entries, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to scan log dir: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), walSegmentSuffix) {
+			continue
+		}
+		base, first, err := parseSegmentName(entry.Name())
+		if err != nil {
+			return err
+		}
+		f.segments = append(f.segments, &walSegment{base: base, first: first})
+	}
+	sort.Slice(f.segments, func(i, j int) bool {
+		return f.segments[i].base < f.segments[j].base
+	})
+
+	segments := f.segments
+	f.segments = nil
+	for i, seg := range segments {
+		name := filepath.Join(f.path, segmentName(seg.base, seg.first))
+		fh, err := os.OpenFile(name, os.O_RDWR, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %v", err)
+		}
+		seg.file = fh
+		f.segments = append(f.segments, seg)
+
+		if err := f.scanSegment(seg); err != nil {
+			if i != len(segments)-1 {
+				return fmt.Errorf("segment %s corrupt: %v", name, err)
+			}
+			f.logger.Printf("[WARN] raft: Truncating torn log tail of segment %s at offset %d: %v", name, seg.size, err)
+			metrics.IncrCounter([]string{"raft", "filelog", "tornTail"}, 1)
+			if err := seg.file.Truncate(seg.size); err != nil {
+				return fmt.Errorf("failed to truncate segment: %v", err)
+			}
+			if err := seg.file.Sync(); err != nil {
+				return fmt.Errorf("failed to sync segment: %v", err)
+			}
+		}
+
+		// Drop segments with no records left
+		if len(seg.offsets) == 0 || seg.first > seg.lastIndex() {
+			f.segments = f.segments[:len(f.segments)-1]
+			if err := f.removeSegment(seg); err != nil {
+				return err
+			}
+			continue
+		}
+		if n := len(f.segments); n > 1 && seg.first <= f.segments[n-2].lastIndex() {
+			return fmt.Errorf("segment %s overlaps the previous segment", name)
+		}
+	}
+	return nil
+}
+
+// scanSegment reads the offsets of the records of a segment, setting its
+// size to the end of the last valid record.
+func (f *FileLogStore) scanSegment(seg *walSegment) error {
+	info, err := seg.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+	var entry Log
+	for seg.size < fileSize {
+		payload, err := readWALRecord(seg.file, seg.size, fileSize)
+		if err != nil {
+			return err
+		}
+		entry = Log{}
+		if err := decodeMsgPack(payload, &entry); err != nil {
+			return fmt.Errorf("failed to decode record: %v", err)
+		}
+		if expected := seg.base + uint64(len(seg.offsets)); entry.Index != expected {
+			return fmt.Errorf("record has index %d, expected %d", entry.Index, expected)
+		}
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size += walRecordHeaderLen + int64(len(payload))
+	}
+	return nil
+}
+
+// readWALRecord reads and verifies the record at an offset in a segment.
+// Params:
+//   - r: segment file.
+//   - offset: offset of the record.
+//   - limit: size of the segment, which the record must not extend past.
+// Returns: the encoded entry, and an error if the record is incomplete or
+// its checksum does not match.
+func readWALRecord(r io.ReaderAt, offset, limit int64) ([]byte, error) {
+	if limit-offset < walRecordHeaderLen {
+		return nil, fmt.Errorf("incomplete record header")
+	}
+	var header [walRecordHeaderLen]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, fmt.Errorf("failed to read record header: %v", err)
+	}
+	length := int64(binary.BigEndian.Uint32(header[4:]))
+	if limit-offset-walRecordHeaderLen < length {
+		return nil, fmt.Errorf("incomplete record")
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+walRecordHeaderLen); err != nil {
+		return nil, fmt.Errorf("failed to read record: %v", err)
+	}
+	crc := crc32.Update(crc32.Checksum(header[4:], walCRCTable), walCRCTable, payload)
+	if crc != binary.BigEndian.Uint32(header[:4]) {
+		return nil, fmt.Errorf("record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// appendWALRecord frames an encoded entry with its length and checksum.
+func appendWALRecord(buf *bytes.Buffer, payload []byte) {
+	var header [walRecordHeaderLen]byte
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	crc := crc32.Update(crc32.Checksum(header[4:], walCRCTable), walCRCTable, payload)
+	binary.BigEndian.PutUint32(header[:4], crc)
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+// FirstIndex implements the LogStore interface.
+func (f *FileLogStore) FirstIndex() (uint64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if len(f.segments) == 0 {
+		return 0, nil
+	}
+	return f.segments[0].first, nil
+}
+
+// LastIndex implements the LogStore interface.
+func (f *FileLogStore) LastIndex() (uint64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if len(f.segments) == 0 {
+		return 0, nil
+	}
+	return f.segments[len(f.segments)-1].lastIndex(), nil
+}
+
+// DiskUsage implements the LogDiskUsage interface.
+func (f *FileLogStore) DiskUsage() (uint64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var usage uint64
+	for _, seg := range f.segments {
+		usage += uint64(seg.size)
+	}
+	return usage, nil
+}
+
+// findSegment returns the segment holding an index, or nil.
+func (f *FileLogStore) findSegment(index uint64) *walSegment {
+	i := sort.Search(len(f.segments), func(i int) bool {
+		return f.segments[i].lastIndex() >= index
+	})
+	if i == len(f.segments) || f.segments[i].first > index {
+		return nil
+	}
+	return f.segments[i]
+}
+
+// GetLog implements the LogStore interface.
+func (f *FileLogStore) GetLog(index uint64, log *Log) error {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	seg := f.findSegment(index)
+	if seg == nil {
+		return ErrLogNotFound
+	}
+	payload, err := readWALRecord(seg.file, seg.offsets[index-seg.base], seg.size)
+	if err != nil {
+		return fmt.Errorf("failed to read log %d: %v", index, err)
+	}
+	*log = Log{}
+	if err := decodeMsgPack(payload, log); err != nil {
+		return fmt.Errorf("failed to decode log %d: %v", index, err)
+	}
+	return nil
+}
+
+// StoreLog implements the LogStore interface.
+func (f *FileLogStore) StoreLog(log *Log) error {
+	return f.StoreLogs([]*Log{log})
+}
+
+// StoreLogs implements the LogStore interface. Entries must have
+// increasing indexes. Entries at or before the last index replace it and
+// every later entry, and entries after a gap start a new segment.
+func (f *FileLogStore) StoreLogs(logs []*Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	defer metrics.MeasureSince([]string{"raft", "filelog", "storeLogs"}, time.Now())
+	for i := 1; i < len(logs); i++ {
+		if logs[i].Index <= logs[i-1].Index {
+			return fmt.Errorf("log indexes not increasing: %d follows %d", logs[i].Index, logs[i-1].Index)
+		}
+	}
+
+	f.lock.Lock()
+	if f.shutdown {
+		f.lock.Unlock()
+		return fmt.Errorf("log store closed")
+	}
+	if err := f.truncateSuffixLocked(logs[0].Index); err != nil {
+		f.lock.Unlock()
+		return err
+	}
+
+	// If any record cannot be written, none of the batch is kept, so that
+	// the segments never index records that are not in their files
+	segments, offsets, size := len(f.segments), 0, int64(0)
+	if segments > 0 {
+		offsets, size = len(f.segments[segments-1].offsets), f.segments[segments-1].size
+	}
+	fail := func(err error) error {
+		f.rollbackLocked(segments, offsets, size)
+		f.lock.Unlock()
+		return err
+	}
+	var batch walBatch
+	for _, l := range logs {
+		payload, err := encodeMsgPack(l)
+		if err != nil {
+			return fail(fmt.Errorf("failed to encode log %d: %v", l.Index, err))
+		}
+		seg, err := f.segmentForLocked(l.Index, &batch)
+		if err != nil {
+			return fail(err)
+		}
+		batch.offsets = append(batch.offsets, seg.size+int64(batch.buf.Len()))
+		appendWALRecord(&batch.buf, payload.Bytes())
+	}
+	if err := f.flushLocked(&batch); err != nil {
+		return fail(err)
+	}
+	f.written++
+	written := f.written
+	if f.fsyncPolicy == FsyncEveryWrite {
+		// Earlier segments were synced when rotated
+		start := time.Now()
+		if err := f.segments[len(f.segments)-1].file.Sync(); err != nil {
+			return fail(fmt.Errorf("failed to sync segment: %v", err))
+		}
+		metrics.MeasureSince([]string{"raft", "filelog", "fsync"}, start)
+		f.lock.Unlock()
+		return nil
+	}
+	f.lock.Unlock()
+
+	if f.fsyncPolicy == FsyncGroupCommit {
+		return f.sync(written)
+	}
+	return nil
+}
+
+// segmentForLocked returns the segment to append a record to, flushing
+// records batched for the active segment and rotating it if it is full or
+// the record does not follow its last one. Must be called with the lock
+// held.
+func (f *FileLogStore) segmentForLocked(index uint64, batch *walBatch) (*walSegment, error) {
+	if n := len(f.segments); n > 0 {
+		active := f.segments[n-1]
+		next := active.lastIndex() + uint64(len(batch.offsets)) + 1
+		if index == next && active.size+int64(batch.buf.Len()) < f.segmentSize {
+			return active, nil
+		}
+		if err := f.flushLocked(batch); err != nil {
+			return nil, err
+		}
+		if err := active.file.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync segment: %v", err)
+		}
+	}
+
+	name := filepath.Join(f.path, segmentName(index, index))
+	fh, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %v", err)
+	}
+	if err := syncDir(f.path); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	seg := &walSegment{base: index, first: index, file: fh}
+	f.segments = append(f.segments, seg)
+	return seg, nil
+}
+
+// flushLocked writes records batched for the active segment, then adds
+// their offsets to it. Must be called with the lock held.
+func (f *FileLogStore) flushLocked(batch *walBatch) error {
+	if batch.buf.Len() == 0 {
+		return nil
+	}
+	active := f.segments[len(f.segments)-1]
+	if _, err := active.file.WriteAt(batch.buf.Bytes(), active.size); err != nil {
+		return fmt.Errorf("failed to write segment: %v", err)
+	}
+	active.offsets = append(active.offsets, batch.offsets...)
+	active.size += int64(batch.buf.Len())
+	batch.buf.Reset()
+	batch.offsets = batch.offsets[:0]
+	return nil
+}
+
+// rollbackLocked undoes a failed StoreLogs, removing the segments it
+// created and truncating the segment that was active before it, which may
+// hold records flushed before a rotation or part of a failed write. Must be
+// called with the lock held.
+// Params:
+//   - segments: number of segments before the write.
+//   - offsets: number of records in the last of them.
+//   - size: size of the last of them.
+func (f *FileLogStore) rollbackLocked(segments, offsets int, size int64) {
+	for len(f.segments) > segments {
+		seg := f.segments[len(f.segments)-1]
+		f.segments = f.segments[:len(f.segments)-1]
+		if err := f.removeSegment(seg); err != nil {
+			f.logger.Printf("[ERR] raft: Failed to remove segment after a failed write: %v", err)
+		}
+	}
+	if segments == 0 {
+		return
+	}
+	active := f.segments[segments-1]
+	active.offsets = active.offsets[:offsets]
+	active.size = size
+	if err := active.file.Truncate(size); err != nil {
+		f.logger.Printf("[ERR] raft: Failed to truncate segment after a failed write: %v", err)
+	}
+}
+
+// sync syncs the active segment, unless a sync since a write already did.
+// Params:
+//   - written: count of writes that must be on disk.
+func (f *FileLogStore) sync(written uint64) error {
+	f.syncLock.Lock()
+	defer f.syncLock.Unlock()
+	if f.synced >= written {
+		metrics.IncrCounter([]string{"raft", "filelog", "groupCommit"}, 1)
+		return nil
+	}
+
+	f.lock.RLock()
+	written = f.written
+	var active *walSegment
+	if len(f.segments) > 0 {
+		active = f.segments[len(f.segments)-1]
+	}
+	f.lock.RUnlock()
+
+	if active != nil {
+		start := time.Now()
+		// A segment removed meanwhile holds no records left to sync
+		if err := active.file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return fmt.Errorf("failed to sync segment: %v", err)
+		}
+		metrics.MeasureSince([]string{"raft", "filelog", "fsync"}, start)
+	}
+	f.synced = written
+	return nil
+}
+
+// runFsync syncs periodically, with FsyncInterval.
+func (f *FileLogStore) runFsync() {
+	defer close(f.doneCh)
+	ticker := time.NewTicker(f.fsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.lock.RLock()
+			written := f.written
+			f.lock.RUnlock()
+			if err := f.sync(written); err != nil {
+				f.logger.Printf("[ERR] raft: Failed to sync log: %v", err)
+			}
+		case <-f.shutdownCh:
+			return
+		}
+	}
+}
+
+// DeleteRange implements the LogStore interface. Only a prefix or a suffix
+// of the log may be deleted.
+func (f *FileLogStore) DeleteRange(min, max uint64) error {
+	if min > max {
+		return nil
+	}
+	f.syncLock.Lock()
+	defer f.syncLock.Unlock()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.segments) == 0 {
+		return nil
+	}
+	first := f.segments[0].first
+	last := f.segments[len(f.segments)-1].lastIndex()
+	switch {
+	case max >= last:
+		return f.truncateSuffixLocked(min)
+	case min <= first:
+		return f.truncatePrefixLocked(max + 1)
+	default:
+		return fmt.Errorf("cannot delete logs %d to %d from the middle of the log", min, max)
+	}
+}
+
+// truncatePrefixLocked deletes every record before an index. Must be called
+// with the lock held.
+func (f *FileLogStore) truncatePrefixLocked(index uint64) error {
+	for len(f.segments) > 0 {
+		seg := f.segments[0]
+		if seg.lastIndex() >= index {
+			if seg.first < index {
+				// Record the new first index in the segment's name
+				oldName := filepath.Join(f.path, segmentName(seg.base, seg.first))
+				newName := filepath.Join(f.path, segmentName(seg.base, index))
+				if err := os.Rename(oldName, newName); err != nil {
+					return fmt.Errorf("failed to rename segment: %v", err)
+				}
+				seg.first = index
+				return syncDir(f.path)
+			}
+			return nil
+		}
+		f.segments = f.segments[1:]
+		if err := f.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateSuffixLocked deletes a record and every later record. Must be
+// called with the lock held.
+func (f *FileLogStore) truncateSuffixLocked(index uint64) error {
+	for len(f.segments) > 0 {
+		seg := f.segments[len(f.segments)-1]
+		if seg.lastIndex() < index {
+			return nil
+		}
+		if seg.first < index {
+			seg.size = seg.offsets[index-seg.base]
+			seg.offsets = seg.offsets[:index-seg.base]
+			if err := seg.file.Truncate(seg.size); err != nil {
+				return fmt.Errorf("failed to truncate segment: %v", err)
+			}
+			if err := seg.file.Sync(); err != nil {
+				return fmt.Errorf("failed to sync segment: %v", err)
+			}
+			return nil
+		}
+		f.segments = f.segments[:len(f.segments)-1]
+		if err := f.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegment closes and deletes the file of a segment.
+func (f *FileLogStore) removeSegment(seg *walSegment) error {
+	if seg.file != nil {
+		seg.file.Close()
+	}
+	if err := os.Remove(filepath.Join(f.path, segmentName(seg.base, seg.first))); err != nil {
+		return fmt.Errorf("failed to remove segment: %v", err)
+	}
+	return syncDir(f.path)
+}
+
+// Close syncs and closes the log.
+func (f *FileLogStore) Close() error {
+	f.lock.Lock()
+	if f.shutdown {
+		f.lock.Unlock()
+		return nil
+	}
+	f.shutdown = true
+	written := f.written
+	f.lock.Unlock()
+
+	close(f.shutdownCh)
+	<-f.doneCh
+	err := f.sync(written)
+
+	f.syncLock.Lock()
+	defer f.syncLock.Unlock()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closeSegments()
+	return err
+}
+
+// closeSegments closes the files of all segments.
+func (f *FileLogStore) closeSegments() {
+	for _, seg := range f.segments {
+		if seg.file != nil {
+			seg.file.Close()
+		}
+	}
+}
+
+// syncDir syncs a directory, so that files created, renamed or removed in
+// it persist.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dir: %v", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dir: %v", err)
+	}
+	return nil
+}
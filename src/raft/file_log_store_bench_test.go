@@ -0,0 +1,56 @@
+package raft_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/raft/bench"
+)
+
+func benchFileLog(b *testing.B, policy raft.FsyncPolicy, fn func(*testing.B, raft.LogStore)) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := raft.NewFileLogStore(dir, &raft.FileLogStoreConfig{FsyncPolicy: policy})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	defer store.Close()
+	fn(b, store)
+}
+
+func BenchmarkFileLogStore_FirstIndex(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.FirstIndex)
+}
+
+func BenchmarkFileLogStore_LastIndex(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.LastIndex)
+}
+
+func BenchmarkFileLogStore_GetLog(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.GetLog)
+}
+
+func BenchmarkFileLogStore_StoreLog(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.StoreLog)
+}
+
+func BenchmarkFileLogStore_StoreLogs(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.StoreLogs)
+}
+
+func BenchmarkFileLogStore_StoreLog_FsyncInterval(b *testing.B) {
+	benchFileLog(b, raft.FsyncInterval, raftbench.StoreLog)
+}
+
+func BenchmarkFileLogStore_StoreLogs_FsyncInterval(b *testing.B) {
+	benchFileLog(b, raft.FsyncInterval, raftbench.StoreLogs)
+}
+
+func BenchmarkFileLogStore_DeleteRange(b *testing.B) {
+	benchFileLog(b, raft.FsyncEveryWrite, raftbench.DeleteRange)
+}
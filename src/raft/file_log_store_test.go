@@ -0,0 +1,485 @@
+package raft
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func FileLogTest(t *testing.T, config *FileLogStoreConfig) (string, *FileLogStore) {
+	// Create a test dir
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	return dir, openFileLog(t, dir, config)
+}
+
+func openFileLog(t *testing.T, dir string, config *FileLogStoreConfig) *FileLogStore {
+	if config == nil {
+		config = &FileLogStoreConfig{}
+	}
+	config.Logger = newTestLogger(t)
+	store, err := NewFileLogStore(dir, config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return store
+}
+
+func testWALLogs(first, last uint64) []*Log {
+	var logs []*Log
+	for i := first; i <= last; i++ {
+		logs = append(logs, &Log{
+			Index:    i,
+			Term:     1,
+			Type:     LogCommand,
+			Data:     []byte(fmt.Sprintf("data%d", i)),
+			ClientID: 7,
+			SeqNo:    i,
+			Keys:     []Key{Key(fmt.Sprintf("key%d", i))},
+		})
+	}
+	return logs
+}
+
+// checkFileLog checks that a store holds exactly the logs from first to
+// last.
+func checkFileLog(t *testing.T, store *FileLogStore, first, last uint64) {
+	t.Helper()
+	if idx, err := store.FirstIndex(); err != nil || idx != first {
+		t.Fatalf("bad first index: %d %v", idx, err)
+	}
+	if idx, err := store.LastIndex(); err != nil || idx != last {
+		t.Fatalf("bad last index: %d %v", idx, err)
+	}
+	if first == 0 {
+		return
+	}
+	for i, expected := range testWALLogs(first, last) {
+		var out Log
+		if err := store.GetLog(expected.Index, &out); err != nil {
+			t.Fatalf("log %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(&out, expected) {
+			t.Fatalf("bad log: %#v %#v", out, expected)
+		}
+	}
+	var out Log
+	if err := store.GetLog(first-1, &out); err != ErrLogNotFound {
+		t.Fatalf("expected not found before first, got: %v", err)
+	}
+	if err := store.GetLog(last+1, &out); err != ErrLogNotFound {
+		t.Fatalf("expected not found after last, got: %v", err)
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, walPath, "*"+walSegmentSuffix))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func TestFileLogStoreImpl(t *testing.T) {
+	var impl interface{} = &FileLogStore{}
+	if _, ok := impl.(LogStore); !ok {
+		t.Fatalf("FileLogStore not a LogStore")
+	}
+}
+
+func TestFileLogStore_StoreAndReopen(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncEveryWrite, FsyncGroupCommit, FsyncInterval} {
+		t.Run(policy.String(), func(t *testing.T) {
+			dir, store := FileLogTest(t, &FileLogStoreConfig{FsyncPolicy: policy})
+			defer os.RemoveAll(dir)
+			checkFileLog(t, store, 0, 0)
+
+			if err := store.StoreLog(testWALLogs(1, 1)[0]); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if err := store.StoreLogs(testWALLogs(2, 20)); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			checkFileLog(t, store, 1, 20)
+			if err := store.Close(); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if err := store.StoreLogs(testWALLogs(21, 21)); err == nil {
+				t.Fatalf("expected error storing to closed store")
+			}
+
+			store = openFileLog(t, dir, &FileLogStoreConfig{FsyncPolicy: policy})
+			defer store.Close()
+			checkFileLog(t, store, 1, 20)
+		})
+	}
+}
+
+func TestFileLogStore_Rotation(t *testing.T) {
+	config := &FileLogStoreConfig{SegmentSize: 256}
+	dir, store := FileLogTest(t, config)
+	defer os.RemoveAll(dir)
+
+	for i := uint64(1); i <= 100; i += 10 {
+		if err := store.StoreLogs(testWALLogs(i, i+9)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	checkFileLog(t, store, 1, 100)
+	segments := segmentFiles(t, dir)
+	if len(segments) < 10 {
+		t.Fatalf("expected many segments, got %v", segments)
+	}
+	for _, name := range segments {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		// A segment is rotated once it reaches the size of one more record
+		if info.Size() > 2*config.SegmentSize {
+			t.Fatalf("segment %s too large: %d", name, info.Size())
+		}
+	}
+	store.Close()
+
+	store = openFileLog(t, dir, config)
+	defer store.Close()
+	checkFileLog(t, store, 1, 100)
+}
+
+func TestFileLogStore_DeleteRange(t *testing.T) {
+	config := &FileLogStoreConfig{SegmentSize: 256}
+	dir, store := FileLogTest(t, config)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 100)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	before := len(segmentFiles(t, dir))
+
+	// Compaction deletes whole segments, and renames a segment to drop a
+	// prefix of it
+	if err := store.DeleteRange(1, 45); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 46, 100)
+	if after := len(segmentFiles(t, dir)); after >= before {
+		t.Fatalf("segments not deleted: %d %d", before, after)
+	}
+
+	// Conflicts delete a suffix
+	if err := store.DeleteRange(90, 100); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 46, 89)
+
+	// The middle of the log cannot be deleted
+	if err := store.DeleteRange(50, 60); err == nil {
+		t.Fatalf("expected error deleting middle of log")
+	}
+
+	// Deletions persist
+	store.Close()
+	store = openFileLog(t, dir, config)
+	checkFileLog(t, store, 46, 89)
+
+	// Deleting everything leaves no segments
+	if err := store.DeleteRange(46, 89); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 0, 0)
+	if segments := segmentFiles(t, dir); len(segments) != 0 {
+		t.Fatalf("expected no segments, got %v", segments)
+	}
+	if err := store.StoreLogs(testWALLogs(200, 210)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 200, 210)
+	store.Close()
+}
+
+func TestFileLogStore_Overwrite(t *testing.T) {
+	dir, store := FileLogTest(t, &FileLogStoreConfig{SegmentSize: 256})
+	defer os.RemoveAll(dir)
+	defer store.Close()
+	if err := store.StoreLogs(testWALLogs(1, 30)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Storing at an earlier index replaces the rest of the log
+	logs := testWALLogs(10, 12)
+	for _, l := range logs {
+		l.Term = 2
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if idx, _ := store.LastIndex(); idx != 12 {
+		t.Fatalf("bad last index: %d", idx)
+	}
+	var out Log
+	if err := store.GetLog(11, &out); err != nil || out.Term != 2 {
+		t.Fatalf("bad log: %#v %v", out, err)
+	}
+	if err := store.GetLog(9, &out); err != nil || out.Term != 1 {
+		t.Fatalf("bad log: %#v %v", out, err)
+	}
+
+	// Entries must be in order
+	bad := []*Log{{Index: 14}, {Index: 13}}
+	if err := store.StoreLogs(bad); err == nil {
+		t.Fatalf("expected error storing logs out of order")
+	}
+}
+
+func TestFileLogStore_Gap(t *testing.T) {
+	dir, store := FileLogTest(t, nil)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 10)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Logs following an installed snapshot may leave a gap
+	if err := store.StoreLogs(testWALLogs(100, 110)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var out Log
+	if err := store.GetLog(50, &out); err != ErrLogNotFound {
+		t.Fatalf("expected not found in gap, got: %v", err)
+	}
+	if err := store.GetLog(105, &out); err != nil || out.Index != 105 {
+		t.Fatalf("bad log: %#v %v", out, err)
+	}
+	if err := store.DeleteRange(1, 99); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 100, 110)
+	store.Close()
+
+	store = openFileLog(t, dir, nil)
+	defer store.Close()
+	checkFileLog(t, store, 100, 110)
+}
+
+func TestFileLogStore_TornTail(t *testing.T) {
+	config := &FileLogStoreConfig{SegmentSize: 512}
+	dir, store := FileLogTest(t, config)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 40)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	store.Close()
+
+	// Tear the last record
+	segments := segmentFiles(t, dir)
+	last := segments[len(segments)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Truncate(last, info.Size()-3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	store = openFileLog(t, dir, config)
+	checkFileLog(t, store, 1, 39)
+
+	// The log continues after the truncated tail
+	if err := store.StoreLogs(testWALLogs(40, 45)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 1, 45)
+	store.Close()
+
+	// Garbage after the last record is truncated
+	segments = segmentFiles(t, dir)
+	fh, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fh.Write([]byte{0, 0, 0, 0, 0, 0, 0, 5, 'j', 'u', 'n', 'k', '!'})
+	fh.Close()
+
+	store = openFileLog(t, dir, config)
+	defer store.Close()
+	checkFileLog(t, store, 1, 45)
+}
+
+func TestFileLogStore_Corruption(t *testing.T) {
+	config := &FileLogStoreConfig{SegmentSize: 256}
+	dir, store := FileLogTest(t, config)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 40)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	store.Close()
+
+	// Corrupting a segment other than the last is not a torn write
+	segments := segmentFiles(t, dir)
+	fh, err := os.OpenFile(segments[0], os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fh.WriteAt([]byte{0xff}, walRecordHeaderLen+2)
+	fh.Close()
+
+	config.Logger = newTestLogger(t)
+	if _, err := NewFileLogStore(dir, config); err == nil {
+		t.Fatalf("expected corruption error")
+	}
+}
+
+func TestFileLogStore_WriteFailure(t *testing.T) {
+	dir, store := FileLogTest(t, nil)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 10)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Fail the next write by swapping in a read-only handle
+	seg := store.segments[len(store.segments)-1]
+	ro, err := os.Open(seg.file.Name())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rw := seg.file
+	seg.file = ro
+	if err := store.StoreLogs(testWALLogs(11, 20)); err == nil {
+		t.Fatalf("expected write error")
+	}
+	seg.file = rw
+	ro.Close()
+
+	// None of the failed batch is indexed, and a retry is stored after the
+	// records already there
+	checkFileLog(t, store, 1, 10)
+	if err := store.StoreLogs(testWALLogs(11, 20)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 1, 20)
+	store.Close()
+
+	store = openFileLog(t, dir, nil)
+	defer store.Close()
+	checkFileLog(t, store, 1, 20)
+}
+
+func TestFileLogStore_RotationFailure(t *testing.T) {
+	dir, store := FileLogTest(t, nil)
+	defer os.RemoveAll(dir)
+	if err := store.StoreLogs(testWALLogs(1, 3)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The gap in the batch starts a new segment at 10, after 4 to 6 are
+	// written to the active one. A directory in its place fails the rotation.
+	blocker := filepath.Join(dir, walPath, segmentName(10, 10))
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	logs := append(testWALLogs(4, 6), testWALLogs(10, 11)...)
+	if err := store.StoreLogs(logs); err == nil {
+		t.Fatalf("expected rotation error")
+	}
+	os.Remove(blocker)
+
+	// The records written before the rotation are rolled back too
+	checkFileLog(t, store, 1, 3)
+	if segments := segmentFiles(t, dir); len(segments) != 1 {
+		t.Fatalf("expected one segment, got %v", segments)
+	}
+	if err := store.StoreLogs(testWALLogs(4, 20)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkFileLog(t, store, 1, 20)
+	store.Close()
+
+	store = openFileLog(t, dir, nil)
+	defer store.Close()
+	checkFileLog(t, store, 1, 20)
+}
+
+func TestFileLogStore_GroupCommit(t *testing.T) {
+	config := &FileLogStoreConfig{FsyncPolicy: FsyncGroupCommit}
+	dir, store := FileLogTest(t, config)
+	defer os.RemoveAll(dir)
+
+	// Writers append in order from many goroutines
+	const writers = 8
+	const perWriter = 20
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	next := uint64(1)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				lock.Lock()
+				err := store.StoreLogs(testWALLogs(next, next))
+				next++
+				lock.Unlock()
+				if err != nil {
+					t.Errorf("err: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if store.synced != store.written {
+		t.Fatalf("writes not synced: %d of %d", store.synced, store.written)
+	}
+	store.Close()
+
+	store = openFileLog(t, dir, config)
+	defer store.Close()
+	checkFileLog(t, store, 1, writers*perWriter)
+}
+
+func TestFileLogStore_DiskUsage(t *testing.T) {
+	dir, store := FileLogTest(t, &FileLogStoreConfig{SegmentSize: 256})
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	fileSizes := func() uint64 {
+		var total uint64
+		for _, name := range segmentFiles(t, dir) {
+			info, err := os.Stat(name)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			total += uint64(info.Size())
+		}
+		return total
+	}
+	if err := store.StoreLogs(testWALLogs(1, 50)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	usage, err := store.DiskUsage()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if usage == 0 || usage != fileSizes() {
+		t.Fatalf("bad usage: %d, files hold %d", usage, fileSizes())
+	}
+
+	// Deleting a prefix frees its segments
+	if err := store.DeleteRange(1, 40); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	after, err := store.DiskUsage()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if after >= usage || after != fileSizes() {
+		t.Fatalf("bad usage after delete: %d, was %d, files hold %d", after, usage, fileSizes())
+	}
+}
@@ -3,6 +3,7 @@ package raft
 import (
 	"bufio"
 	"bytes"
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
 	"hash"
@@ -32,6 +33,37 @@ type FileSnapshotStore struct {
 	path   string
 	retain int
 	logger *log.Logger
+
+	// retention decides which snapshots to keep. If it is not a count of
+	// snapshots, retain is 0 and List returns all of them.
+	retention SnapshotRetentionPolicy
+
+	// Compression and encryption applied to new snapshots. aead is nil if
+	// snapshots are not encrypted.
+	compression SnapshotCompression
+	aead        cipher.AEAD
+	keyID       string
+}
+
+// FileSnapshotStoreConfig configures a FileSnapshotStore.
+type FileSnapshotStoreConfig struct {
+	// Retain controls how many snapshots are retained. Must be at least 1,
+	// unless Retention is set.
+	Retain int
+
+	// Retention decides which snapshots are retained, instead of Retain.
+	Retention SnapshotRetentionPolicy
+
+	// Logger to log to. Nil logs to stderr.
+	Logger *log.Logger
+
+	// Compression applied to the state of new snapshots.
+	Compression SnapshotCompression
+
+	// EncryptionKey is an AES key of 16, 24 or 32 bytes. If set, the state
+	// of new snapshots is encrypted with AES-GCM, and snapshots encrypted
+	// with this key can be opened. Nil stores state unencrypted.
+	EncryptionKey []byte
 }
 
 type snapMetaSlice []*fileSnapshotMeta
@@ -48,6 +80,12 @@ type FileSnapshotSink struct {
 	stateHash hash.Hash64
 	buffered  *bufio.Writer
 
+	// state is the writer of the state, which encodes it before it is
+	// buffered. closers must be closed in order to complete the encoding.
+	state   io.Writer
+	closers []io.Closer
+	size    int64
+
 	closed bool
 }
 
@@ -56,12 +94,27 @@ type FileSnapshotSink struct {
 type fileSnapshotMeta struct {
 	SnapshotMeta
 	CRC []byte
+
+	// Size of the state file, which differs from the size of the state if
+	// it is compressed or encrypted. Zero in snapshots written before it
+	// was recorded.
+	StoredSize int64
+
+	// How the state file is encoded. Empty if not compressed or encrypted.
+	Compression SnapshotCompression
+	Encryption  string
+	// KeyID identifies the encryption key.
+	KeyID string
+
+	// Created is when the snapshot was started. Zero in snapshots written
+	// before it was recorded.
+	Created time.Time
 }
 
 // bufferedFile is returned when we open a snapshot. This way
 // reads are buffered and the file still gets closed.
 type bufferedFile struct {
-	bh *bufio.Reader
+	bh io.Reader
 	fh *os.File
 }
 
@@ -77,12 +130,38 @@ func (b *bufferedFile) Close() error {
 // on a base directory. The `retain` parameter controls how many
 // snapshots are retained. Must be at least 1.
 func NewFileSnapshotStoreWithLogger(base string, retain int, logger *log.Logger) (*FileSnapshotStore, error) {
-	if retain < 1 {
+	return NewFileSnapshotStoreWithConfig(base, &FileSnapshotStoreConfig{
+		Retain: retain,
+		Logger: logger,
+	})
+}
+
+// NewFileSnapshotStoreWithConfig creates a new FileSnapshotStore based on
+// a base directory.
+// Params:
+//   - base: directory to store snapshots under.
+//   - config: configuration of the store.
+// Returns: the store, and an error if the configuration is not valid or the
+// directory is not accessible.
+func NewFileSnapshotStoreWithConfig(base string, config *FileSnapshotStoreConfig) (*FileSnapshotStore, error) {
+	retain := config.Retain
+	retention := config.Retention
+	if retention != nil {
+		retain = 0
+	} else if retain < 1 {
 		return nil, fmt.Errorf("must retain at least one snapshot")
+	} else {
+		retention = KeepSnapshotCount(retain)
 	}
+	logger := config.Logger
 	if logger == nil {
 		logger = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	switch config.Compression {
+	case SnapshotCompressionNone, SnapshotCompressionGzip:
+	default:
+		return nil, fmt.Errorf("unsupported snapshot compression %q", config.Compression)
+	}
 
 	// Ensure our path exists
 	path := filepath.Join(base, snapPath)
@@ -92,9 +171,19 @@ func NewFileSnapshotStoreWithLogger(base string, retain int, logger *log.Logger)
 
 	// Setup the store
 	store := &FileSnapshotStore{
-		path:   path,
-		retain: retain,
-		logger: logger,
+		path:        path,
+		retain:      retain,
+		logger:      logger,
+		retention:   retention,
+		compression: config.Compression,
+	}
+	if config.EncryptionKey != nil {
+		aead, err := newSnapshotAEAD(config.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot encryption key: %v", err)
+		}
+		store.aead = aead
+		store.keyID = snapshotKeyID(config.EncryptionKey)
 	}
 
 	// Do a permissions test
@@ -133,22 +222,38 @@ func (f *FileSnapshotStore) testPermissions() error {
 }
 
 // snapshotName generates a name for the snapshot.
-func snapshotName(term, index uint64) string {
-	now := time.Now()
+func snapshotName(term, index uint64, now time.Time) string {
 	msec := now.UnixNano() / int64(time.Millisecond)
 	return fmt.Sprintf("%d-%d-%d", term, index, msec)
 }
 
 // Create is used to start a new snapshot
 func (f *FileSnapshotStore) Create(version SnapshotVersion, index, term uint64,
-	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, trans Transport) (SnapshotSink, error) {
+	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error) {
+	return f.create("", version, index, term, configuration, configurationIndex, nextClientId, clientResponseCache, witnessRecords, trans)
+}
+
+// CreateDelta implements the IncrementalSnapshotStore interface.
+func (f *FileSnapshotStore) CreateDelta(base string, version SnapshotVersion, index, term uint64,
+	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error) {
+	if _, err := f.readMeta(base); err != nil {
+		f.logger.Printf("[ERR] snapshot: Failed to read base snapshot %v: %v", base, err)
+		return nil, err
+	}
+	return f.create(base, version, index, term, configuration, configurationIndex, nextClientId, clientResponseCache, witnessRecords, trans)
+}
+
+// create starts a new snapshot, which is a delta of base unless it is empty.
+func (f *FileSnapshotStore) create(base string, version SnapshotVersion, index, term uint64,
+	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error) {
 	// We only support version 1 snapshots at this time.
 	if version != 1 {
 		return nil, fmt.Errorf("unsupported snapshot version %d", version)
 	}
 
 	// Create a new path
-	name := snapshotName(term, index)
+	now := time.Now()
+	name := snapshotName(term, index, now)
 	path := filepath.Join(f.path, name+tmpSuffix)
 	f.logger.Printf("[INFO] snapshot: Creating new snapshot at %s", path)
 
@@ -170,13 +275,16 @@ func (f *FileSnapshotStore) Create(version SnapshotVersion, index, term uint64,
 				ID:                  name,
 				Index:               index,
 				Term:                term,
+				Base:                base,
 				NextClientId:        nextClientId,
 				ClientResponseCache: clientResponseCache,
+				WitnessRecords:      witnessRecords,
 				Peers:               encodePeers(configuration, trans),
 				Configuration:       configuration,
 				ConfigurationIndex:  configurationIndex,
 			},
-			CRC: nil,
+			CRC:     nil,
+			Created: now,
 		},
 	}
 
@@ -202,6 +310,16 @@ func (f *FileSnapshotStore) Create(version SnapshotVersion, index, term uint64,
 	multi := io.MultiWriter(sink.stateFile, sink.stateHash)
 	sink.buffered = bufio.NewWriter(multi)
 
+	// Encode the state before it is buffered, so the CRC covers the bytes
+	// on disk
+	sink.state, sink.closers, err = f.encodeSnapshotState(sink.buffered, &sink.meta)
+	if err != nil {
+		f.logger.Printf("[ERR] snapshot: Failed to encode state: %v", err)
+		fh.Close()
+		os.RemoveAll(path)
+		return nil, err
+	}
+
 	// Done
 	return sink, nil
 }
@@ -218,7 +336,7 @@ func (f *FileSnapshotStore) List() ([]*SnapshotMeta, error) {
 	var snapMeta []*SnapshotMeta
 	for _, meta := range snapshots {
 		snapMeta = append(snapMeta, &meta.SnapshotMeta)
-		if len(snapMeta) == f.retain {
+		if f.retain > 0 && len(snapMeta) == f.retain {
 			break
 		}
 	}
@@ -272,6 +390,23 @@ func (f *FileSnapshotStore) getSnapshots() ([]*fileSnapshotMeta, error) {
 	return snapMeta, nil
 }
 
+// Chain implements the IncrementalSnapshotStore interface.
+func (f *FileSnapshotStore) Chain(id string) ([]*SnapshotMeta, error) {
+	var chain []*SnapshotMeta
+	for id != "" {
+		meta, err := f.readMeta(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %v of the chain: %v", id, err)
+		}
+		if len(chain) > 0 && meta.Index >= chain[0].Index {
+			return nil, fmt.Errorf("base %v of snapshot %v is not older", id, chain[0].ID)
+		}
+		chain = append([]*SnapshotMeta{&meta.SnapshotMeta}, chain...)
+		id = meta.Base
+	}
+	return chain, nil
+}
+
 // readMeta is used to read the meta data for a given named backup
 func (f *FileSnapshotStore) readMeta(name string) (*fileSnapshotMeta, error) {
 	// Open the meta file
@@ -338,16 +473,26 @@ func (f *FileSnapshotStore) Open(id string) (*SnapshotMeta, io.ReadCloser, error
 		return nil, nil, err
 	}
 
+	// Decode the state
+	state, err := f.decodeSnapshotState(bufio.NewReader(fh), meta)
+	if err != nil {
+		f.logger.Printf("[ERR] snapshot: Failed to decode state file: %v", err)
+		fh.Close()
+		return nil, nil, err
+	}
+
 	// Return a buffered file
 	buffered := &bufferedFile{
-		bh: bufio.NewReader(fh),
+		bh: state,
 		fh: fh,
 	}
 
 	return &meta.SnapshotMeta, buffered, nil
 }
 
-// ReapSnapshots reaps any snapshots beyond the retain count.
+// ReapSnapshots reaps any snapshots the retention policy does not keep.
+// The newest snapshot is always kept, as are the snapshots the deltas kept
+// are based on.
 func (f *FileSnapshotStore) ReapSnapshots() error {
 	snapshots, err := f.getSnapshots()
 	if err != nil {
@@ -355,7 +500,32 @@ func (f *FileSnapshotStore) ReapSnapshots() error {
 		return err
 	}
 
-	for i := f.retain; i < len(snapshots); i++ {
+	infos := make([]*SnapshotRetentionInfo, len(snapshots))
+	positions := make(map[string]int)
+	for i, meta := range snapshots {
+		infos[i] = meta.retentionInfo()
+		positions[meta.ID] = i
+	}
+	keep := f.retention.Retain(infos, time.Now())
+	if len(keep) != len(snapshots) {
+		err := fmt.Errorf("retention policy decided on %d of %d snapshots", len(keep), len(snapshots))
+		f.logger.Printf("[ERR] snapshot: Failed to apply retention policy: %v", err)
+		return err
+	}
+	if len(keep) > 0 {
+		keep[0] = true
+	}
+
+	// Bases are older than their deltas, so they come later
+	for i, meta := range snapshots {
+		if j, ok := positions[meta.Base]; ok && keep[i] {
+			keep[j] = true
+		}
+	}
+	for i := range snapshots {
+		if keep[i] {
+			continue
+		}
 		path := filepath.Join(f.path, snapshots[i].ID)
 		f.logger.Printf("[INFO] snapshot: reaping snapshot %v", path)
 		if err := os.RemoveAll(path); err != nil {
@@ -375,7 +545,9 @@ func (s *FileSnapshotSink) ID() string {
 // Write is used to append to the state file. We write to the
 // buffered IO object to reduce the amount of context switches.
 func (s *FileSnapshotSink) Write(b []byte) (int, error) {
-	return s.buffered.Write(b)
+	n, err := s.state.Write(b)
+	s.size += int64(n)
+	return n, err
 }
 
 // Close is used to indicate a successful end.
@@ -451,6 +623,14 @@ func (s *FileSnapshotSink) Cancel() error {
 
 // finalize is used to close all of our resources.
 func (s *FileSnapshotSink) finalize() error {
+	// Complete the encoding
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil {
+			s.stateFile.Close()
+			return err
+		}
+	}
+
 	// Flush any remaining data
 	if err := s.buffered.Flush(); err != nil {
 		return err
@@ -473,7 +653,8 @@ func (s *FileSnapshotSink) finalize() error {
 	if statErr != nil {
 		return statErr
 	}
-	s.meta.Size = stat.Size()
+	s.meta.Size = s.size
+	s.meta.StoredSize = stat.Size()
 
 	// Set the CRC
 	s.meta.CRC = s.stateHash.Sum(nil)
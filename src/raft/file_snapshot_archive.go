@@ -0,0 +1,284 @@
+package raft
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+
+Inspection, export and import of FileSnapshotStore snapshots, for tools such
+as raftctl. Verify checks a snapshot's state file against the size and CRC in
+its metadata, without decoding it, so snapshots can be checked without their
+encryption key.
+
+Export writes a snapshot to a tar archive, along with the snapshots of its
+chain if it is a delta. Each snapshot is stored as its directory in the
+store, holding meta.json and state.bin as they are on disk, so an archive of
+an encrypted snapshot stays encrypted. Import adds the snapshots of an
+archive to a store, verifying each before moving it into place, so that a
+snapshot is imported whole or not at all.
+
+*/
+
+// FileSnapshotInfo describes a snapshot in a FileSnapshotStore.
+type FileSnapshotInfo struct {
+	SnapshotMeta
+
+	// CRC64 of the state file.
+	CRC []byte
+
+	// Size of the state file. Zero in snapshots written before it was
+	// recorded.
+	StoredSize int64
+
+	// How the state file is encoded. Empty if not compressed or encrypted.
+	Compression SnapshotCompression
+	Encryption  string
+	// KeyID identifies the encryption key.
+	KeyID string
+
+	// Created is when the snapshot was started. Zero in snapshots written
+	// before it was recorded.
+	Created time.Time
+}
+
+// info returns the description of a snapshot.
+func (m *fileSnapshotMeta) info() *FileSnapshotInfo {
+	return &FileSnapshotInfo{
+		SnapshotMeta: m.SnapshotMeta,
+		CRC:          m.CRC,
+		StoredSize:   m.StoredSize,
+		Compression:  m.Compression,
+		Encryption:   m.Encryption,
+		KeyID:        m.KeyID,
+		Created:      m.Created,
+	}
+}
+
+// ListInfo describes all the snapshots in the store, newest first, including
+// those beyond the retain count that List omits.
+func (f *FileSnapshotStore) ListInfo() ([]*FileSnapshotInfo, error) {
+	snapshots, err := f.getSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*FileSnapshotInfo, len(snapshots))
+	for i, meta := range snapshots {
+		infos[i] = meta.info()
+	}
+	return infos, nil
+}
+
+// Info describes a snapshot in the store.
+func (f *FileSnapshotStore) Info(id string) (*FileSnapshotInfo, error) {
+	meta, err := f.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	return meta.info(), nil
+}
+
+// Verify checks that a snapshot's state file has the size and CRC recorded
+// in its metadata.
+func (f *FileSnapshotStore) Verify(id string) error {
+	_, err := verifySnapshotDir(filepath.Join(f.path, id))
+	return err
+}
+
+// verifySnapshotDir reads the metadata of a snapshot directory and checks
+// its state file against it.
+// Params:
+//   - dir: directory of the snapshot.
+// Returns: the metadata, and an error if the snapshot is not valid.
+func verifySnapshotDir(dir string) (*fileSnapshotMeta, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, metaFilePath))
+	if err != nil {
+		return nil, err
+	}
+	meta := &fileSnapshotMeta{}
+	if err := json.Unmarshal(buf, meta); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %v", err)
+	}
+	if meta.Version < SnapshotVersionMin || meta.Version > SnapshotVersionMax {
+		return nil, fmt.Errorf("unsupported snapshot version %d", meta.Version)
+	}
+
+	fh, err := os.Open(filepath.Join(dir, stateFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	stateHash := crc64.New(crc64.MakeTable(crc64.ECMA))
+	size, err := io.Copy(stateHash, fh)
+	if err != nil {
+		return nil, err
+	}
+	if meta.StoredSize > 0 && size != meta.StoredSize {
+		return nil, fmt.Errorf("state file has %d bytes, expected %d", size, meta.StoredSize)
+	}
+	if computed := stateHash.Sum(nil); !bytes.Equal(meta.CRC, computed) {
+		return nil, fmt.Errorf("CRC mismatch (stored: %x computed: %x)", meta.CRC, computed)
+	}
+	return meta, nil
+}
+
+// Export writes a snapshot, and the snapshots of its chain if it is a delta,
+// to a tar archive.
+// Params:
+//   - id: ID of the snapshot.
+//   - w: writer of the archive.
+// Returns: an error if a snapshot of the chain is missing or corrupt.
+func (f *FileSnapshotStore) Export(id string, w io.Writer) error {
+	chain, err := f.Chain(id)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewWriter(w)
+	for _, meta := range chain {
+		if err := f.Verify(meta.ID); err != nil {
+			return fmt.Errorf("snapshot %v: %v", meta.ID, err)
+		}
+		for _, name := range []string{metaFilePath, stateFilePath} {
+			if err := addArchiveFile(archive, filepath.Join(f.path, meta.ID, name), path.Join(meta.ID, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return archive.Close()
+}
+
+// addArchiveFile adds a file to a tar archive.
+// Params:
+//   - archive: writer of the archive.
+//   - file: path of the file.
+//   - name: name of the file in the archive.
+func addArchiveFile(archive *tar.Writer, file, name string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	stat, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, fh)
+	return err
+}
+
+// Import adds the snapshots of an archive written by Export to the store.
+// Each snapshot is verified before it is moved into place, and the import
+// fails if a snapshot already exists, or if a delta's base is neither in
+// the archive nor in the store. Snapshots are not reaped.
+// Params:
+//   - r: reader of the archive.
+// Returns: the IDs of the snapshots imported, oldest first.
+func (f *FileSnapshotStore) Import(r io.Reader) ([]string, error) {
+	// Unpack the snapshots into temporary directories, removing those left
+	// on failure
+	var ids []string
+	defer func() {
+		for _, id := range ids {
+			os.RemoveAll(filepath.Join(f.path, id+tmpSuffix))
+		}
+	}()
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		id, name := path.Split(header.Name)
+		id = strings.TrimSuffix(id, "/")
+		if !validSnapshotID(id) || (name != metaFilePath && name != stateFilePath) {
+			return nil, fmt.Errorf("unexpected file %q in archive", header.Name)
+		}
+		if _, err := os.Stat(filepath.Join(f.path, id)); err == nil {
+			return nil, fmt.Errorf("snapshot %v already exists", id)
+		}
+		dir := filepath.Join(f.path, id+tmpSuffix)
+		if len(ids) == 0 || ids[len(ids)-1] != id {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		if err := writeArchiveFile(archive, filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Verify the snapshots and their chains
+	imported := make(map[string]bool)
+	for _, id := range ids {
+		meta, err := verifySnapshotDir(filepath.Join(f.path, id+tmpSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %v: %v", id, err)
+		}
+		if meta.ID != id {
+			return nil, fmt.Errorf("snapshot %v has ID %v in its metadata", id, meta.ID)
+		}
+		if meta.Base != "" && !imported[meta.Base] {
+			if _, err := f.readMeta(meta.Base); err != nil {
+				return nil, fmt.Errorf("base %v of snapshot %v is missing", meta.Base, id)
+			}
+		}
+		imported[id] = true
+	}
+
+	// Move them into place, bases first
+	for i, id := range ids {
+		if err := os.Rename(filepath.Join(f.path, id+tmpSuffix), filepath.Join(f.path, id)); err != nil {
+			return ids[:i], err
+		}
+		f.logger.Printf("[INFO] snapshot: Imported snapshot %v", id)
+	}
+	return ids, nil
+}
+
+// validSnapshotID returns whether an ID from an archive can name a snapshot
+// directory.
+func validSnapshotID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`) &&
+		!strings.HasSuffix(id, tmpSuffix)
+}
+
+// writeArchiveFile writes the current file of a tar archive and syncs it.
+func writeArchiveFile(archive *tar.Reader, file string) error {
+	fh, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fh, archive); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
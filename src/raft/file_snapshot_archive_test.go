@@ -0,0 +1,223 @@
+package raft
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// createTestSnapshot writes a snapshot to a store, as a delta of base if it
+// is set.
+func createTestSnapshot(t *testing.T, snap *FileSnapshotStore, base string, index uint64, state string) string {
+	_, trans := NewInmemTransport(NewInmemAddr())
+	configuration := Configuration{Servers: []Server{{ID: "id1", Address: "addr1"}}}
+	cache := map[uint64]map[uint64]clientResponseEntry{1: {1: {}, 2: {}}, 2: {1: {}}}
+	var sink SnapshotSink
+	var err error
+	if base == "" {
+		sink, err = snap.Create(SnapshotVersionMax, index, 3, configuration, 1, 3, cache, nil, trans)
+	} else {
+		sink, err = snap.CreateDelta(base, SnapshotVersionMax, index, 3, configuration, 1, 3, cache, nil, trans)
+	}
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := sink.Write([]byte(state)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return sink.ID()
+}
+
+func TestFileSS_Info(t *testing.T) {
+	dir, snap := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	full := createTestSnapshot(t, snap, "", 10, "full")
+	delta := createTestSnapshot(t, snap, full, 20, "delta")
+
+	infos, err := snap.ListInfo()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(infos) != 2 || infos[0].ID != delta || infos[1].ID != full {
+		t.Fatalf("bad snapshots: %v", infos)
+	}
+	info, err := snap.Info(delta)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.Base != full || info.Index != 20 || info.NextClientId != 3 || info.ConfigurationIndex != 1 {
+		t.Fatalf("bad info: %#v", info)
+	}
+	if len(info.ClientResponseCache) != 2 || len(info.ClientResponseCache[1]) != 2 {
+		t.Fatalf("bad client response cache: %v", info.ClientResponseCache)
+	}
+	if info.StoredSize != 5 || len(info.CRC) == 0 || info.Created.IsZero() {
+		t.Fatalf("bad info: %#v", info)
+	}
+}
+
+func TestFileSS_Verify(t *testing.T) {
+	dir, snap := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	id := createTestSnapshot(t, snap, "", 10, "state")
+	if err := snap.Verify(id); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Corrupt the state
+	statePath := filepath.Join(snap.path, id, stateFilePath)
+	if err := ioutil.WriteFile(statePath, []byte("stata"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := snap.Verify(id); err == nil {
+		t.Fatalf("corruption not detected")
+	}
+
+	// Truncate it
+	if err := ioutil.WriteFile(statePath, []byte("sta"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := snap.Verify(id); err == nil {
+		t.Fatalf("truncation not detected")
+	}
+	if err := snap.Verify("missing"); err == nil {
+		t.Fatalf("verified a missing snapshot")
+	}
+}
+
+func TestFileSS_ExportImport(t *testing.T) {
+	dir, snap := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	full := createTestSnapshot(t, snap, "", 10, "full")
+	delta1 := createTestSnapshot(t, snap, full, 20, "delta1")
+	delta2 := createTestSnapshot(t, snap, delta1, 30, "delta2")
+
+	// Exporting a delta includes its chain
+	var archive bytes.Buffer
+	if err := snap.Export(delta2, &archive); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dir2, snap2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	ids, err := snap2.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{full, delta1, delta2}) {
+		t.Fatalf("bad imported snapshots: %v", ids)
+	}
+	chain, err := snap2.Chain(delta2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("bad chain: %v", chain)
+	}
+	for i, state := range []string{"full", "delta1", "delta2"} {
+		meta, source, err := snap2.Open(ids[i])
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf, err := ioutil.ReadAll(source)
+		source.Close()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(buf) != state {
+			t.Fatalf("bad state: %q", buf)
+		}
+		original, _ := snap.Info(ids[i])
+		if !reflect.DeepEqual(meta.Configuration, original.Configuration) || meta.NextClientId != original.NextClientId {
+			t.Fatalf("bad meta: %#v", meta)
+		}
+	}
+
+	// Importing again fails, leaving nothing behind
+	if _, err := snap2.Import(bytes.NewReader(archive.Bytes())); err == nil {
+		t.Fatalf("imported existing snapshots")
+	}
+	if entries, _ := ioutil.ReadDir(snap2.path); len(entries) != 3 {
+		t.Fatalf("bad snapshot dir: %d entries", len(entries))
+	}
+
+	// A delta cannot be imported without its base
+	var partial bytes.Buffer
+	if err := snap.Export(delta2, &partial); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dir3, snap3 := FileSnapTest(t)
+	defer os.RemoveAll(dir3)
+	if _, err := snap3.Import(dropArchiveSnapshot(t, &partial, full)); err == nil {
+		t.Fatalf("imported a delta without its base")
+	}
+	if entries, _ := ioutil.ReadDir(snap3.path); len(entries) != 0 {
+		t.Fatalf("bad snapshot dir: %d entries", len(entries))
+	}
+}
+
+// dropArchiveSnapshot returns an archive without the files of a snapshot.
+func dropArchiveSnapshot(t *testing.T, archive *bytes.Buffer, id string) *bytes.Buffer {
+	var out bytes.Buffer
+	r := tar.NewReader(archive)
+	w := tar.NewWriter(&out)
+	for {
+		header, err := r.Next()
+		if err != nil {
+			break
+		}
+		if filepath.Dir(header.Name) == id {
+			continue
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf, _ := ioutil.ReadAll(r)
+		w.Write(buf)
+	}
+	w.Close()
+	return &out
+}
+
+func TestFileSS_ImportBadArchive(t *testing.T) {
+	dir, snap := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	id := createTestSnapshot(t, snap, "", 10, "state")
+	var archive bytes.Buffer
+	if err := snap.Export(id, &archive); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A corrupt state file is rejected
+	corrupt := bytes.Replace(archive.Bytes(), []byte("state"), []byte("stata"), 1)
+	dir2, snap2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	if _, err := snap2.Import(bytes.NewReader(corrupt)); err == nil {
+		t.Fatalf("imported a corrupt snapshot")
+	}
+	if entries, _ := ioutil.ReadDir(snap2.path); len(entries) != 0 {
+		t.Fatalf("bad snapshot dir: %d entries", len(entries))
+	}
+
+	// Files outside of a snapshot directory are rejected
+	for _, name := range []string{"../escape/meta.json", "meta.json", id + "/other", "a/b/state.bin"} {
+		var buf bytes.Buffer
+		w := tar.NewWriter(&buf)
+		w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 1})
+		w.Write([]byte("x"))
+		w.Close()
+		if _, err := snap2.Import(&buf); err == nil {
+			t.Fatalf("imported %q", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir2, "escape")); !os.IsNotExist(err) {
+		t.Fatalf("archive escaped the snapshot dir")
+	}
+}
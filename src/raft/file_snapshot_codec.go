@@ -0,0 +1,257 @@
+package raft
+
+import (
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+/*
+
+Compression and encryption of FileSnapshotStore state files. A store
+configured with a compression or an encryption key encodes the state it is
+given before writing it, and records how in meta.json, so that Open decodes
+any snapshot regardless of how the store that reads it is configured, as long
+as it has the key. The CRC in meta.json covers the bytes on disk.
+
+Encrypted state is split into segments of up to snapshotSegmentSize bytes,
+each sealed with AES-GCM under a random nonce. A segment is stored as a
+header, holding the length of the sealed segment and whether it is the last,
+followed by the nonce and the sealed segment. The header and the index of the
+segment are authenticated with it, so segments cannot be reordered, and a
+state file truncated at a segment boundary is detected by the missing last
+segment.
+
+*/
+
+// SnapshotCompression is a compression applied to snapshot state files.
+type SnapshotCompression string
+
+const (
+	// SnapshotCompressionNone stores state uncompressed.
+	SnapshotCompressionNone SnapshotCompression = ""
+	// SnapshotCompressionGzip compresses state with gzip.
+	SnapshotCompressionGzip SnapshotCompression = "gzip"
+)
+
+const (
+	// snapshotEncryptionAESGCM is the encryption recorded in the metadata
+	// of snapshots encrypted with AES-GCM.
+	snapshotEncryptionAESGCM = "aes-gcm"
+
+	// snapshotSegmentSize is the most state encrypted as one segment.
+	snapshotSegmentSize = 64 * 1024
+
+	// snapshotSegmentHeaderLen covers the sealed length and the last flag.
+	snapshotSegmentHeaderLen = 5
+)
+
+// snapshotKeyID identifies an encryption key in snapshot metadata, without
+// revealing it.
+func snapshotKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// newSnapshotAEAD returns the AES-GCM cipher for an encryption key.
+func newSnapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentAdditionalData returns the data authenticated with a segment.
+func segmentAdditionalData(header []byte, index uint64) []byte {
+	ad := make([]byte, len(header)+8)
+	copy(ad, header)
+	binary.BigEndian.PutUint64(ad[len(header):], index)
+	return ad
+}
+
+// snapshotEncrypter encrypts the state written to it in segments.
+type snapshotEncrypter struct {
+	aead  cipher.AEAD
+	w     io.Writer
+	buf   []byte
+	index uint64
+}
+
+func newSnapshotEncrypter(aead cipher.AEAD, w io.Writer) *snapshotEncrypter {
+	return &snapshotEncrypter{
+		aead: aead,
+		w:    w,
+		buf:  make([]byte, 0, snapshotSegmentSize),
+	}
+}
+
+func (e *snapshotEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full segment once more data arrives, so that the
+		// last segment is sealed by Close.
+		if len(e.buf) == snapshotSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):snapshotSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last segment. It does not close the underlying writer.
+func (e *snapshotEncrypter) Close() error {
+	return e.seal(true)
+}
+
+// seal encrypts and writes the buffered segment.
+func (e *snapshotEncrypter) seal(last bool) error {
+	header := make([]byte, snapshotSegmentHeaderLen)
+	binary.BigEndian.PutUint32(header, uint32(len(e.buf)+e.aead.Overhead()))
+	if last {
+		header[4] = 1
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := e.aead.Seal(nil, nonce, e.buf, segmentAdditionalData(header, e.index))
+	for _, b := range [][]byte{header, nonce, sealed} {
+		if _, err := e.w.Write(b); err != nil {
+			return err
+		}
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// snapshotDecrypter decrypts the segments written by a snapshotEncrypter.
+type snapshotDecrypter struct {
+	aead  cipher.AEAD
+	r     io.Reader
+	buf   []byte
+	index uint64
+	last  bool
+}
+
+func newSnapshotDecrypter(aead cipher.AEAD, r io.Reader) *snapshotDecrypter {
+	return &snapshotDecrypter{aead: aead, r: r}
+}
+
+func (d *snapshotDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (d *snapshotDecrypter) open() error {
+	header := make([]byte, snapshotSegmentHeaderLen)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("snapshot state truncated after segment %d", d.index)
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size < uint32(d.aead.Overhead()) || size > uint32(snapshotSegmentSize+d.aead.Overhead()) {
+		return fmt.Errorf("bad snapshot segment size %d", size)
+	}
+	sealed := make([]byte, d.aead.NonceSize()+int(size))
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return err
+	}
+	nonce := sealed[:d.aead.NonceSize()]
+	plain, err := d.aead.Open(nil, nonce, sealed[len(nonce):], segmentAdditionalData(header, d.index))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt snapshot segment %d: %v", d.index, err)
+	}
+	d.buf = plain
+	d.last = header[4] == 1
+	d.index++
+	return nil
+}
+
+// encodeSnapshotState wraps the writer of a state file with the store's
+// encryption and compression, and records them in the snapshot's metadata.
+// Params:
+//   - w: writer of the state file.
+//   - meta: metadata of the snapshot.
+// Returns: the writer to write state to, and the writers that must be closed
+// in order before the state file is complete.
+func (f *FileSnapshotStore) encodeSnapshotState(w io.Writer, meta *fileSnapshotMeta) (io.Writer, []io.Closer, error) {
+	var closers []io.Closer
+	if f.aead != nil {
+		enc := newSnapshotEncrypter(f.aead, w)
+		meta.Encryption = snapshotEncryptionAESGCM
+		meta.KeyID = f.keyID
+		closers = append(closers, enc)
+		w = enc
+	}
+	switch f.compression {
+	case SnapshotCompressionNone:
+	case SnapshotCompressionGzip:
+		gz := gzip.NewWriter(w)
+		meta.Compression = SnapshotCompressionGzip
+		closers = append([]io.Closer{gz}, closers...)
+		w = gz
+	default:
+		return nil, nil, fmt.Errorf("unsupported snapshot compression %q", f.compression)
+	}
+	return w, closers, nil
+}
+
+// decodeSnapshotState wraps the reader of a state file to undo the
+// encryption and compression recorded in the snapshot's metadata.
+// Params:
+//   - r: reader of the state file.
+//   - meta: metadata of the snapshot.
+// Returns: the reader of the state, and an error if the store cannot decode
+// it.
+func (f *FileSnapshotStore) decodeSnapshotState(r io.Reader, meta *fileSnapshotMeta) (io.Reader, error) {
+	switch meta.Encryption {
+	case "":
+	case snapshotEncryptionAESGCM:
+		if f.aead == nil {
+			return nil, fmt.Errorf("snapshot is encrypted, but no encryption key is configured")
+		}
+		if meta.KeyID != f.keyID {
+			return nil, fmt.Errorf("snapshot is encrypted with another key (%s)", meta.KeyID)
+		}
+		r = newSnapshotDecrypter(f.aead, r)
+	default:
+		return nil, fmt.Errorf("unsupported snapshot encryption %q", meta.Encryption)
+	}
+	switch meta.Compression {
+	case SnapshotCompressionNone:
+	case SnapshotCompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress snapshot: %v", err)
+		}
+		r = gz
+	default:
+		return nil, fmt.Errorf("unsupported snapshot compression %q", meta.Compression)
+	}
+	return r, nil
+}
@@ -0,0 +1,218 @@
+package raft
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testSnapshotKey      = bytes.Repeat([]byte{1}, 32)
+	testSnapshotOtherKey = bytes.Repeat([]byte{2}, 32)
+)
+
+// openSnapshotStore opens a FileSnapshotStore in dir with a compression and
+// encryption key.
+func openSnapshotStore(t *testing.T, dir string, compression SnapshotCompression, key []byte) *FileSnapshotStore {
+	snap, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{
+		Retain:        3,
+		Logger:        newTestLogger(t),
+		Compression:   compression,
+		EncryptionKey: key,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return snap
+}
+
+// writeTestSnapshot writes a snapshot with the given state.
+func writeTestSnapshot(t *testing.T, snap *FileSnapshotStore, state []byte) string {
+	_, trans := NewInmemTransport(NewInmemAddr())
+	sink, err := snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, 0, nil, nil, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := sink.Write(state); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return sink.ID()
+}
+
+// readTestSnapshot opens a snapshot and reads its state.
+func readTestSnapshot(snap *FileSnapshotStore, id string) ([]byte, error) {
+	_, r, err := snap.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func TestFileSS_Encoding(t *testing.T) {
+	// Span several encryption segments
+	state := bytes.Repeat([]byte("state of the fsm\n"), 3*snapshotSegmentSize/16)
+	cases := []struct {
+		name        string
+		compression SnapshotCompression
+		key         []byte
+	}{
+		{"plain", SnapshotCompressionNone, nil},
+		{"gzip", SnapshotCompressionGzip, nil},
+		{"encrypted", SnapshotCompressionNone, testSnapshotKey},
+		{"gzip+encrypted", SnapshotCompressionGzip, testSnapshotKey},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "raft")
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			snap := openSnapshotStore(t, dir, tc.compression, tc.key)
+			id := writeTestSnapshot(t, snap, state)
+
+			// The encoding is recorded in the metadata
+			meta, err := snap.readMeta(id)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if meta.Size != int64(len(state)) {
+				t.Fatalf("bad size: %d", meta.Size)
+			}
+			if meta.Compression != tc.compression {
+				t.Fatalf("bad compression: %q", meta.Compression)
+			}
+			if (meta.Encryption != "") != (tc.key != nil) {
+				t.Fatalf("bad encryption: %q", meta.Encryption)
+			}
+			stored, err := ioutil.ReadFile(filepath.Join(dir, snapPath, id, stateFilePath))
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if meta.StoredSize != int64(len(stored)) {
+				t.Fatalf("bad stored size: %d, expected %d", meta.StoredSize, len(stored))
+			}
+			if tc.compression == SnapshotCompressionGzip && len(stored) >= len(state)/10 {
+				t.Fatalf("state not compressed: %d bytes", len(stored))
+			}
+			if tc.key != nil && bytes.Contains(stored, []byte("state of the fsm")) {
+				t.Fatalf("state not encrypted")
+			}
+
+			// A store with the key decodes it, however it is configured
+			other := openSnapshotStore(t, dir, SnapshotCompressionNone, tc.key)
+			read, err := readTestSnapshot(other, id)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !bytes.Equal(read, state) {
+				t.Fatalf("state mismatch")
+			}
+		})
+	}
+}
+
+func TestFileSS_EncryptionKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	id := writeTestSnapshot(t, openSnapshotStore(t, dir, SnapshotCompressionGzip, testSnapshotKey), []byte("secret"))
+
+	if _, err := readTestSnapshot(openSnapshotStore(t, dir, SnapshotCompressionNone, nil), id); err == nil {
+		t.Fatalf("opened encrypted snapshot without a key")
+	}
+	if _, err := readTestSnapshot(openSnapshotStore(t, dir, SnapshotCompressionNone, testSnapshotOtherKey), id); err == nil {
+		t.Fatalf("opened encrypted snapshot with another key")
+	}
+
+	// Bad configurations are rejected
+	if _, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{Retain: 1, EncryptionKey: []byte("short")}); err == nil {
+		t.Fatalf("expected error for bad key")
+	}
+	if _, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{Retain: 1, Compression: "lz4"}); err == nil {
+		t.Fatalf("expected error for unsupported compression")
+	}
+}
+
+func TestFileSS_EncodedCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	snap := openSnapshotStore(t, dir, SnapshotCompressionGzip, testSnapshotKey)
+	id := writeTestSnapshot(t, snap, []byte("state"))
+
+	// The CRC covers the stored bytes
+	path := filepath.Join(dir, snapPath, id, stateFilePath)
+	stored, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stored[len(stored)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, stored, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, _, err := snap.Open(id); err == nil {
+		t.Fatalf("opened corrupt snapshot")
+	}
+}
+
+func TestSnapshotEncrypter(t *testing.T) {
+	aead, err := newSnapshotAEAD(testSnapshotKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	encrypt := func(state []byte) []byte {
+		var buf bytes.Buffer
+		enc := newSnapshotEncrypter(aead, &buf)
+		if _, err := enc.Write(state); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return buf.Bytes()
+	}
+	decrypt := func(sealed []byte) ([]byte, error) {
+		return ioutil.ReadAll(newSnapshotDecrypter(aead, bytes.NewReader(sealed)))
+	}
+	segment := snapshotSegmentHeaderLen + aead.NonceSize() + aead.Overhead()
+
+	for _, size := range []int{0, 1, snapshotSegmentSize, 2*snapshotSegmentSize + 1} {
+		state := bytes.Repeat([]byte{7}, size)
+		read, err := decrypt(encrypt(state))
+		if err != nil {
+			t.Fatalf("size %d: err: %v", size, err)
+		}
+		if !bytes.Equal(read, state) {
+			t.Fatalf("size %d: state mismatch", size)
+		}
+	}
+
+	// Dropping the last segment is detected
+	sealed := encrypt(bytes.Repeat([]byte{7}, 2*snapshotSegmentSize))
+	if _, err := decrypt(sealed[:len(sealed)-segment-snapshotSegmentSize]); err == nil {
+		t.Fatalf("truncated state decrypted")
+	}
+
+	// Reordering segments is detected
+	first := sealed[:segment+snapshotSegmentSize]
+	second := sealed[len(first) : 2*len(first)]
+	swapped := append(append(append([]byte(nil), second...), first...), sealed[2*len(first):]...)
+	if _, err := decrypt(swapped); err == nil {
+		t.Fatalf("reordered state decrypted")
+	}
+	if _, err := io.Copy(ioutil.Discard, newSnapshotDecrypter(aead, bytes.NewReader(nil))); err == nil {
+		t.Fatalf("empty state decrypted")
+	}
+}
@@ -57,7 +57,7 @@ func TestFileSS_CreateSnapshotMissingParentDir(t *testing.T) {
 
 	os.RemoveAll(parent)
 	_, trans := NewInmemTransport(NewInmemAddr())
-	_, err = snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, trans)
+	_, err = snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, 0, nil, nil, trans)
 	if err != nil {
 		t.Fatalf("should not fail when using non existing parent")
 	}
@@ -93,7 +93,7 @@ func TestFileSS_CreateSnapshot(t *testing.T) {
 		Address:  ServerAddress("over here"),
 	})
 	_, trans := NewInmemTransport(NewInmemAddr())
-	sink, err := snap.Create(SnapshotVersionMax, 10, 3, configuration, 2, trans)
+	sink, err := snap.Create(SnapshotVersionMax, 10, 3, configuration, 2, 0, nil, nil, trans)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -186,7 +186,7 @@ func TestFileSS_CancelSnapshot(t *testing.T) {
 
 	// Create a new sink
 	_, trans := NewInmemTransport(NewInmemAddr())
-	sink, err := snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, trans)
+	sink, err := snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, 0, nil, nil, trans)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -223,7 +223,7 @@ func TestFileSS_Retention(t *testing.T) {
 	// Create a few snapshots
 	_, trans := NewInmemTransport(NewInmemAddr())
 	for i := 10; i < 15; i++ {
-		sink, err := snap.Create(SnapshotVersionMax, uint64(i), 3, Configuration{}, 0, trans)
+		sink, err := snap.Create(SnapshotVersionMax, uint64(i), 3, Configuration{}, 0, 0, nil, nil, trans)
 		if err != nil {
 			t.Fatalf("err: %v", err)
 		}
@@ -313,7 +313,7 @@ func TestFileSS_Ordering(t *testing.T) {
 
 	// Create a new sink
 	_, trans := NewInmemTransport(NewInmemAddr())
-	sink, err := snap.Create(SnapshotVersionMax, 130350, 5, Configuration{}, 0, trans)
+	sink, err := snap.Create(SnapshotVersionMax, 130350, 5, Configuration{}, 0, 0, nil, nil, trans)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -322,7 +322,7 @@ func TestFileSS_Ordering(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	sink, err = snap.Create(SnapshotVersionMax, 204917, 36, Configuration{}, 0, trans)
+	sink, err = snap.Create(SnapshotVersionMax, 204917, 36, Configuration{}, 0, 0, nil, nil, trans)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -348,3 +348,48 @@ func TestFileSS_Ordering(t *testing.T) {
 		t.Fatalf("bad snap: %#v", *snaps[1])
 	}
 }
+
+func TestFileSS_WitnessRecords(t *testing.T) {
+	// Create a test dir
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snap, err := NewFileSnapshotStoreWithLogger(dir, 3, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Create a snapshot with a witness section
+	records := []Log{
+		Log{Type: LogCommand, Data: []byte("foo"), ClientID: 1, SeqNo: 2, Keys: []Key{Key("a")}},
+		Log{Type: LogTransaction, Data: []byte("bar"), ClientID: 3, SeqNo: 4, Keys: []Key{Key("b"), Key("c")}},
+	}
+	_, trans := NewInmemTransport(NewInmemAddr())
+	sink, err := snap.Create(SnapshotVersionMax, 10, 3, Configuration{}, 0, 0, nil, records, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := sink.Write([]byte("state")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Reopen the store and check the witness section survived
+	snap, err = NewFileSnapshotStoreWithLogger(dir, 3, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	meta, r, err := snap.Open(sink.ID())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.Close()
+	if !reflect.DeepEqual(meta.WitnessRecords, records) {
+		t.Fatalf("witness records don't match: %v != %v", meta.WitnessRecords, records)
+	}
+}
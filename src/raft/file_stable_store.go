@@ -0,0 +1,205 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+
+Durable StableStore. FileStableStore keeps every key in memory, and on each
+update writes all of them to a temporary file, syncs it, and renames it over
+the previous file, so that a crash at any point leaves either the old or the
+new contents. The file starts with a magic number, a version and a CRC32 of
+the encoded contents, so that a corrupt file is detected when the store is
+opened rather than silently read as empty or stale state.
+
+Each update costs a rewrite of every key and two fsyncs, which suits state
+that changes rarely, such as the current term and vote. It does not suit
+witness state under load: a witness rewrites its whole set of records, with
+two updates, for every operation it records, so each RecordRequest pays
+four fsyncs and a write that grows with the unsynced operations. Servers
+that serve the CURP fast path at high rates need a StableStore with cheaper
+updates.
+
+*/
+
+const (
+	stableFilePath    = "stable.bin"
+	stableFileVersion = 1
+	// stableHeaderLen covers the magic number, version and CRC32
+	stableHeaderLen = 9
+)
+
+var (
+	stableFileMagic = []byte("RSTB")
+)
+
+// FileStableStore implements the StableStore interface with a file on the
+// local disk. Every update rewrites and syncs the whole file, so it is not
+// suited to witness state on servers recording many operations.
+type FileStableStore struct {
+	path string
+
+	lock  sync.RWMutex
+	state fileStableState
+}
+
+// fileStableState is the encoded contents of the file of a FileStableStore.
+type fileStableState struct {
+	KV     map[string][]byte
+	Uint64 map[string]uint64
+}
+
+// NewFileStableStore opens, or creates, a stable store in a base directory.
+// Params:
+//   - base: directory to store the file in.
+//
+// Returns: the store, and an error if the file cannot be read or is corrupt.
+func NewFileStableStore(base string) (*FileStableStore, error) {
+	if err := os.MkdirAll(base, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("stable path not accessible: %v", err)
+	}
+	store := &FileStableStore{
+		path: filepath.Join(base, stableFilePath),
+		state: fileStableState{
+			KV:     make(map[string][]byte),
+			Uint64: make(map[string]uint64),
+		},
+	}
+
+	// A temporary file is left by a crash before its rename
+	if err := os.Remove(store.path + tmpSuffix); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove temporary stable file: %v", err)
+	}
+
+	buf, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stable file: %v", err)
+	}
+	if err := decodeStableFile(buf, &store.state); err != nil {
+		return nil, fmt.Errorf("stable file %s corrupt: %v", store.path, err)
+	}
+	return store, nil
+}
+
+// decodeStableFile verifies and decodes the contents of a stable file.
+func decodeStableFile(buf []byte, state *fileStableState) error {
+	if len(buf) < stableHeaderLen {
+		return fmt.Errorf("incomplete header")
+	}
+	if !bytes.Equal(buf[:len(stableFileMagic)], stableFileMagic) {
+		return fmt.Errorf("bad magic number")
+	}
+	if version := buf[len(stableFileMagic)]; version != stableFileVersion {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	payload := buf[stableHeaderLen:]
+	if crc32.Checksum(payload, walCRCTable) != binary.BigEndian.Uint32(buf[len(stableFileMagic)+1:stableHeaderLen]) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	if err := decodeMsgPack(payload, state); err != nil {
+		return fmt.Errorf("failed to decode: %v", err)
+	}
+	if state.KV == nil {
+		state.KV = make(map[string][]byte)
+	}
+	if state.Uint64 == nil {
+		state.Uint64 = make(map[string]uint64)
+	}
+	return nil
+}
+
+// persistLocked atomically replaces the file with the current state. Must
+// be called with the lock held.
+func (f *FileStableStore) persistLocked() error {
+	payload, err := encodeMsgPack(&f.state)
+	if err != nil {
+		return fmt.Errorf("failed to encode stable state: %v", err)
+	}
+	var buf bytes.Buffer
+	buf.Write(stableFileMagic)
+	buf.WriteByte(stableFileVersion)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum(payload.Bytes(), walCRCTable))
+	buf.Write(crc[:])
+	buf.Write(payload.Bytes())
+
+	tmpPath := f.path + tmpSuffix
+	fh, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create stable file: %v", err)
+	}
+	if _, err := fh.Write(buf.Bytes()); err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to write stable file: %v", err)
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to sync stable file: %v", err)
+	}
+	if err := fh.Close(); err != nil {
+		return fmt.Errorf("failed to close stable file: %v", err)
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return fmt.Errorf("failed to rename stable file: %v", err)
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// Set implements the StableStore interface.
+func (f *FileStableStore) Set(key []byte, val []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	old, ok := f.state.KV[string(key)]
+	f.state.KV[string(key)] = append([]byte(nil), val...)
+	if err := f.persistLocked(); err != nil {
+		if ok {
+			f.state.KV[string(key)] = old
+		} else {
+			delete(f.state.KV, string(key))
+		}
+		return err
+	}
+	return nil
+}
+
+// Get implements the StableStore interface.
+func (f *FileStableStore) Get(key []byte) ([]byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.state.KV[string(key)], nil
+}
+
+// SetUint64 implements the StableStore interface.
+func (f *FileStableStore) SetUint64(key []byte, val uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	old, ok := f.state.Uint64[string(key)]
+	f.state.Uint64[string(key)] = val
+	if err := f.persistLocked(); err != nil {
+		if ok {
+			f.state.Uint64[string(key)] = old
+		} else {
+			delete(f.state.Uint64, string(key))
+		}
+		return err
+	}
+	return nil
+}
+
+// GetUint64 implements the StableStore interface.
+func (f *FileStableStore) GetUint64(key []byte) (uint64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.state.Uint64[string(key)], nil
+}
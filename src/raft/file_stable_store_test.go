@@ -0,0 +1,210 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStableStoreImpl(t *testing.T) {
+	var impl interface{} = &FileStableStore{}
+	if _, ok := impl.(StableStore); !ok {
+		t.Fatalf("FileStableStore not a StableStore")
+	}
+}
+
+func TestFileStableStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStableStore(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if val, err := store.Get([]byte("missing")); err != nil || len(val) != 0 {
+		t.Fatalf("bad: %v %v", val, err)
+	}
+	if val, err := store.GetUint64([]byte("missing")); err != nil || val != 0 {
+		t.Fatalf("bad: %v %v", val, err)
+	}
+	val := []byte("bar")
+	if err := store.Set([]byte("foo"), val); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The store keeps its own copy
+	val[0] = 'c'
+	if err := store.SetUint64(keyCurrentTerm, 42); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Values persist
+	store, err = NewFileStableStore(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if val, err := store.Get([]byte("foo")); err != nil || !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("bad: %q %v", val, err)
+	}
+	if val, err := store.GetUint64(keyCurrentTerm); err != nil || val != 42 {
+		t.Fatalf("bad: %v %v", val, err)
+	}
+}
+
+func TestFileStableStore_Crash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStableStore(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.SetUint64(keyCurrentTerm, 1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A crash before the rename leaves a partial temporary file
+	path := filepath.Join(dir, stableFilePath)
+	if err := ioutil.WriteFile(path+tmpSuffix, stableFileMagic, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	store, err = NewFileStableStore(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if val, _ := store.GetUint64(keyCurrentTerm); val != 1 {
+		t.Fatalf("bad: %v", val)
+	}
+	if _, err := os.Stat(path + tmpSuffix); !os.IsNotExist(err) {
+		t.Fatalf("temporary file not removed: %v", err)
+	}
+}
+
+func TestFileStableStore_Corruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStableStore(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := store.Set(keyLastVoteCand, []byte("candidate")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join(dir, stableFilePath)
+	good, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	corrupt := map[string][]byte{
+		"empty":     nil,
+		"truncated": good[:len(good)-1],
+		"magic":     append([]byte("XXXX"), good[4:]...),
+		"version":   append(append([]byte(nil), good[:4]...), append([]byte{9}, good[5:]...)...),
+		"bitflip":   append(append([]byte(nil), good[:len(good)-1]...), good[len(good)-1]^1),
+	}
+	for name, buf := range corrupt {
+		if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := NewFileStableStore(dir); err == nil {
+			t.Fatalf("%s: expected corruption error", name)
+		}
+	}
+}
+
+func TestRaft_FileStoresRestart(t *testing.T) {
+	conf := inmemConfig(t)
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func() (*FileLogStore, *FileStableStore) {
+		logs := openFileLog(t, dir, nil)
+		stable, err := NewFileStableStore(dir)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return logs, stable
+	}
+	logs, stable := open()
+	_, snaps := FileSnapTest(t)
+	defer os.RemoveAll(snaps.path)
+	addr, trans := NewInmemTransport("")
+	conf.LocalID = ServerID(addr)
+	configuration := Configuration{Servers: []Server{{ID: conf.LocalID, Address: addr}}}
+	if err := BootstrapCluster(conf, logs, stable, snaps, trans, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r, err := NewRaft(conf, &MockFSM{}, logs, stable, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitForState := func(r *Raft) {
+		for i := 0; r.State() != Leader; i++ {
+			if i > 100 {
+				t.Fatalf("no leader")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForState(r)
+	var future Future
+	for i := 0; i < 20; i++ {
+		future = r.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i)), ClientID: 1, SeqNo: uint64(i)}, 0)
+	}
+	if err := future.Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	term := r.getCurrentTerm()
+	lastIndex := r.getLastIndex()
+	if err := r.Shutdown().Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	logs.Close()
+
+	// A restarted server recovers its term and log from disk
+	logs, stable = open()
+	defer logs.Close()
+	if val, _ := stable.GetUint64(keyCurrentTerm); val != term {
+		t.Fatalf("bad term: %d, expected %d", val, term)
+	}
+	_, trans = NewInmemTransport(addr)
+	fsm := &MockFSM{}
+	r, err = NewRaft(conf, fsm, logs, stable, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer r.Shutdown()
+	if idx := r.getLastIndex(); idx != lastIndex {
+		t.Fatalf("bad last index: %d, expected %d", idx, lastIndex)
+	}
+	var entry Log
+	if err := logs.GetLog(lastIndex, &entry); err != nil || string(entry.Data) != "test19" {
+		t.Fatalf("bad last log: %#v %v", entry, err)
+	}
+	waitForState(r)
+
+	// The restarted server keeps serving
+	if err := r.Apply(&Log{Data: []byte("after"), ClientID: 1, SeqNo: 20}, 0).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fsm.Lock()
+	defer fsm.Unlock()
+	if n := len(fsm.logs); n == 0 || string(fsm.logs[n-1]) != "after" {
+		t.Fatalf("bad applied logs: %q", fsm.logs)
+	}
+}
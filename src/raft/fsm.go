@@ -49,11 +49,37 @@ type FSMSnapshot interface {
 func (r *Raft) runFSM() {
 	var lastIndex, lastTerm uint64
 
+	// Index and term of the last entry or snapshot that changed the FSM's
+	// state. Entries that are not commands leave the FSM holding the state
+	// of a restored snapshot, whose deltas can then be applied directly.
+	var stateIndex, stateTerm uint64
+
+	// Set once a corrupted entry is found, after which no entries are
+	// applied or snapshotted
+	var corrupt bool
+
 	commit := func(req *commitTuple) {
+		// Don't apply corrupted entries, or any entries after them. The FSM
+		// can no longer match the other servers, so have the main thread
+		// halt the server rather than let it keep voting with a frozen FSM.
+		if !corrupt && !req.log.verifyChecksum() {
+			r.reportChecksumMismatch(req.log, true)
+			r.logger.Printf("[ERR] raft: Halting, the FSM cannot apply past index %d", lastIndex)
+			corrupt = true
+			r.fsmCorruptCh <- struct{}{}
+		}
+		if corrupt {
+			if req.future != nil {
+				req.future.respond(ErrLogChecksumMismatch)
+			}
+			return
+		}
+
 		// Apply the log if a command
 		var resp interface{}
-		if req.log.Type == LogCommand {
+		if req.log.Type == LogCommand || req.log.Type == LogTransaction {
 			r.applyCommandLocally(req.log, &resp)
+			stateIndex, stateTerm = req.log.Index, req.log.Term
 		}
 
 		// Update the indexes
@@ -71,31 +97,29 @@ func (r *Raft) runFSM() {
 	}
 
 	restore := func(req *restoreFuture) {
-		// Open the snapshot
-		meta, source, err := r.snapshots.Open(req.ID)
-		if err != nil {
-			req.respond(fmt.Errorf("failed to open snapshot %v: %v", req.ID, err))
-			return
-		}
-
-		// Attempt to restore
+		// Attempt to restore, applying only the deltas past the state the
+		// FSM holds if it is part of the snapshot's chain
 		start := time.Now()
-		if err := r.fsm.Restore(source); err != nil {
-			req.respond(fmt.Errorf("failed to restore snapshot %v: %v", req.ID, err))
-			source.Close()
+		meta, err := restoreSnapshotChain(r.fsm, r.snapshots, req.ID, stateIndex, stateTerm)
+		if err != nil {
+			req.respond(err)
 			return
 		}
-		source.Close()
 		metrics.MeasureSince([]string{"raft", "fsm", "restore"}, start)
 
 		// Update the last index and term
 		lastIndex = meta.Index
 		lastTerm = meta.Term
+		stateIndex, stateTerm = meta.Index, meta.Term
 		req.respond(nil)
 	}
 
 	snapshot := func(req *reqSnapshotFuture) {
 		// Is there something to snapshot?
+		if corrupt {
+			req.respond(ErrLogChecksumMismatch)
+			return
+		}
 		if lastIndex == 0 {
 			req.respond(ErrNothingNewToSnapshot)
 			return
@@ -103,13 +127,14 @@ func (r *Raft) runFSM() {
 
 		// Start a snapshot
 		start := time.Now()
-		snap, err := r.fsm.Snapshot()
+		snap, base, err := r.snapshotFSM(req.base, lastIndex)
 		metrics.MeasureSince([]string{"raft", "fsm", "snapshot"}, start)
 
 		// Respond to the request
 		req.index = lastIndex
 		req.term = lastTerm
 		req.snapshot = snap
+		req.base = base
 		req.respond(err)
 	}
 
@@ -138,7 +163,7 @@ func (r *Raft) runFSM() {
 
 // Apply a command to the local FSM. Ensures exactly-once semantics with RIFL.
 // Params:
-//   - log: Log entry to apply locally. Should be of type LogCommand.
+//   - log: Log entry to apply locally. Should be of type LogCommand or LogTransaction.
 //   - resp: Response object to populate after executing command.
 func (r *Raft) applyCommandLocally(log *Log, resp *interface{}) {
 	r.clientResponseLock.Lock()
@@ -153,7 +178,7 @@ func (r *Raft) applyCommandLocally(log *Log, resp *interface{}) {
 		*resp = cachedResp.response
 	} else {
 		start := time.Now()
-		*resp = r.fsm.Apply(log)
+		*resp = applyToFSM(r.fsm, log)
 		metrics.MeasureSince([]string{"raft", "fsm", "apply"}, start)
 		// Add response to clientResponseCache.
 		clientCache[log.SeqNo] = clientResponseEntry{
@@ -101,6 +101,24 @@ func (d *deferError) Error() error {
 	return d.err
 }
 
+// waitOrShutdown is like Error, but returns ErrRaftShutdown if shutdownCh
+// closes first, for futures the main thread may never respond to once it
+// exits.
+func (d *deferError) waitOrShutdown(shutdownCh chan struct{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.errCh == nil {
+		panic("waiting for response on nil channel")
+	}
+	select {
+	case d.err = <-d.errCh:
+		return d.err
+	case <-shutdownCh:
+		return ErrRaftShutdown
+	}
+}
+
 func (d *deferError) respond(err error) {
 	if d.errCh == nil {
 		return
@@ -208,6 +226,10 @@ type reqSnapshotFuture struct {
 	index    uint64
 	term     uint64
 	snapshot FSMSnapshot
+
+	// base is the snapshot to take a delta of, or nil for a full snapshot.
+	// The FSM runner clears it if it takes a full snapshot instead.
+	base *SnapshotMeta
 }
 
 // restoreFuture is used for requesting an FSM to perform a
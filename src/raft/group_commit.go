@@ -0,0 +1,127 @@
+package raft
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Group commit for the leader. The leader waits up to
+Config.GroupCommitMaxDelay for concurrent Apply calls to join an entry, and
+writes up to Config.GroupCommitMaxBatch entries with a single StoreLogs, so
+that they share one fsync. While the batch is being written, it is held in
+pendingLogs and the replicators are notified, so AppendEntries carrying it
+are sent to followers in parallel with the leader's own write. Entries are
+committed once a quorum has stored them, which counts the leader only after
+its write completes.
+
+*/
+
+// pendingLogs holds the batch of entries the leader is writing to its log
+// store, so that replication can read them before the write completes.
+type pendingLogs struct {
+	sync.RWMutex
+	logs []*Log
+}
+
+// set publishes a batch of entries with consecutive indexes.
+func (p *pendingLogs) set(logs []*Log) {
+	p.Lock()
+	defer p.Unlock()
+	p.logs = logs
+}
+
+// clear removes the batch once it is written, or failed to be.
+func (p *pendingLogs) clear() {
+	p.Lock()
+	defer p.Unlock()
+	p.logs = nil
+}
+
+// get copies the pending entry at an index into out, and returns whether
+// there was one.
+func (p *pendingLogs) get(index uint64, out *Log) bool {
+	p.RLock()
+	defer p.RUnlock()
+	if len(p.logs) == 0 || index < p.logs[0].Index {
+		return false
+	}
+	offset := index - p.logs[0].Index
+	if offset >= uint64(len(p.logs)) {
+		return false
+	}
+	*out = *p.logs[offset]
+	return true
+}
+
+// lastIndex returns the index of the last pending entry, or 0.
+func (p *pendingLogs) lastIndex() uint64 {
+	p.RLock()
+	defer p.RUnlock()
+	if len(p.logs) == 0 {
+		return 0
+	}
+	return p.logs[len(p.logs)-1].Index
+}
+
+// getLog gets a log entry for replication, from the batch being written if
+// it is there, and otherwise from the log store.
+func (r *Raft) getLog(index uint64, out *Log) error {
+	if r.pendingLogs.get(index, out) {
+		return nil
+	}
+	return r.logs.GetLog(index, out)
+}
+
+// getReplicationIndex returns the index of the last entry that may be
+// replicated, including the batch the leader is writing.
+func (r *Raft) getReplicationIndex() uint64 {
+	lastLogIdx, _ := r.getLastLog()
+	return max(lastLogIdx, r.pendingLogs.lastIndex())
+}
+
+// gatherLogs collects a batch of entries to dispatch with an entry received
+// from applyCh. This must only be called from the main thread.
+// Params:
+//   - first: entry received from applyCh.
+// Returns: batch of at most GroupCommitMaxBatch entries, including first.
+func (r *Raft) gatherLogs(first *logFuture) []*logFuture {
+	maxBatch := r.conf.GroupCommitMaxBatch
+	if maxBatch == 0 {
+		maxBatch = r.conf.MaxAppendEntries
+	}
+	ready := []*logFuture{first}
+
+	// Take what is already queued
+DRAIN:
+	for len(ready) < maxBatch {
+		select {
+		case newLog := <-r.applyCh:
+			ready = append(ready, newLog)
+		default:
+			break DRAIN
+		}
+	}
+
+	// Wait for more to join the batch
+	if r.conf.GroupCommitMaxDelay > 0 && len(ready) < maxBatch {
+		timer := time.NewTimer(r.conf.GroupCommitMaxDelay)
+		defer timer.Stop()
+	WAIT:
+		for len(ready) < maxBatch {
+			select {
+			case newLog := <-r.applyCh:
+				ready = append(ready, newLog)
+			case <-timer.C:
+				break WAIT
+			case <-r.shutdownCh:
+				break WAIT
+			}
+		}
+	}
+	metrics.AddSample([]string{"raft", "leader", "groupCommit", "batchSize"}, float32(len(ready)))
+	return ready
+}
@@ -0,0 +1,235 @@
+package raft
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedLogStore wraps a LogStore, counting StoreLogs calls and optionally
+// blocking them until released.
+type gatedLogStore struct {
+	LogStore
+	lock   sync.Mutex
+	calls  int
+	gate   chan struct{}
+	gateCh chan struct{}
+}
+
+func (g *gatedLogStore) StoreLogs(logs []*Log) error {
+	g.lock.Lock()
+	g.calls++
+	gate, gateCh := g.gate, g.gateCh
+	g.gate, g.gateCh = nil, nil
+	g.lock.Unlock()
+	if gate != nil {
+		close(gateCh)
+		<-gate
+	}
+	return g.LogStore.StoreLogs(logs)
+}
+
+func (g *gatedLogStore) StoreLog(log *Log) error {
+	return g.StoreLogs([]*Log{log})
+}
+
+// block makes the next StoreLogs block until gate is closed. Returns a
+// channel closed once it is blocked.
+func (g *gatedLogStore) block(gate chan struct{}) chan struct{} {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.gate = gate
+	g.gateCh = make(chan struct{})
+	return g.gateCh
+}
+
+func (g *gatedLogStore) getCalls() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.calls
+}
+
+// makeGatedCluster starts a cluster whose servers' log stores are wrapped
+// by gatedLogStore.
+func makeGatedCluster(t *testing.T, n int, conf *Config) ([]*Raft, []*gatedLogStore, func()) {
+	var rafts []*Raft
+	var stores []*gatedLogStore
+	var trans []*InmemTransport
+	var dirs []string
+	var configuration Configuration
+	for i := 0; i < n; i++ {
+		addr, tr := NewInmemTransport("")
+		trans = append(trans, tr)
+		configuration.Servers = append(configuration.Servers, Server{
+			Suffrage: Voter,
+			ID:       ServerID(fmt.Sprintf("server-%s", addr)),
+			Address:  addr,
+		})
+	}
+	for _, t1 := range trans {
+		for _, t2 := range trans {
+			t1.Connect(t2.LocalAddr(), t2)
+		}
+	}
+	for i := 0; i < n; i++ {
+		store := NewInmemStore()
+		logs := &gatedLogStore{LogStore: store}
+		dir, snaps := FileSnapTest(t)
+		dirs = append(dirs, dir)
+		peerConf := *conf
+		peerConf.LocalID = configuration.Servers[i].ID
+		peerConf.Logger = newTestLoggerWithPrefix(t, string(peerConf.LocalID))
+		if err := BootstrapCluster(&peerConf, logs, store, snaps, trans[i], configuration); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		r, err := NewRaft(&peerConf, &MockFSM{}, logs, store, snaps, trans[i])
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		rafts = append(rafts, r)
+		stores = append(stores, logs)
+	}
+	closeFn := func() {
+		for _, r := range rafts {
+			r.Shutdown().Error()
+		}
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+	return rafts, stores, closeFn
+}
+
+func waitForLeader(t *testing.T, rafts []*Raft) int {
+	for i := 0; i < 200; i++ {
+		for j, r := range rafts {
+			if r.State() == Leader {
+				return j
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no leader")
+	return -1
+}
+
+func TestPendingLogs(t *testing.T) {
+	var p pendingLogs
+	var out Log
+	if p.get(1, &out) || p.lastIndex() != 0 {
+		t.Fatalf("expected no pending logs")
+	}
+	p.set([]*Log{{Index: 5, Data: []byte("a")}, {Index: 6, Data: []byte("b")}})
+	if !p.get(6, &out) || string(out.Data) != "b" {
+		t.Fatalf("bad log: %#v", out)
+	}
+	if p.get(4, &out) || p.get(7, &out) {
+		t.Fatalf("got log outside batch")
+	}
+	if p.lastIndex() != 6 {
+		t.Fatalf("bad last index: %d", p.lastIndex())
+	}
+	p.clear()
+	if p.get(5, &out) || p.lastIndex() != 0 {
+		t.Fatalf("expected no pending logs")
+	}
+}
+
+func TestConfig_GroupCommit(t *testing.T) {
+	conf := DefaultConfig()
+	conf.LocalID = "id"
+	conf.GroupCommitMaxDelay = -time.Millisecond
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("expected error for negative delay")
+	}
+	conf.GroupCommitMaxDelay = 0
+	conf.GroupCommitMaxBatch = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("expected error for negative batch")
+	}
+}
+
+func TestRaft_GroupCommit(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.GroupCommitMaxDelay = 50 * time.Millisecond
+	conf.GroupCommitMaxBatch = 16
+	rafts, stores, closeFn := makeGatedCluster(t, 1, conf)
+	defer closeFn()
+	leader := rafts[waitForLeader(t, rafts)]
+
+	// Wait for the leader's no-op to be written
+	if err := leader.Apply(&Log{Data: []byte("first"), ClientID: 1, SeqNo: 0}, 0).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	before := stores[0].getCalls()
+
+	// Concurrent applies share writes
+	const applies = 32
+	var wg sync.WaitGroup
+	for i := 1; i <= applies; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			future := leader.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i)), ClientID: 1, SeqNo: uint64(i)}, 0)
+			if err := future.Error(); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	writes := stores[0].getCalls() - before
+	if writes < applies/conf.GroupCommitMaxBatch || writes > applies/2 {
+		t.Fatalf("expected batched writes, got %d writes for %d applies", writes, applies)
+	}
+}
+
+func TestRaft_GroupCommitOverlapsReplication(t *testing.T) {
+	conf := inmemConfig(t)
+	rafts, stores, closeFn := makeGatedCluster(t, 3, conf)
+	defer closeFn()
+	leaderIdx := waitForLeader(t, rafts)
+	leader := rafts[leaderIdx]
+	if err := leader.Apply(&Log{Data: []byte("first"), ClientID: 1, SeqNo: 0}, 0).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Block the leader's next write
+	gate := make(chan struct{})
+	blocked := stores[leaderIdx].block(gate)
+	future := leader.Apply(&Log{Data: []byte("second"), ClientID: 1, SeqNo: 1}, 0)
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		close(gate)
+		t.Fatalf("leader did not write")
+	}
+	index := leader.getLastIndex() + 1
+
+	// Followers store the entry while the leader is still writing it
+	deadline := time.Now().Add(time.Second)
+	for i := range rafts {
+		if i == leaderIdx {
+			continue
+		}
+		for {
+			if last, _ := stores[i].LastIndex(); last >= index {
+				break
+			}
+			if time.Now().After(deadline) {
+				close(gate)
+				t.Fatalf("follower %d did not store entry %d during leader write", i, index)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	close(gate)
+	if err := future.Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if last := leader.getLastIndex(); last != index {
+		t.Fatalf("bad last index: %d, expected %d", last, index)
+	}
+}
@@ -0,0 +1,669 @@
+package raft
+
+import (
+	"context"
+	"io"
+
+	"github.com/hashicorp/raft/raftpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+
+This file implements the gRPC service served by GRPCTransport. The service
+and its messages are defined in raftpb/raft.proto, and raftpb holds the code
+generated from it by protoc-gen-go and protoc-gen-go-grpc. Payloads are
+protobuf messages, so service meshes and observability tools can decode
+them with the .proto.
+
+The messages mirror the structures in commands.go, and the functions below
+convert between the two. Every response carries an error string, as in
+NetworkTransport, so that responses carrying an error (such as a
+ClientResponse naming the leader) arrive intact. gRPC status errors are
+reserved for transport failures.
+
+*/
+
+// grpcServiceName is the name of the Raft service, reported by the health
+// service.
+var grpcServiceName = raftpb.Raft_ServiceDesc.ServiceName
+
+// grpcServer implements raftpb.RaftServer, handing requests to the
+// transport's consumer.
+type grpcServer struct {
+	raftpb.UnimplementedRaftServer
+	g *GRPCTransport
+}
+
+// AppendEntries implements raftpb.RaftServer.
+func (s *grpcServer) AppendEntries(ctx context.Context, req *raftpb.AppendEntriesRequest) (*raftpb.AppendEntriesResponse, error) {
+	return s.g.handleAppendEntries(ctx, req)
+}
+
+// AppendEntriesPipeline implements raftpb.RaftServer, answering requests
+// in the order they arrive.
+func (s *grpcServer) AppendEntriesPipeline(stream raftpb.Raft_AppendEntriesPipelineServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		res, err := s.g.handleAppendEntries(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// RequestVote implements raftpb.RaftServer.
+func (s *grpcServer) RequestVote(ctx context.Context, req *raftpb.RequestVoteRequest) (*raftpb.RequestVoteResponse, error) {
+	res, err := s.g.handleUnary(ctx, requestVoteRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*RequestVoteResponse)
+	out := requestVoteResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// InstallSnapshot implements raftpb.RaftServer. Snapshot data is passed to
+// the consumer through a pipe as chunks arrive.
+func (s *grpcServer) InstallSnapshot(stream raftpb.Raft_InstallSnapshotServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.GetRequest() == nil {
+		return status.Error(codes.InvalidArgument, "missing snapshot request")
+	}
+	args := installSnapshotRequestFromPB(first.GetRequest())
+
+	// Copy the data into the pipe until the client closes the stream. Once
+	// the consumer is done with the pipe, remaining data is discarded.
+	pr, pw := io.Pipe()
+	copyDone := make(chan struct{})
+	go func() {
+		defer close(copyDone)
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.Write(chunk.GetData())
+		}
+	}()
+
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		Command:  args,
+		Reader:   io.LimitReader(pr, args.DataSize()),
+		RespChan: respCh,
+	}
+	res, err := s.g.serve(stream.Context(), rpc, respCh, false)
+	pr.Close()
+	<-copyDone
+	if err != nil {
+		return err
+	}
+	resp, _ := res.Response.(*InstallSnapshotResponse)
+	out := installSnapshotResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return stream.SendAndClose(out)
+}
+
+// RecoverData implements raftpb.RaftServer.
+func (s *grpcServer) RecoverData(ctx context.Context, req *raftpb.RecoveryDataRequest) (*raftpb.RecoveryDataResponse, error) {
+	res, err := s.g.handleUnary(ctx, recoveryDataRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*RecoveryDataResponse)
+	out := recoveryDataResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// UnfreezeWitness implements raftpb.RaftServer.
+func (s *grpcServer) UnfreezeWitness(ctx context.Context, req *raftpb.UnfreezeRequest) (*raftpb.UnfreezeResponse, error) {
+	res, err := s.g.handleUnary(ctx, unfreezeRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*UnfreezeResponse)
+	out := unfreezeResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// ClientId implements raftpb.RaftServer.
+func (s *grpcServer) ClientId(ctx context.Context, req *raftpb.ClientIdRequest) (*raftpb.ClientIdResponse, error) {
+	res, err := s.g.handleUnary(ctx, clientIdRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*ClientIdResponse)
+	out := clientIdResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// Client implements raftpb.RaftServer.
+func (s *grpcServer) Client(ctx context.Context, req *raftpb.ClientRequest) (*raftpb.ClientResponse, error) {
+	res, err := s.g.handleUnary(ctx, clientRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*ClientResponse)
+	out := clientResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// Record implements raftpb.RaftServer.
+func (s *grpcServer) Record(ctx context.Context, req *raftpb.RecordRequest) (*raftpb.RecordResponse, error) {
+	res, err := s.g.handleUnary(ctx, recordRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*RecordResponse)
+	out := recordResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// Sync implements raftpb.RaftServer.
+func (s *grpcServer) Sync(ctx context.Context, req *raftpb.SyncRequest) (*raftpb.SyncResponse, error) {
+	res, err := s.g.handleUnary(ctx, syncRequestFromPB(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*SyncResponse)
+	out := syncResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// errorString returns the message of err, empty if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Conversions between the structures in commands.go and raftpb messages.
+// Empty slices are converted to nil, as they are not distinguished on the
+// wire.
+
+func headerToPB(h RPCHeader) *raftpb.RPCHeader {
+	return &raftpb.RPCHeader{
+		ProtocolVersion:   int64(h.ProtocolVersion),
+		AcceptCompression: uint32(h.AcceptCompression),
+	}
+}
+
+func headerFromPB(h *raftpb.RPCHeader) RPCHeader {
+	return RPCHeader{
+		ProtocolVersion:   ProtocolVersion(h.GetProtocolVersion()),
+		AcceptCompression: CompressionType(h.GetAcceptCompression()),
+	}
+}
+
+func logToPB(l *Log) *raftpb.Log {
+	if l == nil {
+		return nil
+	}
+	var keys [][]byte
+	for _, key := range l.Keys {
+		keys = append(keys, key)
+	}
+	return &raftpb.Log{
+		Index:    l.Index,
+		Term:     l.Term,
+		Type:     raftpb.LogType(l.Type),
+		Data:     l.Data,
+		ClientId: l.ClientID,
+		SeqNo:    l.SeqNo,
+		Keys:     keys,
+		Identity: l.Identity,
+		Checksum: l.Checksum,
+	}
+}
+
+func logFromPB(l *raftpb.Log) *Log {
+	if l == nil {
+		return nil
+	}
+	var keys []Key
+	for _, key := range l.GetKeys() {
+		keys = append(keys, Key(key))
+	}
+	return &Log{
+		Index:    l.GetIndex(),
+		Term:     l.GetTerm(),
+		Type:     LogType(l.GetType()),
+		Data:     l.GetData(),
+		ClientID: l.GetClientId(),
+		SeqNo:    l.GetSeqNo(),
+		Keys:     keys,
+		Identity: l.GetIdentity(),
+		Checksum: l.GetChecksum(),
+	}
+}
+
+func appendEntriesRequestToPB(r *AppendEntriesRequest) *raftpb.AppendEntriesRequest {
+	var entries []*raftpb.Log
+	for _, entry := range r.Entries {
+		entries = append(entries, logToPB(entry))
+	}
+	var ops []*raftpb.ClientSeqNo
+	for _, op := range r.CommittedOps {
+		ops = append(ops, &raftpb.ClientSeqNo{ClientId: op.ClientID, SeqNo: op.SeqNo})
+	}
+	return &raftpb.AppendEntriesRequest{
+		Header:            headerToPB(r.RPCHeader),
+		Term:              r.Term,
+		Leader:            r.Leader,
+		PrevLogEntry:      r.PrevLogEntry,
+		PrevLogTerm:       r.PrevLogTerm,
+		Entries:           entries,
+		LeaderCommitIndex: r.LeaderCommitIndex,
+		CommittedOps:      ops,
+	}
+}
+
+func appendEntriesRequestFromPB(r *raftpb.AppendEntriesRequest) *AppendEntriesRequest {
+	var entries []*Log
+	for _, entry := range r.GetEntries() {
+		entries = append(entries, logFromPB(entry))
+	}
+	var ops []ClientSeqNo
+	for _, op := range r.GetCommittedOps() {
+		ops = append(ops, ClientSeqNo{ClientID: op.GetClientId(), SeqNo: op.GetSeqNo()})
+	}
+	return &AppendEntriesRequest{
+		RPCHeader:         headerFromPB(r.GetHeader()),
+		Term:              r.GetTerm(),
+		Leader:            r.GetLeader(),
+		PrevLogEntry:      r.GetPrevLogEntry(),
+		PrevLogTerm:       r.GetPrevLogTerm(),
+		Entries:           entries,
+		LeaderCommitIndex: r.GetLeaderCommitIndex(),
+		CommittedOps:      ops,
+	}
+}
+
+func appendEntriesResponseToPB(r *AppendEntriesResponse) *raftpb.AppendEntriesResponse {
+	if r == nil {
+		return &raftpb.AppendEntriesResponse{}
+	}
+	return &raftpb.AppendEntriesResponse{
+		Header:         headerToPB(r.RPCHeader),
+		Term:           r.Term,
+		LastLog:        r.LastLog,
+		Success:        r.Success,
+		NoRetryBackoff: r.NoRetryBackoff,
+		WitnessTerm:    r.WitnessTerm,
+	}
+}
+
+func appendEntriesResponseFromPB(r *raftpb.AppendEntriesResponse) *AppendEntriesResponse {
+	return &AppendEntriesResponse{
+		RPCHeader:      headerFromPB(r.GetHeader()),
+		Term:           r.GetTerm(),
+		LastLog:        r.GetLastLog(),
+		Success:        r.GetSuccess(),
+		NoRetryBackoff: r.GetNoRetryBackoff(),
+		WitnessTerm:    r.GetWitnessTerm(),
+	}
+}
+
+func requestVoteRequestToPB(r *RequestVoteRequest) *raftpb.RequestVoteRequest {
+	return &raftpb.RequestVoteRequest{
+		Header:       headerToPB(r.RPCHeader),
+		Term:         r.Term,
+		Candidate:    r.Candidate,
+		LastLogIndex: r.LastLogIndex,
+		LastLogTerm:  r.LastLogTerm,
+	}
+}
+
+func requestVoteRequestFromPB(r *raftpb.RequestVoteRequest) *RequestVoteRequest {
+	return &RequestVoteRequest{
+		RPCHeader:    headerFromPB(r.GetHeader()),
+		Term:         r.GetTerm(),
+		Candidate:    r.GetCandidate(),
+		LastLogIndex: r.GetLastLogIndex(),
+		LastLogTerm:  r.GetLastLogTerm(),
+	}
+}
+
+func requestVoteResponseToPB(r *RequestVoteResponse) *raftpb.RequestVoteResponse {
+	if r == nil {
+		return &raftpb.RequestVoteResponse{}
+	}
+	return &raftpb.RequestVoteResponse{
+		Header:  headerToPB(r.RPCHeader),
+		Term:    r.Term,
+		Peers:   r.Peers,
+		Granted: r.Granted,
+	}
+}
+
+func requestVoteResponseFromPB(r *raftpb.RequestVoteResponse) *RequestVoteResponse {
+	return &RequestVoteResponse{
+		RPCHeader: headerFromPB(r.GetHeader()),
+		Term:      r.GetTerm(),
+		Peers:     r.GetPeers(),
+		Granted:   r.GetGranted(),
+	}
+}
+
+func installSnapshotRequestToPB(r *InstallSnapshotRequest) *raftpb.InstallSnapshotRequest {
+	return &raftpb.InstallSnapshotRequest{
+		Header:             headerToPB(r.RPCHeader),
+		SnapshotVersion:    int64(r.SnapshotVersion),
+		Term:               r.Term,
+		Leader:             r.Leader,
+		LastLogIndex:       r.LastLogIndex,
+		LastLogTerm:        r.LastLogTerm,
+		Peers:              r.Peers,
+		Configuration:      r.Configuration,
+		ConfigurationIndex: r.ConfigurationIndex,
+		Size:               r.Size,
+		ClientIdentities:   r.ClientIdentities,
+		ChunkSize:          r.ChunkSize,
+		Offset:             r.Offset,
+		Checksum:           r.Checksum,
+		BaseIndex:          r.BaseIndex,
+		BaseTerm:           r.BaseTerm,
+	}
+}
+
+func installSnapshotRequestFromPB(r *raftpb.InstallSnapshotRequest) *InstallSnapshotRequest {
+	return &InstallSnapshotRequest{
+		RPCHeader:          headerFromPB(r.GetHeader()),
+		SnapshotVersion:    SnapshotVersion(r.GetSnapshotVersion()),
+		Term:               r.GetTerm(),
+		Leader:             r.GetLeader(),
+		LastLogIndex:       r.GetLastLogIndex(),
+		LastLogTerm:        r.GetLastLogTerm(),
+		Peers:              r.GetPeers(),
+		Configuration:      r.GetConfiguration(),
+		ConfigurationIndex: r.GetConfigurationIndex(),
+		Size:               r.GetSize(),
+		ClientIdentities:   r.GetClientIdentities(),
+		ChunkSize:          r.GetChunkSize(),
+		Offset:             r.GetOffset(),
+		Checksum:           r.GetChecksum(),
+		BaseIndex:          r.GetBaseIndex(),
+		BaseTerm:           r.GetBaseTerm(),
+	}
+}
+
+func installSnapshotResponseToPB(r *InstallSnapshotResponse) *raftpb.InstallSnapshotResponse {
+	if r == nil {
+		return &raftpb.InstallSnapshotResponse{}
+	}
+	var snapshots []*raftpb.SnapshotPosition
+	for _, snap := range r.Snapshots {
+		snapshots = append(snapshots, &raftpb.SnapshotPosition{Index: snap.Index, Term: snap.Term})
+	}
+	return &raftpb.InstallSnapshotResponse{
+		Header:      headerToPB(r.RPCHeader),
+		Term:        r.Term,
+		Success:     r.Success,
+		NextOffset:  r.NextOffset,
+		BaseMissing: r.BaseMissing,
+		Snapshots:   snapshots,
+	}
+}
+
+func installSnapshotResponseFromPB(r *raftpb.InstallSnapshotResponse) *InstallSnapshotResponse {
+	var snapshots []SnapshotPosition
+	for _, snap := range r.GetSnapshots() {
+		snapshots = append(snapshots, SnapshotPosition{Index: snap.GetIndex(), Term: snap.GetTerm()})
+	}
+	return &InstallSnapshotResponse{
+		RPCHeader:   headerFromPB(r.GetHeader()),
+		Term:        r.GetTerm(),
+		Success:     r.GetSuccess(),
+		NextOffset:  r.GetNextOffset(),
+		BaseMissing: r.GetBaseMissing(),
+		Snapshots:   snapshots,
+	}
+}
+
+func recoveryDataRequestToPB(r *RecoveryDataRequest) *raftpb.RecoveryDataRequest {
+	return &raftpb.RecoveryDataRequest{
+		Header: headerToPB(r.RPCHeader),
+	}
+}
+
+func recoveryDataRequestFromPB(r *raftpb.RecoveryDataRequest) *RecoveryDataRequest {
+	return &RecoveryDataRequest{
+		RPCHeader: headerFromPB(r.GetHeader()),
+	}
+}
+
+func recoveryDataResponseToPB(r *RecoveryDataResponse) *raftpb.RecoveryDataResponse {
+	if r == nil {
+		return &raftpb.RecoveryDataResponse{}
+	}
+	var entries []*raftpb.Log
+	for i := range r.Entries {
+		entries = append(entries, logToPB(&r.Entries[i]))
+	}
+	return &raftpb.RecoveryDataResponse{
+		Header:  headerToPB(r.RPCHeader),
+		Entries: entries,
+	}
+}
+
+func recoveryDataResponseFromPB(r *raftpb.RecoveryDataResponse) *RecoveryDataResponse {
+	var entries []Log
+	for _, entry := range r.GetEntries() {
+		if entry != nil {
+			entries = append(entries, *logFromPB(entry))
+		}
+	}
+	return &RecoveryDataResponse{
+		RPCHeader: headerFromPB(r.GetHeader()),
+		Entries:   entries,
+	}
+}
+
+func unfreezeRequestToPB(r *UnfreezeRequest) *raftpb.UnfreezeRequest {
+	return &raftpb.UnfreezeRequest{
+		Header: headerToPB(r.RPCHeader),
+		Term:   r.Term,
+	}
+}
+
+func unfreezeRequestFromPB(r *raftpb.UnfreezeRequest) *UnfreezeRequest {
+	return &UnfreezeRequest{
+		RPCHeader: headerFromPB(r.GetHeader()),
+		Term:      r.GetTerm(),
+	}
+}
+
+func unfreezeResponseToPB(r *UnfreezeResponse) *raftpb.UnfreezeResponse {
+	if r == nil {
+		return &raftpb.UnfreezeResponse{}
+	}
+	return &raftpb.UnfreezeResponse{
+		Header: headerToPB(r.RPCHeader),
+	}
+}
+
+func unfreezeResponseFromPB(r *raftpb.UnfreezeResponse) *UnfreezeResponse {
+	return &UnfreezeResponse{
+		RPCHeader: headerFromPB(r.GetHeader()),
+	}
+}
+
+func clientIdRequestToPB(r *ClientIdRequest) *raftpb.ClientIdRequest {
+	return &raftpb.ClientIdRequest{
+		Header:      headerToPB(r.RPCHeader),
+		Credentials: r.Credentials,
+	}
+}
+
+func clientIdRequestFromPB(r *raftpb.ClientIdRequest) *ClientIdRequest {
+	return &ClientIdRequest{
+		RPCHeader:   headerFromPB(r.GetHeader()),
+		Credentials: r.GetCredentials(),
+	}
+}
+
+func clientIdResponseToPB(r *ClientIdResponse) *raftpb.ClientIdResponse {
+	if r == nil {
+		return &raftpb.ClientIdResponse{}
+	}
+	return &raftpb.ClientIdResponse{
+		Header:        headerToPB(r.RPCHeader),
+		ClientId:      r.ClientID,
+		LeaderAddress: string(r.LeaderAddress),
+		Term:          r.Term,
+	}
+}
+
+func clientIdResponseFromPB(r *raftpb.ClientIdResponse) *ClientIdResponse {
+	return &ClientIdResponse{
+		RPCHeader:     headerFromPB(r.GetHeader()),
+		ClientID:      r.GetClientId(),
+		LeaderAddress: ServerAddress(r.GetLeaderAddress()),
+		Term:          r.GetTerm(),
+	}
+}
+
+func clientRequestToPB(r *ClientRequest) *raftpb.ClientRequest {
+	return &raftpb.ClientRequest{
+		Header:      headerToPB(r.RPCHeader),
+		Entry:       logToPB(r.Entry),
+		Credentials: r.Credentials,
+	}
+}
+
+func clientRequestFromPB(r *raftpb.ClientRequest) *ClientRequest {
+	return &ClientRequest{
+		RPCHeader:   headerFromPB(r.GetHeader()),
+		Entry:       logFromPB(r.GetEntry()),
+		Credentials: r.GetCredentials(),
+	}
+}
+
+func clientResponseToPB(r *ClientResponse) *raftpb.ClientResponse {
+	if r == nil {
+		return &raftpb.ClientResponse{}
+	}
+	return &raftpb.ClientResponse{
+		Header:        headerToPB(r.RPCHeader),
+		Success:       r.Success,
+		LeaderAddress: string(r.LeaderAddress),
+		ResponseData:  r.ResponseData,
+		Synced:        r.Synced,
+		Term:          r.Term,
+	}
+}
+
+func clientResponseFromPB(r *raftpb.ClientResponse) *ClientResponse {
+	return &ClientResponse{
+		RPCHeader:     headerFromPB(r.GetHeader()),
+		Success:       r.GetSuccess(),
+		LeaderAddress: ServerAddress(r.GetLeaderAddress()),
+		ResponseData:  r.GetResponseData(),
+		Synced:        r.GetSynced(),
+		Term:          r.GetTerm(),
+	}
+}
+
+func recordRequestToPB(r *RecordRequest) *raftpb.RecordRequest {
+	return &raftpb.RecordRequest{
+		Header:        headerToPB(r.RPCHeader),
+		Entry:         logToPB(r.Entry),
+		Credentials:   r.Credentials,
+		Term:          r.Term,
+		LeaderAddress: string(r.LeaderAddress),
+	}
+}
+
+func recordRequestFromPB(r *raftpb.RecordRequest) *RecordRequest {
+	return &RecordRequest{
+		RPCHeader:     headerFromPB(r.GetHeader()),
+		Entry:         logFromPB(r.GetEntry()),
+		Credentials:   r.GetCredentials(),
+		Term:          r.GetTerm(),
+		LeaderAddress: ServerAddress(r.GetLeaderAddress()),
+	}
+}
+
+func recordResponseToPB(r *RecordResponse) *raftpb.RecordResponse {
+	if r == nil {
+		return &raftpb.RecordResponse{}
+	}
+	return &raftpb.RecordResponse{
+		Header:        headerToPB(r.RPCHeader),
+		Success:       r.Success,
+		Term:          r.Term,
+		LeaderAddress: string(r.LeaderAddress),
+	}
+}
+
+func recordResponseFromPB(r *raftpb.RecordResponse) *RecordResponse {
+	return &RecordResponse{
+		RPCHeader:     headerFromPB(r.GetHeader()),
+		Success:       r.GetSuccess(),
+		Term:          r.GetTerm(),
+		LeaderAddress: ServerAddress(r.GetLeaderAddress()),
+	}
+}
+
+func syncRequestToPB(r *SyncRequest) *raftpb.SyncRequest {
+	return &raftpb.SyncRequest{
+		Header:      headerToPB(r.RPCHeader),
+		Entry:       logToPB(r.Entry),
+		Credentials: r.Credentials,
+	}
+}
+
+func syncRequestFromPB(r *raftpb.SyncRequest) *SyncRequest {
+	return &SyncRequest{
+		RPCHeader:   headerFromPB(r.GetHeader()),
+		Entry:       logFromPB(r.GetEntry()),
+		Credentials: r.GetCredentials(),
+	}
+}
+
+func syncResponseToPB(r *SyncResponse) *raftpb.SyncResponse {
+	if r == nil {
+		return &raftpb.SyncResponse{}
+	}
+	return &raftpb.SyncResponse{
+		Header:        headerToPB(r.RPCHeader),
+		Success:       r.Success,
+		LeaderAddress: string(r.LeaderAddress),
+		ResponseData:  r.ResponseData,
+	}
+}
+
+func syncResponseFromPB(r *raftpb.SyncResponse) *SyncResponse {
+	return &SyncResponse{
+		RPCHeader:     headerFromPB(r.GetHeader()),
+		Success:       r.GetSuccess(),
+		LeaderAddress: ServerAddress(r.GetLeaderAddress()),
+		ResponseData:  r.GetResponseData(),
+	}
+}
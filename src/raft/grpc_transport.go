@@ -0,0 +1,702 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft/raftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// grpcMaxMessageSize is the default limit on the size of a single
+	// message, which bounds the size of an AppendEntries batch.
+	grpcMaxMessageSize = 64 * 1024 * 1024
+
+	// grpcSnapshotChunkSize is the amount of snapshot data sent in each
+	// message of the InstallSnapshot stream.
+	grpcSnapshotChunkSize = 256 * 1024
+)
+
+/*
+
+GRPCTransport provides a network based transport that uses gRPC over
+HTTP/2, for deployments that standardize on gRPC for service meshes,
+load balancing and observability. The service it serves is defined in
+raftpb/raft.proto and implemented in grpc_service.go. It implements both
+the Transport and ClientTransport interfaces, and registers the standard
+gRPC health service, reporting "raft.Raft" as serving until the
+transport is closed.
+
+All RPCs to a peer share one HTTP/2 connection. AppendEntriesPipeline
+is a bidirectional stream and InstallSnapshot streams the snapshot in
+chunks after the request.
+
+*/
+type GRPCTransport struct {
+	conns     map[ServerAddress]*grpc.ClientConn
+	connsLock sync.Mutex
+	dialOpts  []grpc.DialOption
+
+	consumeCh chan RPC
+
+	heartbeatFn     func(RPC)
+	heartbeatFnLock sync.Mutex
+	witnessFn       func(RPC)
+	witnessFnLock   sync.Mutex
+
+	health    *health.Server
+	server    *grpc.Server
+	advertise net.Addr
+
+	logger *log.Logger
+
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+
+	timeout      time.Duration
+	TimeoutScale int
+}
+
+// GRPCTransportConfig encapsulates configuration for the gRPC transport.
+type GRPCTransportConfig struct {
+	Logger *log.Logger
+
+	// Advertise is the address other servers should use to reach this
+	// one, if different from the bound address.
+	Advertise net.Addr
+
+	// Timeout is the deadline of each RPC. For InstallSnapshot, we multiply
+	// the timeout by (SnapshotSize / TimeoutScale).
+	Timeout time.Duration
+
+	// MaxMessageSize limits the size of messages sent and received.
+	// Defaults to 64MB.
+	MaxMessageSize int
+
+	// ServerOptions are added to the options of the gRPC server, such as
+	// credentials and interceptors.
+	ServerOptions []grpc.ServerOption
+
+	// DialOptions are added to the options used to connect to other
+	// servers. Connections are insecure unless credentials are given here.
+	DialOptions []grpc.DialOption
+}
+
+// NewGRPCTransport creates a GRPCTransport serving on bindAddr.
+func NewGRPCTransport(bindAddr string, config *GRPCTransportConfig) (*GRPCTransport, error) {
+	list, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify that we have a usable advertise address
+	addr := config.Advertise
+	if addr == nil {
+		addr = list.Addr()
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		list.Close()
+		return nil, errNotTCP
+	}
+	if tcpAddr.IP.IsUnspecified() {
+		list.Close()
+		return nil, errNotAdvertisable
+	}
+
+	g := newGRPCTransport(config)
+	g.advertise = addr
+
+	maxSize := g.maxMessageSize(config)
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxSize),
+		grpc.MaxSendMsgSize(maxSize),
+	}
+	g.server = grpc.NewServer(append(opts, config.ServerOptions...)...)
+	raftpb.RegisterRaftServer(g.server, &grpcServer{g: g})
+
+	g.health = health.NewServer()
+	g.health.SetServingStatus(grpcServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(g.server, g.health)
+
+	go func() {
+		if err := g.server.Serve(list); err != nil && !g.IsShutdown() {
+			g.logger.Printf("[ERR] raft-grpc: Failed to serve: %v", err)
+		}
+	}()
+	return g, nil
+}
+
+// NewGRPCClientTransport creates a GRPCTransport that only sends RPCs, for
+// use by client sessions.
+func NewGRPCClientTransport(config *GRPCTransportConfig) *GRPCTransport {
+	return newGRPCTransport(config)
+}
+
+func newGRPCTransport(config *GRPCTransportConfig) *GRPCTransport {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	g := &GRPCTransport{
+		conns:        make(map[ServerAddress]*grpc.ClientConn),
+		consumeCh:    make(chan RPC),
+		logger:       logger,
+		shutdownCh:   make(chan struct{}),
+		timeout:      config.Timeout,
+		TimeoutScale: DefaultTimeoutScale,
+	}
+
+	maxSize := g.maxMessageSize(config)
+	g.dialOpts = []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(maxSize),
+			grpc.MaxCallSendMsgSize(maxSize),
+		),
+	}
+	g.dialOpts = append(g.dialOpts, config.DialOptions...)
+	return g
+}
+
+func (g *GRPCTransport) maxMessageSize(config *GRPCTransportConfig) int {
+	if config.MaxMessageSize > 0 {
+		return config.MaxMessageSize
+	}
+	return grpcMaxMessageSize
+}
+
+// SetHeartbeatHandler is used to setup a heartbeat handler
+// as a fast-pass. This is to avoid head-of-line blocking from
+// disk IO.
+func (g *GRPCTransport) SetHeartbeatHandler(cb func(rpc RPC)) {
+	g.heartbeatFnLock.Lock()
+	defer g.heartbeatFnLock.Unlock()
+	g.heartbeatFn = cb
+}
+
+// SetWitnessHandler implements the WithWitnessHandler interface.
+func (g *GRPCTransport) SetWitnessHandler(cb func(rpc RPC)) {
+	g.witnessFnLock.Lock()
+	defer g.witnessFnLock.Unlock()
+	g.witnessFn = cb
+}
+
+// Close is used to stop the transport. The health service reports the
+// Raft service as not serving before the server stops.
+func (g *GRPCTransport) Close() error {
+	g.shutdownLock.Lock()
+	defer g.shutdownLock.Unlock()
+	if g.shutdown {
+		return nil
+	}
+	g.shutdown = true
+	close(g.shutdownCh)
+
+	if g.server != nil {
+		g.health.Shutdown()
+		g.server.Stop()
+	}
+	g.DisconnectAll()
+	return nil
+}
+
+// IsShutdown is used to check if the transport is shutdown.
+func (g *GRPCTransport) IsShutdown() bool {
+	select {
+	case <-g.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Consumer implements the Transport interface.
+func (g *GRPCTransport) Consumer() <-chan RPC {
+	return g.consumeCh
+}
+
+// LocalAddr implements the Transport interface.
+func (g *GRPCTransport) LocalAddr() ServerAddress {
+	if g.advertise == nil {
+		return ""
+	}
+	return ServerAddress(g.advertise.String())
+}
+
+// Server returns the underlying gRPC server, so that other services can be
+// registered on it. Nil for a client transport.
+func (g *GRPCTransport) Server() *grpc.Server {
+	return g.server
+}
+
+// getConn returns the connection to target, creating it if needed.
+// Connections are established lazily by gRPC.
+func (g *GRPCTransport) getConn(target ServerAddress) (*grpc.ClientConn, error) {
+	if g.IsShutdown() {
+		return nil, ErrTransportShutdown
+	}
+	g.connsLock.Lock()
+	defer g.connsLock.Unlock()
+	if conn, ok := g.conns[target]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(string(target), g.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	g.conns[target] = conn
+	return conn, nil
+}
+
+// Connect implements the WithPeers interface. Connections are created
+// on demand, so this does nothing.
+func (g *GRPCTransport) Connect(peer ServerAddress, t Transport) {
+}
+
+// Disconnect implements the WithPeers interface, closing the connection
+// to peer.
+func (g *GRPCTransport) Disconnect(peer ServerAddress) {
+	g.connsLock.Lock()
+	defer g.connsLock.Unlock()
+	if conn, ok := g.conns[peer]; ok {
+		conn.Close()
+		delete(g.conns, peer)
+	}
+}
+
+// DisconnectAll implements the WithPeers interface.
+func (g *GRPCTransport) DisconnectAll() {
+	g.connsLock.Lock()
+	defer g.connsLock.Unlock()
+	for peer, conn := range g.conns {
+		conn.Close()
+		delete(g.conns, peer)
+	}
+}
+
+// callContext returns the context of an RPC with the given deadline.
+func (g *GRPCTransport) callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// invoke sends a unary RPC with call and waits for its response. call
+// returns the error string of the response.
+// Returns: whether the target responded, and the RPC error if any.
+func (g *GRPCTransport) invoke(target ServerAddress, call func(ctx context.Context, client raftpb.RaftClient) (string, error)) (bool, error) {
+	conn, err := g.getConn(target)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := g.callContext(g.timeout)
+	defer cancel()
+
+	respErr, err := call(ctx, raftpb.NewRaftClient(conn))
+	if err != nil {
+		return false, err
+	}
+	if respErr != "" {
+		return true, errors.New(respErr)
+	}
+	return true, nil
+}
+
+// AppendEntriesPipeline implements the Transport interface.
+func (g *GRPCTransport) AppendEntriesPipeline(id ServerID, target ServerAddress) (AppendPipeline, error) {
+	conn, err := g.getConn(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := raftpb.NewRaftClient(conn).AppendEntriesPipeline(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return newGRPCPipeline(stream, cancel), nil
+}
+
+// AppendEntries implements the Transport interface.
+func (g *GRPCTransport) AppendEntries(id ServerID, target ServerAddress, args *AppendEntriesRequest, resp *AppendEntriesResponse) error {
+	_, err := g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.AppendEntries(ctx, appendEntriesRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *appendEntriesResponseFromPB(out)
+		return out.GetError(), nil
+	})
+	return err
+}
+
+// RequestVote implements the Transport interface.
+func (g *GRPCTransport) RequestVote(id ServerID, target ServerAddress, args *RequestVoteRequest, resp *RequestVoteResponse) error {
+	_, err := g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.RequestVote(ctx, requestVoteRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *requestVoteResponseFromPB(out)
+		return out.GetError(), nil
+	})
+	return err
+}
+
+// RecoverData implements the Transport interface.
+func (g *GRPCTransport) RecoverData(id ServerID, target ServerAddress, args *RecoveryDataRequest, resp *RecoveryDataResponse) error {
+	_, err := g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.RecoverData(ctx, recoveryDataRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *recoveryDataResponseFromPB(out)
+		return out.GetError(), nil
+	})
+	return err
+}
+
+// UnfreezeWitness implements the Transport interface.
+func (g *GRPCTransport) UnfreezeWitness(id ServerID, target ServerAddress, args *UnfreezeRequest, resp *UnfreezeResponse) error {
+	_, err := g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.UnfreezeWitness(ctx, unfreezeRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *unfreezeResponseFromPB(out)
+		return out.GetError(), nil
+	})
+	return err
+}
+
+// InstallSnapshot implements the Transport interface. The request is sent
+// first on the stream, followed by the snapshot data in chunks.
+func (g *GRPCTransport) InstallSnapshot(id ServerID, target ServerAddress, args *InstallSnapshotRequest, resp *InstallSnapshotResponse, data io.Reader) error {
+	conn, err := g.getConn(target)
+	if err != nil {
+		return err
+	}
+
+	// Set a deadline, scaled by request size
+	timeout := g.timeout
+	if timeout > 0 {
+		timeout = g.timeout * time.Duration(args.DataSize()/int64(g.TimeoutScale))
+		if timeout < g.timeout {
+			timeout = g.timeout
+		}
+	}
+	ctx, cancel := g.callContext(timeout)
+	defer cancel()
+
+	stream, err := raftpb.NewRaftClient(conn).InstallSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Send the request, then stream the state. An io.EOF from Send means
+	// the server ended the stream, and the reason is returned by
+	// CloseAndRecv below.
+	err = stream.Send(&raftpb.InstallSnapshotChunk{Request: installSnapshotRequestToPB(args)})
+	buf := make([]byte, grpcSnapshotChunkSize)
+	for err == nil {
+		n, readErr := data.Read(buf)
+		if n > 0 {
+			err = stream.Send(&raftpb.InstallSnapshotChunk{Data: buf[:n]})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// Decode the response
+	out, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	*resp = *installSnapshotResponseFromPB(out)
+	if out.GetError() != "" {
+		return errors.New(out.GetError())
+	}
+	return nil
+}
+
+// EncodePeer implements the Transport interface.
+func (g *GRPCTransport) EncodePeer(id ServerID, p ServerAddress) []byte {
+	return []byte(p)
+}
+
+// DecodePeer implements the Transport interface.
+func (g *GRPCTransport) DecodePeer(buf []byte) ServerAddress {
+	return ServerAddress(buf)
+}
+
+// OpenClientConn implements the ClientTransport interface. Connects to
+// target and waits until the connection is ready or has failed.
+func (g *GRPCTransport) OpenClientConn(target ServerAddress) error {
+	conn, err := g.getConn(target)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.callContext(g.timeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("failed to connect to %v: %v", target, state)
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// SendClientIdRequest implements the ClientTransport interface.
+func (g *GRPCTransport) SendClientIdRequest(target ServerAddress, args *ClientIdRequest, resp *ClientIdResponse) (bool, error) {
+	return g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.ClientId(ctx, clientIdRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *clientIdResponseFromPB(out)
+		return out.GetError(), nil
+	})
+}
+
+// SendClientRequest implements the ClientTransport interface.
+func (g *GRPCTransport) SendClientRequest(target ServerAddress, args *ClientRequest, resp *ClientResponse) (bool, error) {
+	return g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.Client(ctx, clientRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *clientResponseFromPB(out)
+		return out.GetError(), nil
+	})
+}
+
+// SendRecordRequest implements the ClientTransport interface.
+func (g *GRPCTransport) SendRecordRequest(target ServerAddress, args *RecordRequest, resp *RecordResponse) (bool, error) {
+	return g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.Record(ctx, recordRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *recordResponseFromPB(out)
+		return out.GetError(), nil
+	})
+}
+
+// SendSyncRequest implements the ClientTransport interface.
+func (g *GRPCTransport) SendSyncRequest(target ServerAddress, args *SyncRequest, resp *SyncResponse) (bool, error) {
+	return g.invoke(target, func(ctx context.Context, client raftpb.RaftClient) (string, error) {
+		out, err := client.Sync(ctx, syncRequestToPB(args))
+		if err != nil {
+			return "", err
+		}
+		*resp = *syncResponseFromPB(out)
+		return out.GetError(), nil
+	})
+}
+
+// handleAppendEntries serves an AppendEntries request, sent on its own or
+// on a pipeline.
+func (g *GRPCTransport) handleAppendEntries(ctx context.Context, req *raftpb.AppendEntriesRequest) (*raftpb.AppendEntriesResponse, error) {
+	args := appendEntriesRequestFromPB(req)
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		Command:  args,
+		RespChan: respCh,
+	}
+	res, err := g.serve(ctx, rpc, respCh, isHeartbeatRequest(args))
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := res.Response.(*AppendEntriesResponse)
+	out := appendEntriesResponseToPB(resp)
+	out.Error = errorString(res.Error)
+	return out, nil
+}
+
+// handleUnary serves a unary RPC of the Raft service other than
+// AppendEntries.
+func (g *GRPCTransport) handleUnary(ctx context.Context, command interface{}) (RPCResponse, error) {
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		Command:  command,
+		RespChan: respCh,
+	}
+	return g.serve(ctx, rpc, respCh, false)
+}
+
+// serve hands rpc to the heartbeat or witness fast-path, or the consumer,
+// and waits for the response.
+func (g *GRPCTransport) serve(ctx context.Context, rpc RPC, respCh chan RPCResponse, isHeartbeat bool) (RPCResponse, error) {
+	// Check for heartbeat fast-path
+	dispatched := false
+	if isHeartbeat {
+		g.heartbeatFnLock.Lock()
+		fn := g.heartbeatFn
+		g.heartbeatFnLock.Unlock()
+		if fn != nil {
+			fn(rpc)
+			dispatched = true
+		}
+	}
+
+	// Check for witness fast-path
+	if _, ok := rpc.Command.(*RecordRequest); ok {
+		g.witnessFnLock.Lock()
+		fn := g.witnessFn
+		g.witnessFnLock.Unlock()
+		if fn != nil {
+			fn(rpc)
+			dispatched = true
+		}
+	}
+
+	// Dispatch the RPC
+	if !dispatched {
+		select {
+		case g.consumeCh <- rpc:
+		case <-ctx.Done():
+			return RPCResponse{}, status.FromContextError(ctx.Err()).Err()
+		case <-g.shutdownCh:
+			return RPCResponse{}, status.Error(codes.Unavailable, ErrTransportShutdown.Error())
+		}
+	}
+
+	// Wait for response
+	select {
+	case resp := <-respCh:
+		return resp, nil
+	case <-ctx.Done():
+		return RPCResponse{}, status.FromContextError(ctx.Err()).Err()
+	case <-g.shutdownCh:
+		return RPCResponse{}, status.Error(codes.Unavailable, ErrTransportShutdown.Error())
+	}
+}
+
+// grpcPipeline sends AppendEntries requests on a bidirectional stream.
+type grpcPipeline struct {
+	stream raftpb.Raft_AppendEntriesPipelineClient
+	cancel context.CancelFunc
+
+	doneCh       chan AppendFuture
+	inprogressCh chan *appendFuture
+
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+}
+
+// newGRPCPipeline is used to construct a grpcPipeline from a given stream.
+func newGRPCPipeline(stream raftpb.Raft_AppendEntriesPipelineClient, cancel context.CancelFunc) *grpcPipeline {
+	p := &grpcPipeline{
+		stream:       stream,
+		cancel:       cancel,
+		doneCh:       make(chan AppendFuture, rpcMaxPipeline),
+		inprogressCh: make(chan *appendFuture, rpcMaxPipeline),
+		shutdownCh:   make(chan struct{}),
+	}
+	go p.decodeResponses()
+	return p
+}
+
+// decodeResponses is a long running routine that decodes the responses
+// sent on the stream.
+func (p *grpcPipeline) decodeResponses() {
+	for {
+		select {
+		case future := <-p.inprogressCh:
+			out, err := p.stream.Recv()
+			if err == nil {
+				*future.resp = *appendEntriesResponseFromPB(out)
+				if out.GetError() != "" {
+					err = errors.New(out.GetError())
+				}
+			}
+			future.respond(err)
+			select {
+			case p.doneCh <- future:
+			case <-p.shutdownCh:
+				return
+			}
+		case <-p.shutdownCh:
+			return
+		}
+	}
+}
+
+// AppendEntries is used to pipeline a new append entries request.
+func (p *grpcPipeline) AppendEntries(args *AppendEntriesRequest, resp *AppendEntriesResponse) (AppendFuture, error) {
+	// Create a new future
+	future := &appendFuture{
+		start: time.Now(),
+		args:  args,
+		resp:  resp,
+	}
+	future.init()
+
+	// Send the RPC
+	if err := p.stream.Send(appendEntriesRequestToPB(future.args)); err != nil {
+		return nil, err
+	}
+
+	// Hand-off for decoding, this can also cause back-pressure
+	// to prevent too many inflight requests
+	select {
+	case p.inprogressCh <- future:
+		return future, nil
+	case <-p.shutdownCh:
+		return nil, ErrPipelineShutdown
+	}
+}
+
+// Consumer returns a channel that can be used to consume complete futures.
+func (p *grpcPipeline) Consumer() <-chan AppendFuture {
+	return p.doneCh
+}
+
+// Close is used to shutdown the pipeline stream.
+func (p *grpcPipeline) Close() error {
+	p.shutdownLock.Lock()
+	defer p.shutdownLock.Unlock()
+	if p.shutdown {
+		return nil
+	}
+
+	// Cancel the stream
+	p.cancel()
+
+	p.shutdown = true
+	close(p.shutdownCh)
+	return nil
+}
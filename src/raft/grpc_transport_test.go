@@ -0,0 +1,182 @@
+package raft
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft/raftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func makeGRPCTransport(t *testing.T) *GRPCTransport {
+	trans, err := NewGRPCTransport("127.0.0.1:0", &GRPCTransportConfig{
+		Logger:  newTestLogger(t),
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return trans
+}
+
+func TestGRPCTransport_ErrorResponse(t *testing.T) {
+	trans1 := makeGRPCTransport(t)
+	defer trans1.Close()
+	go func() {
+		for rpc := range trans1.Consumer() {
+			rpc.Respond(&ClientResponse{LeaderAddress: "leader"}, ErrNotLeader)
+		}
+	}()
+
+	trans2 := NewGRPCClientTransport(&GRPCTransportConfig{Timeout: time.Second})
+	defer trans2.Close()
+
+	// Responses carrying an error arrive intact
+	var resp ClientResponse
+	responded, err := trans2.SendClientRequest(trans1.LocalAddr(), &ClientRequest{}, &resp)
+	if !responded || err == nil || err.Error() != ErrNotLeader.Error() {
+		t.Fatalf("bad: %v %v", responded, err)
+	}
+	if resp.LeaderAddress != "leader" {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+
+	// A server that is down does not respond
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	list.Close()
+	responded, err = trans2.SendClientRequest(ServerAddress(list.Addr().String()), &ClientRequest{}, &resp)
+	if responded || err == nil {
+		t.Fatalf("bad: %v %v", responded, err)
+	}
+}
+
+func TestGRPCTransport_Heartbeat(t *testing.T) {
+	trans1 := makeGRPCTransport(t)
+	defer trans1.Close()
+	heartbeatCh := make(chan *AppendEntriesRequest, 1)
+	trans1.SetHeartbeatHandler(func(rpc RPC) {
+		heartbeatCh <- rpc.Command.(*AppendEntriesRequest)
+		rpc.Respond(&AppendEntriesResponse{Term: 4, Success: true}, nil)
+	})
+
+	trans2 := NewGRPCClientTransport(&GRPCTransportConfig{Timeout: time.Second})
+	defer trans2.Close()
+
+	// Heartbeats skip the consumer
+	var resp AppendEntriesResponse
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success || resp.Term != 4 {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+	select {
+	case req := <-heartbeatCh:
+		if req.Term != 10 {
+			t.Fatalf("bad req: %#v", req)
+		}
+	default:
+		t.Fatalf("heartbeat handler not called")
+	}
+}
+
+func TestGRPCTransport_Health(t *testing.T) {
+	trans := makeGRPCTransport(t)
+
+	conn, err := grpc.NewClient(string(trans.LocalAddr()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: grpcServiceName})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("bad status: %v", resp.Status)
+	}
+
+	// Not serving once closed
+	trans.Close()
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: grpcServiceName}); err == nil {
+		t.Fatalf("expected health check to fail")
+	}
+}
+
+func TestGRPCTransport_Protobuf(t *testing.T) {
+	trans := makeGRPCTransport(t)
+	defer trans.Close()
+	go func() {
+		for rpc := range trans.Consumer() {
+			req := rpc.Command.(*ClientRequest)
+			rpc.Respond(&ClientResponse{Success: true, ResponseData: req.Entry.Data, Term: 3}, nil)
+		}
+	}()
+
+	// Clients generated from raft.proto can call the service directly
+	conn, err := grpc.NewClient(string(trans.LocalAddr()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	client := raftpb.NewRaftClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.Client(ctx, &raftpb.ClientRequest{
+		Entry: &raftpb.Log{Type: raftpb.LogType_LOG_TYPE_COMMAND, Data: []byte("cmd"), ClientId: 7, SeqNo: 1},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success || string(resp.ResponseData) != "cmd" || resp.Term != 3 || resp.Error != "" {
+		t.Fatalf("bad resp: %v", resp)
+	}
+}
+
+func TestGRPCTransport_Session(t *testing.T) {
+	trans1 := makeGRPCTransport(t)
+	defer trans1.Close()
+	go func() {
+		for rpc := range trans1.Consumer() {
+			switch rpc.Command.(type) {
+			case *ClientIdRequest:
+				rpc.Respond(&ClientIdResponse{ClientID: 7, Term: 2}, nil)
+			case *ClientRequest:
+				rpc.Respond(&ClientResponse{Success: true, ResponseData: []byte("ok"), Term: 2}, nil)
+			default:
+				rpc.Respond(nil, nil)
+			}
+		}
+	}()
+
+	clientTrans := NewGRPCClientTransport(&GRPCTransportConfig{Timeout: time.Second})
+	defer clientTrans.Close()
+
+	session, err := CreateClientSession(clientTrans, []ServerAddress{trans1.LocalAddr()})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if session.clientID != 7 {
+		t.Fatalf("bad client ID: %v", session.clientID)
+	}
+	var resp ClientResponse
+	if err := session.SendRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(resp.ResponseData) != "ok" {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+}
@@ -0,0 +1,273 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+
+Incremental snapshots. An FSM implementing IncrementalFSM can persist only the
+changes since an earlier snapshot, its base, rather than its whole state. A
+SnapshotStore implementing IncrementalSnapshotStore stores such a delta with
+the ID of its base in SnapshotMeta.Base, so that snapshots form chains: a full
+snapshot followed by deltas, each of the snapshot before it. A new snapshot is
+a delta of the latest one until Config.MaxSnapshotChain deltas follow the
+last full snapshot, which bounds the time to restore.
+
+A snapshot is restored by restoring the full snapshot its chain starts from,
+then applying each delta in turn with IncrementalFSM.RestoreDelta. Snapshots
+of the chain the FSM already holds are skipped, so a follower installing a
+chain one snapshot at a time only applies each delta. The leader sends a
+follower the latest snapshot of its chain, each delta carrying the index and
+term of its base. A follower that does not have the base rejects the delta
+and lists the snapshots it has, and the leader sends the deltas after the
+newest of those in its chain, or the whole chain from the full snapshot if
+the follower has none of it.
+
+Snapshots taken with Raft.Snapshot are always full, so that they can be
+opened and restored on their own.
+
+*/
+
+// IncrementalFSM is an FSM that can snapshot the changes since an earlier
+// snapshot rather than its whole state.
+type IncrementalFSM interface {
+	FSM
+
+	// SnapshotDelta is like Snapshot, but the FSMSnapshot only persists the
+	// changes since the state at base, which is the latest snapshot the FSM
+	// was snapshotted or restored to. It returns ErrFullSnapshotRequired to
+	// take a full snapshot instead, for example if base is not the state it
+	// tracks changes since.
+	SnapshotDelta(base *SnapshotMeta) (FSMSnapshot, error)
+
+	// RestoreDelta applies the changes persisted by SnapshotDelta to the
+	// state of their base, which the FSM holds. It is not called
+	// concurrently with any other command.
+	RestoreDelta(io.ReadCloser) error
+}
+
+// IncrementalSnapshotStore is a SnapshotStore that also stores deltas, and
+// the chains they form.
+type IncrementalSnapshotStore interface {
+	SnapshotStore
+
+	// CreateDelta is like Create, but begins a snapshot holding the changes
+	// since the snapshot base. The store must keep base as long as it keeps
+	// the delta.
+	CreateDelta(base string, version SnapshotVersion, index, term uint64, configuration Configuration,
+		configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error)
+
+	// Chain returns the snapshots a snapshot is restored from: a full
+	// snapshot followed by the deltas up to and including the snapshot.
+	Chain(id string) ([]*SnapshotMeta, error)
+}
+
+// errSnapshotBaseMissing is returned when a follower does not have the base
+// of a delta sent by the leader.
+var errSnapshotBaseMissing = errors.New("snapshot base missing")
+
+// snapshotBase returns the snapshot a new snapshot can be a delta of, or nil
+// if it must be full. This must only be called from the snapshot thread.
+func (r *Raft) snapshotBase() *SnapshotMeta {
+	if r.conf.MaxSnapshotChain == 0 {
+		return nil
+	}
+	if _, ok := r.fsm.(IncrementalFSM); !ok {
+		return nil
+	}
+	store, ok := r.snapshots.(IncrementalSnapshotStore)
+	if !ok {
+		return nil
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		r.logger.Printf("[ERR] raft: Failed to list snapshots: %v", err)
+		return nil
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	chain, err := store.Chain(snapshots[0].ID)
+	if err != nil {
+		r.logger.Printf("[WARN] raft: Failed to get chain of snapshot %v, taking a full snapshot: %v",
+			snapshots[0].ID, err)
+		return nil
+	}
+	if len(chain) > r.conf.MaxSnapshotChain {
+		return nil
+	}
+	return snapshots[0]
+}
+
+// snapshotFSM starts a snapshot of the FSM, as a delta of base if it holds
+// state past it. This must only be called from the FSM thread.
+// Params:
+//   - base: snapshot to take a delta of, or nil for a full snapshot.
+//   - lastIndex: last index applied to the FSM.
+// Returns: the snapshot, the base it is a delta of or nil if it is full, and
+// an error.
+func (r *Raft) snapshotFSM(base *SnapshotMeta, lastIndex uint64) (FSMSnapshot, *SnapshotMeta, error) {
+	if base != nil && lastIndex > base.Index {
+		snap, err := r.fsm.(IncrementalFSM).SnapshotDelta(base)
+		if err != ErrFullSnapshotRequired {
+			return snap, base, err
+		}
+	}
+	snap, err := r.fsm.Snapshot()
+	return snap, nil, err
+}
+
+// snapshotChain returns the chain of snapshots ending with a snapshot.
+func (r *Raft) snapshotChain(meta *SnapshotMeta) ([]*SnapshotMeta, error) {
+	if meta.Base == "" {
+		return []*SnapshotMeta{meta}, nil
+	}
+	store, ok := r.snapshots.(IncrementalSnapshotStore)
+	if !ok {
+		return nil, fmt.Errorf("snapshot %v is a delta, but the snapshot store is not an IncrementalSnapshotStore", meta.ID)
+	}
+	return store.Chain(meta.ID)
+}
+
+// restoreSnapshotChain restores an FSM from a snapshot, restoring the full
+// snapshot of its chain and then applying each delta. This must only be
+// called from the FSM thread, or before it starts.
+// Params:
+//   - fsm: FSM to restore.
+//   - snaps: store of the snapshot.
+//   - id: ID of the snapshot.
+//   - index: last index that changed the FSM's state, or zero to restore
+//     the whole chain.
+//   - term: term of that index.
+// Returns: the metadata of the snapshot.
+func restoreSnapshotChain(fsm FSM, snaps SnapshotStore, id string, index, term uint64) (*SnapshotMeta, error) {
+	chain := []*SnapshotMeta{{ID: id}}
+	if store, ok := snaps.(IncrementalSnapshotStore); ok {
+		var err error
+		if chain, err = store.Chain(id); err != nil {
+			return nil, fmt.Errorf("failed to get chain of snapshot %v: %v", id, err)
+		}
+	}
+
+	// Skip the snapshots the FSM already holds, all of them if it holds
+	// the state of the snapshot itself
+	start := 0
+	for i := len(chain) - 1; i >= 0 && index != 0; i-- {
+		if chain[i].Index == index && chain[i].Term == term {
+			start = i + 1
+			break
+		}
+	}
+	if start == len(chain) {
+		return chain[start-1], nil
+	}
+
+	var meta *SnapshotMeta
+	for i := start; i < len(chain); i++ {
+		var err error
+		if meta, err = restoreSnapshotLink(fsm, snaps, chain[i].ID, i > 0); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// restoreSnapshotLink restores an FSM from a snapshot of a chain.
+// Params:
+//   - fsm: FSM to restore.
+//   - snaps: store of the snapshot.
+//   - id: ID of the snapshot.
+//   - delta: whether the snapshot is a delta, applied to the FSM's state.
+// Returns: the metadata of the snapshot.
+func restoreSnapshotLink(fsm FSM, snaps SnapshotStore, id string, delta bool) (*SnapshotMeta, error) {
+	meta, source, err := snaps.Open(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot %v: %v", id, err)
+	}
+	defer source.Close()
+
+	if delta {
+		incremental, ok := fsm.(IncrementalFSM)
+		if !ok {
+			return nil, fmt.Errorf("snapshot %v is a delta, but the FSM is not an IncrementalFSM", id)
+		}
+		err = incremental.RestoreDelta(source)
+	} else {
+		err = fsm.Restore(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot %v: %v", id, err)
+	}
+	return meta, nil
+}
+
+// createInstallSnapshot creates the snapshot a follower receives from the
+// leader, as a delta of the local snapshot it is based on if the leader
+// sends a delta. This must only be called from the main thread.
+// Params:
+//   - req: first InstallSnapshot request of the snapshot.
+//   - configuration: configuration of the snapshot.
+//   - configurationIndex: index of the configuration.
+// Returns: the sink of the snapshot, and errSnapshotBaseMissing if the
+// follower does not have the base of the delta.
+func (r *Raft) createInstallSnapshot(req *InstallSnapshotRequest, configuration Configuration,
+	configurationIndex uint64) (SnapshotSink, error) {
+	version := getSnapshotVersion(r.protocolVersion)
+	if req.BaseIndex == 0 {
+		return r.snapshots.Create(version, req.LastLogIndex, req.LastLogTerm,
+			configuration, configurationIndex, r.nextClientId, r.copyClientResponseCache(), r.witnessRecords(), r.trans)
+	}
+
+	store, ok := r.snapshots.(IncrementalSnapshotStore)
+	if !ok {
+		return nil, fmt.Errorf("received a snapshot delta, but the snapshot store is not an IncrementalSnapshotStore")
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Index == req.BaseIndex && snapshot.Term == req.BaseTerm {
+			return store.CreateDelta(snapshot.ID, version, req.LastLogIndex, req.LastLogTerm,
+				configuration, configurationIndex, r.nextClientId, r.copyClientResponseCache(), r.witnessRecords(), r.trans)
+		}
+	}
+	return nil, errSnapshotBaseMissing
+}
+
+// snapshotPositions returns the index and term of the local snapshots, newest
+// first, for the leader to find the deltas a follower is missing.
+func (r *Raft) snapshotPositions() []SnapshotPosition {
+	snapshots, err := r.snapshots.List()
+	if err != nil {
+		r.logger.Printf("[ERR] raft: Failed to list snapshots: %v", err)
+		return nil
+	}
+	positions := make([]SnapshotPosition, len(snapshots))
+	for i, snapshot := range snapshots {
+		positions[i] = SnapshotPosition{Index: snapshot.Index, Term: snapshot.Term}
+	}
+	return positions
+}
+
+// chainStart returns the snapshot of a chain to send a follower first: the
+// one after the newest snapshot the follower has, or the full snapshot if
+// the follower has none of the chain.
+// Params:
+//   - chain: chain of the latest snapshot, starting from the full snapshot.
+//   - has: snapshots the follower has.
+// Returns: the index in chain of the snapshot to send first, or len(chain)
+// if the follower has the latest snapshot.
+func chainStart(chain []*SnapshotMeta, has []SnapshotPosition) int {
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, position := range has {
+			if position.Index == chain[i].Index && position.Term == chain[i].Term {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
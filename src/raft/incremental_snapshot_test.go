@@ -0,0 +1,519 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// incrementalFSM is a MockFSM that snapshots the logs applied since the base
+// as a delta, and counts the snapshots it takes and restores.
+type incrementalFSM struct {
+	MockFSM
+	indexes       []uint64
+	deltas        int
+	restores      int
+	deltaRestores int
+}
+
+// incrementalState is the state persisted by an incrementalFSM.
+type incrementalState struct {
+	Logs    [][]byte
+	Indexes []uint64
+}
+
+type incrementalSnapshot struct {
+	state incrementalState
+}
+
+func (m *incrementalFSM) Apply(log *Log) interface{} {
+	m.Lock()
+	defer m.Unlock()
+	m.logs = append(m.logs, log.Data)
+	m.indexes = append(m.indexes, log.Index)
+	return len(m.logs)
+}
+
+func (m *incrementalFSM) Snapshot() (FSMSnapshot, error) {
+	m.Lock()
+	defer m.Unlock()
+	return &incrementalSnapshot{incrementalState{m.logs, m.indexes}}, nil
+}
+
+func (m *incrementalFSM) SnapshotDelta(base *SnapshotMeta) (FSMSnapshot, error) {
+	m.Lock()
+	defer m.Unlock()
+	i := len(m.indexes)
+	for i > 0 && m.indexes[i-1] > base.Index {
+		i--
+	}
+	m.deltas++
+	return &incrementalSnapshot{incrementalState{m.logs[i:], m.indexes[i:]}}, nil
+}
+
+func (m *incrementalFSM) Restore(inp io.ReadCloser) error {
+	m.Lock()
+	defer m.Unlock()
+	m.logs, m.indexes = nil, nil
+	m.restores++
+	return m.decode(inp)
+}
+
+func (m *incrementalFSM) RestoreDelta(inp io.ReadCloser) error {
+	m.Lock()
+	defer m.Unlock()
+	m.deltaRestores++
+	return m.decode(inp)
+}
+
+// decode appends the state persisted by a snapshot.
+func (m *incrementalFSM) decode(inp io.ReadCloser) error {
+	defer inp.Close()
+	var state incrementalState
+	if err := codec.NewDecoder(inp, &codec.MsgpackHandle{}).Decode(&state); err != nil {
+		return err
+	}
+	m.logs = append(m.logs, state.Logs...)
+	m.indexes = append(m.indexes, state.Indexes...)
+	return nil
+}
+
+// counts returns the logs applied and the snapshots restored.
+func (m *incrementalFSM) counts() (logs, restores, deltaRestores int) {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.logs), m.restores, m.deltaRestores
+}
+
+func (s *incrementalSnapshot) Persist(sink SnapshotSink) error {
+	if err := codec.NewEncoder(sink, &codec.MsgpackHandle{}).Encode(&s.state); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *incrementalSnapshot) Release() {
+}
+
+// startIncrementalServer bootstraps and starts a single server.
+func startIncrementalServer(t *testing.T, conf *Config, fsm FSM, store *InmemStore, snaps SnapshotStore) *Raft {
+	addr, trans := NewInmemTransport("")
+	conf.LocalID = ServerID(addr)
+	configuration := Configuration{Servers: []Server{{ID: conf.LocalID, Address: addr}}}
+	if err := BootstrapCluster(conf, store, store, snaps, trans, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r, err := NewRaft(conf, fsm, store, store, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitForLeader(t, []*Raft{r})
+	return r
+}
+
+// applyTestLogs applies n logs, with sequence numbers from first.
+func applyTestLogs(t *testing.T, r *Raft, first, n int) {
+	for i := first; i < first+n; i++ {
+		if err := r.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i)), ClientID: 1, SeqNo: uint64(i)}, 0).Error(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
+
+// snapshotBases takes n snapshots, each after applying 10 logs with sequence
+// numbers from first on, and returns their IDs and bases.
+func snapshotBases(t *testing.T, r *Raft, snaps *FileSnapshotStore, first, n int) (ids, bases []string) {
+	for i := 0; i < n; i++ {
+		applyTestLogs(t, r, first+10*i, 10)
+		id, err := r.takeSnapshot(true)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		meta, err := snaps.readMeta(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		ids = append(ids, id)
+		bases = append(bases, meta.Base)
+	}
+	return ids, bases
+}
+
+func TestFileSS_Chain(t *testing.T) {
+	dir, snap := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	var impl interface{} = snap
+	if _, ok := impl.(IncrementalSnapshotStore); !ok {
+		t.Fatalf("FileSnapshotStore not an IncrementalSnapshotStore")
+	}
+	snap.retain = 1
+	snap.retention = KeepSnapshotCount(1)
+
+	_, trans := NewInmemTransport(NewInmemAddr())
+	create := func(base string, index uint64) string {
+		var sink SnapshotSink
+		var err error
+		if base == "" {
+			sink, err = snap.Create(SnapshotVersionMax, index, 3, Configuration{}, 0, 0, nil, nil, trans)
+		} else {
+			sink, err = snap.CreateDelta(base, SnapshotVersionMax, index, 3, Configuration{}, 0, 0, nil, nil, trans)
+		}
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := sink.Write([]byte("state")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return sink.ID()
+	}
+	full := create("", 10)
+	delta1 := create(full, 20)
+	delta2 := create(delta1, 30)
+
+	// The chain is kept, though only one snapshot is retained
+	chain, err := snap.Chain(delta2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for _, meta := range chain {
+		ids = append(ids, meta.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{full, delta1, delta2}) {
+		t.Fatalf("bad chain: %v", ids)
+	}
+	if chain[2].Base != delta1 {
+		t.Fatalf("bad base: %q", chain[2].Base)
+	}
+	if snapshots, _ := snap.List(); len(snapshots) != 1 || snapshots[0].ID != delta2 {
+		t.Fatalf("bad snapshots: %v", snapshots)
+	}
+
+	// A delta needs its base
+	if _, err := snap.CreateDelta("missing", SnapshotVersionMax, 40, 3, Configuration{}, 0, 0, nil, nil, trans); err == nil {
+		t.Fatalf("created delta of a missing snapshot")
+	}
+
+	// A new full snapshot lets the old chain be reaped
+	create("", 40)
+	if _, err := snap.Chain(delta2); err == nil {
+		t.Fatalf("old chain not reaped")
+	}
+	if _, err := snap.readMeta(full); err == nil {
+		t.Fatalf("old full snapshot not reaped")
+	}
+}
+
+func TestRaft_IncrementalSnapshots(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.MaxSnapshotChain = 2
+	conf.TrailingLogs = 5
+	conf.SnapshotChunkSize = 64
+	fsm := &incrementalFSM{}
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	r := startIncrementalServer(t, conf, fsm, store, snaps)
+	defer func() { r.Shutdown().Error() }()
+
+	// A full snapshot is followed by deltas
+	ids, bases := snapshotBases(t, r, snaps, 0, 3)
+	if !reflect.DeepEqual(bases, []string{"", ids[0], ids[1]}) {
+		t.Fatalf("bad bases: %v of %v", bases, ids)
+	}
+	fsm.Lock()
+	deltas := fsm.deltas
+	fsm.Unlock()
+	if deltas != 2 {
+		t.Fatalf("bad deltas: %d", deltas)
+	}
+
+	// A new server is sent the chain
+	addr2, trans2 := NewInmemTransport("")
+	r.trans.(*InmemTransport).Connect(addr2, trans2)
+	trans2.Connect(r.localAddr, r.trans.(*InmemTransport))
+	followerConf := *conf
+	followerConf.LocalID = ServerID(addr2)
+	followerFSM := &incrementalFSM{}
+	store2 := NewInmemStore()
+	dir2, snaps2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	follower, err := NewRaft(&followerConf, followerFSM, store2, store2, snaps2, trans2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { follower.Shutdown().Error() }()
+	if err := r.AddVoter(followerConf.LocalID, addr2, 0, 5*time.Second).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _, _ := followerFSM.counts(); n == 30 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower did not install the chain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// It restored the full snapshot once, and applied each delta to it
+	if _, restores, deltaRestores := followerFSM.counts(); restores != 1 || deltaRestores != 2 {
+		t.Fatalf("bad restores: %d full, %d deltas", restores, deltaRestores)
+	}
+	latest := mustListSnapshots(t, follower)[0]
+	if chain, err := snaps2.Chain(latest.ID); err != nil || len(chain) != 3 {
+		t.Fatalf("bad follower chain: %v %v", chain, err)
+	}
+
+	// A delta without its base is refused
+	_, client := NewInmemTransport("")
+	client.Connect(addr2, trans2)
+	var resp InstallSnapshotResponse
+	err = client.InstallSnapshot(follower.localID, addr2, &InstallSnapshotRequest{
+		RPCHeader:       r.getRPCHeader(),
+		SnapshotVersion: SnapshotVersionMax,
+		Term:            r.getCurrentTerm(),
+		Leader:          r.trans.EncodePeer(r.localID, r.localAddr),
+		LastLogIndex:    1000,
+		LastLogTerm:     r.getCurrentTerm(),
+		Configuration:   encodeConfiguration(Configuration{}),
+		BaseIndex:       999,
+		BaseTerm:        r.getCurrentTerm(),
+	}, &resp, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Success || !resp.BaseMissing {
+		t.Fatalf("bad response: %#v", resp)
+	}
+	if len(resp.Snapshots) == 0 || resp.Snapshots[0] != (SnapshotPosition{Index: latest.Index, Term: latest.Term}) {
+		t.Fatalf("bad follower snapshots: %v", resp.Snapshots)
+	}
+
+	// The chain is bounded, and user snapshots are full
+	ids, bases = snapshotBases(t, r, snaps, 30, 2)
+	if !reflect.DeepEqual(bases, []string{"", ids[0]}) {
+		t.Fatalf("bad bases: %v of %v", bases, ids)
+	}
+	applyTestLogs(t, r, 50, 10)
+	future := r.Snapshot()
+	if err := future.Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	meta, source, err := future.Open()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	source.Close()
+	if meta.Base != "" {
+		t.Fatalf("user snapshot is a delta of %v", meta.Base)
+	}
+}
+
+func TestChainStart(t *testing.T) {
+	chain := []*SnapshotMeta{{Index: 10, Term: 1}, {Index: 20, Term: 1}, {Index: 30, Term: 2}}
+	cases := []struct {
+		has   []SnapshotPosition
+		start int
+	}{
+		{nil, 0},
+		{[]SnapshotPosition{{Index: 10, Term: 2}, {Index: 15, Term: 1}}, 0},
+		{[]SnapshotPosition{{Index: 10, Term: 1}}, 1},
+		{[]SnapshotPosition{{Index: 25, Term: 1}, {Index: 20, Term: 1}, {Index: 10, Term: 1}}, 2},
+		{[]SnapshotPosition{{Index: 30, Term: 2}}, 3},
+		{[]SnapshotPosition{{Index: 30, Term: 1}}, 0},
+	}
+	for _, c := range cases {
+		if start := chainStart(chain, c.has); start != c.start {
+			t.Fatalf("bad start for %v: %d, expected %d", c.has, start, c.start)
+		}
+	}
+}
+
+func TestRaft_IncrementalSnapshotCatchUp(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.MaxSnapshotChain = 4
+	conf.TrailingLogs = 5
+	addr1, trans1 := NewInmemTransport("")
+	addr2, trans2 := NewInmemTransport("")
+	trans1.Connect(addr2, trans2)
+	trans2.Connect(addr1, trans1)
+	recorder := &chunkRecorder{Transport: trans1}
+
+	// Start a leader with a full snapshot
+	leaderConf := *conf
+	leaderConf.LocalID = ServerID(addr1)
+	store1 := NewInmemStore()
+	dir1, snaps1 := FileSnapTest(t)
+	defer os.RemoveAll(dir1)
+	configuration := Configuration{Servers: []Server{{ID: leaderConf.LocalID, Address: addr1}}}
+	if err := BootstrapCluster(&leaderConf, store1, store1, snaps1, recorder, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	leader, err := NewRaft(&leaderConf, &incrementalFSM{}, store1, store1, snaps1, recorder)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { leader.Shutdown().Error() }()
+	waitForLeader(t, []*Raft{leader})
+	snapshotBases(t, leader, snaps1, 0, 1)
+
+	// Add a server, which is sent the full snapshot
+	followerConf := *conf
+	followerConf.LocalID = ServerID(addr2)
+	fsm := &incrementalFSM{}
+	store2 := NewInmemStore()
+	dir2, snaps2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	follower, err := NewRaft(&followerConf, fsm, store2, store2, snaps2, trans2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { follower.Shutdown().Error() }()
+	if err := leader.AddNonvoter(followerConf.LocalID, addr2, 0, 5*time.Second).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitForLogs := func(n int) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			if logs, _, _ := fsm.counts(); logs == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("follower did not reach %d logs", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForLogs(10)
+	sent := len(recorder.getBaseIndexes())
+
+	// While it is disconnected, the leader takes deltas and compacts the
+	// logs the follower is missing
+	trans1.Disconnect(addr2)
+	_, bases := snapshotBases(t, leader, snaps1, 10, 2)
+	if bases[0] == "" || bases[1] == "" {
+		t.Fatalf("bad bases: %v", bases)
+	}
+	trans1.Connect(addr2, trans2)
+	waitForLogs(30)
+
+	// Only the deltas were sent to it
+	for _, base := range recorder.getBaseIndexes()[sent:] {
+		if base == 0 {
+			t.Fatalf("full snapshot sent again: %v", recorder.getBaseIndexes())
+		}
+	}
+	if _, restores, deltaRestores := fsm.counts(); restores != 1 || deltaRestores != 2 {
+		t.Fatalf("bad restores: %d full, %d deltas", restores, deltaRestores)
+	}
+}
+
+func TestRaft_IncrementalSnapshotNewFollower(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.MaxSnapshotChain = 4
+	conf.TrailingLogs = 5
+	addr1, trans1 := NewInmemTransport("")
+	addr2, trans2 := NewInmemTransport("")
+	trans1.Connect(addr2, trans2)
+	trans2.Connect(addr1, trans1)
+	recorder := &chunkRecorder{Transport: trans1}
+
+	// Start a leader with a chain of snapshots
+	leaderConf := *conf
+	leaderConf.LocalID = ServerID(addr1)
+	store1 := NewInmemStore()
+	dir1, snaps1 := FileSnapTest(t)
+	defer os.RemoveAll(dir1)
+	configuration := Configuration{Servers: []Server{{ID: leaderConf.LocalID, Address: addr1}}}
+	if err := BootstrapCluster(&leaderConf, store1, store1, snaps1, recorder, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	leader, err := NewRaft(&leaderConf, &incrementalFSM{}, store1, store1, snaps1, recorder)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { leader.Shutdown().Error() }()
+	waitForLeader(t, []*Raft{leader})
+	_, bases := snapshotBases(t, leader, snaps1, 0, 3)
+	if bases[0] != "" || bases[1] == "" || bases[2] == "" {
+		t.Fatalf("bad bases: %v", bases)
+	}
+
+	// Add a server with an empty log
+	followerConf := *conf
+	followerConf.LocalID = ServerID(addr2)
+	fsm := &incrementalFSM{}
+	store2 := NewInmemStore()
+	dir2, snaps2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	follower, err := NewRaft(&followerConf, fsm, store2, store2, snaps2, trans2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { follower.Shutdown().Error() }()
+	if err := leader.AddNonvoter(followerConf.LocalID, addr2, 0, 5*time.Second).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if logs, _, _ := fsm.counts(); logs == 30 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower did not reach 30 logs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// It is sent the full snapshot first, rather than the latest delta
+	if baseIndexes := recorder.getBaseIndexes(); len(baseIndexes) != 3 || baseIndexes[0] != 0 {
+		t.Fatalf("bad snapshots sent: %v", baseIndexes)
+	}
+	if _, restores, deltaRestores := fsm.counts(); restores != 1 || deltaRestores != 2 {
+		t.Fatalf("bad restores: %d full, %d deltas", restores, deltaRestores)
+	}
+}
+
+func TestRaft_IncrementalSnapshotRestart(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.MaxSnapshotChain = 2
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	r := startIncrementalServer(t, conf, &incrementalFSM{}, store, snaps)
+	snapshotBases(t, r, snaps, 0, 3)
+	if err := r.Shutdown().Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A restarted server replays the chain
+	_, trans := NewInmemTransport(r.localAddr)
+	fsm := &incrementalFSM{}
+	r, err := NewRaft(conf, fsm, store, store, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { r.Shutdown().Error() }()
+	if n, restores, deltaRestores := fsm.counts(); n != 30 || restores != 1 || deltaRestores != 2 {
+		t.Fatalf("bad restore: %d logs, %d full, %d deltas", n, restores, deltaRestores)
+	}
+	fsm.Lock()
+	defer fsm.Unlock()
+	for i, data := range fsm.logs {
+		if string(data) != fmt.Sprintf("test%d", i) {
+			t.Fatalf("bad log %d: %q", i, data)
+		}
+	}
+}
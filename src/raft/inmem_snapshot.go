@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"sync"
+	"time"
 )
 
 // InmemSnapshotStore implements the SnapshotStore interface and
@@ -33,13 +34,13 @@ func NewInmemSnapshotStore() *InmemSnapshotStore {
 
 // Create replaces the stored snapshot with a new one using the given args
 func (m *InmemSnapshotStore) Create(version SnapshotVersion, index, term uint64,
-	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, trans Transport) (SnapshotSink, error) {
+	configuration Configuration, configurationIndex uint64, nextClientId uint64, clientResponseCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error) {
 	// We only support version 1 snapshots at this time.
 	if version != 1 {
 		return nil, fmt.Errorf("unsupported snapshot version %d", version)
 	}
 
-	name := snapshotName(term, index)
+	name := snapshotName(term, index, time.Now())
 
 	m.Lock()
 	defer m.Unlock()
@@ -52,6 +53,7 @@ func (m *InmemSnapshotStore) Create(version SnapshotVersion, index, term uint64,
 			Term:                term,
 			NextClientId:        nextClientId,
 			ClientResponseCache: clientResponseCache,
+			WitnessRecords:      witnessRecords,
 			Peers:               encodePeers(configuration, trans),
 			Configuration:       configuration,
 			ConfigurationIndex:  configurationIndex,
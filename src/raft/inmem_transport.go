@@ -41,6 +41,7 @@ type InmemTransport struct {
 	peers      map[ServerAddress]*InmemTransport
 	pipelines  []*inmemPipeline
 	timeout    time.Duration
+	witnessFn  func(RPC)
 }
 
 // NewInmemTransport is used to initialize a new transport
@@ -63,6 +64,13 @@ func NewInmemTransport(addr ServerAddress) (ServerAddress, *InmemTransport) {
 func (i *InmemTransport) SetHeartbeatHandler(cb func(RPC)) {
 }
 
+// SetWitnessHandler implements the WithWitnessHandler interface.
+func (i *InmemTransport) SetWitnessHandler(cb func(RPC)) {
+	i.Lock()
+	defer i.Unlock()
+	i.witnessFn = cb
+}
+
 // Consumer implements the Transport interface.
 func (i *InmemTransport) Consumer() <-chan RPC {
 	return i.consumerCh
@@ -92,68 +100,104 @@ func (i *InmemTransport) AppendEntriesPipeline(id ServerID, target ServerAddress
 // AppendEntries implements the Transport interface.
 func (i *InmemTransport) AppendEntries(id ServerID, target ServerAddress, args *AppendEntriesRequest, resp *AppendEntriesResponse) error {
 	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
-	if err != nil {
-		return err
-	}
 
-	// Copy the result back
-	out := rpcResp.Response.(*AppendEntriesResponse)
-	*resp = *out
-	return nil
+	// Copy the result back, which may accompany an error
+	if out, ok := rpcResp.Response.(*AppendEntriesResponse); ok {
+		*resp = *out
+	}
+	return err
 }
 
 // RequestVote implements the Transport interface.
 func (i *InmemTransport) RequestVote(id ServerID, target ServerAddress, args *RequestVoteRequest, resp *RequestVoteResponse) error {
 	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
-	if err != nil {
-		return err
-	}
 
-	// Copy the result back
-	out := rpcResp.Response.(*RequestVoteResponse)
-	*resp = *out
-	return nil
+	// Copy the result back, which may accompany an error
+	if out, ok := rpcResp.Response.(*RequestVoteResponse); ok {
+		*resp = *out
+	}
+	return err
 }
 
 // RecoverData implements the Transport interface.
 func (i *InmemTransport) RecoverData(id ServerID, target ServerAddress, args *RecoveryDataRequest, resp *RecoveryDataResponse) error {
 	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
-	if err != nil {
-		return err
-	}
 
-	// Copy the result back
-	out := rpcResp.Response.(*RecoveryDataResponse)
-	*resp = *out
-	return nil
+	// Copy the result back, which may accompany an error
+	if out, ok := rpcResp.Response.(*RecoveryDataResponse); ok {
+		*resp = *out
+	}
+	return err
 }
 
 // UnfreezeWitness implements the Transport interface.
 func (i *InmemTransport) UnfreezeWitness(id ServerID, target ServerAddress, args *UnfreezeRequest, resp *UnfreezeResponse) error {
 	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
-	if err != nil {
-		return err
+
+	// Copy the result back, which may accompany an error
+	if out, ok := rpcResp.Response.(*UnfreezeResponse); ok {
+		*resp = *out
 	}
+	return err
+}
 
-	// Copy the result back
-	out := rpcResp.Response.(*UnfreezeResponse)
-	*resp = *out
+// OpenClientConn implements the ClientTransport interface. Fails if target
+// is not connected.
+func (i *InmemTransport) OpenClientConn(target ServerAddress) error {
+	i.RLock()
+	_, ok := i.peers[target]
+	i.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to connect to peer: %v", target)
+	}
 	return nil
 }
 
+// SendClientIdRequest implements the ClientTransport interface.
+func (i *InmemTransport) SendClientIdRequest(target ServerAddress, args *ClientIdRequest, resp *ClientIdResponse) (bool, error) {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if out, ok := rpcResp.Response.(*ClientIdResponse); ok {
+		*resp = *out
+	}
+	return err == nil || rpcResp.Error != nil, err
+}
+
+// SendClientRequest implements the ClientTransport interface.
+func (i *InmemTransport) SendClientRequest(target ServerAddress, args *ClientRequest, resp *ClientResponse) (bool, error) {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if out, ok := rpcResp.Response.(*ClientResponse); ok {
+		*resp = *out
+	}
+	return err == nil || rpcResp.Error != nil, err
+}
+
+// SendRecordRequest implements the ClientTransport interface.
+func (i *InmemTransport) SendRecordRequest(target ServerAddress, args *RecordRequest, resp *RecordResponse) (bool, error) {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if out, ok := rpcResp.Response.(*RecordResponse); ok {
+		*resp = *out
+	}
+	return err == nil || rpcResp.Error != nil, err
+}
 
+// SendSyncRequest implements the ClientTransport interface.
+func (i *InmemTransport) SendSyncRequest(target ServerAddress, args *SyncRequest, resp *SyncResponse) (bool, error) {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if out, ok := rpcResp.Response.(*SyncResponse); ok {
+		*resp = *out
+	}
+	return err == nil || rpcResp.Error != nil, err
+}
 
 // InstallSnapshot implements the Transport interface.
 func (i *InmemTransport) InstallSnapshot(id ServerID, target ServerAddress, args *InstallSnapshotRequest, resp *InstallSnapshotResponse, data io.Reader) error {
 	rpcResp, err := i.makeRPC(target, args, data, 10*i.timeout)
-	if err != nil {
-		return err
-	}
 
-	// Copy the result back
-	out := rpcResp.Response.(*InstallSnapshotResponse)
-	*resp = *out
-	return nil
+	// Copy the result back, which may accompany an error
+	if out, ok := rpcResp.Response.(*InstallSnapshotResponse); ok {
+		*resp = *out
+	}
+	return err
 }
 
 func (i *InmemTransport) makeRPC(target ServerAddress, args interface{}, r io.Reader, timeout time.Duration) (rpcResp RPCResponse, err error) {
@@ -166,13 +210,25 @@ func (i *InmemTransport) makeRPC(target ServerAddress, args interface{}, r io.Re
 		return
 	}
 
-	// Send the RPC over
-	respCh := make(chan RPCResponse)
-	peer.consumerCh <- RPC{
+	// Send the RPC over, using the witness fast-path if set. The witness
+	// handler may respond before we start waiting
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
 		Command:  args,
 		Reader:   r,
 		RespChan: respCh,
 	}
+	var witnessFn func(RPC)
+	if _, isRecord := args.(*RecordRequest); isRecord {
+		peer.RLock()
+		witnessFn = peer.witnessFn
+		peer.RUnlock()
+	}
+	if witnessFn != nil {
+		witnessFn(rpc)
+	} else {
+		peer.consumerCh <- rpc
+	}
 
 	// Wait for a response
 	select {
@@ -310,6 +366,13 @@ func (i *inmemPipeline) AppendEntries(args *AppendEntriesRequest, resp *AppendEn
 		timeout = time.After(i.trans.timeout)
 	}
 
+	// Don't send once closed, which the select below may still do
+	select {
+	case <-i.shutdownCh:
+		return nil, ErrPipelineShutdown
+	default:
+	}
+
 	// Send the RPC over
 	respCh := make(chan RPCResponse, 1)
 	rpc := RPC{
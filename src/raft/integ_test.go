@@ -233,7 +233,7 @@ func TestRaft_Integ(t *testing.T) {
 		// Do some commits
 		var futures []ApplyFuture
 		for i := 0; i < n; i++ {
-			futures = append(futures, leader.raft.Apply(logBytes(i, sz), 0))
+			futures = append(futures, leader.raft.Apply(testCommand(logBytes(i, sz)), 0))
 		}
 		for _, f := range futures {
 			NoErr(WaitFuture(f, t), t)
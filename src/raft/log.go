@@ -34,6 +34,14 @@ const (
 
 	// LogNextClientId is used to set the next client ID across the cluster.
 	LogNextClientId
+
+	// LogTransaction is a multi-key conditional transaction. Data holds an
+	// encoded Transaction, which is applied to a TransactionFSM.
+	LogTransaction
+
+	// LogClientIdentity binds a client ID to the identity the client
+	// authenticated as, across the cluster.
+	LogClientIdentity
 )
 
 // Log entries are replicated to all members of the Raft cluster
@@ -51,14 +59,23 @@ type Log struct {
 	// Data holds the log entry's type-specific data.
 	Data []byte
 
-	// Client ID. Only used for LogCommand.
+	// Client ID. Only used for LogCommand and LogTransaction.
 	ClientID uint64
 
-	// Sequence number of command. Only used for LogCommand.
+	// Sequence number of command. Only used for LogCommand and LogTransaction.
 	SeqNo uint64
 
 	// Keys associated with RPC, used to check for commutativity.
 	Keys []Key
+
+	// Identity of the client, set by the server from the identity bound to
+	// ClientID. Empty if clients are not authenticated. Only used for
+	// LogCommand and LogTransaction.
+	Identity string
+
+	// Checksum of the other fields, set by the leader if
+	// Config.LogChecksums is enabled. Nil if the entry has no checksum.
+	Checksum []byte
 }
 
 // Used to check for operations that conflict in commutativity checks.
@@ -85,3 +102,10 @@ type LogStore interface {
 	// DeleteRange deletes a range of log entries. The range is inclusive.
 	DeleteRange(min, max uint64) error
 }
+
+// LogDiskUsage is implemented by LogStores that can report the space they
+// use on disk, which Config.SnapshotDiskUsage compares against.
+type LogDiskUsage interface {
+	// DiskUsage returns the number of bytes the log uses on disk.
+	DiskUsage() (uint64, error)
+}
@@ -0,0 +1,92 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Log entry checksums. With Config.LogChecksums, the leader sets
+Log.Checksum of every entry it appends to a CRC64 of the entry's contents.
+The checksum is stored and replicated with the entry. Followers verify it
+before storing entries from AppendEntries, and reject the request if it does
+not match, so that the leader sends them again. runFSM verifies it before
+applying an entry; an entry that was corrupted in the log store is not
+applied, and neither is any later entry, since the FSM could no longer be
+consistent with the other servers. The FSM then signals the main thread,
+which halts the server: it steps down and rejects every RPC and operation
+with ErrLogChecksumMismatch, rather than keep voting with a frozen FSM, until
+the operator shuts it down. Its log must be repaired, or the server
+replaced, before it rejoins. Both checks send a LogChecksumObservation to
+observers. Entries without a checksum are not verified.
+
+*/
+
+var (
+	logChecksumTable = crc64.MakeTable(crc64.ECMA)
+)
+
+// LogChecksumObservation is sent to observers when the checksum of a log
+// entry does not match its contents.
+type LogChecksumObservation struct {
+	Index uint64
+	Term  uint64
+	// Applying is true if the entry was read from the log store to apply it
+	// to the FSM, and false if it was received from the leader.
+	Applying bool
+}
+
+// computeChecksum returns the checksum of the contents of a log entry,
+// excluding its checksum.
+func (l *Log) computeChecksum() []byte {
+	var buf bytes.Buffer
+	var scratch [8]byte
+	putUint64 := func(v uint64) {
+		binary.BigEndian.PutUint64(scratch[:], v)
+		buf.Write(scratch[:])
+	}
+	putBytes := func(b []byte) {
+		putUint64(uint64(len(b)))
+		buf.Write(b)
+	}
+	putUint64(l.Index)
+	putUint64(l.Term)
+	buf.WriteByte(byte(l.Type))
+	putBytes(l.Data)
+	putUint64(l.ClientID)
+	putUint64(l.SeqNo)
+	putUint64(uint64(len(l.Keys)))
+	for _, key := range l.Keys {
+		putBytes(key)
+	}
+	putBytes([]byte(l.Identity))
+
+	binary.BigEndian.PutUint64(scratch[:], crc64.Checksum(buf.Bytes(), logChecksumTable))
+	return append([]byte(nil), scratch[:]...)
+}
+
+// verifyChecksum returns whether the checksum of a log entry matches its
+// contents. Entries without a checksum always match.
+func (l *Log) verifyChecksum() bool {
+	if l.Checksum == nil {
+		return true
+	}
+	return bytes.Equal(l.Checksum, l.computeChecksum())
+}
+
+// reportChecksumMismatch logs and reports a log entry whose checksum does
+// not match its contents.
+// Params:
+//   - l: corrupt log entry.
+//   - applying: whether the entry was being applied to the FSM, rather
+//     than received from the leader.
+func (r *Raft) reportChecksumMismatch(l *Log, applying bool) {
+	r.logger.Printf("[ERR] raft: Checksum mismatch for log at index %d term %d (applying: %v)",
+		l.Index, l.Term, applying)
+	metrics.IncrCounter([]string{"raft", "log", "checksumMismatch"}, 1)
+	r.observe(LogChecksumObservation{Index: l.Index, Term: l.Term, Applying: applying})
+}
@@ -0,0 +1,245 @@
+package raft
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLog_Checksum(t *testing.T) {
+	log := &Log{
+		Index:    3,
+		Term:     2,
+		Type:     LogCommand,
+		Data:     []byte("data"),
+		ClientID: 7,
+		SeqNo:    9,
+		Keys:     []Key{Key("a"), Key("b")},
+		Identity: "client",
+	}
+	if !log.verifyChecksum() {
+		t.Fatalf("log without checksum should verify")
+	}
+	log.Checksum = log.computeChecksum()
+	if !log.verifyChecksum() {
+		t.Fatalf("checksum should verify")
+	}
+
+	tampers := map[string]func(l *Log){
+		"index":    func(l *Log) { l.Index++ },
+		"term":     func(l *Log) { l.Term++ },
+		"type":     func(l *Log) { l.Type = LogNoop },
+		"data":     func(l *Log) { l.Data = []byte("dato") },
+		"clientID": func(l *Log) { l.ClientID++ },
+		"seqNo":    func(l *Log) { l.SeqNo++ },
+		"keys":     func(l *Log) { l.Keys = []Key{Key("ab")} },
+		"identity": func(l *Log) { l.Identity = "other" },
+		"checksum": func(l *Log) { l.Checksum = []byte{0} },
+	}
+	for name, tamper := range tampers {
+		tampered := *log
+		tamper(&tampered)
+		if tampered.verifyChecksum() {
+			t.Fatalf("tampered %s should not verify", name)
+		}
+	}
+}
+
+// checksumObserver registers an observer of checksum mismatches.
+func checksumObserver(r *Raft) chan Observation {
+	ch := make(chan Observation, 8)
+	r.RegisterObserver(NewObserver(ch, false, func(o *Observation) bool {
+		_, ok := o.Data.(LogChecksumObservation)
+		return ok
+	}))
+	return ch
+}
+
+func expectChecksumObservation(t *testing.T, ch chan Observation, index uint64, applying bool) {
+	select {
+	case o := <-ch:
+		obs := o.Data.(LogChecksumObservation)
+		if obs.Index != index || obs.Applying != applying {
+			t.Fatalf("bad observation: %#v", obs)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no checksum observation")
+	}
+}
+
+func TestRaft_LogChecksums(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.LogChecksums = true
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+
+	if err := leader.Apply(&Log{Data: []byte("test"), ClientID: 1, SeqNo: 0}, 0).Error(); err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	c.WaitForReplication(1)
+
+	// Entries are stored with their checksums on every server
+	index := leader.getLastIndex()
+	for i, store := range c.stores {
+		var log Log
+		if err := store.GetLog(index, &log); err != nil {
+			c.FailNowf("[ERR] server %d: err: %v", i, err)
+		}
+		if log.Checksum == nil || !log.verifyChecksum() {
+			c.FailNowf("[ERR] server %d: bad checksum for %#v", i, log)
+		}
+	}
+}
+
+func TestRaft_AppendEntriesChecksumMismatch(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.LogChecksums = true
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+	follower := c.Followers()[0]
+	observations := checksumObserver(follower)
+	if err := leader.Apply(&Log{Data: []byte("test"), ClientID: 1, SeqNo: 0}, 0).Error(); err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	c.WaitForReplication(1)
+
+	// Send an entry corrupted in transit
+	lastIndex, lastTerm := follower.getLastLog()
+	entry := &Log{
+		Index:    lastIndex + 1,
+		Term:     leader.getCurrentTerm(),
+		Type:     LogCommand,
+		Data:     []byte("corrupt"),
+		ClientID: 1,
+		SeqNo:    1,
+	}
+	entry.Checksum = entry.computeChecksum()
+	entry.Data[0] ^= 0xff
+
+	followerTrans := c.trans[c.IndexOf(follower)].(*InmemTransport)
+	_, client := NewInmemTransport("")
+	client.Connect(follower.localAddr, followerTrans)
+	var resp AppendEntriesResponse
+	err := client.AppendEntries(follower.localID, follower.localAddr, &AppendEntriesRequest{
+		RPCHeader:    leader.getRPCHeader(),
+		Term:         leader.getCurrentTerm(),
+		Leader:       leader.trans.EncodePeer(leader.localID, leader.localAddr),
+		PrevLogEntry: lastIndex,
+		PrevLogTerm:  lastTerm,
+		Entries:      []*Log{entry},
+	}, &resp)
+	if err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	if resp.Success {
+		c.FailNowf("[ERR] corrupt entry accepted")
+	}
+	if last := follower.getLastIndex(); last != lastIndex {
+		c.FailNowf("[ERR] corrupt entry stored: last index %d", last)
+	}
+	expectChecksumObservation(t, observations, lastIndex+1, false)
+}
+
+func TestRaft_FSMChecksumMismatch(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.LogChecksums = true
+	// Keep the isolated follower from starting an election
+	conf.HeartbeatTimeout = 500 * time.Millisecond
+	conf.ElectionTimeout = 500 * time.Millisecond
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+	follower := c.Followers()[0]
+	followerIdx := c.IndexOf(follower)
+	observations := checksumObserver(follower)
+	if err := leader.Apply(&Log{Data: []byte("test"), ClientID: 1, SeqNo: 0}, 0).Error(); err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	c.WaitForReplication(1)
+
+	// Replicate entries to the isolated follower ourselves
+	c.Disconnect(follower.localAddr)
+	time.Sleep(100 * time.Millisecond)
+	followerTrans := c.trans[followerIdx].(*InmemTransport)
+	_, client := NewInmemTransport("")
+	client.Connect(follower.localAddr, followerTrans)
+	appendEntries := func(prevIndex, prevTerm, commit uint64, entries []*Log) {
+		var resp AppendEntriesResponse
+		err := client.AppendEntries(follower.localID, follower.localAddr, &AppendEntriesRequest{
+			RPCHeader:         leader.getRPCHeader(),
+			Term:              leader.getCurrentTerm(),
+			Leader:            leader.trans.EncodePeer(leader.localID, leader.localAddr),
+			PrevLogEntry:      prevIndex,
+			PrevLogTerm:       prevTerm,
+			Entries:           entries,
+			LeaderCommitIndex: commit,
+		}, &resp)
+		if err != nil {
+			c.FailNowf("[ERR] err: %v", err)
+		}
+		if !resp.Success {
+			c.FailNowf("[ERR] append entries failed")
+		}
+	}
+
+	lastIndex, lastTerm := follower.getLastLog()
+	term := leader.getCurrentTerm()
+	var entries []*Log
+	for i := uint64(1); i <= 2; i++ {
+		entry := &Log{
+			Index:    lastIndex + i,
+			Term:     term,
+			Type:     LogCommand,
+			Data:     []byte("entry"),
+			ClientID: 1,
+			SeqNo:    i,
+		}
+		entry.Checksum = entry.computeChecksum()
+		entries = append(entries, entry)
+	}
+	appendEntries(lastIndex, lastTerm, follower.getCommitIndex(), entries)
+
+	// Corrupt the first entry on disk, then commit both
+	store := c.stores[followerIdx]
+	store.l.Lock()
+	store.logs[lastIndex+1].Data = []byte("entrz")
+	store.l.Unlock()
+	appendEntries(lastIndex+2, term, lastIndex+2, nil)
+	expectChecksumObservation(t, observations, lastIndex+1, true)
+
+	// The follower halts, rejecting RPCs until it is shut down
+	deadline := time.Now().Add(time.Second)
+	for {
+		var resp AppendEntriesResponse
+		err := client.AppendEntries(follower.localID, follower.localAddr, &AppendEntriesRequest{
+			RPCHeader: leader.getRPCHeader(),
+			Term:      leader.getCurrentTerm(),
+			Leader:    leader.trans.EncodePeer(leader.localID, leader.localAddr),
+		}, &resp)
+		if err != nil && err.Error() == ErrLogChecksumMismatch.Error() {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.FailNowf("[ERR] follower not halted after checksum mismatch, err: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := follower.getState(); state != Follower {
+		c.FailNowf("[ERR] halted server in state %v", state)
+	}
+	if err := follower.Shutdown().Error(); err != nil {
+		c.FailNowf("[ERR] shutdown err: %v", err)
+	}
+
+	// having applied neither entry
+	fsm := c.fsms[followerIdx]
+	fsm.Lock()
+	defer fsm.Unlock()
+	for _, data := range fsm.logs {
+		if bytes.Equal(data, []byte("entry")) || bytes.Equal(data, []byte("entrz")) {
+			c.FailNowf("[ERR] applied entry after checksum mismatch")
+		}
+	}
+}
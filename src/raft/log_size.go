@@ -0,0 +1,195 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Byte-based compaction. Entries vary in size from bytes to megabytes, so a
+count of entries says little about how much disk the log uses. logSizes
+tracks the bytes appended to the log since the last snapshot, which
+Config.SnapshotLogBytes and Config.MaxLogBytes compare against, and
+Config.SnapshotDiskUsage compares the space a LogStore implementing
+LogDiskUsage reports. Once MaxLogBytes is reached, Apply asks the snapshot
+thread for a snapshot and waits until compaction brings the log back under
+the limit, so that a burst of writes cannot fill the disk. Client requests
+are rejected with ErrOverloaded instead, before the leader executes them on
+the fast path.
+
+*/
+
+const (
+	// logEntryOverhead approximates the bytes a log entry takes besides its
+	// variable-length fields.
+	logEntryOverhead = 64
+
+	// logFullRetry is how often Apply asks for a snapshot again while the
+	// log is over MaxLogBytes, in case the last one had nothing to compact.
+	logFullRetry = 100 * time.Millisecond
+)
+
+// size approximates the bytes a log entry takes in a LogStore.
+func (l *Log) size() uint64 {
+	size := uint64(logEntryOverhead + len(l.Data) + len(l.Identity) + len(l.Checksum))
+	for _, key := range l.Keys {
+		size += uint64(len(key))
+	}
+	return size
+}
+
+// logSizeMark records the total bytes appended up to an index.
+type logSizeMark struct {
+	index uint64
+	total uint64
+}
+
+// logSizes tracks the bytes appended to the log since the last snapshot, at
+// the granularity of the batches entries are stored in.
+type logSizes struct {
+	lock sync.Mutex
+	// Marks after the last snapshot, in increasing index order.
+	marks []logSizeMark
+	// Total bytes appended, and the total at the last snapshot.
+	total uint64
+	base  uint64
+	// Closed when the log is next compacted.
+	compactCh chan struct{}
+}
+
+// appended records a batch of entries stored in the log.
+func (s *logSizes) appended(logs []*Log) {
+	if len(logs) == 0 {
+		return
+	}
+	var bytes uint64
+	for _, l := range logs {
+		bytes += l.size()
+	}
+	s.add(logs[len(logs)-1].Index, bytes)
+}
+
+// add records entries of a number of bytes stored in the log, up to an
+// index.
+func (s *logSizes) add(index, bytes uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.total += bytes
+	s.marks = append(s.marks, logSizeMark{index: index, total: s.total})
+}
+
+// truncated records the deletion of the entries from an index onwards.
+func (s *logSizes) truncated(index uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := sort.Search(len(s.marks), func(i int) bool { return s.marks[i].index >= index })
+	s.marks = s.marks[:i]
+	if i > 0 {
+		s.total = s.marks[i-1].total
+	} else {
+		s.total = s.base
+	}
+}
+
+// compacted records a snapshot up to an index, and wakes those waiting for
+// the log to be compacted.
+func (s *logSizes) compacted(index uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := sort.Search(len(s.marks), func(i int) bool { return s.marks[i].index > index })
+	if i > 0 {
+		s.base = s.marks[i-1].total
+		s.marks = append([]logSizeMark(nil), s.marks[i:]...)
+	}
+	if s.compactCh != nil {
+		close(s.compactCh)
+		s.compactCh = nil
+	}
+}
+
+// sinceSnapshot returns the bytes appended since the last snapshot, and a
+// channel closed when the log is next compacted.
+func (s *logSizes) sinceSnapshot() (uint64, <-chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.compactCh == nil {
+		s.compactCh = make(chan struct{})
+	}
+	return s.total - s.base, s.compactCh
+}
+
+// logBytesExceeded returns whether the log has grown enough, in bytes, to
+// take a snapshot.
+func (r *Raft) logBytesExceeded() bool {
+	since, _ := r.logSizes.sinceSnapshot()
+	if r.conf.SnapshotLogBytes > 0 && since >= r.conf.SnapshotLogBytes {
+		return true
+	}
+	if r.conf.MaxLogBytes > 0 && since >= r.conf.MaxLogBytes {
+		return true
+	}
+	if r.conf.SnapshotDiskUsage > 0 {
+		usage, err := r.logs.(LogDiskUsage).DiskUsage()
+		if err != nil {
+			r.logger.Printf("[ERR] raft: Failed to get log disk usage: %v", err)
+			return false
+		}
+		metrics.SetGauge([]string{"raft", "log", "diskUsage"}, float32(usage))
+		if usage >= r.conf.SnapshotDiskUsage {
+			return true
+		}
+	}
+	return false
+}
+
+// logFull returns whether the log is at MaxLogBytes, asking for a snapshot
+// if it is.
+func (r *Raft) logFull() bool {
+	if r.conf.MaxLogBytes == 0 {
+		return false
+	}
+	if since, _ := r.logSizes.sinceSnapshot(); since < r.conf.MaxLogBytes {
+		return false
+	}
+	metrics.IncrCounter([]string{"raft", "apply", "logFull"}, 1)
+	asyncNotifyCh(r.logFullCh)
+	return true
+}
+
+// waitForLogSpace blocks until the log is under MaxLogBytes, asking for
+// snapshots while it is not.
+// Params:
+//   - timer: fires when the caller stops waiting, or nil to wait forever.
+// Returns: ErrEnqueueTimeout if the timer fired, ErrRaftShutdown on
+// shutdown, or nil.
+func (r *Raft) waitForLogSpace(timer <-chan time.Time) error {
+	if r.conf.MaxLogBytes == 0 {
+		return nil
+	}
+	for {
+		since, compactCh := r.logSizes.sinceSnapshot()
+		if since < r.conf.MaxLogBytes {
+			return nil
+		}
+		// Stop asking for snapshots once shutdown starts
+		select {
+		case <-r.shutdownCh:
+			return ErrRaftShutdown
+		default:
+		}
+		metrics.IncrCounter([]string{"raft", "apply", "logFull"}, 1)
+		asyncNotifyCh(r.logFullCh)
+		select {
+		case <-compactCh:
+		case <-time.After(logFullRetry):
+		case <-timer:
+			return ErrEnqueueTimeout
+		case <-r.shutdownCh:
+			return ErrRaftShutdown
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package raft
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// gatedSnapshotFSM is a MockFSM whose snapshots wait for a gate to open.
+type gatedSnapshotFSM struct {
+	*MockFSM
+	gate chan struct{}
+}
+
+func (g *gatedSnapshotFSM) Snapshot() (FSMSnapshot, error) {
+	<-g.gate
+	return g.MockFSM.Snapshot()
+}
+
+func TestLogSizes(t *testing.T) {
+	var s logSizes
+	entries := func(first, last uint64) []*Log {
+		var logs []*Log
+		for i := first; i <= last; i++ {
+			logs = append(logs, &Log{Index: i, Data: make([]byte, 36)})
+		}
+		return logs
+	}
+	since := func() uint64 {
+		n, _ := s.sinceSnapshot()
+		return n
+	}
+	s.appended(entries(1, 5))
+	s.appended(entries(6, 10))
+	if n := since(); n != 1000 {
+		t.Fatalf("bad size: %d", n)
+	}
+
+	// Truncating drops the batches from the index onwards
+	s.truncated(6)
+	if n := since(); n != 500 {
+		t.Fatalf("bad size: %d", n)
+	}
+	s.appended(entries(6, 7))
+	if n := since(); n != 700 {
+		t.Fatalf("bad size: %d", n)
+	}
+
+	// Compacting counts from the last batch the snapshot covers, and wakes
+	// waiters
+	_, compactCh := s.sinceSnapshot()
+	s.compacted(6)
+	select {
+	case <-compactCh:
+	default:
+		t.Fatalf("compaction not notified")
+	}
+	if n := since(); n != 200 {
+		t.Fatalf("bad size: %d", n)
+	}
+	s.compacted(7)
+	if n := since(); n != 0 {
+		t.Fatalf("bad size: %d", n)
+	}
+
+	// Truncating everything after a snapshot leaves nothing
+	s.appended(entries(8, 9))
+	s.truncated(8)
+	if n := since(); n != 0 {
+		t.Fatalf("bad size: %d", n)
+	}
+}
+
+func TestNewRaft_SnapshotDiskUsage(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.LocalID = "id"
+	conf.SnapshotDiskUsage = 1 << 20
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	_, trans := NewInmemTransport("")
+	if _, err := NewRaft(conf, &MockFSM{}, store, store, snaps, trans); err == nil {
+		t.Fatalf("expected error for a LogStore without disk usage")
+	}
+}
+
+func TestRaft_SnapshotLogBytes(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.SnapshotInterval = conf.CommitTimeout * 2
+	conf.SnapshotThreshold = 1 << 20
+	conf.SnapshotLogBytes = 16 * 1024
+	c := MakeCluster(1, t, conf)
+	defer c.Close()
+	r := c.Leader()
+
+	// A few large entries trigger a snapshot, far below the threshold
+	data := bytes.Repeat([]byte{1}, 1024)
+	for i := 0; i < 20; i++ {
+		if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: uint64(i)}, 0).Error(); err != nil {
+			c.FailNowf("[ERR] err: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if last, _ := r.getLastSnapshot(); last > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.FailNowf("[ERR] no snapshot taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if since, _ := r.logSizes.sinceSnapshot(); since >= conf.SnapshotLogBytes {
+		c.FailNowf("[ERR] log not compacted: %d bytes", since)
+	}
+}
+
+func TestRaft_SnapshotDiskUsage(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.SnapshotInterval = conf.CommitTimeout * 2
+	conf.SnapshotThreshold = 1 << 20
+	conf.TrailingLogs = 1
+	conf.SnapshotDiskUsage = 8 * 1024
+	dir, logs := FileLogTest(t, &FileLogStoreConfig{SegmentSize: 1024})
+	defer os.RemoveAll(dir)
+	defer logs.Close()
+	dir2, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	stable := NewInmemStore()
+	addr, trans := NewInmemTransport("")
+	conf.LocalID = ServerID(addr)
+	configuration := Configuration{Servers: []Server{{ID: conf.LocalID, Address: addr}}}
+	if err := BootstrapCluster(conf, logs, stable, snaps, trans, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r, err := NewRaft(conf, &MockFSM{}, logs, stable, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { r.Shutdown().Error() }()
+	waitForLeader(t, []*Raft{r})
+
+	data := bytes.Repeat([]byte{1}, 512)
+	for i := 0; i < 40; i++ {
+		if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: uint64(i)}, 0).Error(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The snapshot compacts the log back under the limit
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		usage, err := logs.DiskUsage()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if last, _ := r.getLastSnapshot(); last > 0 && usage < conf.SnapshotDiskUsage {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log not compacted: %d bytes", usage)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRaft_MaxLogBytes(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.SnapshotInterval = time.Hour
+	conf.SnapshotThreshold = 1 << 20
+	conf.MaxLogBytes = 8 * 1024
+	addr, trans := NewInmemTransport("")
+	conf.LocalID = ServerID(addr)
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	configuration := Configuration{Servers: []Server{{ID: conf.LocalID, Address: addr}}}
+	if err := BootstrapCluster(conf, store, store, snaps, trans, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fsm := &gatedSnapshotFSM{MockFSM: &MockFSM{}, gate: make(chan struct{})}
+	r, err := NewRaft(conf, fsm, store, store, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { r.Shutdown().Error() }()
+	waitForLeader(t, []*Raft{r})
+
+	// Fill the log up to the limit
+	data := bytes.Repeat([]byte{1}, 1024)
+	seq := uint64(0)
+	for since, _ := r.logSizes.sinceSnapshot(); since < conf.MaxLogBytes; since, _ = r.logSizes.sinceSnapshot() {
+		if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: seq}, 0).Error(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		seq++
+	}
+
+	// Apply waits while the snapshot cannot complete
+	if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: seq}, 50*time.Millisecond).Error(); err != ErrEnqueueTimeout {
+		t.Fatalf("expected enqueue timeout, got %v", err)
+	}
+
+	// and resumes once it compacts the log
+	time.AfterFunc(50*time.Millisecond, func() { close(fsm.gate) })
+	if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: seq}, 0).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if last, _ := r.getLastSnapshot(); last == 0 {
+		t.Fatalf("no snapshot taken")
+	}
+	fsm.Lock()
+	defer fsm.Unlock()
+	if len(fsm.logs) != int(seq)+1 {
+		t.Fatalf("bad logs: %d", len(fsm.logs))
+	}
+}
+
+func TestRaft_MaxLogBytesClientRequest(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.SnapshotInterval = time.Hour
+	conf.SnapshotThreshold = 1 << 20
+	conf.MaxLogBytes = 8 * 1024
+	addr, trans := NewInmemTransport("")
+	conf.LocalID = ServerID(addr)
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	configuration := Configuration{Servers: []Server{{ID: conf.LocalID, Address: addr}}}
+	if err := BootstrapCluster(conf, store, store, snaps, trans, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fsm := &gatedSnapshotFSM{MockFSM: &MockFSM{}, gate: make(chan struct{})}
+	r, err := NewRaft(conf, fsm, store, store, snaps, trans)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() { r.Shutdown().Error() }()
+	waitForLeader(t, []*Raft{r})
+
+	_, client := NewInmemTransport("")
+	client.Connect(addr, trans)
+	session, err := CreateClientSession(client, []ServerAddress{addr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Fill the log up to the limit
+	data := bytes.Repeat([]byte{1}, 1024)
+	seq := uint64(0)
+	for since, _ := r.logSizes.sinceSnapshot(); since < conf.MaxLogBytes; since, _ = r.logSizes.sinceSnapshot() {
+		if err := r.Apply(&Log{Data: data, ClientID: 1, SeqNo: seq}, 0).Error(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		seq++
+	}
+	fsm.Lock()
+	applied := len(fsm.logs)
+	fsm.Unlock()
+
+	// Client requests are rejected before the leader executes them, on
+	// either path
+	header := RPCHeader{ProtocolVersion: ProtocolVersionMax}
+	entry := &Log{Type: LogCommand, Data: []byte("a"), Keys: []Key{Key("a")}, ClientID: session.clientID}
+	var resp ClientResponse
+	_, err = client.SendClientRequest(addr, &ClientRequest{RPCHeader: header, Entry: entry}, &resp)
+	if !isRemoteError(err, ErrOverloaded) {
+		t.Fatalf("expected overloaded, got: %v", err)
+	}
+	var syncResp SyncResponse
+	_, err = client.SendSyncRequest(addr, &SyncRequest{RPCHeader: header, Entry: entry}, &syncResp)
+	if !isRemoteError(err, ErrOverloaded) {
+		t.Fatalf("expected overloaded, got: %v", err)
+	}
+	if !r.unsyncedOps.synced([]Key{Key("a")}) {
+		t.Fatalf("rejected command executed on the fast path")
+	}
+	fsm.Lock()
+	if len(fsm.logs) != applied {
+		t.Fatalf("rejected command applied: %d", len(fsm.logs))
+	}
+	fsm.Unlock()
+
+	// and accepted once the log is compacted
+	close(fsm.gate)
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = client.SendClientRequest(addr, &ClientRequest{RPCHeader: header, Entry: entry}, &resp)
+		if err == nil {
+			break
+		}
+		if !isRemoteError(err, ErrOverloaded) || time.Now().After(deadline) {
+			t.Fatalf("err: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(resp.ResponseData) == 0 {
+		t.Fatalf("bad response: %#v", resp)
+	}
+}
@@ -66,6 +66,14 @@ InstallSnapshot is special, in that after the RPC request we stream
 the entire state. That socket is not re-used as the connection state
 is not known if there is an error.
 
+If Multiplex is set in the config, RPCs are instead sent over a single
+connection per peer using the versioned framing described in
+net_transport_mux.go, falling back to this framing for older peers.
+Servers always accept both.
+
+If Compression is set in the config, large requests are compressed once
+the peer advertises support, as described in net_transport_compress.go.
+
 */
 type NetworkTransport struct {
 	connPool     map[ServerAddress][]*netConn
@@ -76,10 +84,28 @@ type NetworkTransport struct {
 	heartbeatFn     func(RPC)
 	heartbeatFnLock sync.Mutex
 
+	witnessFn     func(RPC)
+	witnessFnLock sync.Mutex
+
 	logger *log.Logger
 
 	maxPool int
 
+	// Compression used for requests to peers that accept it, and the
+	// encoded size a request must reach to be compressed.
+	compression          CompressionType
+	compressionThreshold int
+
+	// Size a compressed request may expand to.
+	maxDecompressedSize int64
+
+	// Multiplexed connections by peer, and when peers that only speak the
+	// original framing last refused the handshake.
+	multiplex   bool
+	muxConns    map[ServerAddress]*muxConn
+	legacyPeers map[ServerAddress]time.Time
+	muxLock     sync.Mutex
+
 	serverAddressProvider ServerAddressProvider
 
 	shutdown     bool
@@ -108,6 +134,24 @@ type NetworkTransportConfig struct {
 	// Timeout is used to apply I/O deadlines. For InstallSnapshot, we multiply
 	// the timeout by (SnapshotSize / TimeoutScale).
 	Timeout time.Duration
+
+	// Multiplex sends RPCs of all types over a single connection per peer,
+	// with many outstanding at once. Peers that do not support it are sent
+	// RPCs using the original framing over pooled connections.
+	Multiplex bool
+
+	// Compression is the algorithm used to compress requests sent to peers
+	// that advertise support for it, or CompressionNone.
+	Compression CompressionType
+
+	// CompressionThreshold is the size in bytes an encoded request must
+	// reach to be compressed. Defaults to DefaultCompressionThreshold.
+	CompressionThreshold int
+
+	// MaxDecompressedSize is the size in bytes a compressed request from a
+	// peer may expand to before it is rejected. Defaults to
+	// DefaultMaxDecompressedSize.
+	MaxDecompressedSize int64
 }
 
 type ServerAddressProvider interface {
@@ -130,6 +174,8 @@ type netConn struct {
 	w      *bufio.Writer
 	dec    *codec.Decoder
 	enc    *codec.Encoder
+
+	compression connCompression
 }
 
 func (n *netConn) Release() error {
@@ -155,11 +201,25 @@ func NewNetworkTransportWithConfig(
 	if config.Logger == nil {
 		config.Logger = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	compressionThreshold := config.CompressionThreshold
+	if compressionThreshold <= 0 {
+		compressionThreshold = DefaultCompressionThreshold
+	}
+	maxDecompressedSize := config.MaxDecompressedSize
+	if maxDecompressedSize <= 0 {
+		maxDecompressedSize = DefaultMaxDecompressedSize
+	}
 	trans := &NetworkTransport{
 		connPool:              make(map[ServerAddress][]*netConn),
 		consumeCh:             make(chan RPC),
 		logger:                config.Logger,
 		maxPool:               config.MaxPool,
+		compression:           config.Compression,
+		compressionThreshold:  compressionThreshold,
+		maxDecompressedSize:   maxDecompressedSize,
+		multiplex:             config.Multiplex,
+		muxConns:              make(map[ServerAddress]*muxConn),
+		legacyPeers:           make(map[ServerAddress]time.Time),
 		shutdownCh:            make(chan struct{}),
 		stream:                config.Stream,
 		timeout:               config.Timeout,
@@ -211,6 +271,13 @@ func (n *NetworkTransport) SetHeartbeatHandler(cb func(rpc RPC)) {
 	n.heartbeatFn = cb
 }
 
+// SetWitnessHandler implements the WithWitnessHandler interface.
+func (n *NetworkTransport) SetWitnessHandler(cb func(rpc RPC)) {
+	n.witnessFnLock.Lock()
+	defer n.witnessFnLock.Unlock()
+	n.witnessFn = cb
+}
+
 // Close is used to stop the network transport.
 func (n *NetworkTransport) Close() error {
 	n.shutdownLock.Lock()
@@ -220,6 +287,7 @@ func (n *NetworkTransport) Close() error {
 		close(n.shutdownCh)
 		n.stream.Close()
 		n.shutdown = true
+		n.closeMuxConns()
 	}
 	return nil
 }
@@ -298,6 +366,8 @@ func (n *NetworkTransport) getConn(target ServerAddress) (*netConn, error) {
 		conn:   conn,
 		r:      bufio.NewReader(conn),
 		w:      bufio.NewWriter(conn),
+
+		compression: n.newConnCompression(),
 	}
 
 	// Setup encoder/decoders
@@ -326,6 +396,17 @@ func (n *NetworkTransport) returnConn(conn *netConn) {
 // AppendEntriesPipeline returns an interface that can be used to pipeline
 // AppendEntries requests.
 func (n *NetworkTransport) AppendEntriesPipeline(id ServerID, target ServerAddress) (AppendPipeline, error) {
+	// Share the multiplexed connection if the peer supports it
+	if n.multiplex {
+		mc, err := n.getMuxConn(n.getProviderAddressOrFallback(id, target))
+		if err == nil {
+			return newMuxPipeline(n, mc), nil
+		}
+		if err != errMuxUnsupported {
+			return nil, err
+		}
+	}
+
 	// Get a connection
 	conn, err := n.getConnFromAddressProvider(id, target)
 	if err != nil {
@@ -358,10 +439,28 @@ func (n *NetworkTransport) UnfreezeWitness(id ServerID, target ServerAddress, ar
 
 // genericRPC handles a simple request/response RPC.
 func (n *NetworkTransport) genericRPC(id ServerID, target ServerAddress, rpcType uint8, args interface{}, resp interface{}) error {
+	_, err := n.sendRPC(n.getProviderAddressOrFallback(id, target), rpcType, args, resp)
+	return err
+}
+
+// sendRPC sends a request and waits for its response, using the
+// multiplexed connection to the target if enabled and supported.
+// Returns: whether the target responded, and the RPC error if any.
+func (n *NetworkTransport) sendRPC(target ServerAddress, rpcType uint8, args interface{}, resp interface{}) (bool, error) {
+	if n.multiplex {
+		mc, err := n.getMuxConn(target)
+		if err == nil {
+			return mc.call(rpcType, args, resp)
+		}
+		if err != errMuxUnsupported {
+			return false, err
+		}
+	}
+
 	// Get a conn
-	conn, err := n.getConnFromAddressProvider(id, target)
+	conn, err := n.getConn(target)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Set a deadline
@@ -371,7 +470,7 @@ func (n *NetworkTransport) genericRPC(id ServerID, target ServerAddress, rpcType
 
 	// Send the RPC
 	if err = sendRPC(conn, rpcType, args); err != nil {
-		return err
+		return false, err
 	}
 
 	// Decode the response
@@ -379,7 +478,45 @@ func (n *NetworkTransport) genericRPC(id ServerID, target ServerAddress, rpcType
 	if canReturn {
 		n.returnConn(conn)
 	}
-	return err
+	return canReturn, err
+}
+
+// OpenClientConn implements the ClientTransport interface. Opens a
+// connection to target, if there is not one already, so that later RPCs
+// do not pay for dialing.
+func (n *NetworkTransport) OpenClientConn(target ServerAddress) error {
+	if n.multiplex {
+		_, err := n.getMuxConn(target)
+		if err != errMuxUnsupported {
+			return err
+		}
+	}
+	conn, err := n.getConn(target)
+	if err != nil {
+		return err
+	}
+	n.returnConn(conn)
+	return nil
+}
+
+// SendClientIdRequest implements the ClientTransport interface.
+func (n *NetworkTransport) SendClientIdRequest(target ServerAddress, args *ClientIdRequest, resp *ClientIdResponse) (bool, error) {
+	return n.sendRPC(target, rpcClientIdRequest, args, resp)
+}
+
+// SendClientRequest implements the ClientTransport interface.
+func (n *NetworkTransport) SendClientRequest(target ServerAddress, args *ClientRequest, resp *ClientResponse) (bool, error) {
+	return n.sendRPC(target, rpcClientRequest, args, resp)
+}
+
+// SendRecordRequest implements the ClientTransport interface.
+func (n *NetworkTransport) SendRecordRequest(target ServerAddress, args *RecordRequest, resp *RecordResponse) (bool, error) {
+	return n.sendRPC(target, rpcRecordRequest, args, resp)
+}
+
+// SendSyncRequest implements the ClientTransport interface.
+func (n *NetworkTransport) SendSyncRequest(target ServerAddress, args *SyncRequest, resp *SyncResponse) (bool, error) {
+	return n.sendRPC(target, rpcSyncRequest, args, resp)
 }
 
 // InstallSnapshot implements the Transport interface.
@@ -393,21 +530,25 @@ func (n *NetworkTransport) InstallSnapshot(id ServerID, target ServerAddress, ar
 
 	// Set a deadline, scaled by request size
 	if n.timeout > 0 {
-		timeout := n.timeout * time.Duration(args.Size/int64(n.TimeoutScale))
+		timeout := n.timeout * time.Duration(args.DataSize()/int64(n.TimeoutScale))
 		if timeout < n.timeout {
 			timeout = n.timeout
 		}
 		conn.conn.SetDeadline(time.Now().Add(timeout))
 	}
 
-	// Send the RPC
-	if err = sendRPC(conn, rpcInstallSnapshot, args); err != nil {
-		return err
-	}
-
-	// Stream the state
-	if _, err = io.Copy(conn.w, data); err != nil {
-		return err
+	// Send the RPC, and stream the state compressed if the peer accepts it
+	if conn.compression.active() {
+		if err = sendCompressedSnapshot(conn, args, data); err != nil {
+			return err
+		}
+	} else {
+		if err = sendRPC(conn, rpcInstallSnapshot, args); err != nil {
+			return err
+		}
+		if _, err = io.Copy(conn.w, data); err != nil {
+			return err
+		}
 	}
 
 	// Flush
@@ -456,11 +597,21 @@ func (n *NetworkTransport) handleConn(conn net.Conn) {
 	defer conn.Close()
 	r := bufio.NewReader(conn)
 	w := bufio.NewWriter(conn)
+
+	// Multiplexed connections start with a handshake instead of an rpc type
+	if b, err := r.Peek(1); err == nil && b[0] == rpcMuxHandshake {
+		n.handleMuxConn(conn, r, w)
+		return
+	}
+
 	dec := codec.NewDecoder(r, &codec.MsgpackHandle{})
 	enc := codec.NewEncoder(w, &codec.MsgpackHandle{})
 
 	for {
-		if err := n.handleCommand(r, dec, enc); err != nil {
+		if err := n.handleCommand(r, dec, enc); err == errCloseAfterResponse {
+			w.Flush()
+			return
+		} else if err != nil {
 			if err != io.EOF {
 				n.logger.Printf("[ERR] raft-net: Failed to decode incoming command: %v", err)
 			}
@@ -488,76 +639,127 @@ func (n *NetworkTransport) handleCommand(r *bufio.Reader, dec *codec.Decoder, en
 	}
 
 	// Decode the command
+	isHeartbeat, err := n.decodeCommand(rpcType, &rpc, r, dec)
+	if err != nil {
+		return err
+	}
+
+	// Dispatch the RPC
+	if err := n.dispatchCommand(rpc, isHeartbeat); err != nil {
+		return err
+	}
+
+	// Wait for response
+	select {
+	case resp := <-respCh:
+		if err := encodeResponse(enc, resp); err != nil {
+			return err
+		}
+		// The end of a compressed snapshot stream may be left unread, so the
+		// connection cannot carry further commands.
+		if rpcType == rpcInstallSnapshot|rpcCompressed {
+			return errCloseAfterResponse
+		}
+		return nil
+	case <-n.shutdownCh:
+		return ErrTransportShutdown
+	}
+}
+
+// decodeCommand decodes the body of a command into rpc.
+// Returns: whether the command is a heartbeat, and any decoding error.
+func (n *NetworkTransport) decodeCommand(rpcType uint8, rpc *RPC, r *bufio.Reader, dec *codec.Decoder) (bool, error) {
+	if rpcType&rpcCompressed != 0 {
+		return n.decodeCompressedCommand(rpcType&^rpcCompressed, rpc, r, dec)
+	}
+
 	isHeartbeat := false
 	switch rpcType {
 	case rpcAppendEntries:
 		var req AppendEntriesRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
 		// Check if this is a heartbeat
-		if req.Term != 0 && req.Leader != nil &&
-			req.PrevLogEntry == 0 && req.PrevLogTerm == 0 &&
-			len(req.Entries) == 0 && req.LeaderCommitIndex == 0 {
-			isHeartbeat = true
-		}
+		isHeartbeat = isHeartbeatRequest(&req)
 
 	case rpcRequestVote:
 		var req RequestVoteRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
 	case rpcInstallSnapshot:
 		var req InstallSnapshotRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
-		rpc.Reader = io.LimitReader(r, req.Size)
+		rpc.Reader = io.LimitReader(r, req.DataSize())
 
 	case rpcSyncRequest:
 		var req SyncRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
 	case rpcRecordRequest:
 		var req RecordRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
     case rpcRecoverDataRequest:
         var req RecoveryDataRequest
         if err := dec.Decode(&req); err != nil {
-            return err
+            return false, err
         }
         rpc.Command = &req
 
+	case rpcUnfreezeRequest:
+		var req UnfreezeRequest
+		if err := dec.Decode(&req); err != nil {
+			return false, err
+		}
+		rpc.Command = &req
+
 	case rpcClientRequest:
 		var req ClientRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
 	case rpcClientIdRequest:
 		var req ClientIdRequest
 		if err := dec.Decode(&req); err != nil {
-			return err
+			return false, err
 		}
 		rpc.Command = &req
 
 	default:
-		return fmt.Errorf("unknown rpc type %d", rpcType)
+		return false, fmt.Errorf("unknown rpc type %d", rpcType)
 	}
 
+	return isHeartbeat, nil
+}
+
+// isHeartbeatRequest reports whether req is a heartbeat, which is served
+// by the heartbeat fast-path.
+func isHeartbeatRequest(req *AppendEntriesRequest) bool {
+	return req.Term != 0 && req.Leader != nil &&
+		req.PrevLogEntry == 0 && req.PrevLogTerm == 0 &&
+		len(req.Entries) == 0 && req.LeaderCommitIndex == 0
+}
+
+// dispatchCommand hands a decoded command to the heartbeat or witness
+// fast-path, or the consumer.
+func (n *NetworkTransport) dispatchCommand(rpc RPC, isHeartbeat bool) error {
 	// Check for heartbeat fast-path
 	if isHeartbeat {
 		n.heartbeatFnLock.Lock()
@@ -565,38 +767,43 @@ func (n *NetworkTransport) handleCommand(r *bufio.Reader, dec *codec.Decoder, en
 		n.heartbeatFnLock.Unlock()
 		if fn != nil {
 			fn(rpc)
-			goto RESP
+			return nil
+		}
+	}
+
+	// Check for witness fast-path
+	if _, ok := rpc.Command.(*RecordRequest); ok {
+		n.witnessFnLock.Lock()
+		fn := n.witnessFn
+		n.witnessFnLock.Unlock()
+		if fn != nil {
+			fn(rpc)
+			return nil
 		}
 	}
 
 	// Dispatch the RPC
 	select {
 	case n.consumeCh <- rpc:
+		return nil
 	case <-n.shutdownCh:
 		return ErrTransportShutdown
 	}
+}
 
-	// Wait for response
-RESP:
-	select {
-	case resp := <-respCh:
-		// Send the error first
-		respErr := ""
-		if resp.Error != nil {
-			respErr = resp.Error.Error()
-		}
-		if err := enc.Encode(respErr); err != nil {
-			return err
-		}
-
-		// Send the response
-		if err := enc.Encode(resp.Response); err != nil {
-			return err
-		}
-	case <-n.shutdownCh:
-		return ErrTransportShutdown
+// encodeResponse sends the error string and response object of an RPC.
+func encodeResponse(enc *codec.Encoder, resp RPCResponse) error {
+	// Send the error first
+	respErr := ""
+	if resp.Error != nil {
+		respErr = resp.Error.Error()
 	}
-	return nil
+	if err := enc.Encode(respErr); err != nil {
+		return err
+	}
+
+	// Send the response
+	return enc.Encode(resp.Response)
 }
 
 // decodeResponse is used to decode an RPC response and reports whether
@@ -614,6 +821,7 @@ func decodeResponse(conn *netConn, resp interface{}) (bool, error) {
 		conn.Release()
 		return false, err
 	}
+	conn.compression.negotiate(resp)
 
 	// Format an error if any
 	if rpcError != "" {
@@ -624,6 +832,21 @@ func decodeResponse(conn *netConn, resp interface{}) (bool, error) {
 
 // sendRPC is used to encode and send the RPC.
 func sendRPC(conn *netConn, rpcType uint8, args interface{}) error {
+	// Compress the request if the peer accepts it
+	var body []byte
+	if conn.compression.active() {
+		var compressed bool
+		var err error
+		body, compressed, err = conn.compression.encode(args)
+		if err != nil {
+			conn.Release()
+			return err
+		}
+		if compressed {
+			rpcType |= rpcCompressed
+		}
+	}
+
 	// Write the request type
 	if err := conn.w.WriteByte(rpcType); err != nil {
 		conn.Release()
@@ -631,7 +854,13 @@ func sendRPC(conn *netConn, rpcType uint8, args interface{}) error {
 	}
 
 	// Send the request
-	if err := conn.enc.Encode(args); err != nil {
+	var err error
+	if body != nil {
+		_, err = conn.w.Write(body)
+	} else {
+		err = conn.enc.Encode(args)
+	}
+	if err != nil {
 		conn.Release()
 		return err
 	}
@@ -644,6 +873,21 @@ func sendRPC(conn *netConn, rpcType uint8, args interface{}) error {
 	return nil
 }
 
+// sendCompressedSnapshot is used to send an InstallSnapshot request
+// followed by the compressed snapshot data.
+func sendCompressedSnapshot(conn *netConn, args *InstallSnapshotRequest, data io.Reader) error {
+	if err := conn.w.WriteByte(rpcInstallSnapshot | rpcCompressed); err != nil {
+		return err
+	}
+	if err := conn.enc.Encode(conn.compression.algorithm); err != nil {
+		return err
+	}
+	if err := conn.enc.Encode(args); err != nil {
+		return err
+	}
+	return conn.compression.writeSnapshot(conn.w, data)
+}
+
 // newNetPipeline is used to construct a netPipeline from a given
 // transport and connection.
 func newNetPipeline(trans *NetworkTransport, conn *netConn) *netPipeline {
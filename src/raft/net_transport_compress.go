@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+/*
+
+NetworkTransport can compress request payloads, such as AppendEntries
+batches and snapshot streams. Raft servers advertise the algorithms their
+transport can decode in the RPCHeader of every message (see
+WithCompression). A connection starts uncompressed; once a response on it
+advertises the algorithm configured in NetworkTransportConfig.Compression,
+later requests on that connection whose encoding reaches the threshold are
+compressed.
+
+A compressed request sets rpcCompressed in its rpc type byte. In place of
+the request it carries the algorithm followed by the compressed MsgPack
+encoding of the request, both MsgPack encoded. For InstallSnapshot, the
+request itself is sent uncompressed after the algorithm, and the snapshot
+data that follows is compressed as one stream.
+
+*/
+
+// CompressionType identifies algorithms used to compress RPC payloads.
+// Values are bits, so that a set of algorithms can be advertised.
+type CompressionType uint8
+
+const (
+	// CompressionNone sends payloads uncompressed.
+	CompressionNone CompressionType = 0
+
+	// CompressionFlate compresses payloads with DEFLATE (RFC 1951).
+	CompressionFlate CompressionType = 1 << 0
+
+	// supportedCompression is the set of algorithms this version can decode.
+	supportedCompression = CompressionFlate
+
+	// DefaultCompressionThreshold is the default size in bytes an encoded
+	// request must reach to be compressed.
+	DefaultCompressionThreshold = 1024
+
+	// DefaultMaxDecompressedSize is the default size in bytes a compressed
+	// request may expand to.
+	DefaultMaxDecompressedSize = 64 * 1024 * 1024
+
+	// rpcCompressed is set in the rpc type byte of compressed requests.
+	rpcCompressed uint8 = 0x80
+)
+
+var (
+	// ErrUnsupportedCompression is returned when a request is compressed
+	// with an algorithm this version does not support.
+	ErrUnsupportedCompression = errors.New("unsupported compression algorithm")
+
+	// ErrDecompressedSizeExceeded is returned when a compressed request
+	// expands past NetworkTransportConfig.MaxDecompressedSize.
+	ErrDecompressedSizeExceeded = errors.New("decompressed request too large")
+
+	// errCloseAfterResponse is returned by handleCommand when the connection
+	// must be closed once the response is sent.
+	errCloseAfterResponse = errors.New("close after response")
+
+	// flateWriters caches compressors, which are expensive to allocate.
+	flateWriters = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+)
+
+// WithCompression is an interface that a transport may provide which
+// allows it to receive compressed requests. Raft advertises the algorithms
+// it returns in the RPCHeader of every request and response.
+type WithCompression interface {
+	// AcceptedCompression returns the set of algorithms the transport can
+	// decode.
+	AcceptedCompression() CompressionType
+}
+
+// AcceptedCompression implements the WithCompression interface.
+func (n *NetworkTransport) AcceptedCompression() CompressionType {
+	return supportedCompression
+}
+
+// connCompression tracks payload compression of requests sent on one
+// connection.
+type connCompression struct {
+	// Algorithm to use once the peer advertises it, or CompressionNone.
+	algorithm CompressionType
+	// Size an encoded request must reach to be compressed.
+	threshold int
+	// Set to 1 once the peer advertised algorithm. Accessed atomically.
+	negotiated int32
+}
+
+// newConnCompression returns the compression state of a new connection.
+func (n *NetworkTransport) newConnCompression() connCompression {
+	return connCompression{
+		algorithm: n.compression,
+		threshold: n.compressionThreshold,
+	}
+}
+
+// negotiate records the algorithms advertised in the header of a response
+// received on the connection.
+func (c *connCompression) negotiate(resp interface{}) {
+	if c.algorithm == CompressionNone {
+		return
+	}
+	withHeader, ok := resp.(WithRPCHeader)
+	if !ok {
+		return
+	}
+	if withHeader.GetRPCHeader().AcceptCompression&c.algorithm != 0 {
+		atomic.StoreInt32(&c.negotiated, 1)
+	}
+}
+
+// active reports whether requests on the connection may be compressed.
+func (c *connCompression) active() bool {
+	return atomic.LoadInt32(&c.negotiated) == 1
+}
+
+// encode encodes a request, compressing it if it reaches the threshold and
+// compression makes it smaller.
+// Returns: the body to send in place of the MsgPack encoding of args, and
+// whether it is compressed.
+func (c *connCompression) encode(args interface{}) ([]byte, bool, error) {
+	raw, err := encodeMsgPack(args)
+	if err != nil {
+		return nil, false, err
+	}
+	if raw.Len() < c.threshold {
+		return raw.Bytes(), false, nil
+	}
+
+	var compressed bytes.Buffer
+	fw := flateWriters.Get().(*flate.Writer)
+	fw.Reset(&compressed)
+	_, err = fw.Write(raw.Bytes())
+	if err == nil {
+		err = fw.Close()
+	}
+	flateWriters.Put(fw)
+	if err != nil {
+		return nil, false, err
+	}
+	recordCompression(raw.Len(), compressed.Len())
+	if compressed.Len() >= raw.Len() {
+		return raw.Bytes(), false, nil
+	}
+
+	var body bytes.Buffer
+	enc := codec.NewEncoder(&body, &codec.MsgpackHandle{})
+	if err := enc.Encode(c.algorithm); err != nil {
+		return nil, false, err
+	}
+	if err := enc.Encode(compressed.Bytes()); err != nil {
+		return nil, false, err
+	}
+	return body.Bytes(), true, nil
+}
+
+// writeSnapshot writes the snapshot data compressed to w.
+func (c *connCompression) writeSnapshot(w io.Writer, data io.Reader) error {
+	counter := &countingWriter{w: w}
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(counter)
+	n, err := io.Copy(fw, data)
+	if err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	recordCompression(int(n), int(counter.n))
+	return nil
+}
+
+// recordCompression emits metrics on the sizes of a compressed payload.
+func recordCompression(raw, compressed int) {
+	metrics.IncrCounter([]string{"raft", "net", "compression", "raw_bytes"}, float32(raw))
+	metrics.IncrCounter([]string{"raft", "net", "compression", "compressed_bytes"}, float32(compressed))
+	if raw > 0 {
+		metrics.AddSample([]string{"raft", "net", "compression", "ratio"}, float32(compressed)/float32(raw))
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeCompressedCommand decodes the body of a compressed command into rpc.
+// Returns: whether the command is a heartbeat, and any decoding error.
+func (n *NetworkTransport) decodeCompressedCommand(rpcType uint8, rpc *RPC, r *bufio.Reader, dec *codec.Decoder) (bool, error) {
+	var algorithm CompressionType
+	if err := dec.Decode(&algorithm); err != nil {
+		return false, err
+	}
+	if algorithm != CompressionFlate {
+		return false, fmt.Errorf("%v: %d", ErrUnsupportedCompression, algorithm)
+	}
+
+	// Snapshot data is decompressed as the consumer reads it
+	if rpcType == rpcInstallSnapshot {
+		var req InstallSnapshotRequest
+		if err := dec.Decode(&req); err != nil {
+			return false, err
+		}
+		rpc.Command = &req
+		rpc.Reader = io.LimitReader(flate.NewReader(r), req.DataSize())
+		return false, nil
+	}
+
+	var compressed []byte
+	if err := dec.Decode(&compressed); err != nil {
+		return false, err
+	}
+	limited := io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), n.maxDecompressedSize+1)
+	raw, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return false, err
+	}
+	if int64(len(raw)) > n.maxDecompressedSize {
+		return false, ErrDecompressedSizeExceeded
+	}
+	return n.decodeCommand(rpcType, rpc, r, codec.NewDecoderBytes(raw, &codec.MsgpackHandle{}))
+}
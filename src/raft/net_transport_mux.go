@@ -0,0 +1,578 @@
+package raft
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+/*
+
+Multiplexed framing for NetworkTransport. A client that wants many
+outstanding RPCs on one connection starts the connection with a handshake
+byte, rpcMuxHandshake, followed by the highest framing version it speaks.
+The server answers with the version it will use. rpcMuxHandshake is not a
+valid rpc type, so servers that predate multiplexing close the connection
+and the client falls back to the original framing for that peer.
+
+After the handshake, each request is framed as the rpc type byte followed
+by the MsgPack encoded request ID and request. Each response is the MsgPack
+encoded request ID, error string and response object. Responses may arrive
+in any order. Requests are dispatched to the consumer in the order they
+were sent, so pipelined AppendEntries keep their ordering.
+
+InstallSnapshot streams its state after the request and is always sent on
+a dedicated connection using the original framing.
+
+*/
+
+const (
+	// rpcMuxHandshake is the first byte sent on a multiplexed connection.
+	rpcMuxHandshake uint8 = 0xff
+
+	// muxVersionMax is the latest multiplexed framing version understood.
+	muxVersionMax uint8 = 1
+
+	// muxRetryInterval controls how long a peer that refused the handshake
+	// is spoken to with the original framing before trying again.
+	muxRetryInterval = time.Minute
+)
+
+var (
+	// errMuxUnsupported is returned when a peer does not understand the
+	// multiplexed framing.
+	errMuxUnsupported = errors.New("peer does not support multiplexed connections")
+
+	// errMuxConnClosed is returned for RPCs outstanding on a multiplexed
+	// connection when it is closed.
+	errMuxConnClosed = errors.New("multiplexed connection closed")
+)
+
+// muxCall is an RPC outstanding on a multiplexed connection.
+type muxCall struct {
+	// Response object decoded into.
+	resp interface{}
+	// Receives the result once the response is decoded or the connection fails.
+	errCh chan error
+	// True if a response was received from the peer.
+	responded bool
+}
+
+// muxConn is a client connection carrying many outstanding RPCs.
+type muxConn struct {
+	target ServerAddress
+	trans  *NetworkTransport
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	dec    *codec.Decoder
+	enc    *codec.Encoder
+
+	compression connCompression
+
+	// Lock serializing writes of request frames.
+	writeLock sync.Mutex
+
+	// Outstanding RPCs by request ID.
+	pending map[uint64]*muxCall
+	nextID  uint64
+	closed  bool
+	// Lock protecting pending, nextID and closed.
+	lock sync.Mutex
+}
+
+// getMuxConn returns the multiplexed connection to a peer, dialing one if
+// needed. Returns errMuxUnsupported if the peer only speaks the original
+// framing.
+func (n *NetworkTransport) getMuxConn(target ServerAddress) (*muxConn, error) {
+	n.muxLock.Lock()
+	if mc, ok := n.muxConns[target]; ok {
+		n.muxLock.Unlock()
+		return mc, nil
+	}
+	if refused, ok := n.legacyPeers[target]; ok && time.Since(refused) < muxRetryInterval {
+		n.muxLock.Unlock()
+		return nil, errMuxUnsupported
+	}
+	n.muxLock.Unlock()
+
+	mc, err := n.dialMux(target)
+	if err == errMuxUnsupported {
+		n.logger.Printf("[WARN] raft-net: %v does not support multiplexed connections, using original framing", target)
+		n.muxLock.Lock()
+		n.legacyPeers[target] = time.Now()
+		n.muxLock.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.muxLock.Lock()
+	defer n.muxLock.Unlock()
+	if existing, ok := n.muxConns[target]; ok {
+		// Lost a race with another dialer.
+		mc.close()
+		return existing, nil
+	}
+	if n.IsShutdown() {
+		mc.close()
+		return nil, ErrTransportShutdown
+	}
+	delete(n.legacyPeers, target)
+	n.muxConns[target] = mc
+	go mc.readResponses()
+	return mc, nil
+}
+
+// dialMux dials a peer and performs the multiplexing handshake.
+func (n *NetworkTransport) dialMux(target ServerAddress) (*muxConn, error) {
+	conn, err := n.stream.Dial(target, n.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if n.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(n.timeout))
+	}
+
+	mc := &muxConn{
+		target:  target,
+		trans:   n,
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		pending: make(map[uint64]*muxCall),
+
+		compression: n.newConnCompression(),
+	}
+	mc.dec = codec.NewDecoder(mc.r, &codec.MsgpackHandle{})
+	mc.enc = codec.NewEncoder(mc.w, &codec.MsgpackHandle{})
+
+	// Send the handshake
+	if _, err := mc.w.Write([]byte{rpcMuxHandshake, muxVersionMax}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := mc.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Older servers close the connection on the unknown rpc type.
+	version, err := mc.r.ReadByte()
+	if err != nil {
+		conn.Close()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, err
+		}
+		return nil, errMuxUnsupported
+	}
+	if version == 0 || version > muxVersionMax {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported multiplexed framing version %d", version)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return mc, nil
+}
+
+// removeMuxConn forgets a closed multiplexed connection.
+func (n *NetworkTransport) removeMuxConn(mc *muxConn) {
+	n.muxLock.Lock()
+	defer n.muxLock.Unlock()
+	if n.muxConns[mc.target] == mc {
+		delete(n.muxConns, mc.target)
+	}
+}
+
+// closeMuxConns closes all multiplexed connections.
+func (n *NetworkTransport) closeMuxConns() {
+	n.muxLock.Lock()
+	conns := make([]*muxConn, 0, len(n.muxConns))
+	for _, mc := range n.muxConns {
+		conns = append(conns, mc)
+	}
+	n.muxLock.Unlock()
+	for _, mc := range conns {
+		mc.close()
+	}
+}
+
+// start sends a request without waiting for its response.
+// Params:
+//   - rpcType: type of RPC being sent.
+//   - args: request to send.
+//   - resp: response object the response is decoded into.
+// Returns: outstanding call, to be passed to wait.
+func (m *muxConn) start(rpcType uint8, args interface{}, resp interface{}) (*muxCall, error) {
+	call := &muxCall{
+		resp:  resp,
+		errCh: make(chan error, 1),
+	}
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, errMuxConnClosed
+	}
+	id := m.nextID
+	m.nextID++
+	m.pending[id] = call
+	m.lock.Unlock()
+
+	// Compress the request if the peer accepts it
+	var body []byte
+	if m.compression.active() {
+		var compressed bool
+		var err error
+		body, compressed, err = m.compression.encode(args)
+		if err != nil {
+			m.lock.Lock()
+			delete(m.pending, id)
+			m.lock.Unlock()
+			return nil, err
+		}
+		if compressed {
+			rpcType |= rpcCompressed
+		}
+	}
+
+	m.writeLock.Lock()
+	if timeout := m.trans.timeout; timeout > 0 {
+		m.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	err := m.w.WriteByte(rpcType)
+	if err == nil {
+		err = m.enc.Encode(id)
+	}
+	if err == nil && body != nil {
+		_, err = m.w.Write(body)
+	} else if err == nil {
+		err = m.enc.Encode(args)
+	}
+	if err == nil {
+		err = m.w.Flush()
+	}
+	m.writeLock.Unlock()
+
+	if err != nil {
+		// The frame may be partially written, so the connection is unusable.
+		m.close()
+		return nil, err
+	}
+	return call, nil
+}
+
+// wait waits for the response to an outstanding call.
+// Params:
+//   - call: call returned by start.
+//   - timeout: how long to wait, or zero to wait until the connection fails.
+// Returns: whether the peer responded, and the RPC error if any.
+func (m *muxConn) wait(call *muxCall, timeout time.Duration) (bool, error) {
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case err := <-call.errCh:
+		return call.responded, err
+	case <-timeoutCh:
+	}
+
+	// Give up on the call, unless the reader is already decoding its
+	// response into call.resp.
+	m.lock.Lock()
+	for id, pending := range m.pending {
+		if pending == call {
+			delete(m.pending, id)
+			m.lock.Unlock()
+			return false, fmt.Errorf("rpc to %v timed out", m.target)
+		}
+	}
+	m.lock.Unlock()
+	err := <-call.errCh
+	return call.responded, err
+}
+
+// call sends a request and waits for its response.
+// Returns: whether the peer responded, and the RPC error if any.
+func (m *muxConn) call(rpcType uint8, args interface{}, resp interface{}) (bool, error) {
+	call, err := m.start(rpcType, args, resp)
+	if err != nil {
+		return false, err
+	}
+	return m.wait(call, m.trans.timeout)
+}
+
+// readResponses is a long running routine that decodes responses and hands
+// them to the outstanding calls, until the connection fails.
+func (m *muxConn) readResponses() {
+	for {
+		var id uint64
+		if err := m.dec.Decode(&id); err != nil {
+			m.fail(err)
+			return
+		}
+		var rpcError string
+		if err := m.dec.Decode(&rpcError); err != nil {
+			m.fail(err)
+			return
+		}
+
+		m.lock.Lock()
+		call, ok := m.pending[id]
+		delete(m.pending, id)
+		m.lock.Unlock()
+
+		// Discard responses to calls that timed out.
+		if !ok {
+			var discard interface{}
+			if err := m.dec.Decode(&discard); err != nil {
+				m.fail(err)
+				return
+			}
+			continue
+		}
+
+		if err := m.dec.Decode(call.resp); err != nil {
+			call.errCh <- err
+			m.fail(err)
+			return
+		}
+		m.compression.negotiate(call.resp)
+		call.responded = true
+		if rpcError != "" {
+			call.errCh <- errors.New(rpcError)
+		} else {
+			call.errCh <- nil
+		}
+	}
+}
+
+// fail closes the connection after a read error, failing outstanding calls.
+func (m *muxConn) fail(err error) {
+	if err != io.EOF && !m.isClosed() {
+		m.trans.logger.Printf("[ERR] raft-net: Multiplexed connection to %v failed: %v", m.target, err)
+	}
+	m.close()
+}
+
+// isClosed reports whether the connection has been closed.
+func (m *muxConn) isClosed() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.closed
+}
+
+// close closes the connection and fails all outstanding calls.
+func (m *muxConn) close() {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.closed = true
+	pending := m.pending
+	m.pending = make(map[uint64]*muxCall)
+	m.lock.Unlock()
+
+	m.conn.Close()
+	m.trans.removeMuxConn(m)
+	for _, call := range pending {
+		call.errCh <- errMuxConnClosed
+	}
+}
+
+// handleMuxConn serves an inbound multiplexed connection after the
+// handshake byte has been peeked.
+func (n *NetworkTransport) handleMuxConn(conn net.Conn, r *bufio.Reader, w *bufio.Writer) {
+	// Agree on a framing version
+	if _, err := r.ReadByte(); err != nil {
+		return
+	}
+	version, err := r.ReadByte()
+	if err != nil || version == 0 {
+		return
+	}
+	if version > muxVersionMax {
+		version = muxVersionMax
+	}
+	if err := w.WriteByte(version); err != nil {
+		return
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	dec := codec.NewDecoder(r, &codec.MsgpackHandle{})
+	enc := codec.NewEncoder(w, &codec.MsgpackHandle{})
+	var writeLock sync.Mutex
+
+	for {
+		if err := n.handleMuxCommand(conn, r, dec, w, enc, &writeLock); err != nil {
+			if err != io.EOF {
+				n.logger.Printf("[ERR] raft-net: Failed to decode incoming multiplexed command: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// handleMuxCommand is used to decode and dispatch a single multiplexed
+// command. The response is written asynchronously once available.
+func (n *NetworkTransport) handleMuxCommand(conn net.Conn, r *bufio.Reader, dec *codec.Decoder,
+	w *bufio.Writer, enc *codec.Encoder, writeLock *sync.Mutex) error {
+	// Get the rpc type and request ID
+	rpcType, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	var id uint64
+	if err := dec.Decode(&id); err != nil {
+		return err
+	}
+	if rpcType&^rpcCompressed == rpcInstallSnapshot {
+		return fmt.Errorf("InstallSnapshot is not supported on multiplexed connections")
+	}
+
+	// Create the RPC object
+	respCh := make(chan RPCResponse, 1)
+	rpc := RPC{
+		RespChan: respCh,
+	}
+	isHeartbeat, err := n.decodeCommand(rpcType, &rpc, r, dec)
+	if err != nil {
+		return err
+	}
+
+	// Dispatch in order, so pipelined requests keep their ordering
+	if err := n.dispatchCommand(rpc, isHeartbeat); err != nil {
+		return err
+	}
+
+	// Wait for response
+	go func() {
+		select {
+		case resp := <-respCh:
+			writeLock.Lock()
+			err := enc.Encode(id)
+			if err == nil {
+				err = encodeResponse(enc, resp)
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+			writeLock.Unlock()
+			if err != nil {
+				n.logger.Printf("[ERR] raft-net: Failed to send multiplexed response: %v", err)
+				conn.Close()
+			}
+		case <-n.shutdownCh:
+		}
+	}()
+	return nil
+}
+
+// muxPipeline pipelines AppendEntries requests over a shared multiplexed
+// connection. Closing the pipeline does not close the connection.
+type muxPipeline struct {
+	conn  *muxConn
+	trans *NetworkTransport
+
+	doneCh       chan AppendFuture
+	inprogressCh chan *muxPipelineCall
+
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+}
+
+// muxPipelineCall is an AppendEntries request outstanding in a muxPipeline.
+type muxPipelineCall struct {
+	future *appendFuture
+	call   *muxCall
+}
+
+// newMuxPipeline is used to construct a muxPipeline from a given
+// transport and multiplexed connection.
+func newMuxPipeline(trans *NetworkTransport, conn *muxConn) *muxPipeline {
+	n := &muxPipeline{
+		conn:         conn,
+		trans:        trans,
+		doneCh:       make(chan AppendFuture, rpcMaxPipeline),
+		inprogressCh: make(chan *muxPipelineCall, rpcMaxPipeline),
+		shutdownCh:   make(chan struct{}),
+	}
+	go n.decodeResponses()
+	return n
+}
+
+// decodeResponses is a long running routine that completes futures in the
+// order their requests were sent.
+func (n *muxPipeline) decodeResponses() {
+	for {
+		select {
+		case inprogress := <-n.inprogressCh:
+			_, err := n.conn.wait(inprogress.call, n.trans.timeout)
+			inprogress.future.respond(err)
+			select {
+			case n.doneCh <- inprogress.future:
+			case <-n.shutdownCh:
+				return
+			}
+		case <-n.shutdownCh:
+			return
+		}
+	}
+}
+
+// AppendEntries is used to pipeline a new append entries request.
+func (n *muxPipeline) AppendEntries(args *AppendEntriesRequest, resp *AppendEntriesResponse) (AppendFuture, error) {
+	// Create a new future
+	future := &appendFuture{
+		start: time.Now(),
+		args:  args,
+		resp:  resp,
+	}
+	future.init()
+
+	// Send the RPC
+	call, err := n.conn.start(rpcAppendEntries, future.args, future.resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand-off for decoding, this can also cause back-pressure
+	// to prevent too many inflight requests
+	select {
+	case n.inprogressCh <- &muxPipelineCall{future: future, call: call}:
+		return future, nil
+	case <-n.shutdownCh:
+		return nil, ErrPipelineShutdown
+	}
+}
+
+// Consumer returns a channel that can be used to consume complete futures.
+func (n *muxPipeline) Consumer() <-chan AppendFuture {
+	return n.doneCh
+}
+
+// Close is used to shutdown the pipeline. The shared connection stays open.
+func (n *muxPipeline) Close() error {
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	if n.shutdown {
+		return nil
+	}
+	n.shutdown = true
+	close(n.shutdownCh)
+	return nil
+}
@@ -1,11 +1,16 @@
 package raft
 
 import (
+	"bufio"
 	"bytes"
+	"io/ioutil"
+	"net"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
 )
 
 type testAddrProvider struct {
@@ -494,3 +499,396 @@ func makeTransport(t *testing.T, useAddrProvider bool, addressOverride string) (
 	}
 	return NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
 }
+
+func makeMuxTransport(t *testing.T) (*NetworkTransport, error) {
+	config := &NetworkTransportConfig{MaxPool: 2, Timeout: time.Second, Logger: newTestLogger(t), Multiplex: true}
+	return NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+}
+
+func TestNetworkTransport_Multiplex_OutOfOrder(t *testing.T) {
+	// Transport 1 is consumer
+	trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	// Hold the vote until the append has been answered
+	voteCh := make(chan struct{})
+	appendDoneCh := make(chan struct{})
+	go func() {
+		vote := <-rpcCh
+		close(voteCh)
+		append := <-rpcCh
+		append.Respond(&AppendEntriesResponse{Term: 4, Success: true}, nil)
+		<-appendDoneCh
+		vote.Respond(&RequestVoteResponse{Term: 4, Granted: true}, nil)
+	}()
+
+	// Transport 2 makes outbound requests on one connection
+	trans2, err := makeMuxTransport(t)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	voteErrCh := make(chan error, 1)
+	var voteResp RequestVoteResponse
+	go func() {
+		voteErrCh <- trans2.RequestVote("id1", trans1.LocalAddr(), &RequestVoteRequest{Term: 4}, &voteResp)
+	}()
+	<-voteCh
+
+	var appendResp AppendEntriesResponse
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman"), PrevLogEntry: 100}
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &args, &appendResp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !appendResp.Success {
+		t.Fatalf("bad resp: %#v", appendResp)
+	}
+	close(appendDoneCh)
+
+	if err := <-voteErrCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !voteResp.Granted {
+		t.Fatalf("bad resp: %#v", voteResp)
+	}
+
+	// Both RPCs shared a single connection
+	addr := trans1.LocalAddr()
+	if len(trans2.muxConns) != 1 || len(trans2.connPool[addr]) != 0 {
+		t.Fatalf("expected one multiplexed conn, got %d mux and %d pooled",
+			len(trans2.muxConns), len(trans2.connPool[addr]))
+	}
+}
+
+func TestNetworkTransport_Multiplex_Pipeline(t *testing.T) {
+	// Transport 1 is consumer
+	trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	// Respond with the index of each append, to check ordering
+	go func() {
+		for {
+			select {
+			case rpc := <-rpcCh:
+				switch req := rpc.Command.(type) {
+				case *AppendEntriesRequest:
+					rpc.Respond(&AppendEntriesResponse{Term: 4, LastLog: req.PrevLogEntry, Success: true}, nil)
+				default:
+					rpc.Respond(&RequestVoteResponse{Term: 4, Granted: true}, nil)
+				}
+			case <-time.After(200 * time.Millisecond):
+				return
+			}
+		}
+	}()
+
+	trans2, err := makeMuxTransport(t)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+	pipeline, err := trans2.AppendEntriesPipeline("id1", trans1.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := pipeline.(*muxPipeline); !ok {
+		t.Fatalf("expected multiplexed pipeline, got %T", pipeline)
+	}
+
+	for i := 0; i < 10; i++ {
+		args := &AppendEntriesRequest{Term: 10, Leader: []byte("cartman"), PrevLogEntry: uint64(i + 1)}
+		if _, err := pipeline.AppendEntries(args, new(AppendEntriesResponse)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		// Other RPCs share the connection with the pipeline
+		if i == 5 {
+			var out RequestVoteResponse
+			if err := trans2.RequestVote("id1", trans1.LocalAddr(), &RequestVoteRequest{Term: 4}, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	respCh := pipeline.Consumer()
+	for i := 0; i < 10; i++ {
+		select {
+		case ready := <-respCh:
+			if ready.Error() != nil {
+				t.Fatalf("err: %v", ready.Error())
+			}
+			if ready.Response().LastLog != uint64(i+1) {
+				t.Fatalf("out of order response: %#v", ready.Response())
+			}
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("timeout")
+		}
+	}
+	pipeline.Close()
+
+	if len(trans2.muxConns) != 1 || len(trans2.connPool[trans1.LocalAddr()]) != 0 {
+		t.Fatalf("expected one multiplexed conn")
+	}
+}
+
+func TestNetworkTransport_Multiplex_Fallback(t *testing.T) {
+	// Server that only speaks the original framing, closing the connection
+	// on unknown rpc types like older versions do
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer list.Close()
+	go func() {
+		for {
+			conn, err := list.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				w := bufio.NewWriter(conn)
+				dec := codec.NewDecoder(r, &codec.MsgpackHandle{})
+				enc := codec.NewEncoder(w, &codec.MsgpackHandle{})
+				for {
+					rpcType, err := r.ReadByte()
+					if err != nil || rpcType != rpcAppendEntries {
+						return
+					}
+					var req AppendEntriesRequest
+					if err := dec.Decode(&req); err != nil {
+						return
+					}
+					enc.Encode("")
+					enc.Encode(&AppendEntriesResponse{Term: req.Term, Success: true})
+					w.Flush()
+				}
+			}(conn)
+		}
+	}()
+
+	trans, err := makeMuxTransport(t)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+
+	target := ServerAddress(list.Addr().String())
+	for i := 0; i < 2; i++ {
+		var out AppendEntriesResponse
+		args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+		if err := trans.AppendEntries("id1", target, &args, &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !out.Success || out.Term != 10 {
+			t.Fatalf("bad resp: %#v", out)
+		}
+	}
+
+	// The peer is remembered as only speaking the original framing
+	if _, ok := trans.legacyPeers[target]; !ok {
+		t.Fatalf("peer should be marked legacy")
+	}
+	if len(trans.muxConns) != 0 || len(trans.connPool[target]) != 1 {
+		t.Fatalf("expected one pooled conn")
+	}
+}
+
+// Serve AppendEntries and InstallSnapshot, advertising accept as the
+// compression the server can decode. Received commands and snapshot data
+// are sent on receivedCh.
+func serveCompression(trans *NetworkTransport, accept CompressionType, receivedCh chan interface{}) {
+	go func() {
+		for rpc := range trans.Consumer() {
+			header := RPCHeader{AcceptCompression: accept}
+			switch req := rpc.Command.(type) {
+			case *AppendEntriesRequest:
+				receivedCh <- req
+				rpc.Respond(&AppendEntriesResponse{RPCHeader: header, Term: req.Term, Success: true}, nil)
+			case *InstallSnapshotRequest:
+				data, err := ioutil.ReadAll(rpc.Reader)
+				if err != nil {
+					rpc.Respond(nil, err)
+					continue
+				}
+				receivedCh <- data
+				rpc.Respond(&InstallSnapshotResponse{RPCHeader: header, Term: req.Term, Success: true}, nil)
+			}
+		}
+	}()
+}
+
+func TestNetworkTransport_Compression(t *testing.T) {
+	for _, multiplex := range []bool{false, true} {
+		trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer trans1.Close()
+		receivedCh := make(chan interface{}, 4)
+		serveCompression(trans1, trans1.AcceptedCompression(), receivedCh)
+
+		config := &NetworkTransportConfig{
+			MaxPool:              2,
+			Timeout:              time.Second,
+			Logger:               newTestLogger(t),
+			Multiplex:            multiplex,
+			Compression:          CompressionFlate,
+			CompressionThreshold: 64,
+		}
+		trans2, err := NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer trans2.Close()
+
+		// The first request negotiates, the rest are compressed
+		args := AppendEntriesRequest{
+			Term:         10,
+			Leader:       []byte("cartman"),
+			PrevLogEntry: 100,
+			PrevLogTerm:  4,
+			Entries: []*Log{
+				&Log{Index: 101, Term: 4, Type: LogCommand, Data: bytes.Repeat([]byte("raft"), 1024)},
+			},
+			LeaderCommitIndex: 90,
+		}
+		for i := 0; i < 3; i++ {
+			var out AppendEntriesResponse
+			if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &args, &out); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if req := (<-receivedCh).(*AppendEntriesRequest); !reflect.DeepEqual(req, &args) {
+				t.Fatalf("command mismatch: %#v %#v", *req, args)
+			}
+		}
+
+		var active bool
+		if multiplex {
+			active = trans2.muxConns[trans1.LocalAddr()].compression.active()
+		} else {
+			active = trans2.connPool[trans1.LocalAddr()][0].compression.active()
+		}
+		if !active {
+			t.Fatalf("compression should be negotiated (multiplex %v)", multiplex)
+		}
+	}
+}
+
+func TestNetworkTransport_Compression_Snapshot(t *testing.T) {
+	trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	receivedCh := make(chan interface{}, 4)
+	serveCompression(trans1, trans1.AcceptedCompression(), receivedCh)
+
+	config := &NetworkTransportConfig{MaxPool: 2, Timeout: time.Second, Logger: newTestLogger(t), Compression: CompressionFlate}
+	trans2, err := NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	// Negotiate on the pooled connection, which the snapshot then uses
+	var out AppendEntriesResponse
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &AppendEntriesRequest{Term: 10}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	<-receivedCh
+
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+	args := InstallSnapshotRequest{Term: 10, Leader: []byte("kyle"), LastLogIndex: 100, LastLogTerm: 9, Size: int64(len(data))}
+	var resp InstallSnapshotResponse
+	if err := trans2.InstallSnapshot("id1", trans1.LocalAddr(), &args, &resp, bytes.NewReader(data)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+	if received := (<-receivedCh).([]byte); !bytes.Equal(received, data) {
+		t.Fatalf("snapshot data mismatch: %d bytes", len(received))
+	}
+
+	// Other connections are unaffected
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &AppendEntriesRequest{Term: 11}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestNetworkTransport_Compression_NotAccepted(t *testing.T) {
+	trans1, err := NewTCPTransportWithLogger("127.0.0.1:0", nil, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	receivedCh := make(chan interface{}, 4)
+	serveCompression(trans1, CompressionNone, receivedCh)
+
+	config := &NetworkTransportConfig{MaxPool: 2, Timeout: time.Second, Logger: newTestLogger(t), Compression: CompressionFlate}
+	trans2, err := NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var out AppendEntriesResponse
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &AppendEntriesRequest{Term: 10}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	<-receivedCh
+	if trans2.connPool[trans1.LocalAddr()][0].compression.active() {
+		t.Fatalf("compression should not be used with a peer that does not accept it")
+	}
+}
+
+func TestNetworkTransport_Compression_MaxDecompressedSize(t *testing.T) {
+	config := &NetworkTransportConfig{MaxPool: 2, Timeout: time.Second, Logger: newTestLogger(t), MaxDecompressedSize: 64 * 1024}
+	trans, err := NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+
+	// A small payload that expands past the limit is rejected
+	c := connCompression{algorithm: CompressionFlate}
+	args := AppendEntriesRequest{
+		Term:    10,
+		Entries: []*Log{&Log{Index: 101, Term: 4, Type: LogCommand, Data: make([]byte, 1024*1024)}},
+	}
+	body, compressed, err := c.encode(&args)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !compressed || len(body) > 8*1024 {
+		t.Fatalf("bad body: %d bytes, compressed %v", len(body), compressed)
+	}
+	dec := codec.NewDecoderBytes(body, &codec.MsgpackHandle{})
+	var rpc RPC
+	if _, err := trans.decodeCompressedCommand(rpcAppendEntries, &rpc, nil, dec); err != ErrDecompressedSizeExceeded {
+		t.Fatalf("expected size exceeded, got %v", err)
+	}
+
+	// and one within it is decoded
+	args.Entries[0].Data = make([]byte, 16*1024)
+	if body, _, err = c.encode(&args); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dec = codec.NewDecoderBytes(body, &codec.MsgpackHandle{})
+	if _, err := trans.decodeCompressedCommand(rpcAppendEntries, &rpc, nil, dec); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if req := rpc.Command.(*AppendEntriesRequest); len(req.Entries[0].Data) != 16*1024 {
+		t.Fatalf("bad request: %#v", req)
+	}
+}
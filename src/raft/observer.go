@@ -9,7 +9,7 @@ type Observation struct {
 	// Raft holds the Raft instance generating the observation.
 	Raft *Raft
 	// Data holds observation-specific data. Possible types are
-	// *RequestVoteRequest and RaftState.
+	// *RequestVoteRequest, RaftState and LogChecksumObservation.
 	Data interface{}
 }
 
@@ -10,6 +10,7 @@ import (
 	"github.com/armon/go-metrics"
 	"io"
 	"io/ioutil"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,15 +24,20 @@ var (
 	keyLastVoteCand        = []byte("LastVoteCand")
 	keyWitnessStateKeys    = []byte("WitnessStateKeys")
 	keyWitnessStateRecords = []byte("WitnessStateRecords")
+	keyWitnessTerm         = []byte("WitnessTerm")
 )
 
 // getRPCHeader returns an initialized RPCHeader struct for the given
 // Raft instance. This structure is sent along with RPC requests and
 // responses.
 func (r *Raft) getRPCHeader() RPCHeader {
-	return RPCHeader{
+	header := RPCHeader{
 		ProtocolVersion: r.conf.ProtocolVersion,
 	}
+	if withCompression, ok := r.trans.(WithCompression); ok {
+		header.AcceptCompression = withCompression.AcceptedCompression()
+	}
+	return header
 }
 
 // checkRPCHeader houses logic about whether this instance of Raft can process
@@ -87,6 +93,12 @@ type leaderState struct {
 	replState  map[ServerID]*followerReplication
 	notify     map[*verifyFuture]struct{}
 	stepDown   chan struct{}
+	// witnessesReady is closed once recovery from witnesses has completed,
+	// after which witnesses may be initialized for this term.
+	witnessesReady chan struct{}
+	// committedOps holds recently committed client operations, sent to
+	// witnesses on heartbeats for garbage collection.
+	committedOps *committedOps
 }
 
 // Tuple used to uniquely identify RPC using RIFL.
@@ -138,6 +150,12 @@ func (r *Raft) run() {
 		default:
 		}
 
+		// A halted server only waits to be shut down
+		if r.halted {
+			r.runHalted()
+			continue
+		}
+
 		// Enter into a sub-FSM
 		switch r.getState() {
 		case Follower:
@@ -216,6 +234,54 @@ func (r *Raft) runFollower() {
 				return
 			}
 
+		case <-r.fsmCorruptCh:
+			r.halt()
+			return
+
+		case <-r.shutdownCh:
+			return
+		}
+	}
+}
+
+// halt stops the server from taking part in the cluster once its FSM has
+// found a corrupted entry, stepping down if it is the leader. The server
+// keeps running until it is shut down, so that Shutdown still closes the
+// transport and waits for the server's goroutines.
+func (r *Raft) halt() {
+	r.logger.Printf("[ERR] raft: %v halted after a log checksum mismatch, shut it down and repair its log", r)
+	r.halted = true
+	r.setState(Follower)
+	r.setLeader("")
+}
+
+// runHalted runs the main thread of a halted server, rejecting every RPC
+// and operation until the server is shut down.
+func (r *Raft) runHalted() {
+	for {
+		select {
+		case rpc := <-r.rpcCh:
+			rpc.Respond(nil, ErrLogChecksumMismatch)
+
+		case c := <-r.configurationChangeCh:
+			c.respond(ErrLogChecksumMismatch)
+
+		case a := <-r.applyCh:
+			a.respond(ErrLogChecksumMismatch)
+
+		case v := <-r.verifyCh:
+			v.respond(ErrLogChecksumMismatch)
+
+		case r := <-r.userRestoreCh:
+			r.respond(ErrLogChecksumMismatch)
+
+		case c := <-r.configurationsCh:
+			c.configurations = r.configurations.Clone()
+			c.respond(nil)
+
+		case b := <-r.bootstrapCh:
+			b.respond(ErrLogChecksumMismatch)
+
 		case <-r.shutdownCh:
 			return
 		}
@@ -317,6 +383,10 @@ func (r *Raft) runCandidate() {
 			r.logger.Printf("[WARN] raft: Election timeout reached, restarting election")
 			return
 
+		case <-r.fsmCorruptCh:
+			r.halt()
+			return
+
 		case <-r.shutdownCh:
 			return
 		}
@@ -349,6 +419,8 @@ func (r *Raft) runLeader() {
 	r.leaderState.replState = make(map[ServerID]*followerReplication)
 	r.leaderState.notify = make(map[*verifyFuture]struct{})
 	r.leaderState.stepDown = make(chan struct{}, 1)
+	r.leaderState.witnessesReady = make(chan struct{})
+	r.leaderState.committedOps = newCommittedOps(16 * r.conf.MaxWitnessGcOps)
 
 	// Cleanup state on step down
 	defer func() {
@@ -381,6 +453,12 @@ func (r *Raft) runLeader() {
 		r.leaderState.replState = nil
 		r.leaderState.notify = nil
 		r.leaderState.stepDown = nil
+		r.leaderState.witnessesReady = nil
+		r.leaderState.committedOps = nil
+
+		// Unsynced operations are either committed by the next leader
+		// or recovered from witnesses.
+		r.unsyncedOps.clear()
 
 		// If we are stepping down for some reason, no known leader.
 		// We may have stepped down due to an RPC call, which would
@@ -426,6 +504,7 @@ func (r *Raft) runLeader() {
 
 	//TODO: make sure it's safe to replay from witnesses here (can't start having client requests)
 	r.recoverWithWitness()
+	close(r.leaderState.witnessesReady)
 
 	// Sit in the leader loop until we step down
 	r.leaderLoop()
@@ -457,6 +536,8 @@ func (r *Raft) startStopReplication() {
 				lastContact: time.Now(),
 				notifyCh:    make(chan struct{}, 1),
 				stepDown:    r.leaderState.stepDown,
+				witnessesReady: r.leaderState.witnessesReady,
+				committedOps:   r.leaderState.committedOps,
 			}
 			r.leaderState.replState[server.ID] = s
 			r.goFunc(func() { r.replicate(s) })
@@ -533,10 +614,14 @@ func (r *Raft) recoverWithWitness() {
             }
         }
     }
-    for _,chosenWitness := range chosenWitnesses {
-        // Unfreeze all chosen f+1 witnesses.
+    // Unfreeze the chosen f+1 witnesses and initialize them for this term.
+    // Recorded operations have been replayed, so they can discard records
+    // from earlier terms. The other witnesses were not frozen, and are
+    // initialized by the heartbeat (see initializeWitness).
+    for _, chosenWitness := range chosenWitnesses {
         unfreezeReq := &UnfreezeRequest{
             RPCHeader: r.getRPCHeader(),
+            Term:      r.getCurrentTerm(),
         }
         err := r.trans.UnfreezeWitness(chosenWitness.ID, chosenWitness.Address, unfreezeReq, &UnfreezeResponse{})
         if err != nil {
@@ -545,6 +630,46 @@ func (r *Raft) recoverWithWitness() {
     }
 }
 
+// Initialize a witness that has not been initialized for the current term,
+// such as a server added with AddVoter, a server restored from
+// InstallSnapshot, or a server that was unreachable after the election.
+// Operations the leader executed before the witness was initialized are not
+// recorded at it, so all of them are synced before it may accept records.
+// Runs asynchronously, and at most once at a time per follower.
+// Params:
+//   - s: replication state of follower to initialize.
+//   - stopCh: closed when replication to the follower stops.
+func (r *Raft) initializeWitness(s *followerReplication, stopCh chan struct{}) {
+    if !atomic.CompareAndSwapInt32(&s.initializingWitness, 0, 1) {
+        return
+    }
+    r.goFunc(func() {
+        defer atomic.StoreInt32(&s.initializingWitness, 0)
+
+        // Wait for recovery from witnesses to complete.
+        select {
+        case <-s.witnessesReady:
+        case <-stopCh:
+            return
+        }
+
+        // Sync all operations that are currently unsynced.
+        if !r.unsyncedOps.waitSynced(stopCh) {
+            return
+        }
+
+        req := &UnfreezeRequest{
+            RPCHeader: r.getRPCHeader(),
+            Term:      s.currentTerm,
+        }
+        if err := r.trans.UnfreezeWitness(s.peer.ID, s.peer.Address, req, &UnfreezeResponse{}); err != nil {
+            r.logger.Printf("[ERR] raft: Failed to initialize witness %v: %v", s.peer, err)
+            return
+        }
+        r.logger.Printf("[INFO] raft: Initialized witness %v for term %d", s.peer, s.currentTerm)
+    })
+}
+
 // configurationChangeChIfStable returns r.configurationChangeCh if it's safe
 // to process requests from it, or nil otherwise. This must only be called
 // from the main thread.
@@ -658,15 +783,7 @@ func (r *Raft) leaderLoop() {
 
 		case newLog := <-r.applyCh:
 			// Group commit, gather all the ready commits
-			ready := []*logFuture{newLog}
-			for i := 0; i < r.conf.MaxAppendEntries; i++ {
-				select {
-				case newLog := <-r.applyCh:
-					ready = append(ready, newLog)
-				default:
-					break
-				}
-			}
+			ready := r.gatherLogs(newLog)
 
 			// Dispatch the logs
 			if stepDown {
@@ -692,6 +809,9 @@ func (r *Raft) leaderLoop() {
 			// Renew the lease timer
 			lease = time.After(checkInterval)
 
+		case <-r.fsmCorruptCh:
+			r.halt()
+
 		case <-r.shutdownCh:
 			return
 		}
@@ -828,7 +948,7 @@ func (r *Raft) restoreUserSnapshot(meta *SnapshotMeta, reader io.Reader) error {
 	// Dump the snapshot. Note that we use the latest configuration,
 	// not the one that came with the snapshot.
 	sink, err := r.snapshots.Create(version, lastIndex, term,
-		r.configurations.latest, r.configurations.latestIndex, r.nextClientId, r.clientResponseCache, r.trans)
+		r.configurations.latest, r.configurations.latestIndex, r.nextClientId, r.copyClientResponseCache(), r.witnessRecords(), r.trans)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot: %v", err)
 	}
@@ -867,6 +987,7 @@ func (r *Raft) restoreUserSnapshot(meta *SnapshotMeta, reader io.Reader) error {
 	r.setLastLog(lastIndex, term)
 	r.setLastApplied(lastIndex)
 	r.setLastSnapshot(lastIndex, term)
+	r.logSizes.compacted(lastIndex)
 
 	r.logger.Printf("[INFO] raft: Restored user snapshot (index %d)", lastIndex)
 	return nil
@@ -927,10 +1048,22 @@ func (r *Raft) dispatchLogs(applyLogs []*logFuture) {
 		lastIndex++
 		applyLog.log.Index = lastIndex
 		applyLog.log.Term = term
+		applyLog.log.Checksum = nil
+		if r.conf.LogChecksums {
+			applyLog.log.Checksum = applyLog.log.computeChecksum()
+		}
 		logs[idx] = &applyLog.log
 		r.leaderState.inflight.PushBack(applyLog)
 	}
 
+	// Notify the replicators of the new log, so they send it while we
+	// write it locally
+	r.pendingLogs.set(logs)
+	defer r.pendingLogs.clear()
+	for _, f := range r.leaderState.replState {
+		asyncNotifyCh(f.triggerCh)
+	}
+
 	// Write the log entry locally
 	if err := r.logs.StoreLogs(logs); err != nil {
 		r.logger.Printf("[ERR] raft: Failed to commit logs: %v", err)
@@ -941,14 +1074,10 @@ func (r *Raft) dispatchLogs(applyLogs []*logFuture) {
 		return
 	}
 	r.leaderState.commitment.match(r.localID, lastIndex)
+	r.logSizes.appended(logs)
 
 	// Update the last log since it's on disk now
 	r.setLastLog(lastIndex, term)
-
-	// Notify the replicators of the new log
-	for _, f := range r.leaderState.replState {
-		asyncNotifyCh(f.triggerCh)
-	}
 }
 
 // processLogs is used to apply all the committed entires that haven't been
@@ -993,7 +1122,7 @@ func (r *Raft) processLog(l *Log, future *logFuture) {
 		// Barrier is handled by the FSM
 		fallthrough
 
-	case LogCommand:
+	case LogCommand, LogTransaction:
 		// Forward to the fsm handler
 		select {
 		case r.fsmMutateCh <- &commitTuple{l, future}:
@@ -1003,21 +1132,20 @@ func (r *Raft) processLog(l *Log, future *logFuture) {
 			}
 		}
 
-		// Garbage collect at witnesses.
+		// Operation is now synced at the leader.
 		clientSeqNo := ClientSeqNo{
 			ClientID: l.ClientID,
 			SeqNo:    l.SeqNo,
 		}
-		// TODO: also need to delete key
-		records, keys := stableGetWitnessState(r.stable)
-		delete(records, clientSeqNo)
-		for _, key := range l.Keys {
-			hash := getKeyHash(key)
-			if bytes.Compare(key, keys[hash]) == 0 {
-				delete(keys, hash)
-			}
+		r.unsyncedOps.remove(clientSeqNo)
+
+		// Tell witnesses the operation committed on the next heartbeat.
+		if r.leaderState.committedOps != nil {
+			r.leaderState.committedOps.add(l.Index, clientSeqNo)
 		}
-		stableSetWitnessState(r.stable, records, keys)
+
+		// Garbage collect at witnesses.
+		r.gcWitnessRecords([]ClientSeqNo{clientSeqNo})
 
 		// Return so that the future is only responded to
 		// by the FSM handler when the application is done
@@ -1030,6 +1158,13 @@ func (r *Raft) processLog(l *Log, future *logFuture) {
 		}
 		r.nextClientId = nextClientId
 
+	case LogClientIdentity:
+		var binding clientIdentity
+		if err := decodeMsgPack(l.Data, &binding); err != nil {
+			panic(fmt.Errorf("failed to decode client identity: %v", err))
+		}
+		r.setClientIdentities(map[uint64]string{binding.ClientID: binding.Identity})
+
 	case LogConfiguration:
 	case LogAddPeerDeprecated:
 	case LogRemovePeerDeprecated:
@@ -1056,8 +1191,39 @@ func getKeyHash(key Key) uint32 {
 	return binary.LittleEndian.Uint32(hashSlice)
 }
 
+// Garbage collect records of committed operations at this witness, along
+// with the keys they hold. Writes witness state to stable storage only if a
+// record was removed.
+// Params:
+//   - ops: client ID and sequence numbers of committed operations.
+// Returns: number of records removed.
+func (r *Raft) gcWitnessRecords(ops []ClientSeqNo) int {
+	r.witnessLock.Lock()
+	defer r.witnessLock.Unlock()
+	records, keys := stableGetWitnessState(r.stable)
+	removed := 0
+	for _, op := range ops {
+		record, ok := records[op]
+		if !ok {
+			continue
+		}
+		for _, key := range record.Keys {
+			hash := getKeyHash(key)
+			if bytes.Compare(key, keys[hash]) == 0 {
+				delete(keys, hash)
+			}
+		}
+		delete(records, op)
+		removed++
+	}
+	if removed > 0 {
+		stableSetWitnessState(r.stable, records, keys)
+	}
+	return removed
+}
+
 // stableSetWitnessStorage writes the witnessState to stable storage.
-// Should only be called if r.witnessState.Lock is held. Panics if
+// Should only be called if r.witnessLock is held. Panics if
 // failure.
 func stableSetWitnessState(stable StableStore, records map[ClientSeqNo]Log, keys map[uint32]Key) {
 	recordsBuf, err1 := encodeMsgPack(records)
@@ -1078,12 +1244,18 @@ func stableSetWitnessState(stable StableStore, records map[ClientSeqNo]Log, keys
 	}
 }
 
+// stableGetWitnessState reads the witnessState from stable storage. Returns
+// empty state if none has been written. Panics if failure.
 func stableGetWitnessState(stable StableStore) (map[ClientSeqNo]Log, map[uint32]Key) {
+	records := make(map[ClientSeqNo]Log)
+	keys := make(map[uint32]Key)
+	if !stableHasWitnessState(stable) {
+		return records, keys
+	}
 	recordsBuf, err1 := stable.Get(keyWitnessStateRecords)
 	if err1 != nil {
 		panic(fmt.Errorf("failed to read witness state records from stable storage: %v", err1))
 	}
-	records := make(map[ClientSeqNo]Log)
 	err2 := decodeMsgPack(recordsBuf, &records)
 	if err2 != nil {
 		panic(fmt.Errorf("failed to decode witness state records: %v", err2))
@@ -1092,7 +1264,6 @@ func stableGetWitnessState(stable StableStore) (map[ClientSeqNo]Log, map[uint32]
 	if err3 != nil {
 		panic(fmt.Errorf("failed to read witness state keys from stable storage: %v", err3))
 	}
-	keys := make(map[uint32]Key)
 	err4 := decodeMsgPack(keysBuf, &keys)
 	if err4 != nil {
 		panic(fmt.Errorf("failed to decode witness state keys: %v", err4))
@@ -1100,6 +1271,68 @@ func stableGetWitnessState(stable StableStore) (map[ClientSeqNo]Log, map[uint32]
 	return records, keys
 }
 
+// stableHasWitnessState returns true if witness state has been written to
+// stable storage. False for a new server or one whose stable storage was lost.
+func stableHasWitnessState(stable StableStore) bool {
+	buf, err := stable.Get(keyWitnessStateRecords)
+	return err == nil && len(buf) > 0
+}
+
+// stableGetWitnessRecords reads the operations recorded at the witness from
+// stable storage, for use in the witness section of a snapshot.
+func stableGetWitnessRecords(stable StableStore) []Log {
+	records, _ := stableGetWitnessState(stable)
+	logs := make([]Log, 0, len(records))
+	for _, log := range records {
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+// witnessRecords returns the operations recorded at this witness.
+func (r *Raft) witnessRecords() []Log {
+	r.witnessLock.Lock()
+	defer r.witnessLock.Unlock()
+	return stableGetWitnessRecords(r.stable)
+}
+
+// restoreWitnessRecords replaces witness state in stable storage with the
+// given records, such as the witness section of a snapshot. Must be called
+// with witnessLock held.
+func (r *Raft) restoreWitnessRecords(logs []Log) {
+	records := make(map[ClientSeqNo]Log)
+	keys := make(map[uint32]Key)
+	for _, log := range logs {
+		clientSeqNo := ClientSeqNo{
+			ClientID: log.ClientID,
+			SeqNo:    log.SeqNo,
+		}
+		records[clientSeqNo] = log
+		for _, key := range log.Keys {
+			keys[getKeyHash(key)] = key
+		}
+	}
+	stableSetWitnessState(r.stable, records, keys)
+}
+
+// setWitnessTerm records the term this server was initialized as a witness
+// for by the leader, and persists it. Must be called with frozenLock held.
+// Panics if failure.
+func (r *Raft) setWitnessTerm(term uint64) {
+	if err := r.stable.SetUint64(keyWitnessTerm, term); err != nil {
+		panic(fmt.Errorf("failed to save witness term: %v", err))
+	}
+	r.witnessTerm = term
+}
+
+// getWitnessTerm returns the term this server was last initialized as a
+// witness for.
+func (r *Raft) getWitnessTerm() uint64 {
+	r.frozenLock.RLock()
+	defer r.frozenLock.RUnlock()
+	return r.witnessTerm
+}
+
 // processRPC is called to handle an incoming RPC request. This must only be
 // called from the main thread.
 func (r *Raft) processRPC(rpc RPC) {
@@ -1156,6 +1389,49 @@ func (r *Raft) processHeartbeat(rpc RPC) {
 	}
 }
 
+// processRecord is a special handler used just for RecordRequests so that
+// they can be fast-pathed if a transport supports it. Hands the RPC to a
+// witness worker.
+func (r *Raft) processRecord(rpc RPC) {
+	select {
+	case r.witnessCh <- rpc:
+	case <-r.shutdownCh:
+		rpc.Respond(nil, ErrRaftShutdown)
+	}
+}
+
+// runWitnessWorker is a long running goroutine that handles RecordRequests
+// received on the witness fast-path.
+func (r *Raft) runWitnessWorker() {
+	for {
+		select {
+		case rpc := <-r.witnessCh:
+			r.processWitnessRPC(rpc)
+		case <-r.shutdownCh:
+			return
+		}
+	}
+}
+
+// processWitnessRPC handles a RecordRequest received on the witness
+// fast-path.
+func (r *Raft) processWitnessRPC(rpc RPC) {
+	defer metrics.MeasureSince([]string{"raft", "rpc", "processRecord"}, time.Now())
+	if err := r.checkRPCHeader(rpc); err != nil {
+		rpc.Respond(nil, err)
+		return
+	}
+
+	// Ensure we are only handling a record
+	switch cmd := rpc.Command.(type) {
+	case *RecordRequest:
+		r.recordRequest(rpc, cmd)
+	default:
+		r.logger.Printf("[ERR] raft: Expected record request, got command: %#v", rpc.Command)
+		rpc.Respond(nil, fmt.Errorf("unexpected command"))
+	}
+}
+
 // appendEntries is invoked when we get an append entries RPC call. This must
 // only be called from the main thread.
 func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
@@ -1167,6 +1443,7 @@ func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
 		LastLog:        r.getLastIndex(),
 		Success:        false,
 		NoRetryBackoff: false,
+		WitnessTerm:    r.getWitnessTerm(),
 	}
 	var rpcErr error
 	defer func() {
@@ -1221,6 +1498,14 @@ func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
 	if len(a.Entries) > 0 {
 		start := time.Now()
 
+		// Reject entries corrupted in transit, so the leader sends them again
+		for _, entry := range a.Entries {
+			if !entry.verifyChecksum() {
+				r.reportChecksumMismatch(entry, false)
+				return
+			}
+		}
+
 		// Delete any conflicting entries, skip any duplicates
 		lastLogIdx, _ := r.getLastLog()
 		var newEntries []*Log
@@ -1241,6 +1526,7 @@ func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
 					r.logger.Printf("[ERR] raft: Failed to clear log suffix: %v", err)
 					return
 				}
+				r.logSizes.truncated(entry.Index)
 				if entry.Index <= r.configurations.latestIndex {
 					r.configurations.latest = r.configurations.committed
 					r.configurations.latestIndex = r.configurations.committedIndex
@@ -1259,6 +1545,8 @@ func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
 				return
 			}
 
+			r.logSizes.appended(newEntries)
+
 			// Handle any new configuration changes
 			for _, newEntry := range newEntries {
 				r.processConfigurationLogEntry(newEntry)
@@ -1285,6 +1573,12 @@ func (r *Raft) appendEntries(rpc RPC, a *AppendEntriesRequest) {
 		metrics.MeasureSince([]string{"raft", "rpc", "appendEntries", "processLogs"}, start)
 	}
 
+	// Garbage collect witness records of operations the leader committed.
+	if len(a.CommittedOps) > 0 {
+		n := r.gcWitnessRecords(a.CommittedOps)
+		metrics.IncrCounter([]string{"raft", "witness", "gcRecords"}, float32(n))
+	}
+
 	// Everything went well, set success
 	resp.Success = true
 	r.setLastContact()
@@ -1439,6 +1733,24 @@ func (r *Raft) installSnapshot(rpc RPC, req *InstallSnapshotRequest) {
 	// Save the current leader
 	r.setLeader(ServerAddress(r.trans.DecodePeer(req.Leader)))
 
+	// Learn client identities bound by entries the snapshot compacted
+	if len(req.ClientIdentities) > 0 {
+		identities := make(map[uint64]string)
+		if err := decodeMsgPack(req.ClientIdentities, &identities); err != nil {
+			r.logger.Printf("[ERR] raft: Failed to decode client identities: %v", err)
+			rpcErr = err
+			return
+		}
+		r.setClientIdentities(identities)
+	}
+
+	// Abandon a partial snapshot the leader is no longer sending
+	if in := r.snapshotInstall; in != nil && !in.matches(req) {
+		r.logger.Printf("[INFO] raft: Abandoning partial snapshot at index %d after %d / %d bytes",
+			in.index, in.offset, in.size)
+		r.cancelSnapshotInstall()
+	}
+
 	// Create a new snapshot
 	var reqConfiguration Configuration
 	var reqConfigurationIndex uint64
@@ -1449,39 +1761,69 @@ func (r *Raft) installSnapshot(rpc RPC, req *InstallSnapshotRequest) {
 		reqConfiguration = decodePeers(req.Peers, r.trans)
 		reqConfigurationIndex = req.LastLogIndex
 	}
-	version := getSnapshotVersion(r.protocolVersion)
-	sink, err := r.snapshots.Create(version, req.LastLogIndex, req.LastLogTerm,
-		reqConfiguration, reqConfigurationIndex, r.nextClientId, r.clientResponseCache, r.trans)
-	if err != nil {
-		r.logger.Printf("[ERR] raft: Failed to create snapshot to install: %v", err)
-		rpcErr = fmt.Errorf("failed to create snapshot: %v", err)
+	if r.snapshotInstall == nil {
+		if req.Offset != 0 {
+			// We don't have the start of the snapshot
+			r.logger.Printf("[WARN] raft: Snapshot chunk at offset %d without earlier chunks", req.Offset)
+			return
+		}
+		sink, err := r.createInstallSnapshot(req, reqConfiguration, reqConfigurationIndex)
+		if err == errSnapshotBaseMissing {
+			r.logger.Printf("[WARN] raft: Missing base at index %d of snapshot delta", req.BaseIndex)
+			resp.BaseMissing = true
+			resp.Snapshots = r.snapshotPositions()
+			return
+		}
+		if err != nil {
+			r.logger.Printf("[ERR] raft: Failed to create snapshot to install: %v", err)
+			rpcErr = fmt.Errorf("failed to create snapshot: %v", err)
+			return
+		}
+		r.snapshotInstall = &snapshotInstall{
+			sink:  sink,
+			index: req.LastLogIndex,
+			term:  req.LastLogTerm,
+			size:  req.Size,
+		}
+	}
+	in := r.snapshotInstall
+	resp.NextOffset = in.offset
+	if req.Offset != in.offset {
+		r.logger.Printf("[WARN] raft: Snapshot chunk at offset %d, expected %d", req.Offset, in.offset)
+		return
+	}
+	if in.offset+req.DataSize() > in.size {
+		r.logger.Printf("[ERR] raft: Snapshot chunk at offset %d of %d bytes exceeds snapshot size %d",
+			req.Offset, req.DataSize(), in.size)
 		return
 	}
 
 	// Spill the remote snapshot to disk
-	n, err := io.Copy(sink, rpc.Reader)
-	if err != nil {
-		sink.Cancel()
+	if err := r.receiveSnapshotChunk(in, req, rpc.Reader); err != nil {
 		r.logger.Printf("[ERR] raft: Failed to copy snapshot: %v", err)
-		rpcErr = err
+		if r.snapshotInstall == nil {
+			resp.NextOffset = 0
+			rpcErr = err
+		}
 		return
 	}
-
-	// Check that we received it all
-	if n != req.Size {
-		sink.Cancel()
-		r.logger.Printf("[ERR] raft: Failed to receive whole snapshot: %d / %d", n, req.Size)
-		rpcErr = fmt.Errorf("short read")
+	resp.NextOffset = in.offset
+	if in.offset < in.size {
+		resp.Success = true
+		r.setLastContact()
 		return
 	}
 
 	// Finalize the snapshot
+	r.snapshotInstall = nil
+	resp.NextOffset = 0
+	sink := in.sink
 	if err := sink.Close(); err != nil {
 		r.logger.Printf("[ERR] raft: Failed to finalize snapshot: %v", err)
 		rpcErr = err
 		return
 	}
-	r.logger.Printf("[INFO] raft: Copied %d bytes to local snapshot", n)
+	r.logger.Printf("[INFO] raft: Copied %d bytes to local snapshot", in.size)
 
 	// Restore snapshot
 	future := &restoreFuture{ID: sink.ID()}
@@ -1505,6 +1847,13 @@ func (r *Raft) installSnapshot(rpc RPC, req *InstallSnapshotRequest) {
 
 	// Update the last stable snapshot info
 	r.setLastSnapshot(req.LastLogIndex, req.LastLogTerm)
+	r.logSizes.compacted(req.LastLogIndex)
+
+	// A server that needed a snapshot missed operations recorded at other
+	// witnesses, so refuse records until the leader initializes it again.
+	r.frozenLock.Lock()
+	r.setWitnessTerm(0)
+	r.frozenLock.Unlock()
 
 	// Restore the peer set
 	r.configurations.latest = reqConfiguration
@@ -1519,6 +1868,7 @@ func (r *Raft) installSnapshot(rpc RPC, req *InstallSnapshotRequest) {
 
 	r.logger.Printf("[INFO] raft: Installed remote snapshot")
 	resp.Success = true
+	resp.NextOffset = in.size
 	r.setLastContact()
 	return
 }
@@ -1530,7 +1880,14 @@ func (r *Raft) installSnapshot(rpc RPC, req *InstallSnapshotRequest) {
 //   - rpc: RPC object used to send a response.
 //   - req: Recovery DAta Request being handled.
 func (r *Raft) recoveryDataRequest(rpc RPC, req *RecoveryDataRequest) {
+    // Freeze before reading records, so that no record is accepted after
+    // they are read.
+    r.frozenLock.Lock()
+    r.frozen = true
+    r.witnessLock.Lock()
     logMap,_ := stableGetWitnessState(r.stable)
+    r.witnessLock.Unlock()
+    r.frozenLock.Unlock()
     logs := make([]Log, 0)
     for _,log := range logMap {
         logs = append(logs, log)
@@ -1539,23 +1896,43 @@ func (r *Raft) recoveryDataRequest(rpc RPC, req *RecoveryDataRequest) {
         RPCHeader: r.getRPCHeader(),
         Entries: logs,
     }
-    r.frozenLock.Lock()
-    r.frozen = true
-    r.frozenLock.Unlock()
     rpc.Respond(resp, nil)
 }
 
 // Handle a unfreezeRequest from new leader to witness. Sent after
 // recoveryDataRequest to allow witness to start receiving client
-// record requests again.
+// record requests again, and to initialize new witnesses. Records
+// from earlier terms are discarded, since the leader has already
+// recovered or synced them.
 func (r *Raft) unfreezeRequest(rpc RPC, req *UnfreezeRequest) {
-    r.frozenLock.Lock()
-    r.frozen = false
-    r.frozenLock.Unlock()
     resp := &UnfreezeResponse {
         RPCHeader: r.getRPCHeader(),
+    }
 
+    // Ignore a stale leader.
+    if req.Term < r.getCurrentTerm() {
+        rpc.Respond(resp, ErrStaleTerm)
+        return
     }
+
+    r.frozenLock.Lock()
+    if r.witnessTerm != req.Term {
+        // Keep records accepted in this term, such as those restored from
+        // a snapshot, since the leader may not have synced them yet.
+        r.witnessLock.Lock()
+        records, _ := stableGetWitnessState(r.stable)
+        kept := make([]Log, 0, len(records))
+        for _, log := range records {
+            if log.Term >= req.Term {
+                kept = append(kept, log)
+            }
+        }
+        r.restoreWitnessRecords(kept)
+        r.witnessLock.Unlock()
+        r.setWitnessTerm(req.Term)
+    }
+    r.frozen = false
+    r.frozenLock.Unlock()
     rpc.Respond(resp, nil)
 }
 
@@ -1569,9 +1946,20 @@ func (r *Raft) clientIdRequest(rpc RPC, c *ClientIdRequest) {
 	leader := r.Leader()
 	resp := &ClientIdResponse{
 		LeaderAddress: leader,
+		Term:          r.getCurrentTerm(),
 	}
 	// Can only assign client IDs at the leader.
 	if r.getState() == Leader {
+		if !r.admission.admit() {
+			rpc.Respond(resp, ErrOverloaded)
+			return
+		}
+		identity, err := r.authenticate(c.Credentials)
+		if err != nil {
+			r.admission.release()
+			rpc.Respond(resp, err)
+			return
+		}
 		resp.ClientID = r.nextClientId
 		r.nextClientId += 1
 		r.clientResponseLock.Lock()
@@ -1579,7 +1967,12 @@ func (r *Raft) clientIdRequest(rpc RPC, c *ClientIdRequest) {
 		r.clientResponseLock.Unlock()
 		r.logger.Printf("Client ID to send is %v", r.nextClientId)
 		go func(r *Raft, resp *ClientIdResponse, rpc RPC) {
+			defer r.admission.release()
 			f := r.SendNextClientId(0)
+			// Bind the client ID before the client can use it.
+			if f.Error() == nil && r.conf.Authenticator != nil {
+				f = r.bindClientIdentity(resp.ClientID, identity)
+			}
 			if f.Error() != nil {
 				r.logger.Printf("err :%v", f.Error())
 			}
@@ -1614,10 +2007,26 @@ func (r *Raft) syncRequest(rpc RPC, sync *SyncRequest) {
 	// Check if request has already been made.
 	// Have we contacted the leader?
 	if r.getState() == Leader {
+		entry, err := r.authorize(sync.Entry, sync.Credentials)
+		if err != nil {
+			rpc.Respond(resp, err)
+			return
+		}
+		// Push back while the log is full, before executing anything,
+		// rather than execute the command and then wait in Apply.
+		if r.logFull() {
+			rpc.Respond(resp, ErrOverloaded)
+			return
+		}
+		if !r.admission.admitClient(entry.ClientID) {
+			rpc.Respond(resp, ErrOverloaded)
+			return
+		}
 		// Apply all commands in client request.
 		r.goFunc(func() {
+			defer r.admission.release()
 			var rpcErr error
-			resp.ResponseData = r.applySynchronousCommand(sync.Entry, &rpcErr)
+			resp.ResponseData = r.applySynchronousCommand(entry, &rpcErr)
 			resp.Success = true
 			rpc.Respond(resp, rpcErr)
 		})
@@ -1629,50 +2038,88 @@ func (r *Raft) syncRequest(rpc RPC, sync *SyncRequest) {
 
 // Handle a recordRequest from client. Can only be handled
 // at a witness, not the leader. Records an operation successfully
-// if it is commutative with other stored operations.
+// if it is commutative with other stored operations. Called from the
+// main thread, or from witness workers concurrently with it.
 func (r *Raft) recordRequest(rpc RPC, record *RecordRequest) {
+	// Tell client the current term and leader so it can fence witnesses.
+	leader := r.Leader()
+	resp := &RecordResponse{
+		Success:       false,
+		Term:          r.getCurrentTerm(),
+		LeaderAddress: leader,
+	}
+
 	// Master can't act as a witness.
 	if r.getState() == Leader {
-		resp := &RecordResponse{
-			Success: false,
-		}
 		rpc.Respond(resp, ErrNotWitness)
 		return
 	}
 
-    // Can't accept record request if frozen.
-    r.frozenLock.RLock()
-    isFrozen := r.frozen
-    r.frozenLock.RUnlock()
-    if isFrozen {
-        resp := &RecordResponse {
-            Success: false,
-        }
-        rpc.Respond(resp, ErrWitnessFrozen)
-        return
-    }
-
-    // Can't accept record request if sending to stale set of witnesses.
-    if record.Term < r.getCurrentTerm() {
-        resp := &RecordResponse {
-            Success: false,
-        }
-        rpc.Respond(resp, ErrStaleTerm)
-        return
-    }
+	err := r.record(record, resp.Term, leader)
+	resp.Success = err == nil
+	if err == nil || err == ErrNotCommutative {
+		r.logger.Printf("witness says client req is commutative: %v", resp.Success)
+	}
 
-	success := r.storeIfCommutative(record.Entry)
-	r.logger.Printf("witness says client req is commutative: %b", success)
 	// Respond to client.
-	resp := &RecordResponse{
-		Success: success,
+	rpc.Respond(resp, err)
+}
+
+// Record an operation at the witness if the witness accepts it. Holds
+// frozenLock until the operation is stored, since records may be handled
+// concurrently with the main thread: a concurrent recoveryDataRequest
+// either returns the operation or freezes the witness first.
+// Params:
+//   - record: Record Request being handled.
+//   - term: current term of this server.
+//   - leader: leader this server follows.
+// Returns: nil if the operation is stored, otherwise the error to respond
+// with.
+func (r *Raft) record(record *RecordRequest, term uint64, leader ServerAddress) error {
+	r.frozenLock.RLock()
+	defer r.frozenLock.RUnlock()
+
+	// Can't accept record request if frozen.
+	if r.frozen {
+		return ErrWitnessFrozen
 	}
 
-	if success {
-		rpc.Respond(resp, nil)
-	} else {
-		rpc.Respond(resp, ErrNotCommutative)
+	// Can't accept record request if client and witness disagree on the
+	// term. If the client is behind, it may be sending to a stale leader
+	// and set of witnesses. If the witness is behind, it may not be part of
+	// the current leader's configuration.
+	if record.Term != term {
+		return ErrStaleTerm
+	}
+
+	// Can't accept record request if client is sending to a different
+	// leader than the one this witness follows.
+	if leader != "" && record.LeaderAddress != leader {
+		return ErrStaleLeader
 	}
+
+	// Can't accept record request until the leader has initialized this
+	// witness for the current term.
+	if r.witnessTerm != term {
+		return ErrWitnessUninitialized
+	}
+
+	entry, err := r.authorize(record.Entry, record.Credentials)
+	if err != nil {
+		return err
+	}
+	if !r.admission.admitClient(entry.ClientID) {
+		return ErrOverloaded
+	}
+	defer r.admission.release()
+
+	// Remember the term the record was accepted in, so that it is kept
+	// when the witness is initialized again in the same term.
+	entry.Term = term
+	if !r.storeIfCommutative(entry) {
+		return ErrNotCommutative
+	}
+	return nil
 }
 
 // Check if an operation is commutative with other operations
@@ -1683,6 +2130,8 @@ func (r *Raft) recordRequest(rpc RPC, record *RecordRequest) {
 // Return true if successfully stored (must be commutative with
 // other operations, false otherwise.
 func (r *Raft) storeIfCommutative(log *Log) bool {
+	r.witnessLock.Lock()
+	defer r.witnessLock.Unlock()
 	records, keys := stableGetWitnessState(r.stable)
 
 	// Check if operation involving key already stored at witness or no
@@ -1724,6 +2173,7 @@ func (r *Raft) clientRequest(rpc RPC, c *ClientRequest) {
 	resp := &ClientResponse{
 		Success:       false,
 		LeaderAddress: leader,
+		Term:          r.getCurrentTerm(),
 	}
 	// Check if client ID is valid.
 	r.clientResponseLock.RLock()
@@ -1736,10 +2186,26 @@ func (r *Raft) clientRequest(rpc RPC, c *ClientRequest) {
 	// Check if request has already been made.
 	// Have we contacted the leader?
 	if r.getState() == Leader {
+		entry, err := r.authorize(c.Entry, c.Credentials)
+		if err != nil {
+			rpc.Respond(resp, err)
+			return
+		}
+		// Push back while the log is full, before executing anything,
+		// rather than execute the command and then wait in Apply.
+		if r.logFull() {
+			rpc.Respond(resp, ErrOverloaded)
+			return
+		}
+		if !r.admission.admitClient(entry.ClientID) {
+			rpc.Respond(resp, ErrOverloaded)
+			return
+		}
 		// Apply all commands in client request.
 		r.goFunc(func() {
+			defer r.admission.release()
 			var rpcErr error
-			r.applyCommand(c.Entry, resp, &rpcErr)
+			r.applyCommand(entry, resp, &rpcErr)
 			rpc.Respond(resp, rpcErr)
 		})
 	} else {
@@ -1748,7 +2214,7 @@ func (r *Raft) clientRequest(rpc RPC, c *ClientRequest) {
 	}
 }
 
-// Apply a command locally if it is commutative (not synced) or
+// Apply a command locally if it is commutative with all unsynced operations (not synced) or
 // replicate to followers (synced). Sets fields in resp based on
 // execution of request and if synced.
 // Params:
@@ -1756,10 +2222,9 @@ func (r *Raft) clientRequest(rpc RPC, c *ClientRequest) {
 //   - resp: Response to populate after completing command.
 //   - rpcErr: Pointer to error to set if necessary.
 func (r *Raft) applyCommand(log *Log, resp *ClientResponse, rpcErr *error) {
-	commutative := r.storeIfCommutative(log)
+	data, commutative := r.applyCommutativeCommand(log, rpcErr)
 	if commutative {
-		// Apply locally, store in witness cache, and respond
-		resp.ResponseData = r.applyCommutativeCommand(log, rpcErr)
+		resp.ResponseData = data
 		resp.Synced = false
 	} else {
 		// Sync all previous requests and execute this request synchronously.
@@ -1769,21 +2234,45 @@ func (r *Raft) applyCommand(log *Log, resp *ClientResponse, rpcErr *error) {
 	resp.LeaderAddress = r.Leader()
 }
 
-// Apply a command locally. Should only be called by the leader if
-// the leader has confirmed that the operation is commutative and
-// is stored in its set of current operations.
+// Apply a command locally if it is commutative with all operations the
+// leader has not yet synced, and track it as unsynced until it commits.
 // Params:
 //   - log: Log entry to apply commutatively, type LogCommand.
 //   - rpcErr: Pointer to error to set if necessary.
-// Returns: byte array containing response to applying command.
-func (r *Raft) applyCommutativeCommand(log *Log, rpcErr *error) []byte {
-	// Apply locally, store in witness cache, and respond
+// Returns: byte array containing response to applying command, and false
+// if the command is not commutative and was not applied.
+func (r *Raft) applyCommutativeCommand(log *Log, rpcErr *error) ([]byte, bool) {
+	if !r.unsyncedOps.addIfCommutative(log) {
+		return nil, false
+	}
+	// Apply locally and respond
 	var response interface{}
 	r.applyCommandLocally(log, &response)
 	data, _ := json.Marshal(response)
-	// Replicate to client asynchronously
-	r.goFunc(func() { r.Apply(log, 0) })
-	return data
+	// Replicate to client asynchronously. If the command does not commit,
+	// release its keys so that later commands on them are not forced onto
+	// the sync path until step-down.
+	r.goFunc(func() {
+		// Wait for the result in another goroutine, so that shutdown does
+		// not wait for a future nobody responds to.
+		future := r.Apply(log, 0)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- future.Error()
+		}()
+		var err error
+		select {
+		case err = <-errCh:
+		case <-r.shutdownCh:
+			return
+		}
+		if err != nil {
+			r.logger.Printf("[ERR] raft: Failed to replicate commutative command %v/%v: %v",
+				log.ClientID, log.SeqNo, err)
+			r.unsyncedOps.remove(ClientSeqNo{ClientID: log.ClientID, SeqNo: log.SeqNo})
+		}
+	})
+	return data, true
 }
 
 // Replicate a command to followers. Should be called if leader has
@@ -25,6 +25,15 @@ type MockFSM struct {
 	logs [][]byte
 }
 
+// testSeqNo numbers the commands built by testCommand.
+var testSeqNo uint64
+
+// testCommand returns a command carrying data, with a sequence number no
+// other test command uses, so that RIFL does not treat it as a retry.
+func testCommand(data []byte) *Log {
+	return &Log{Data: data, ClientID: 1, SeqNo: atomic.AddUint64(&testSeqNo, 1)}
+}
+
 type MockSnapshot struct {
 	logs     [][]byte
 	maxIndex int
@@ -673,7 +682,7 @@ func TestRaft_AfterShutdown(t *testing.T) {
 	raft := c.rafts[0]
 
 	// Everything should fail now
-	if f := raft.Apply(nil, 0); f.Error() != ErrRaftShutdown {
+	if f := raft.Apply(testCommand(nil), 0); f.Error() != ErrRaftShutdown {
 		c.FailNowf("[ERR] should be shutdown: %v", f.Error())
 	}
 
@@ -730,7 +739,7 @@ func TestRaft_LiveBootstrap(t *testing.T) {
 	c.EnsureLeader(t, leader.localAddr)
 
 	// Should be able to apply.
-	future := leader.Apply([]byte("test"), c.conf.CommitTimeout)
+	future := leader.Apply(testCommand([]byte("test")), c.conf.CommitTimeout)
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] apply err: %v", err)
 	}
@@ -777,7 +786,7 @@ func TestRaft_RecoverCluster(t *testing.T) {
 		c.logger.Printf("[DEBUG] Running with applies=%d", applies)
 		leader := c.Leader()
 		for i := 0; i < applies; i++ {
-			future := leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+			future := leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 			if err := future.Error(); err != nil {
 				c.FailNowf("[ERR] apply err: %v", err)
 			}
@@ -916,7 +925,7 @@ func TestRaft_SingleNode(t *testing.T) {
 	}
 
 	// Should be able to apply
-	future := raft.Apply([]byte("test"), c.conf.HeartbeatTimeout)
+	future := raft.Apply(testCommand([]byte("test")), c.conf.HeartbeatTimeout)
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] err: %v", err)
 	}
@@ -948,7 +957,7 @@ func TestRaft_TripleNode(t *testing.T) {
 	c.EnsureLeader(t, leader.localAddr)
 
 	// Should be able to apply
-	future := leader.Apply([]byte("test"), c.conf.CommitTimeout)
+	future := leader.Apply(testCommand([]byte("test")), c.conf.CommitTimeout)
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] err: %v", err)
 	}
@@ -965,7 +974,7 @@ func TestRaft_LeaderFail(t *testing.T) {
 	leader := c.Leader()
 
 	// Should be able to apply
-	future := leader.Apply([]byte("test"), c.conf.CommitTimeout)
+	future := leader.Apply(testCommand([]byte("test")), c.conf.CommitTimeout)
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] err: %v", err)
 	}
@@ -996,10 +1005,10 @@ func TestRaft_LeaderFail(t *testing.T) {
 	}
 
 	// Apply should work not work on old leader
-	future1 := leader.Apply([]byte("fail"), c.conf.CommitTimeout)
+	future1 := leader.Apply(testCommand([]byte("fail")), c.conf.CommitTimeout)
 
 	// Apply should work on newer leader
-	future2 := newLead.Apply([]byte("apply"), c.conf.CommitTimeout)
+	future2 := newLead.Apply(testCommand([]byte("apply")), c.conf.CommitTimeout)
 
 	// Future2 should work
 	if err := future2.Error(); err != nil {
@@ -1048,7 +1057,7 @@ func TestRaft_BehindFollower(t *testing.T) {
 	// Commit a lot of things
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1090,7 +1099,7 @@ func TestRaft_ApplyNonLeader(t *testing.T) {
 	follower := followers[0]
 
 	// Try to apply
-	future := follower.Apply([]byte("test"), c.conf.CommitTimeout)
+	future := follower.Apply(testCommand([]byte("test")), c.conf.CommitTimeout)
 	if future.Error() != ErrNotLeader {
 		c.FailNowf("[ERR] should not apply on follower")
 	}
@@ -1119,7 +1128,7 @@ func TestRaft_ApplyConcurrent(t *testing.T) {
 
 	applyF := func(i int) {
 		defer group.Done()
-		future := leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future := leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 		if err := future.Error(); err != nil {
 			c.Failf("[ERR] err: %v", err)
 		}
@@ -1168,7 +1177,7 @@ func TestRaft_ApplyConcurrent_Timeout(t *testing.T) {
 	var didTimeout int32
 	for i := 0; (i < 5000) && (atomic.LoadInt32(&didTimeout) == 0); i++ {
 		go func(i int) {
-			future := leader.Apply([]byte(fmt.Sprintf("test%d", i)), time.Microsecond)
+			future := leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), time.Microsecond)
 			if future.Error() == ErrEnqueueTimeout {
 				atomic.StoreInt32(&didTimeout, 1)
 			}
@@ -1325,7 +1334,7 @@ func TestRaft_RemoveLeader_NoShutdown(t *testing.T) {
 				c.FailNowf("[ERR] err: %v, remove leader failed", err)
 			}
 		}
-		future := leader.Apply([]byte{i}, 0)
+		future := leader.Apply(testCommand([]byte{i}), 0)
 		if i > 80 {
 			if err := future.Error(); err == nil || err != ErrNotLeader {
 				c.FailNowf("[ERR] err: %v, future entries should fail", err)
@@ -1495,7 +1504,7 @@ func TestRaft_SnapshotRestore(t *testing.T) {
 	leader := c.Leader()
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1561,7 +1570,7 @@ func TestRaft_SnapshotRestore_PeerChange(t *testing.T) {
 	leader := c.Leader()
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1666,7 +1675,7 @@ func TestRaft_AutoSnapshot(t *testing.T) {
 	leader := c.Leader()
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1700,7 +1709,7 @@ func TestRaft_UserSnapshot(t *testing.T) {
 	// Commit some things.
 	var future Future
 	for i := 0; i < 10; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test %d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test %d", i))), 0)
 	}
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] Error Apply new log entries: %v", err)
@@ -1729,7 +1738,7 @@ func snapshotAndRestore(t *testing.T, offset uint64) {
 	leader := c.Leader()
 	var future Future
 	for i := 0; i < 10; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test %d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test %d", i))), 0)
 	}
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] Error Apply new log entries: %v", err)
@@ -1743,7 +1752,7 @@ func snapshotAndRestore(t *testing.T, offset uint64) {
 
 	// Commit some more things.
 	for i := 10; i < 20; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test %d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test %d", i))), 0)
 	}
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] Error Apply new log entries: %v", err)
@@ -1796,7 +1805,7 @@ func snapshotAndRestore(t *testing.T, offset uint64) {
 
 	// Commit some more things.
 	for i := 20; i < 30; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test %d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test %d", i))), 0)
 	}
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] Error Apply new log entries: %v", err)
@@ -1832,7 +1841,7 @@ func TestRaft_SendSnapshotFollower(t *testing.T) {
 	// Commit a lot of things
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1874,7 +1883,7 @@ func TestRaft_SendSnapshotAndLogsFollower(t *testing.T) {
 	// Commit a lot of things
 	var future Future
 	for i := 0; i < 100; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -1895,7 +1904,7 @@ func TestRaft_SendSnapshotAndLogsFollower(t *testing.T) {
 
 	// Commit more logs past the snapshot.
 	for i := 100; i < 200; i++ {
-		future = leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		future = leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for the last future to apply
@@ -2062,7 +2071,7 @@ func TestRaft_Barrier(t *testing.T) {
 
 	// Commit a lot of things
 	for i := 0; i < 100; i++ {
-		leader.Apply([]byte(fmt.Sprintf("test%d", i)), 0)
+		leader.Apply(testCommand([]byte(fmt.Sprintf("test%d", i))), 0)
 	}
 
 	// Wait for a barrier complete
@@ -2202,7 +2211,7 @@ func TestRaft_StartAsLeader(t *testing.T) {
 	}
 
 	// Should be able to apply
-	future := raft.Apply([]byte("test"), c.conf.CommitTimeout)
+	future := raft.Apply(testCommand([]byte("test")), c.conf.CommitTimeout)
 	if err := future.Error(); err != nil {
 		c.FailNowf("[ERR] err: %v", err)
 	}
@@ -2423,3 +2432,285 @@ func TestRaft_ProtocolVersion_Upgrade_2_3(t *testing.T) {
 //
 // Storage errors handled properly.
 // Commit index updated properly.
+
+// blockingReader blocks reads until released, signalling when the first
+// read starts.
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestRaft_WitnessFastPathDuringInstallSnapshot(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.WitnessWorkers = 2
+	// Keep the isolated witness from starting an election
+	conf.HeartbeatTimeout = 500 * time.Millisecond
+	conf.ElectionTimeout = 500 * time.Millisecond
+	c := MakeCluster(3, t, conf)
+	defer c.Close()
+	leader := c.Leader()
+	witness := c.Followers()[0]
+	term := leader.getCurrentTerm()
+	future := leader.GetConfiguration()
+	if err := future.Error(); err != nil {
+		c.FailNowf("[ERR] get configuration: %v", err)
+	}
+
+	// Wait for the leader to initialize the witness
+	deadline := time.Now().Add(c.propagateTimeout)
+	for witness.getWitnessTerm() != term {
+		if time.Now().After(deadline) {
+			c.FailNowf("[ERR] witness not initialized for term %d", term)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Isolate the witness from the cluster, so that no replication RPC
+	// times out while its main thread is blocked, and let in-flight RPCs
+	// drain
+	c.Disconnect(witness.localAddr)
+	time.Sleep(100 * time.Millisecond)
+
+	// Block the witness's main thread in an InstallSnapshot
+	reader := &blockingReader{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(reader.release)
+	witnessTrans := c.trans[c.IndexOf(witness)].(*InmemTransport)
+	witnessTrans.consumerCh <- RPC{
+		Command: &InstallSnapshotRequest{
+			RPCHeader:          leader.getRPCHeader(),
+			SnapshotVersion:    SnapshotVersionMax,
+			Term:               term,
+			Leader:             leader.trans.EncodePeer(leader.localID, leader.localAddr),
+			LastLogIndex:       1000,
+			LastLogTerm:        term,
+			Size:               1 << 20,
+			Configuration:      encodeConfiguration(future.Configuration()),
+			ConfigurationIndex: 1,
+		},
+		Reader:   reader,
+		RespChan: make(chan RPCResponse, 1),
+	}
+	select {
+	case <-reader.started:
+	case <-time.After(time.Second):
+		c.FailNowf("[ERR] snapshot install not started")
+	}
+
+	// Records complete promptly while the install is in progress
+	_, client := NewInmemTransport("")
+	client.Connect(witness.localAddr, witnessTrans)
+	const maxLatency = 50 * time.Millisecond
+	for i := 0; i < 20; i++ {
+		req := &RecordRequest{
+			RPCHeader: RPCHeader{ProtocolVersion: ProtocolVersionMax},
+			Entry: &Log{
+				Type:     LogCommand,
+				ClientID: 1,
+				SeqNo:    uint64(i),
+				Keys:     []Key{Key(fmt.Sprintf("key%d", i))},
+			},
+			Term:          term,
+			LeaderAddress: leader.localAddr,
+		}
+		var resp RecordResponse
+		start := time.Now()
+		if _, err := client.SendRecordRequest(witness.localAddr, req, &resp); err != nil || !resp.Success {
+			c.FailNowf("[ERR] record %d failed: %v", i, err)
+		}
+		if latency := time.Since(start); latency > maxLatency {
+			c.FailNowf("[ERR] record %d took %v", i, latency)
+		}
+	}
+	select {
+	case <-reader.release:
+		c.FailNowf("[ERR] snapshot install finished early")
+	default:
+	}
+}
+
+func TestRaft_WitnessFencing(t *testing.T) {
+	c := MakeCluster(3, t, nil)
+	defer c.Close()
+	leader := c.Leader()
+	witness := c.Followers()[0]
+	term := leader.getCurrentTerm()
+
+	// Wait for the leader to initialize the witness
+	deadline := time.Now().Add(c.propagateTimeout)
+	for witness.getWitnessTerm() != term {
+		if time.Now().After(deadline) {
+			c.FailNowf("[ERR] witness not initialized for term %d", term)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, client := NewInmemTransport("")
+	client.Connect(witness.localAddr, c.trans[c.IndexOf(witness)])
+	record := func(seqNo, term uint64, leaderAddr ServerAddress) (*RecordResponse, error) {
+		req := &RecordRequest{
+			RPCHeader: RPCHeader{ProtocolVersion: ProtocolVersionMax},
+			Entry: &Log{
+				Type:     LogCommand,
+				ClientID: 1,
+				SeqNo:    seqNo,
+				Keys:     []Key{Key(fmt.Sprintf("key%d", seqNo))},
+			},
+			Term:          term,
+			LeaderAddress: leaderAddr,
+		}
+		var resp RecordResponse
+		_, err := client.SendRecordRequest(witness.localAddr, req, &resp)
+		return &resp, err
+	}
+
+	// A client behind or ahead of the witness's term is rejected, and told
+	// the witness's term and leader
+	for _, clientTerm := range []uint64{term - 1, term + 1} {
+		resp, err := record(1, clientTerm, leader.localAddr)
+		if err == nil || err.Error() != ErrStaleTerm.Error() || resp.Success {
+			c.FailNowf("[ERR] expected stale term for term %d, got: %v", clientTerm, err)
+		}
+		if resp.Term != term || resp.LeaderAddress != leader.localAddr {
+			c.FailNowf("[ERR] bad response: %#v", resp)
+		}
+	}
+
+	// So is a client sending to another leader
+	resp, err := record(2, term, witness.localAddr)
+	if err == nil || err.Error() != ErrStaleLeader.Error() || resp.Success {
+		c.FailNowf("[ERR] expected stale leader, got: %v", err)
+	}
+	if resp.LeaderAddress != leader.localAddr {
+		c.FailNowf("[ERR] bad leader hint: %v", resp.LeaderAddress)
+	}
+
+	// Nothing was recorded, so the keys are still free
+	for seqNo := uint64(1); seqNo <= 2; seqNo++ {
+		if resp, err := record(seqNo, term, leader.localAddr); err != nil || !resp.Success {
+			c.FailNowf("[ERR] record %d failed: %v", seqNo, err)
+		}
+	}
+}
+
+func TestRaft_WitnessRestoredRecordsKept(t *testing.T) {
+	c := MakeCluster(3, t, nil)
+	defer c.Close()
+	leader := c.Leader()
+	witness := c.Followers()[0]
+	term := leader.getCurrentTerm()
+
+	waitInitialized := func() {
+		deadline := time.Now().Add(c.propagateTimeout)
+		for witness.getWitnessTerm() != term {
+			if time.Now().After(deadline) {
+				c.FailNowf("[ERR] witness not initialized for term %d", term)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitInitialized()
+
+	// A record accepted by the witness is stamped with the term
+	req := &RecordRequest{
+		Entry:         &Log{Type: LogCommand, ClientID: 1, SeqNo: 1, Keys: []Key{Key("a")}},
+		Term:          term,
+		LeaderAddress: leader.localAddr,
+	}
+	if err := witness.record(req, term, leader.localAddr); err != nil {
+		c.FailNowf("[ERR] record failed: %v", err)
+	}
+	records, _ := stableGetWitnessState(witness.stable)
+	recorded := records[ClientSeqNo{ClientID: 1, SeqNo: 1}]
+	if recorded.Term != term {
+		c.FailNowf("[ERR] bad record term: %d", recorded.Term)
+	}
+
+	// The witness loses its state, and restores records from a snapshot
+	// taken across the term change
+	witness.frozenLock.Lock()
+	witness.witnessLock.Lock()
+	witness.restoreWitnessRecords([]Log{
+		recorded,
+		{Type: LogCommand, Term: term - 1, ClientID: 1, SeqNo: 2, Keys: []Key{Key("b")}},
+	})
+	witness.witnessLock.Unlock()
+	witness.setWitnessTerm(0)
+	witness.frozenLock.Unlock()
+
+	// Initializing it again keeps the record from this term, and discards
+	// the one from the earlier term
+	waitInitialized()
+	records, keys := stableGetWitnessState(witness.stable)
+	if _, ok := records[ClientSeqNo{ClientID: 1, SeqNo: 1}]; !ok || len(records) != 1 {
+		c.FailNowf("[ERR] bad records: %v", records)
+	}
+	if _, ok := keys[getKeyHash(Key("a"))]; !ok || len(keys) != 1 {
+		c.FailNowf("[ERR] bad keys: %v", keys)
+	}
+}
+
+func TestRaft_RecoverWithWitness_UnfreezesChosen(t *testing.T) {
+	_, trans := NewInmemTransport("")
+	defer trans.Close()
+
+	// The first witness can't be recovered from
+	var lock sync.Mutex
+	recovered := make(map[ServerAddress]bool)
+	unfrozen := make(map[ServerAddress]uint64)
+	servers := []Server{{Suffrage: Voter, ID: "leader", Address: trans.LocalAddr()}}
+	for i := 0; i < 4; i++ {
+		var addr ServerAddress
+		addr, peer := startStubServer(trans, func(rpc RPC) {
+			lock.Lock()
+			defer lock.Unlock()
+			switch req := rpc.Command.(type) {
+			case *RecoveryDataRequest:
+				if addr == servers[1].Address {
+					rpc.Respond(nil, fmt.Errorf("unavailable"))
+					return
+				}
+				recovered[addr] = true
+				rpc.Respond(&RecoveryDataResponse{}, nil)
+			case *UnfreezeRequest:
+				unfrozen[addr] = req.Term
+				rpc.Respond(&UnfreezeResponse{}, nil)
+			}
+		})
+		defer peer.Close()
+		servers = append(servers, Server{Suffrage: Voter, ID: ServerID(addr), Address: addr})
+	}
+
+	r := &Raft{
+		localID: "leader",
+		trans:   trans,
+		logger:  newTestLogger(t),
+	}
+	r.configurations.latest = Configuration{Servers: servers}
+	r.raftState.setCurrentTerm(2)
+	r.recoverWithWitness()
+
+	// Only the f+1 witnesses recovered from are unfrozen
+	lock.Lock()
+	defer lock.Unlock()
+	if len(recovered) != 3 || recovered[servers[1].Address] {
+		t.Fatalf("bad recovered witnesses: %v", recovered)
+	}
+	if len(unfrozen) != len(recovered) {
+		t.Fatalf("bad unfrozen witnesses: %v", unfrozen)
+	}
+	for addr, term := range unfrozen {
+		if !recovered[addr] || term != 2 {
+			t.Fatalf("bad unfreeze of %v for term %d", addr, term)
+		}
+	}
+}
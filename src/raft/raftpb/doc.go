@@ -0,0 +1,5 @@
+// Package raftpb holds the protobuf messages and gRPC service used by
+// GRPCTransport, generated from raft.proto.
+package raftpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative raft.proto
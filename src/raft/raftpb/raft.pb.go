@@ -0,0 +1,2098 @@
+// Protocol of GRPCTransport. The messages mirror the structures in
+// commands.go, which GRPCTransport converts to and from them. Fields that
+// are themselves encoded by Raft, such as configurations and peers, are
+// carried as bytes.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: raft.proto
+
+package raftpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// LogType is the type of a log entry.
+type LogType int32
+
+const (
+	LogType_LOG_TYPE_COMMAND                LogType = 0
+	LogType_LOG_TYPE_NOOP                   LogType = 1
+	LogType_LOG_TYPE_ADD_PEER_DEPRECATED    LogType = 2
+	LogType_LOG_TYPE_REMOVE_PEER_DEPRECATED LogType = 3
+	LogType_LOG_TYPE_BARRIER                LogType = 4
+	LogType_LOG_TYPE_CONFIGURATION          LogType = 5
+	LogType_LOG_TYPE_NEXT_CLIENT_ID         LogType = 6
+	LogType_LOG_TYPE_TRANSACTION            LogType = 7
+	LogType_LOG_TYPE_CLIENT_IDENTITY        LogType = 8
+)
+
+// Enum value maps for LogType.
+var (
+	LogType_name = map[int32]string{
+		0: "LOG_TYPE_COMMAND",
+		1: "LOG_TYPE_NOOP",
+		2: "LOG_TYPE_ADD_PEER_DEPRECATED",
+		3: "LOG_TYPE_REMOVE_PEER_DEPRECATED",
+		4: "LOG_TYPE_BARRIER",
+		5: "LOG_TYPE_CONFIGURATION",
+		6: "LOG_TYPE_NEXT_CLIENT_ID",
+		7: "LOG_TYPE_TRANSACTION",
+		8: "LOG_TYPE_CLIENT_IDENTITY",
+	}
+	LogType_value = map[string]int32{
+		"LOG_TYPE_COMMAND":                0,
+		"LOG_TYPE_NOOP":                   1,
+		"LOG_TYPE_ADD_PEER_DEPRECATED":    2,
+		"LOG_TYPE_REMOVE_PEER_DEPRECATED": 3,
+		"LOG_TYPE_BARRIER":                4,
+		"LOG_TYPE_CONFIGURATION":          5,
+		"LOG_TYPE_NEXT_CLIENT_ID":         6,
+		"LOG_TYPE_TRANSACTION":            7,
+		"LOG_TYPE_CLIENT_IDENTITY":        8,
+	}
+)
+
+func (x LogType) Enum() *LogType {
+	p := new(LogType)
+	*p = x
+	return p
+}
+
+func (x LogType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (LogType) Descriptor() protoreflect.EnumDescriptor {
+	return file_raft_proto_enumTypes[0].Descriptor()
+}
+
+func (LogType) Type() protoreflect.EnumType {
+	return &file_raft_proto_enumTypes[0]
+}
+
+func (x LogType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use LogType.Descriptor instead.
+func (LogType) EnumDescriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{0}
+}
+
+// RPCHeader is sent with every request and response.
+type RPCHeader struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Version of the protocol the sender is speaking.
+	ProtocolVersion int64 `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	// Set of compression algorithms the sender can decode.
+	AcceptCompression uint32 `protobuf:"varint,2,opt,name=accept_compression,json=acceptCompression,proto3" json:"accept_compression,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *RPCHeader) Reset() {
+	*x = RPCHeader{}
+	mi := &file_raft_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RPCHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RPCHeader) ProtoMessage() {}
+
+func (x *RPCHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RPCHeader.ProtoReflect.Descriptor instead.
+func (*RPCHeader) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RPCHeader) GetProtocolVersion() int64 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *RPCHeader) GetAcceptCompression() uint32 {
+	if x != nil {
+		return x.AcceptCompression
+	}
+	return 0
+}
+
+// Log is an entry of the replicated log.
+type Log struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Index uint64                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Term  uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Type  LogType                `protobuf:"varint,3,opt,name=type,proto3,enum=raft.LogType" json:"type,omitempty"`
+	// Type-specific data of the entry.
+	Data []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	// Client ID and sequence number of a command or transaction.
+	ClientId uint64 `protobuf:"varint,5,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	SeqNo    uint64 `protobuf:"varint,6,opt,name=seq_no,json=seqNo,proto3" json:"seq_no,omitempty"`
+	// Keys used to check for commutativity.
+	Keys [][]byte `protobuf:"bytes,7,rep,name=keys,proto3" json:"keys,omitempty"`
+	// Identity of the client, empty if clients are not authenticated.
+	Identity string `protobuf:"bytes,8,opt,name=identity,proto3" json:"identity,omitempty"`
+	// Checksum of the other fields, empty if the entry has none.
+	Checksum      []byte `protobuf:"bytes,9,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Log) Reset() {
+	*x = Log{}
+	mi := &file_raft_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Log) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Log) ProtoMessage() {}
+
+func (x *Log) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Log.ProtoReflect.Descriptor instead.
+func (*Log) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Log) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *Log) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *Log) GetType() LogType {
+	if x != nil {
+		return x.Type
+	}
+	return LogType_LOG_TYPE_COMMAND
+}
+
+func (x *Log) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Log) GetClientId() uint64 {
+	if x != nil {
+		return x.ClientId
+	}
+	return 0
+}
+
+func (x *Log) GetSeqNo() uint64 {
+	if x != nil {
+		return x.SeqNo
+	}
+	return 0
+}
+
+func (x *Log) GetKeys() [][]byte {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+func (x *Log) GetIdentity() string {
+	if x != nil {
+		return x.Identity
+	}
+	return ""
+}
+
+func (x *Log) GetChecksum() []byte {
+	if x != nil {
+		return x.Checksum
+	}
+	return nil
+}
+
+// ClientSeqNo identifies a client operation.
+type ClientSeqNo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      uint64                 `protobuf:"varint,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	SeqNo         uint64                 `protobuf:"varint,2,opt,name=seq_no,json=seqNo,proto3" json:"seq_no,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientSeqNo) Reset() {
+	*x = ClientSeqNo{}
+	mi := &file_raft_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientSeqNo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientSeqNo) ProtoMessage() {}
+
+func (x *ClientSeqNo) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientSeqNo.ProtoReflect.Descriptor instead.
+func (*ClientSeqNo) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ClientSeqNo) GetClientId() uint64 {
+	if x != nil {
+		return x.ClientId
+	}
+	return 0
+}
+
+func (x *ClientSeqNo) GetSeqNo() uint64 {
+	if x != nil {
+		return x.SeqNo
+	}
+	return 0
+}
+
+type AppendEntriesRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Header            *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term              uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Leader            []byte                 `protobuf:"bytes,3,opt,name=leader,proto3" json:"leader,omitempty"`
+	PrevLogEntry      uint64                 `protobuf:"varint,4,opt,name=prev_log_entry,json=prevLogEntry,proto3" json:"prev_log_entry,omitempty"`
+	PrevLogTerm       uint64                 `protobuf:"varint,5,opt,name=prev_log_term,json=prevLogTerm,proto3" json:"prev_log_term,omitempty"`
+	Entries           []*Log                 `protobuf:"bytes,6,rep,name=entries,proto3" json:"entries,omitempty"`
+	LeaderCommitIndex uint64                 `protobuf:"varint,7,opt,name=leader_commit_index,json=leaderCommitIndex,proto3" json:"leader_commit_index,omitempty"`
+	// Client operations committed on the leader, sent with heartbeats.
+	CommittedOps  []*ClientSeqNo `protobuf:"bytes,8,rep,name=committed_ops,json=committedOps,proto3" json:"committed_ops,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendEntriesRequest) Reset() {
+	*x = AppendEntriesRequest{}
+	mi := &file_raft_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendEntriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendEntriesRequest) ProtoMessage() {}
+
+func (x *AppendEntriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendEntriesRequest.ProtoReflect.Descriptor instead.
+func (*AppendEntriesRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AppendEntriesRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *AppendEntriesRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *AppendEntriesRequest) GetLeader() []byte {
+	if x != nil {
+		return x.Leader
+	}
+	return nil
+}
+
+func (x *AppendEntriesRequest) GetPrevLogEntry() uint64 {
+	if x != nil {
+		return x.PrevLogEntry
+	}
+	return 0
+}
+
+func (x *AppendEntriesRequest) GetPrevLogTerm() uint64 {
+	if x != nil {
+		return x.PrevLogTerm
+	}
+	return 0
+}
+
+func (x *AppendEntriesRequest) GetEntries() []*Log {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *AppendEntriesRequest) GetLeaderCommitIndex() uint64 {
+	if x != nil {
+		return x.LeaderCommitIndex
+	}
+	return 0
+}
+
+func (x *AppendEntriesRequest) GetCommittedOps() []*ClientSeqNo {
+	if x != nil {
+		return x.CommittedOps
+	}
+	return nil
+}
+
+type AppendEntriesResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Header         *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term           uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	LastLog        uint64                 `protobuf:"varint,3,opt,name=last_log,json=lastLog,proto3" json:"last_log,omitempty"`
+	Success        bool                   `protobuf:"varint,4,opt,name=success,proto3" json:"success,omitempty"`
+	NoRetryBackoff bool                   `protobuf:"varint,5,opt,name=no_retry_backoff,json=noRetryBackoff,proto3" json:"no_retry_backoff,omitempty"`
+	WitnessTerm    uint64                 `protobuf:"varint,6,opt,name=witness_term,json=witnessTerm,proto3" json:"witness_term,omitempty"`
+	// Error returned by the server, empty if none. Responses are sent with
+	// the error so that both arrive, and gRPC status errors are reserved for
+	// transport failures.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendEntriesResponse) Reset() {
+	*x = AppendEntriesResponse{}
+	mi := &file_raft_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendEntriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendEntriesResponse) ProtoMessage() {}
+
+func (x *AppendEntriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendEntriesResponse.ProtoReflect.Descriptor instead.
+func (*AppendEntriesResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AppendEntriesResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *AppendEntriesResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *AppendEntriesResponse) GetLastLog() uint64 {
+	if x != nil {
+		return x.LastLog
+	}
+	return 0
+}
+
+func (x *AppendEntriesResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *AppendEntriesResponse) GetNoRetryBackoff() bool {
+	if x != nil {
+		return x.NoRetryBackoff
+	}
+	return false
+}
+
+func (x *AppendEntriesResponse) GetWitnessTerm() uint64 {
+	if x != nil {
+		return x.WitnessTerm
+	}
+	return 0
+}
+
+func (x *AppendEntriesResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RequestVoteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term          uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Candidate     []byte                 `protobuf:"bytes,3,opt,name=candidate,proto3" json:"candidate,omitempty"`
+	LastLogIndex  uint64                 `protobuf:"varint,4,opt,name=last_log_index,json=lastLogIndex,proto3" json:"last_log_index,omitempty"`
+	LastLogTerm   uint64                 `protobuf:"varint,5,opt,name=last_log_term,json=lastLogTerm,proto3" json:"last_log_term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestVoteRequest) Reset() {
+	*x = RequestVoteRequest{}
+	mi := &file_raft_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestVoteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestVoteRequest) ProtoMessage() {}
+
+func (x *RequestVoteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestVoteRequest.ProtoReflect.Descriptor instead.
+func (*RequestVoteRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RequestVoteRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *RequestVoteRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *RequestVoteRequest) GetCandidate() []byte {
+	if x != nil {
+		return x.Candidate
+	}
+	return nil
+}
+
+func (x *RequestVoteRequest) GetLastLogIndex() uint64 {
+	if x != nil {
+		return x.LastLogIndex
+	}
+	return 0
+}
+
+func (x *RequestVoteRequest) GetLastLogTerm() uint64 {
+	if x != nil {
+		return x.LastLogTerm
+	}
+	return 0
+}
+
+type RequestVoteResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Header  *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term    uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Peers   []byte                 `protobuf:"bytes,3,opt,name=peers,proto3" json:"peers,omitempty"`
+	Granted bool                   `protobuf:"varint,4,opt,name=granted,proto3" json:"granted,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestVoteResponse) Reset() {
+	*x = RequestVoteResponse{}
+	mi := &file_raft_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestVoteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestVoteResponse) ProtoMessage() {}
+
+func (x *RequestVoteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestVoteResponse.ProtoReflect.Descriptor instead.
+func (*RequestVoteResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RequestVoteResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *RequestVoteResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *RequestVoteResponse) GetPeers() []byte {
+	if x != nil {
+		return x.Peers
+	}
+	return nil
+}
+
+func (x *RequestVoteResponse) GetGranted() bool {
+	if x != nil {
+		return x.Granted
+	}
+	return false
+}
+
+func (x *RequestVoteResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type InstallSnapshotRequest struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Header             *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	SnapshotVersion    int64                  `protobuf:"varint,2,opt,name=snapshot_version,json=snapshotVersion,proto3" json:"snapshot_version,omitempty"`
+	Term               uint64                 `protobuf:"varint,3,opt,name=term,proto3" json:"term,omitempty"`
+	Leader             []byte                 `protobuf:"bytes,4,opt,name=leader,proto3" json:"leader,omitempty"`
+	LastLogIndex       uint64                 `protobuf:"varint,5,opt,name=last_log_index,json=lastLogIndex,proto3" json:"last_log_index,omitempty"`
+	LastLogTerm        uint64                 `protobuf:"varint,6,opt,name=last_log_term,json=lastLogTerm,proto3" json:"last_log_term,omitempty"`
+	Peers              []byte                 `protobuf:"bytes,7,opt,name=peers,proto3" json:"peers,omitempty"`
+	Configuration      []byte                 `protobuf:"bytes,8,opt,name=configuration,proto3" json:"configuration,omitempty"`
+	ConfigurationIndex uint64                 `protobuf:"varint,9,opt,name=configuration_index,json=configurationIndex,proto3" json:"configuration_index,omitempty"`
+	// Size of the whole snapshot.
+	Size             int64  `protobuf:"varint,10,opt,name=size,proto3" json:"size,omitempty"`
+	ClientIdentities []byte `protobuf:"bytes,11,opt,name=client_identities,json=clientIdentities,proto3" json:"client_identities,omitempty"`
+	// Size of the data sent with the request if the snapshot is sent in
+	// chunks, zero if the request carries the whole snapshot.
+	ChunkSize     int64  `protobuf:"varint,12,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	Offset        int64  `protobuf:"varint,13,opt,name=offset,proto3" json:"offset,omitempty"`
+	Checksum      []byte `protobuf:"bytes,14,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	BaseIndex     uint64 `protobuf:"varint,15,opt,name=base_index,json=baseIndex,proto3" json:"base_index,omitempty"`
+	BaseTerm      uint64 `protobuf:"varint,16,opt,name=base_term,json=baseTerm,proto3" json:"base_term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstallSnapshotRequest) Reset() {
+	*x = InstallSnapshotRequest{}
+	mi := &file_raft_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstallSnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstallSnapshotRequest) ProtoMessage() {}
+
+func (x *InstallSnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstallSnapshotRequest.ProtoReflect.Descriptor instead.
+func (*InstallSnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *InstallSnapshotRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetSnapshotVersion() int64 {
+	if x != nil {
+		return x.SnapshotVersion
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetLeader() []byte {
+	if x != nil {
+		return x.Leader
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetLastLogIndex() uint64 {
+	if x != nil {
+		return x.LastLogIndex
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetLastLogTerm() uint64 {
+	if x != nil {
+		return x.LastLogTerm
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetPeers() []byte {
+	if x != nil {
+		return x.Peers
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetConfiguration() []byte {
+	if x != nil {
+		return x.Configuration
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetConfigurationIndex() uint64 {
+	if x != nil {
+		return x.ConfigurationIndex
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetClientIdentities() []byte {
+	if x != nil {
+		return x.ClientIdentities
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetChecksum() []byte {
+	if x != nil {
+		return x.Checksum
+	}
+	return nil
+}
+
+func (x *InstallSnapshotRequest) GetBaseIndex() uint64 {
+	if x != nil {
+		return x.BaseIndex
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetBaseTerm() uint64 {
+	if x != nil {
+		return x.BaseTerm
+	}
+	return 0
+}
+
+// InstallSnapshotChunk is a message of the InstallSnapshot stream. The first
+// message carries only the request, the rest carry only snapshot data.
+type InstallSnapshotChunk struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Request       *InstallSnapshotRequest `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	Data          []byte                  `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstallSnapshotChunk) Reset() {
+	*x = InstallSnapshotChunk{}
+	mi := &file_raft_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstallSnapshotChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstallSnapshotChunk) ProtoMessage() {}
+
+func (x *InstallSnapshotChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstallSnapshotChunk.ProtoReflect.Descriptor instead.
+func (*InstallSnapshotChunk) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *InstallSnapshotChunk) GetRequest() *InstallSnapshotRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *InstallSnapshotChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// SnapshotPosition is the last index and term included in a snapshot.
+type SnapshotPosition struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint64                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Term          uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotPosition) Reset() {
+	*x = SnapshotPosition{}
+	mi := &file_raft_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotPosition) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotPosition) ProtoMessage() {}
+
+func (x *SnapshotPosition) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotPosition.ProtoReflect.Descriptor instead.
+func (*SnapshotPosition) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SnapshotPosition) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *SnapshotPosition) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+type InstallSnapshotResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Header      *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term        uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	Success     bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	NextOffset  int64                  `protobuf:"varint,4,opt,name=next_offset,json=nextOffset,proto3" json:"next_offset,omitempty"`
+	BaseMissing bool                   `protobuf:"varint,5,opt,name=base_missing,json=baseMissing,proto3" json:"base_missing,omitempty"`
+	Snapshots   []*SnapshotPosition    `protobuf:"bytes,6,rep,name=snapshots,proto3" json:"snapshots,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstallSnapshotResponse) Reset() {
+	*x = InstallSnapshotResponse{}
+	mi := &file_raft_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstallSnapshotResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstallSnapshotResponse) ProtoMessage() {}
+
+func (x *InstallSnapshotResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstallSnapshotResponse.ProtoReflect.Descriptor instead.
+func (*InstallSnapshotResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *InstallSnapshotResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *InstallSnapshotResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *InstallSnapshotResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *InstallSnapshotResponse) GetNextOffset() int64 {
+	if x != nil {
+		return x.NextOffset
+	}
+	return 0
+}
+
+func (x *InstallSnapshotResponse) GetBaseMissing() bool {
+	if x != nil {
+		return x.BaseMissing
+	}
+	return false
+}
+
+func (x *InstallSnapshotResponse) GetSnapshots() []*SnapshotPosition {
+	if x != nil {
+		return x.Snapshots
+	}
+	return nil
+}
+
+func (x *InstallSnapshotResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RecoveryDataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecoveryDataRequest) Reset() {
+	*x = RecoveryDataRequest{}
+	mi := &file_raft_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecoveryDataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecoveryDataRequest) ProtoMessage() {}
+
+func (x *RecoveryDataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecoveryDataRequest.ProtoReflect.Descriptor instead.
+func (*RecoveryDataRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RecoveryDataRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+type RecoveryDataResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Header  *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Entries []*Log                 `protobuf:"bytes,2,rep,name=entries,proto3" json:"entries,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecoveryDataResponse) Reset() {
+	*x = RecoveryDataResponse{}
+	mi := &file_raft_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecoveryDataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecoveryDataResponse) ProtoMessage() {}
+
+func (x *RecoveryDataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecoveryDataResponse.ProtoReflect.Descriptor instead.
+func (*RecoveryDataResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *RecoveryDataResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *RecoveryDataResponse) GetEntries() []*Log {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *RecoveryDataResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type UnfreezeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Term          uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnfreezeRequest) Reset() {
+	*x = UnfreezeRequest{}
+	mi := &file_raft_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnfreezeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnfreezeRequest) ProtoMessage() {}
+
+func (x *UnfreezeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnfreezeRequest.ProtoReflect.Descriptor instead.
+func (*UnfreezeRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *UnfreezeRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *UnfreezeRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+type UnfreezeResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Header *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnfreezeResponse) Reset() {
+	*x = UnfreezeResponse{}
+	mi := &file_raft_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnfreezeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnfreezeResponse) ProtoMessage() {}
+
+func (x *UnfreezeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnfreezeResponse.ProtoReflect.Descriptor instead.
+func (*UnfreezeResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *UnfreezeResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *UnfreezeResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ClientIdRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Credentials   []byte                 `protobuf:"bytes,2,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientIdRequest) Reset() {
+	*x = ClientIdRequest{}
+	mi := &file_raft_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientIdRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientIdRequest) ProtoMessage() {}
+
+func (x *ClientIdRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientIdRequest.ProtoReflect.Descriptor instead.
+func (*ClientIdRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ClientIdRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ClientIdRequest) GetCredentials() []byte {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+type ClientIdResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	ClientId      uint64                 `protobuf:"varint,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,3,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	Term          uint64                 `protobuf:"varint,4,opt,name=term,proto3" json:"term,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientIdResponse) Reset() {
+	*x = ClientIdResponse{}
+	mi := &file_raft_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientIdResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientIdResponse) ProtoMessage() {}
+
+func (x *ClientIdResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientIdResponse.ProtoReflect.Descriptor instead.
+func (*ClientIdResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ClientIdResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ClientIdResponse) GetClientId() uint64 {
+	if x != nil {
+		return x.ClientId
+	}
+	return 0
+}
+
+func (x *ClientIdResponse) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+func (x *ClientIdResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *ClientIdResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ClientRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Entry         *Log                   `protobuf:"bytes,2,opt,name=entry,proto3" json:"entry,omitempty"`
+	Credentials   []byte                 `protobuf:"bytes,3,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientRequest) Reset() {
+	*x = ClientRequest{}
+	mi := &file_raft_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientRequest) ProtoMessage() {}
+
+func (x *ClientRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientRequest.ProtoReflect.Descriptor instead.
+func (*ClientRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ClientRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ClientRequest) GetEntry() *Log {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *ClientRequest) GetCredentials() []byte {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+type ClientResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,3,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	ResponseData  []byte                 `protobuf:"bytes,4,opt,name=response_data,json=responseData,proto3" json:"response_data,omitempty"`
+	Synced        bool                   `protobuf:"varint,5,opt,name=synced,proto3" json:"synced,omitempty"`
+	Term          uint64                 `protobuf:"varint,6,opt,name=term,proto3" json:"term,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientResponse) Reset() {
+	*x = ClientResponse{}
+	mi := &file_raft_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientResponse) ProtoMessage() {}
+
+func (x *ClientResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientResponse.ProtoReflect.Descriptor instead.
+func (*ClientResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ClientResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ClientResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ClientResponse) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+func (x *ClientResponse) GetResponseData() []byte {
+	if x != nil {
+		return x.ResponseData
+	}
+	return nil
+}
+
+func (x *ClientResponse) GetSynced() bool {
+	if x != nil {
+		return x.Synced
+	}
+	return false
+}
+
+func (x *ClientResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *ClientResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RecordRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Entry         *Log                   `protobuf:"bytes,2,opt,name=entry,proto3" json:"entry,omitempty"`
+	Credentials   []byte                 `protobuf:"bytes,3,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	Term          uint64                 `protobuf:"varint,4,opt,name=term,proto3" json:"term,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,5,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordRequest) Reset() {
+	*x = RecordRequest{}
+	mi := &file_raft_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordRequest) ProtoMessage() {}
+
+func (x *RecordRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordRequest.ProtoReflect.Descriptor instead.
+func (*RecordRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *RecordRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *RecordRequest) GetEntry() *Log {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *RecordRequest) GetCredentials() []byte {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+func (x *RecordRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *RecordRequest) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+type RecordResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Term          uint64                 `protobuf:"varint,3,opt,name=term,proto3" json:"term,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,4,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordResponse) Reset() {
+	*x = RecordResponse{}
+	mi := &file_raft_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordResponse) ProtoMessage() {}
+
+func (x *RecordResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordResponse.ProtoReflect.Descriptor instead.
+func (*RecordResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *RecordResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *RecordResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *RecordResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *RecordResponse) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+func (x *RecordResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type SyncRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Entry         *Log                   `protobuf:"bytes,2,opt,name=entry,proto3" json:"entry,omitempty"`
+	Credentials   []byte                 `protobuf:"bytes,3,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncRequest) Reset() {
+	*x = SyncRequest{}
+	mi := &file_raft_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncRequest) ProtoMessage() {}
+
+func (x *SyncRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncRequest.ProtoReflect.Descriptor instead.
+func (*SyncRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *SyncRequest) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *SyncRequest) GetEntry() *Log {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *SyncRequest) GetCredentials() []byte {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+type SyncResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        *RPCHeader             `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,3,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	ResponseData  []byte                 `protobuf:"bytes,4,opt,name=response_data,json=responseData,proto3" json:"response_data,omitempty"`
+	// Error returned by the server, empty if none.
+	Error         string `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncResponse) Reset() {
+	*x = SyncResponse{}
+	mi := &file_raft_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncResponse) ProtoMessage() {}
+
+func (x *SyncResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncResponse.ProtoReflect.Descriptor instead.
+func (*SyncResponse) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *SyncResponse) GetHeader() *RPCHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *SyncResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *SyncResponse) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+func (x *SyncResponse) GetResponseData() []byte {
+	if x != nil {
+		return x.ResponseData
+	}
+	return nil
+}
+
+func (x *SyncResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_raft_proto protoreflect.FileDescriptor
+
+const file_raft_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"raft.proto\x12\x04raft\"e\n" +
+	"\tRPCHeader\x12)\n" +
+	"\x10protocol_version\x18\x01 \x01(\x03R\x0fprotocolVersion\x12-\n" +
+	"\x12accept_compression\x18\x02 \x01(\rR\x11acceptCompression\"\xe6\x01\n" +
+	"\x03Log\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12!\n" +
+	"\x04type\x18\x03 \x01(\x0e2\r.raft.LogTypeR\x04type\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\fR\x04data\x12\x1b\n" +
+	"\tclient_id\x18\x05 \x01(\x04R\bclientId\x12\x15\n" +
+	"\x06seq_no\x18\x06 \x01(\x04R\x05seqNo\x12\x12\n" +
+	"\x04keys\x18\a \x03(\fR\x04keys\x12\x1a\n" +
+	"\bidentity\x18\b \x01(\tR\bidentity\x12\x1a\n" +
+	"\bchecksum\x18\t \x01(\fR\bchecksum\"A\n" +
+	"\vClientSeqNo\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\x04R\bclientId\x12\x15\n" +
+	"\x06seq_no\x18\x02 \x01(\x04R\x05seqNo\"\xc2\x02\n" +
+	"\x14AppendEntriesRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12\x16\n" +
+	"\x06leader\x18\x03 \x01(\fR\x06leader\x12$\n" +
+	"\x0eprev_log_entry\x18\x04 \x01(\x04R\fprevLogEntry\x12\"\n" +
+	"\rprev_log_term\x18\x05 \x01(\x04R\vprevLogTerm\x12#\n" +
+	"\aentries\x18\x06 \x03(\v2\t.raft.LogR\aentries\x12.\n" +
+	"\x13leader_commit_index\x18\a \x01(\x04R\x11leaderCommitIndex\x126\n" +
+	"\rcommitted_ops\x18\b \x03(\v2\x11.raft.ClientSeqNoR\fcommittedOps\"\xec\x01\n" +
+	"\x15AppendEntriesResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12\x19\n" +
+	"\blast_log\x18\x03 \x01(\x04R\alastLog\x12\x18\n" +
+	"\asuccess\x18\x04 \x01(\bR\asuccess\x12(\n" +
+	"\x10no_retry_backoff\x18\x05 \x01(\bR\x0enoRetryBackoff\x12!\n" +
+	"\fwitness_term\x18\x06 \x01(\x04R\vwitnessTerm\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"\xb9\x01\n" +
+	"\x12RequestVoteRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12\x1c\n" +
+	"\tcandidate\x18\x03 \x01(\fR\tcandidate\x12$\n" +
+	"\x0elast_log_index\x18\x04 \x01(\x04R\flastLogIndex\x12\"\n" +
+	"\rlast_log_term\x18\x05 \x01(\x04R\vlastLogTerm\"\x98\x01\n" +
+	"\x13RequestVoteResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12\x14\n" +
+	"\x05peers\x18\x03 \x01(\fR\x05peers\x12\x18\n" +
+	"\agranted\x18\x04 \x01(\bR\agranted\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"\x9f\x04\n" +
+	"\x16InstallSnapshotRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12)\n" +
+	"\x10snapshot_version\x18\x02 \x01(\x03R\x0fsnapshotVersion\x12\x12\n" +
+	"\x04term\x18\x03 \x01(\x04R\x04term\x12\x16\n" +
+	"\x06leader\x18\x04 \x01(\fR\x06leader\x12$\n" +
+	"\x0elast_log_index\x18\x05 \x01(\x04R\flastLogIndex\x12\"\n" +
+	"\rlast_log_term\x18\x06 \x01(\x04R\vlastLogTerm\x12\x14\n" +
+	"\x05peers\x18\a \x01(\fR\x05peers\x12$\n" +
+	"\rconfiguration\x18\b \x01(\fR\rconfiguration\x12/\n" +
+	"\x13configuration_index\x18\t \x01(\x04R\x12configurationIndex\x12\x12\n" +
+	"\x04size\x18\n" +
+	" \x01(\x03R\x04size\x12+\n" +
+	"\x11client_identities\x18\v \x01(\fR\x10clientIdentities\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\f \x01(\x03R\tchunkSize\x12\x16\n" +
+	"\x06offset\x18\r \x01(\x03R\x06offset\x12\x1a\n" +
+	"\bchecksum\x18\x0e \x01(\fR\bchecksum\x12\x1d\n" +
+	"\n" +
+	"base_index\x18\x0f \x01(\x04R\tbaseIndex\x12\x1b\n" +
+	"\tbase_term\x18\x10 \x01(\x04R\bbaseTerm\"b\n" +
+	"\x14InstallSnapshotChunk\x126\n" +
+	"\arequest\x18\x01 \x01(\v2\x1c.raft.InstallSnapshotRequestR\arequest\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"<\n" +
+	"\x10SnapshotPosition\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\"\x80\x02\n" +
+	"\x17InstallSnapshotResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\x12\x18\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x1f\n" +
+	"\vnext_offset\x18\x04 \x01(\x03R\n" +
+	"nextOffset\x12!\n" +
+	"\fbase_missing\x18\x05 \x01(\bR\vbaseMissing\x124\n" +
+	"\tsnapshots\x18\x06 \x03(\v2\x16.raft.SnapshotPositionR\tsnapshots\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\">\n" +
+	"\x13RecoveryDataRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\"z\n" +
+	"\x14RecoveryDataResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12#\n" +
+	"\aentries\x18\x02 \x03(\v2\t.raft.LogR\aentries\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"N\n" +
+	"\x0fUnfreezeRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\"Q\n" +
+	"\x10UnfreezeResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"\\\n" +
+	"\x0fClientIdRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12 \n" +
+	"\vcredentials\x18\x02 \x01(\fR\vcredentials\"\xa9\x01\n" +
+	"\x10ClientIdResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x1b\n" +
+	"\tclient_id\x18\x02 \x01(\x04R\bclientId\x12%\n" +
+	"\x0eleader_address\x18\x03 \x01(\tR\rleaderAddress\x12\x12\n" +
+	"\x04term\x18\x04 \x01(\x04R\x04term\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"{\n" +
+	"\rClientRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x1f\n" +
+	"\x05entry\x18\x02 \x01(\v2\t.raft.LogR\x05entry\x12 \n" +
+	"\vcredentials\x18\x03 \x01(\fR\vcredentials\"\xe1\x01\n" +
+	"\x0eClientResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12%\n" +
+	"\x0eleader_address\x18\x03 \x01(\tR\rleaderAddress\x12#\n" +
+	"\rresponse_data\x18\x04 \x01(\fR\fresponseData\x12\x16\n" +
+	"\x06synced\x18\x05 \x01(\bR\x06synced\x12\x12\n" +
+	"\x04term\x18\x06 \x01(\x04R\x04term\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"\xb6\x01\n" +
+	"\rRecordRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x1f\n" +
+	"\x05entry\x18\x02 \x01(\v2\t.raft.LogR\x05entry\x12 \n" +
+	"\vcredentials\x18\x03 \x01(\fR\vcredentials\x12\x12\n" +
+	"\x04term\x18\x04 \x01(\x04R\x04term\x12%\n" +
+	"\x0eleader_address\x18\x05 \x01(\tR\rleaderAddress\"\xa4\x01\n" +
+	"\x0eRecordResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x12\n" +
+	"\x04term\x18\x03 \x01(\x04R\x04term\x12%\n" +
+	"\x0eleader_address\x18\x04 \x01(\tR\rleaderAddress\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\"y\n" +
+	"\vSyncRequest\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x1f\n" +
+	"\x05entry\x18\x02 \x01(\v2\t.raft.LogR\x05entry\x12 \n" +
+	"\vcredentials\x18\x03 \x01(\fR\vcredentials\"\xb3\x01\n" +
+	"\fSyncResponse\x12'\n" +
+	"\x06header\x18\x01 \x01(\v2\x0f.raft.RPCHeaderR\x06header\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12%\n" +
+	"\x0eleader_address\x18\x03 \x01(\tR\rleaderAddress\x12#\n" +
+	"\rresponse_data\x18\x04 \x01(\fR\fresponseData\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error*\x80\x02\n" +
+	"\aLogType\x12\x14\n" +
+	"\x10LOG_TYPE_COMMAND\x10\x00\x12\x11\n" +
+	"\rLOG_TYPE_NOOP\x10\x01\x12 \n" +
+	"\x1cLOG_TYPE_ADD_PEER_DEPRECATED\x10\x02\x12#\n" +
+	"\x1fLOG_TYPE_REMOVE_PEER_DEPRECATED\x10\x03\x12\x14\n" +
+	"\x10LOG_TYPE_BARRIER\x10\x04\x12\x1a\n" +
+	"\x16LOG_TYPE_CONFIGURATION\x10\x05\x12\x1b\n" +
+	"\x17LOG_TYPE_NEXT_CLIENT_ID\x10\x06\x12\x18\n" +
+	"\x14LOG_TYPE_TRANSACTION\x10\a\x12\x1c\n" +
+	"\x18LOG_TYPE_CLIENT_IDENTITY\x10\b2\x96\x05\n" +
+	"\x04Raft\x12H\n" +
+	"\rAppendEntries\x12\x1a.raft.AppendEntriesRequest\x1a\x1b.raft.AppendEntriesResponse\x12T\n" +
+	"\x15AppendEntriesPipeline\x12\x1a.raft.AppendEntriesRequest\x1a\x1b.raft.AppendEntriesResponse(\x010\x01\x12B\n" +
+	"\vRequestVote\x12\x18.raft.RequestVoteRequest\x1a\x19.raft.RequestVoteResponse\x12N\n" +
+	"\x0fInstallSnapshot\x12\x1a.raft.InstallSnapshotChunk\x1a\x1d.raft.InstallSnapshotResponse(\x01\x12D\n" +
+	"\vRecoverData\x12\x19.raft.RecoveryDataRequest\x1a\x1a.raft.RecoveryDataResponse\x12@\n" +
+	"\x0fUnfreezeWitness\x12\x15.raft.UnfreezeRequest\x1a\x16.raft.UnfreezeResponse\x129\n" +
+	"\bClientId\x12\x15.raft.ClientIdRequest\x1a\x16.raft.ClientIdResponse\x123\n" +
+	"\x06Client\x12\x13.raft.ClientRequest\x1a\x14.raft.ClientResponse\x123\n" +
+	"\x06Record\x12\x13.raft.RecordRequest\x1a\x14.raft.RecordResponse\x12-\n" +
+	"\x04Sync\x12\x11.raft.SyncRequest\x1a\x12.raft.SyncResponseB\"Z github.com/hashicorp/raft/raftpbb\x06proto3"
+
+var (
+	file_raft_proto_rawDescOnce sync.Once
+	file_raft_proto_rawDescData []byte
+)
+
+func file_raft_proto_rawDescGZIP() []byte {
+	file_raft_proto_rawDescOnce.Do(func() {
+		file_raft_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_raft_proto_rawDesc), len(file_raft_proto_rawDesc)))
+	})
+	return file_raft_proto_rawDescData
+}
+
+var file_raft_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
+var file_raft_proto_goTypes = []any{
+	(LogType)(0),                    // 0: raft.LogType
+	(*RPCHeader)(nil),               // 1: raft.RPCHeader
+	(*Log)(nil),                     // 2: raft.Log
+	(*ClientSeqNo)(nil),             // 3: raft.ClientSeqNo
+	(*AppendEntriesRequest)(nil),    // 4: raft.AppendEntriesRequest
+	(*AppendEntriesResponse)(nil),   // 5: raft.AppendEntriesResponse
+	(*RequestVoteRequest)(nil),      // 6: raft.RequestVoteRequest
+	(*RequestVoteResponse)(nil),     // 7: raft.RequestVoteResponse
+	(*InstallSnapshotRequest)(nil),  // 8: raft.InstallSnapshotRequest
+	(*InstallSnapshotChunk)(nil),    // 9: raft.InstallSnapshotChunk
+	(*SnapshotPosition)(nil),        // 10: raft.SnapshotPosition
+	(*InstallSnapshotResponse)(nil), // 11: raft.InstallSnapshotResponse
+	(*RecoveryDataRequest)(nil),     // 12: raft.RecoveryDataRequest
+	(*RecoveryDataResponse)(nil),    // 13: raft.RecoveryDataResponse
+	(*UnfreezeRequest)(nil),         // 14: raft.UnfreezeRequest
+	(*UnfreezeResponse)(nil),        // 15: raft.UnfreezeResponse
+	(*ClientIdRequest)(nil),         // 16: raft.ClientIdRequest
+	(*ClientIdResponse)(nil),        // 17: raft.ClientIdResponse
+	(*ClientRequest)(nil),           // 18: raft.ClientRequest
+	(*ClientResponse)(nil),          // 19: raft.ClientResponse
+	(*RecordRequest)(nil),           // 20: raft.RecordRequest
+	(*RecordResponse)(nil),          // 21: raft.RecordResponse
+	(*SyncRequest)(nil),             // 22: raft.SyncRequest
+	(*SyncResponse)(nil),            // 23: raft.SyncResponse
+}
+var file_raft_proto_depIdxs = []int32{
+	0,  // 0: raft.Log.type:type_name -> raft.LogType
+	1,  // 1: raft.AppendEntriesRequest.header:type_name -> raft.RPCHeader
+	2,  // 2: raft.AppendEntriesRequest.entries:type_name -> raft.Log
+	3,  // 3: raft.AppendEntriesRequest.committed_ops:type_name -> raft.ClientSeqNo
+	1,  // 4: raft.AppendEntriesResponse.header:type_name -> raft.RPCHeader
+	1,  // 5: raft.RequestVoteRequest.header:type_name -> raft.RPCHeader
+	1,  // 6: raft.RequestVoteResponse.header:type_name -> raft.RPCHeader
+	1,  // 7: raft.InstallSnapshotRequest.header:type_name -> raft.RPCHeader
+	8,  // 8: raft.InstallSnapshotChunk.request:type_name -> raft.InstallSnapshotRequest
+	1,  // 9: raft.InstallSnapshotResponse.header:type_name -> raft.RPCHeader
+	10, // 10: raft.InstallSnapshotResponse.snapshots:type_name -> raft.SnapshotPosition
+	1,  // 11: raft.RecoveryDataRequest.header:type_name -> raft.RPCHeader
+	1,  // 12: raft.RecoveryDataResponse.header:type_name -> raft.RPCHeader
+	2,  // 13: raft.RecoveryDataResponse.entries:type_name -> raft.Log
+	1,  // 14: raft.UnfreezeRequest.header:type_name -> raft.RPCHeader
+	1,  // 15: raft.UnfreezeResponse.header:type_name -> raft.RPCHeader
+	1,  // 16: raft.ClientIdRequest.header:type_name -> raft.RPCHeader
+	1,  // 17: raft.ClientIdResponse.header:type_name -> raft.RPCHeader
+	1,  // 18: raft.ClientRequest.header:type_name -> raft.RPCHeader
+	2,  // 19: raft.ClientRequest.entry:type_name -> raft.Log
+	1,  // 20: raft.ClientResponse.header:type_name -> raft.RPCHeader
+	1,  // 21: raft.RecordRequest.header:type_name -> raft.RPCHeader
+	2,  // 22: raft.RecordRequest.entry:type_name -> raft.Log
+	1,  // 23: raft.RecordResponse.header:type_name -> raft.RPCHeader
+	1,  // 24: raft.SyncRequest.header:type_name -> raft.RPCHeader
+	2,  // 25: raft.SyncRequest.entry:type_name -> raft.Log
+	1,  // 26: raft.SyncResponse.header:type_name -> raft.RPCHeader
+	4,  // 27: raft.Raft.AppendEntries:input_type -> raft.AppendEntriesRequest
+	4,  // 28: raft.Raft.AppendEntriesPipeline:input_type -> raft.AppendEntriesRequest
+	6,  // 29: raft.Raft.RequestVote:input_type -> raft.RequestVoteRequest
+	9,  // 30: raft.Raft.InstallSnapshot:input_type -> raft.InstallSnapshotChunk
+	12, // 31: raft.Raft.RecoverData:input_type -> raft.RecoveryDataRequest
+	14, // 32: raft.Raft.UnfreezeWitness:input_type -> raft.UnfreezeRequest
+	16, // 33: raft.Raft.ClientId:input_type -> raft.ClientIdRequest
+	18, // 34: raft.Raft.Client:input_type -> raft.ClientRequest
+	20, // 35: raft.Raft.Record:input_type -> raft.RecordRequest
+	22, // 36: raft.Raft.Sync:input_type -> raft.SyncRequest
+	5,  // 37: raft.Raft.AppendEntries:output_type -> raft.AppendEntriesResponse
+	5,  // 38: raft.Raft.AppendEntriesPipeline:output_type -> raft.AppendEntriesResponse
+	7,  // 39: raft.Raft.RequestVote:output_type -> raft.RequestVoteResponse
+	11, // 40: raft.Raft.InstallSnapshot:output_type -> raft.InstallSnapshotResponse
+	13, // 41: raft.Raft.RecoverData:output_type -> raft.RecoveryDataResponse
+	15, // 42: raft.Raft.UnfreezeWitness:output_type -> raft.UnfreezeResponse
+	17, // 43: raft.Raft.ClientId:output_type -> raft.ClientIdResponse
+	19, // 44: raft.Raft.Client:output_type -> raft.ClientResponse
+	21, // 45: raft.Raft.Record:output_type -> raft.RecordResponse
+	23, // 46: raft.Raft.Sync:output_type -> raft.SyncResponse
+	37, // [37:47] is the sub-list for method output_type
+	27, // [27:37] is the sub-list for method input_type
+	27, // [27:27] is the sub-list for extension type_name
+	27, // [27:27] is the sub-list for extension extendee
+	0,  // [0:27] is the sub-list for field type_name
+}
+
+func init() { file_raft_proto_init() }
+func file_raft_proto_init() {
+	if File_raft_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_raft_proto_rawDesc), len(file_raft_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   23,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_raft_proto_goTypes,
+		DependencyIndexes: file_raft_proto_depIdxs,
+		EnumInfos:         file_raft_proto_enumTypes,
+		MessageInfos:      file_raft_proto_msgTypes,
+	}.Build()
+	File_raft_proto = out.File
+	file_raft_proto_goTypes = nil
+	file_raft_proto_depIdxs = nil
+}
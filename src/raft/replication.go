@@ -1,8 +1,11 @@
 package raft
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"sync"
 	"time"
 
@@ -77,6 +80,21 @@ type followerReplication struct {
 	// allowPipeline is used to determine when to pipeline the AppendEntries RPCs.
 	// It is private to this replication goroutine.
 	allowPipeline bool
+
+	// witnessesReady is closed once this leader has recovered from witnesses.
+	witnessesReady chan struct{}
+	// committedOps holds operations committed by this leader, sent on
+	// heartbeats for witness garbage collection.
+	committedOps *committedOps
+	// initializingWitness is 1 while the follower is being initialized as a
+	// witness for this term, 0 otherwise. Accessed atomically.
+	initializingWitness int32
+
+	// snapshotID and snapshotOffset are the snapshot last sent to the
+	// follower and the offset the follower expects next, so that an
+	// interrupted transfer of the same snapshot resumes there.
+	snapshotID     string
+	snapshotOffset int64
 }
 
 // notifyAll is used to notify all the waiting verify futures
@@ -128,11 +146,9 @@ RPC:
 			}
 			return
 		case <-s.triggerCh:
-			lastLogIdx, _ := r.getLastLog()
-			shouldStop = r.replicateTo(s, lastLogIdx)
+			shouldStop = r.replicateTo(s, r.getReplicationIndex())
 		case <-randomTimeout(r.conf.CommitTimeout): // TODO: what is this?
-			lastLogIdx, _ := r.getLastLog()
-			shouldStop = r.replicateTo(s, lastLogIdx)
+			shouldStop = r.replicateTo(s, r.getReplicationIndex())
 		}
 
 		// If things looks healthy, switch to pipeline mode
@@ -250,7 +266,9 @@ SEND_SNAP:
 }
 
 // sendLatestSnapshot is used to send the latest snapshot we have
-// down to our follower.
+// down to our follower. If it is a delta, it is sent first on its own, and
+// if the follower does not have its base, the snapshots of its chain after
+// the newest one the follower has are sent in turn.
 func (r *Raft) sendLatestSnapshot(s *followerReplication) (bool, error) {
 	// Get the snapshots
 	snapshots, err := r.snapshots.List()
@@ -264,12 +282,75 @@ func (r *Raft) sendLatestSnapshot(s *followerReplication) (bool, error) {
 		return false, fmt.Errorf("no snapshots found")
 	}
 
-	// Open the most recent snapshot
-	snapID := snapshots[0].ID
+	// Get the chain of the most recent snapshot
+	chain, err := r.snapshotChain(snapshots[0])
+	if err != nil {
+		r.logger.Printf("[ERR] raft: Failed to get chain of snapshot %v: %v", snapshots[0].ID, err)
+		return false, err
+	}
+
+	// Send a follower with an empty log the full snapshot, and others the
+	// latest delta, which falls back to the deltas they are missing. Resume
+	// an interrupted transfer from the snapshot it was sending.
+	start := len(chain) - 1
+	if s.nextIndex <= 1 {
+		start = 0
+	}
+	for i, link := range chain {
+		if link.ID == s.snapshotID {
+			start = i
+		}
+	}
+	var meta *SnapshotMeta
+	for i := start; i < len(chain); i++ {
+		var sent, stop bool
+		meta, sent, stop, err = r.sendSnapshot(s, chain, i)
+		if !sent || stop || err != nil {
+			return stop, err
+		}
+		if meta == chain[len(chain)-1] {
+			// The follower already has the latest snapshot
+			break
+		}
+		if i+1 < len(chain) {
+			s.snapshotID, s.snapshotOffset = chain[i+1].ID, 0
+		}
+	}
+
+	// Update the indexes
+	s.snapshotID, s.snapshotOffset = "", 0
+	s.nextIndex = meta.Index + 1
+	s.commitment.match(s.peer.ID, meta.Index)
+
+	// Clear any failures
+	s.failures = 0
+
+	// Notify we are still leader
+	s.notifyAll(true)
+	return false, nil
+}
+
+// sendSnapshot sends a snapshot of a chain to a follower, in chunks if
+// configured, resuming an interrupted transfer of the same snapshot.
+// Params:
+//   - s: replication state of the follower.
+//   - chain: chain of the snapshot, starting from the full snapshot.
+//   - i: index in chain of the snapshot.
+// Returns: the metadata of the snapshot, or of the latest snapshot if the
+// follower already has it, whether the follower received all of it, whether
+// to stop replication, and an error.
+func (r *Raft) sendSnapshot(s *followerReplication, chain []*SnapshotMeta, i int) (*SnapshotMeta, bool, bool, error) {
+	snapID := chain[i].ID
+	var base *SnapshotMeta
+	if i > 0 {
+		base = chain[i-1]
+	}
+
+	// Open the snapshot
 	meta, snapshot, err := r.snapshots.Open(snapID)
 	if err != nil {
 		r.logger.Printf("[ERR] raft: Failed to open snapshot %v: %v", snapID, err)
-		return false, err
+		return nil, false, false, err
 	}
 	defer snapshot.Close()
 
@@ -282,46 +363,120 @@ func (r *Raft) sendLatestSnapshot(s *followerReplication) (bool, error) {
 		LastLogIndex:       meta.Index,
 		LastLogTerm:        meta.Term,
 		Peers:              meta.Peers,
-		Size:               meta.Size,
 		Configuration:      encodeConfiguration(meta.Configuration),
 		ConfigurationIndex: meta.ConfigurationIndex,
+		Size:               meta.Size,
 	}
-
-	// Make the call
-	start := time.Now()
-	var resp InstallSnapshotResponse
-	if err := r.trans.InstallSnapshot(s.peer.ID, s.peer.Address, &req, &resp, snapshot); err != nil {
-		r.logger.Printf("[ERR] raft: Failed to install snapshot %v: %v", snapID, err)
-		s.failures++
-		return false, err
+	if base != nil {
+		req.BaseIndex, req.BaseTerm = base.Index, base.Term
+	}
+	chunked := r.conf.SnapshotChunkSize > 0
+	chunkSize := int64(r.conf.SnapshotChunkSize)
+	if !chunked {
+		chunkSize = meta.Size
 	}
-	metrics.MeasureSince([]string{"raft", "replication", "installSnapshot", string(s.peer.ID)}, start)
 
-	// Check for a newer term, stop running
-	if resp.Term > req.Term {
-		r.handleStaleTerm(s)
-		return true, nil
+	// Resume an interrupted transfer of the same snapshot
+	var offset int64
+	if s.snapshotID == snapID && s.snapshotOffset <= meta.Size {
+		offset = s.snapshotOffset
+	}
+	s.snapshotID, s.snapshotOffset = snapID, offset
+	if offset > 0 {
+		r.logger.Printf("[INFO] raft: Resuming snapshot %v to %v at offset %d / %d",
+			snapID, s.peer, offset, meta.Size)
+		if _, err := io.CopyN(ioutil.Discard, snapshot, offset); err != nil {
+			r.logger.Printf("[ERR] raft: Failed to read snapshot %v: %v", snapID, err)
+			return nil, false, false, err
+		}
 	}
 
-	// Update the last contact
-	s.setLastContact()
+	// Send the chunks
+	start := time.Now()
+	throttle := newSnapshotThrottle(r.conf.SnapshotBandwidth)
+	var buf []byte
+	for {
+		n := chunkSize
+		if remaining := meta.Size - offset; remaining < n {
+			n = remaining
+		}
+		req.Offset = offset
+		var data io.Reader = io.LimitReader(snapshot, n)
+		if chunked {
+			req.ChunkSize = n
+			if int64(len(buf)) < n {
+				buf = make([]byte, n)
+			}
+			if _, err := io.ReadFull(snapshot, buf[:n]); err != nil {
+				r.logger.Printf("[ERR] raft: Failed to read snapshot %v: %v", snapID, err)
+				return nil, false, false, err
+			}
+			req.Checksum = chunkChecksum(buf[:n])
+			data = bytes.NewReader(buf[:n])
+		}
+		req.ClientIdentities = nil
+		if offset+n == meta.Size {
+			req.ClientIdentities = r.encodeClientIdentities()
+		}
 
-	// Check for success
-	if resp.Success {
-		// Update the indexes
-		s.nextIndex = meta.Index + 1
-		s.commitment.match(s.peer.ID, meta.Index)
+		// Respect the bandwidth limit
+		if wait := throttle.delay(n, time.Now()); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.stopCh:
+				return nil, false, true, nil
+			}
+		}
 
-		// Clear any failures
-		s.failures = 0
+		// Make the call
+		var resp InstallSnapshotResponse
+		if err := r.trans.InstallSnapshot(s.peer.ID, s.peer.Address, &req, &resp, data); err != nil {
+			r.logger.Printf("[ERR] raft: Failed to install snapshot %v at offset %d: %v", snapID, offset, err)
+			s.failures++
+			return nil, false, false, err
+		}
 
-		// Notify we are still leader
-		s.notifyAll(true)
-	} else {
-		s.failures++
-		r.logger.Printf("[WARN] raft: InstallSnapshot to %v rejected", s.peer)
+		// Check for a newer term, stop running
+		if resp.Term > req.Term {
+			r.handleStaleTerm(s)
+			return nil, false, true, nil
+		}
+
+		// Update the last contact
+		s.setLastContact()
+
+		// Send the chain from the newest snapshot the follower has if it
+		// does not have the base of this delta, or nothing if it has the
+		// latest snapshot
+		if resp.BaseMissing {
+			next := chainStart(chain, resp.Snapshots)
+			if next == len(chain) {
+				r.logger.Printf("[INFO] raft: %v already has snapshot %v", s.peer, chain[next-1].ID)
+				return chain[next-1], true, false, nil
+			}
+			s.failures++
+			s.snapshotID, s.snapshotOffset = chain[next].ID, 0
+			r.logger.Printf("[WARN] raft: %v does not have the base of snapshot %v, sending the chain from %v",
+				s.peer, snapID, s.snapshotID)
+			return nil, false, false, nil
+		}
+
+		// Resume where the follower expects if it rejected the chunk
+		if !resp.Success {
+			s.failures++
+			s.snapshotOffset = resp.NextOffset
+			r.logger.Printf("[WARN] raft: InstallSnapshot to %v rejected at offset %d, next offset %d",
+				s.peer, offset, resp.NextOffset)
+			return nil, false, false, nil
+		}
+		offset += n
+		s.snapshotOffset = offset
+		if offset >= meta.Size {
+			break
+		}
 	}
-	return false, nil
+	metrics.MeasureSince([]string{"raft", "replication", "installSnapshot", string(s.peer.ID)}, start)
+	return meta, true, false, nil
 }
 
 // heartbeat is used to periodically invoke AppendEntries on a peer
@@ -329,6 +484,8 @@ func (r *Raft) sendLatestSnapshot(s *followerReplication) (bool, error) {
 // since that routine could potentially be blocked on disk IO.
 func (r *Raft) heartbeat(s *followerReplication, stopCh chan struct{}) {
 	var failures uint64
+	// Index of last committed operation sent for witness garbage collection.
+	var gcIndex uint64
 	req := AppendEntriesRequest{
 		RPCHeader: r.getRPCHeader(),
 		Term:      s.currentTerm,
@@ -344,6 +501,10 @@ func (r *Raft) heartbeat(s *followerReplication, stopCh chan struct{}) {
 			return
 		}
 
+		// Piggyback committed operations for witness garbage collection.
+		var gcSent uint64
+		req.CommittedOps, gcSent = s.committedOps.since(gcIndex, r.conf.MaxWitnessGcOps)
+
 		start := time.Now()
 		if err := r.trans.AppendEntries(s.peer.ID, s.peer.Address, &req, &resp); err != nil {
 			r.logger.Printf("[ERR] raft: Failed to heartbeat to %v: %v", s.peer.Address, err)
@@ -357,6 +518,14 @@ func (r *Raft) heartbeat(s *followerReplication, stopCh chan struct{}) {
 			failures = 0
 			metrics.MeasureSince([]string{"raft", "replication", "heartbeat", string(s.peer.ID)}, start)
 			s.notifyAll(resp.Success)
+			if resp.Success {
+				gcIndex = gcSent
+			}
+
+			// Initialize follower as a witness if it is new or lost its state.
+			if resp.Success && resp.WitnessTerm < s.currentTerm {
+				r.initializeWitness(s, stopCh)
+			}
 		}
 	}
 }
@@ -400,11 +569,9 @@ SEND:
 			}
 			break SEND
 		case <-s.triggerCh:
-			lastLogIdx, _ := r.getLastLog()
-			shouldStop = r.pipelineSend(s, pipeline, &nextIndex, lastLogIdx)
+			shouldStop = r.pipelineSend(s, pipeline, &nextIndex, r.getReplicationIndex())
 		case <-randomTimeout(r.conf.CommitTimeout):
-			lastLogIdx, _ := r.getLastLog()
-			shouldStop = r.pipelineSend(s, pipeline, &nextIndex, lastLogIdx)
+			shouldStop = r.pipelineSend(s, pipeline, &nextIndex, r.getReplicationIndex())
 		}
 	}
 
@@ -503,7 +670,7 @@ func (r *Raft) setPreviousLog(req *AppendEntriesRequest, nextIndex uint64) error
 
 	} else {
 		var l Log
-		if err := r.logs.GetLog(nextIndex-1, &l); err != nil {
+		if err := r.getLog(nextIndex-1, &l); err != nil {
 			r.logger.Printf("[ERR] raft: Failed to get log at index %d: %v",
 				nextIndex-1, err)
 			return err
@@ -523,7 +690,7 @@ func (r *Raft) setNewLogs(req *AppendEntriesRequest, nextIndex, lastIndex uint64
 	maxIndex := min(nextIndex+uint64(r.conf.MaxAppendEntries)-1, lastIndex)
 	for i := nextIndex; i <= maxIndex; i++ {
 		oldLog := new(Log)
-		if err := r.logs.GetLog(i, oldLog); err != nil {
+		if err := r.getLog(i, oldLog); err != nil {
 			r.logger.Printf("[ERR] raft: Failed to get log at index %d: %v", i, err)
 			return err
 		}
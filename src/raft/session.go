@@ -1,6 +1,7 @@
 package raft
 
 import (
+	"encoding/json"
 	"errors"
 	"sync"
 	"time"
@@ -10,21 +11,12 @@ import (
 // Client library for Raft. Provides session abstraction that handles starting
 // a session, making requests, and closing a session.
 
-// Connection and associated lock for synchronization.
-type syncedConn struct {
-	// Connection to Raft server.
-	conn *netConn
-	// Lock protecting conn.
-	lock sync.Mutex
-}
-
 // Session abstraction used to make requests to Raft cluster.
 type Session struct {
-	// Client network layer.
-	trans *NetworkTransport
-	// Connections to all Raft nodes.
-	conns []syncedConn
-	// Leader is index into conns or addrs arrays.
+	// Client network layer. Connections to Raft servers are owned by the
+	// transport, and shared by all requests when it is multiplexed.
+	trans ClientTransport
+	// Leader is index into addrs array.
 	leader     int
 	leaderLock sync.RWMutex
     // Term tracks the current Raft term to avoid stale witnesses.
@@ -38,34 +30,53 @@ type Session struct {
 	rpcSeqNo uint64
     // Size of superquorum (number of witnesses need to record commutative operation in).
     superquorumSz int
+	// Credentials sent with every request, for servers that authenticate
+	// clients.
+	credentials []byte
 }
 
+const (
+	// Initial time to wait before retrying a request rejected with
+	// ErrOverloaded, doubled on each further rejection.
+	overloadBackoffBase = 10 * time.Millisecond
+	// Caps the number of times the wait is doubled.
+	overloadBackoffLimit = 8
+	// Number of times a request rejected with ErrOverloaded is retried
+	// before the error is returned.
+	maxOverloadRetries = 10
+)
+
 // Open client session to cluster.
 // Params:
 //   - trans: Client transport layer for networking opertaions
 //   - addrs: Addresses of all Raft servers
 // Return: created session
-func CreateClientSession(trans *NetworkTransport, addrs []ServerAddress) (*Session, error) {
+func CreateClientSession(trans ClientTransport, addrs []ServerAddress) (*Session, error) {
+	return CreateClientSessionWithCredentials(trans, addrs, nil)
+}
+
+// Open client session to cluster whose servers authenticate clients (see
+// Config.Authenticator).
+// Params:
+//   - trans: Client transport layer for networking opertaions
+//   - addrs: Addresses of all Raft servers
+//   - credentials: Credentials sent with every request, such as a token
+//                  created by NewHMACToken
+// Return: created session
+func CreateClientSessionWithCredentials(trans ClientTransport, addrs []ServerAddress, credentials []byte) (*Session, error) {
 	session := &Session{
-		trans:    trans,
-		conns:    make([]syncedConn, len(addrs)),
-		leader:   -1,
-		addrs:    addrs,
-		rpcSeqNo: 0,
+		trans:       trans,
+		leader:      -1,
+		addrs:       addrs,
+		rpcSeqNo:    0,
+		credentials: credentials,
 	}
     f := len(addrs) / 2     // Raft needs 2f+1 replicas
     session.superquorumSz = f + int(math.Ceil(float64(f)/2.0)) + 1
 
-	// Initialize syncedConn array.
-	for i := range session.conns {
-		session.conns[i] = syncedConn{}
-	}
-
 	// Open connections to all raft servers.
-	var err error
 	for i, addr := range addrs {
-		session.conns[i].conn, err = trans.getConn(addr)
-		if err == nil {
+		if err := trans.OpenClientConn(addr); err == nil {
 			session.leader = i
 		}
 	}
@@ -80,13 +91,17 @@ func CreateClientSession(trans *NetworkTransport, addrs []ServerAddress) (*Sessi
 		RPCHeader: RPCHeader{
 			ProtocolVersion: ProtocolVersionMax,
 		},
+		Credentials: credentials,
 	}
 	resp := ClientIdResponse{}
-	err = session.sendToActiveLeader(&req, &resp, rpcClientIdRequest)
+	err := session.sendToActiveLeader(&resp, func(target ServerAddress) (bool, error) {
+		return trans.SendClientIdRequest(target, &req, &resp)
+	})
 	if err != nil {
 		return nil, err
 	}
 	session.clientID = resp.ClientID
+	session.updateTerm(resp.Term)
 	return session, nil
 }
 
@@ -123,8 +138,11 @@ func (s *Session) SendRequestWithSeqNo(data []byte, keys []Key, resp *ClientResp
 			ClientID: s.clientID,
 			SeqNo:    seqno,
 		},
+		Credentials: s.credentials,
 	}
-	return s.sendToActiveLeader(&req, resp, rpcClientRequest)
+	return s.sendToActiveLeader(resp, func(target ServerAddress) (bool, error) {
+		return s.trans.SendClientRequest(target, &req, resp)
+	})
 }
 
 // Close client session.
@@ -134,16 +152,18 @@ func (s *Session) CloseClientSession() error {
 }
 
 // Make request to Raft cluster following CURP protocol. Send to witnesses and
-// master simultaneously to complete in 1 RTT.
+// master simultaneously to complete in 1 RTT. If the cluster rejects the
+// session's credentials, resp is not populated.
 // Params:
 //   - data: client request to send to cluster
 //   - keys: array of keys that request updates, used in commutativity checks
 //   - resp: pointer to response that will be populated
-//   - seqno: sequence number to use for request (for testing purposes)
-func (s *Session) SendFastRequest(data []byte, keys []Key, resp *ClientResponse) {
+// Returns: ErrOverloaded if the cluster stays overloaded, or an error if it
+// rejects the session's credentials.
+func (s *Session) SendFastRequest(data []byte, keys []Key, resp *ClientResponse) error {
 	seqNo := s.rpcSeqNo
 	s.rpcSeqNo++
-	s.SendFastRequestWithSeqNo(data, keys, resp, seqNo)
+	return s.SendFastRequestWithSeqNo(data, keys, resp, seqNo)
 }
 
 // Make request to Raft cluster following CURP protocol. Send to witnesses and
@@ -154,60 +174,130 @@ func (s *Session) SendFastRequest(data []byte, keys []Key, resp *ClientResponse)
 //   - keys: array of keys that request updates, used in commutativity checks
 //   - resp: pointer to response that will be populated
 //   - seqno: sequence number to use for request (for testing purposes)
-func (s *Session) SendFastRequestWithSeqNo(data []byte, keys []Key, resp *ClientResponse, seqNo uint64) {
+// Returns: ErrOverloaded if the cluster stays overloaded, or an error if it
+// rejects the session's credentials.
+func (s *Session) SendFastRequestWithSeqNo(data []byte, keys []Key, resp *ClientResponse, seqNo uint64) error {
+	entry := &Log{
+		Type:     LogCommand,
+		Data:     data,
+		Keys:     keys,
+		ClientID: s.clientID,
+		SeqNo:    seqNo,
+	}
+	return s.sendFastEntry(entry, resp)
+}
+
+// Apply a multi-key conditional transaction at the Raft cluster following
+// CURP protocol. The writes are applied only if every read precondition holds
+// at the FSM. The transaction is sent as a single log entry and recorded at
+// witnesses under every key it reads or writes.
+// Params:
+//   - txn: transaction to apply
+//   - resp: pointer to transaction response that will be populated
+func (s *Session) Transaction(txn *Transaction, resp *TransactionResponse) error {
+	if resp == nil {
+		return errors.New("Response is nil")
+	}
+	data, err := encodeMsgPack(txn)
+	if err != nil {
+		return err
+	}
+	seqNo := s.rpcSeqNo
+	s.rpcSeqNo++
+	entry := &Log{
+		Type:     LogTransaction,
+		Data:     data.Bytes(),
+		Keys:     txn.keys(),
+		ClientID: s.clientID,
+		SeqNo:    seqNo,
+	}
+	clientResp := ClientResponse{}
+	if err := s.sendFastEntry(entry, &clientResp); err != nil {
+		return err
+	}
+	return json.Unmarshal(clientResp.ResponseData, resp)
+}
+
+// Send log entry to Raft cluster following CURP protocol. Send to witnesses and
+// master simultaneously to complete in 1 RTT, and fall back to a sync request
+// if the entry could not be recorded at a superquorum of witnesses.
+// Params:
+//   - entry: log entry to send, with client ID and sequence number set
+//   - resp: pointer to response that will be populated
+// Returns: ErrOverloaded if the leader stays overloaded through the retries
+// of a sync request, or an error if the cluster rejected the session's
+// credentials.
+func (s *Session) sendFastEntry(entry *Log, resp *ClientResponse) error {
 	req := ClientRequest{
 		RPCHeader: RPCHeader{
 			ProtocolVersion: ProtocolVersionMax,
 		},
-		Entry: &Log{
-			Type:     LogCommand,
-			Data:     data,
-			Keys:     keys,
-			ClientID: s.clientID,
-			SeqNo:    seqNo,
-		},
+		Entry:       entry,
+		Credentials: s.credentials,
 	}
 
 	// Repeat until success.
 	// TODO: only retry limited number of times
-	for true {
+	for {
+		// Fence witnesses with the term and leader the command is sent to.
+		s.termLock.RLock()
+		term := s.term
+		s.termLock.RUnlock()
+		s.leaderLock.RLock()
+		leaderAddr := s.addrs[s.leader]
+		s.leaderLock.RUnlock()
+
+		leaderCh := make(chan bool, 1)
 		resultCh := make(chan bool, len(s.addrs))
-		go func(s *Session, req *ClientRequest, resp *ClientResponse, resultCh *chan bool) {
-			err := s.sendToActiveLeader(req, resp, rpcClientRequest)
+		go func(s *Session, req *ClientRequest, resp *ClientResponse, leaderCh chan bool) {
+			err := s.sendToActiveLeader(resp, func(target ServerAddress) (bool, error) {
+				return s.trans.SendClientRequest(target, req, resp)
+			})
 			if err != nil {
-				*resultCh <- false
+				leaderCh <- false
 			} else {
-				*resultCh <- true
+				leaderCh <- true
 			}
-		}(s, &req, resp, &resultCh)
-		s.sendToAllWitnesses(req.Entry, &resultCh)
-
-		success := true
+		}(s, &req, resp, leaderCh)
+		s.sendToAllWitnesses(req.Entry, term, leaderAddr, &resultCh)
 
-        // Wait for superquorum to respond.
-		for i := 0; i <= s.superquorumSz; i += 1 { // TODO: should this be len + 1?
+		// Wait for leader and superquorum of witnesses to respond.
+		success := <-leaderCh
+		s.updateTerm(resp.Term)
+		for i := 0; i < s.superquorumSz; i += 1 {
 			result := <-resultCh
 			success = success && result
-			// TODO: if synced, automatically succeed, otherwise if not success need to retry
+		}
+		// Records only count if accepted in the term of the leader that
+		// executed the command.
+		if resp.Term != term {
+			success = false
 		}
 		if success || resp.Synced {
-			return
+			return nil
 		}
 		// If fail to record at witnesses and not synced, issue sync request.
 		sync := &SyncRequest{
 			RPCHeader: RPCHeader{
 				ProtocolVersion: ProtocolVersionMax,
 			},
-			Entry: req.Entry,
+			Entry:       req.Entry,
+			Credentials: s.credentials,
 		}
 		var syncResp SyncResponse
-		err := s.sendToActiveLeader(sync, &syncResp, rpcSyncRequest)
+		err := s.sendToActiveLeader(&syncResp, func(target ServerAddress) (bool, error) {
+			return s.trans.SendSyncRequest(target, sync, &syncResp)
+		})
 		if err == nil && syncResp.Success {
-			return
+			return nil
+		}
+		// Credentials rejected, or the leader still overloaded once its
+		// retries are spent - stop retrying.
+		if err == ErrUnauthenticated || err == ErrUnauthorized || err == ErrOverloaded {
+			return err
 		}
 		// Failed to sync. Try everything again
 	}
-
 }
 
 // Send log entry to all witnesses in parallel and put results (success
@@ -215,110 +305,139 @@ func (s *Session) SendFastRequestWithSeqNo(data []byte, keys []Key, resp *Client
 // RPCs to witnesses have completed.
 // Params:
 //   - entry: Log entry to send to all witnesses.
+//   - term: term the client believes is current, used to fence witnesses.
+//   - leaderAddr: leader the client is sending the command to.
 //   - resultCh: channel to put completion status into.
-func (s *Session) sendToAllWitnesses(entry *Log, resultCh *chan bool) {
-	s.termLock.RLock()
-    term := s.term
-    s.termLock.RUnlock()
-
+func (s *Session) sendToAllWitnesses(entry *Log, term uint64, leaderAddr ServerAddress, resultCh *chan bool) {
     req := &RecordRequest{
 		RPCHeader: RPCHeader{
 			ProtocolVersion: ProtocolVersionMax,
 		},
 		Entry: entry,
+		Credentials: s.credentials,
         Term: term,
+        LeaderAddress: leaderAddr,
     }
 
 	// Send to all witnesses.
-	for i := range s.conns {
-		go func(req *RecordRequest, resultCh *chan bool) {
+	for i := range s.addrs {
+		go func(i int, req *RecordRequest, resultCh *chan bool) {
 			*resultCh <- s.sendToWitness(i, req)
-		}(req, resultCh)
+		}(i, req, resultCh)
 	}
 }
 
-// Send request to a witness specified by id. Synchronous.
+// Send request to a witness specified by id. Synchronous. A witness that
+// is overloaded is not retried: the record fails, and the command is
+// synced through the leader instead.
 // Params:
 //   - id: ID of witness sending request to
 //   - req: RecordRequest to send to witness
 // Returns: success or failure of RPC.
 func (s *Session) sendToWitness(id int, req *RecordRequest) bool {
-	var err error
-	s.conns[id].lock.Lock()
-	if s.conns[id].conn == nil {
-		s.conns[id].conn, err = s.trans.getConn(s.addrs[id])
-		if err != nil {
-			s.conns[id].lock.Unlock()
-			return false
-		}
-	}
-	err = sendRPC(s.conns[id].conn, rpcRecordRequest, req)
-	if err != nil {
-		s.conns[id].lock.Unlock()
-		return false
-	}
 	resp := &RecordResponse{}
-	_, err = decodeResponse(s.conns[id].conn, resp)
-	s.conns[id].lock.Unlock()
+	_, err := s.trans.SendRecordRequest(s.addrs[id], req, resp)
 
     // Update term if found new term.
-    s.termLock.Lock()
-    if resp.Term > s.term {
-        s.term = resp.Term
-    }
-    s.termLock.Unlock()
+    s.updateTerm(resp.Term)
 
     if err != nil || !resp.Success {
+		// The witness follows another leader, so send the next attempt
+		// there.
+		if err != nil && resp.LeaderAddress != "" && resp.LeaderAddress != req.LeaderAddress {
+			s.updateLeader(resp.Term, resp.LeaderAddress)
+		}
 		return false
 	}
 	return true
 }
 
+// Use the leader reported by a witness as the session's leader, if the
+// witness is in the term the session believes is current.
+// Params:
+//   - term: term reported by the witness.
+//   - leaderAddr: leader the witness follows.
+func (s *Session) updateLeader(term uint64, leaderAddr ServerAddress) {
+	s.termLock.RLock()
+	current := s.term
+	s.termLock.RUnlock()
+	if term != current {
+		return
+	}
+	s.leaderLock.Lock()
+	defer s.leaderLock.Unlock()
+	for i, addr := range s.addrs {
+		if addr == leaderAddr {
+			s.leader = i
+			return
+		}
+	}
+}
+
+// Update the term the session uses to fence witnesses if a newer term
+// is discovered.
+// Params:
+//   - term: term reported by a Raft server.
+func (s *Session) updateTerm(term uint64) {
+    s.termLock.Lock()
+    if term > s.term {
+        s.term = term
+    }
+    s.termLock.Unlock()
+}
+
 // Send a RPC to the active leader. Try to use the currently cached active leader, and
 // if there is no cached leader or it is unreachable, try other Raft servers until a
-// leader is found. If no active Raft server is found, return an error.
+// leader is found. If no active Raft server is found, return an error. Requests
+// rejected with ErrOverloaded are retried with exponential backoff, and
+// ErrOverloaded is returned if the server remains overloaded. Requests whose
+// credentials are rejected are not retried.
 // Params:
-//   - request: JSON representation of request
 //   - response: client response that contains a leader address to help find an active leader
-//   - rpcType: type of RPC being sent.
-func (s *Session) sendToActiveLeader(request interface{}, response GenericClientResponse, rpcType uint8) error {
+//   - send: sends the request to a server and decodes into response. Returns
+//           whether the server responded, and any error.
+func (s *Session) sendToActiveLeader(response GenericClientResponse, send func(target ServerAddress) (bool, error)) error {
 	sendFailures := 0
-	var err error
+	overloads := 0
 
 	s.leaderLock.Lock()
 	defer s.leaderLock.Unlock()
 
 	// Continue trying to send until have tried contacting all servers.
 	for sendFailures < len(s.addrs) {
-		// If no open connection to guessed leader, try to open one.
-		s.conns[s.leader].lock.Lock()
-		if s.conns[s.leader].conn == nil {
-			s.conns[s.leader].conn, err = s.trans.getConn(s.addrs[s.leader])
-			if err != nil {
-				s.conns[s.leader].lock.Unlock()
-				sendFailures += 1
-				s.leader = (s.leader + 1) % len(s.conns)
-				continue
-			}
-		}
-		err = sendRPC(s.conns[s.leader].conn, rpcType, request)
+		responded, err := send(s.addrs[s.leader])
 
-		// Failed to send RPC - try next server.
-		if err != nil {
-			s.conns[s.leader].lock.Unlock()
+		// Failed to send RPC or get a response - try next server.
+		if !responded {
 			sendFailures += 1
-			s.leader = (s.leader + 1) % len(s.conns)
+			s.leader = (s.leader + 1) % len(s.addrs)
 			continue
 		}
 
-		// Try to decode response.
-		_, err = decodeResponse(s.conns[s.leader].conn, &response)
-		s.conns[s.leader].lock.Unlock()
+		// Credentials rejected - retrying cannot succeed.
+		for _, authErr := range []error{ErrUnauthenticated, ErrUnauthorized} {
+			if isRemoteError(err, authErr) {
+				return authErr
+			}
+		}
+
+		// Server is overloaded - back off and retry it.
+		if isRemoteError(err, ErrOverloaded) {
+			overloads += 1
+			if overloads > maxOverloadRetries {
+				return ErrOverloaded
+			}
+			// Let other requests on the session proceed while backing off
+			s.leaderLock.Unlock()
+			time.Sleep(backoff(overloadBackoffBase, uint64(overloads), overloadBackoffLimit))
+			s.leaderLock.Lock()
+			continue
+		}
 
 		// If failure, use leader hint or wait for election to complete.
 		if err != nil {
 			if response != nil && response.GetLeaderAddress() != "" {
-				s.leader = (s.leader + 1) % len(s.conns)
+				s.leader = (s.leader + 1) % len(s.addrs)
 				for i, addr := range s.addrs {
 					if addr == response.GetLeaderAddress() {
 						s.leader = i
@@ -335,3 +454,13 @@ func (s *Session) sendToActiveLeader(request interface{}, response GenericClient
 
 	return ErrNoActiveLeader
 }
+
+// Check if a server rejected a request with the given error. Errors
+// received over the network only keep their message, so compare messages.
+// Params:
+//   - err: error returned by a client transport.
+//   - target: error the server may have responded with.
+// Returns: true if err is target.
+func isRemoteError(err error, target error) bool {
+	return err != nil && err.Error() == target.Error()
+}
@@ -0,0 +1,133 @@
+package raft
+
+import (
+	"testing"
+)
+
+// startStubServer serves the RPCs sent to a new in-memory transport with
+// handler, and connects client to it.
+func startStubServer(client *InmemTransport, handler func(rpc RPC)) (ServerAddress, *InmemTransport) {
+	addr, trans := NewInmemTransport("")
+	client.Connect(addr, trans)
+	go func() {
+		for rpc := range trans.Consumer() {
+			handler(rpc)
+		}
+	}()
+	return addr, trans
+}
+
+func TestSession_FastPathSyncsOnTermChange(t *testing.T) {
+	_, client := NewInmemTransport("")
+	defer client.Close()
+
+	// The leader executes the command in a newer term than the one the
+	// witness accepted it in
+	syncs := make(chan struct{}, 10)
+	var addr ServerAddress
+	addr, trans := startStubServer(client, func(rpc RPC) {
+		switch rpc.Command.(type) {
+		case *ClientIdRequest:
+			rpc.Respond(&ClientIdResponse{ClientID: 1, LeaderAddress: addr, Term: 1}, nil)
+		case *ClientRequest:
+			rpc.Respond(&ClientResponse{Success: true, LeaderAddress: addr, Term: 2}, nil)
+		case *RecordRequest:
+			rpc.Respond(&RecordResponse{Success: true, LeaderAddress: addr, Term: 1}, nil)
+		case *SyncRequest:
+			syncs <- struct{}{}
+			rpc.Respond(&SyncResponse{Success: true, LeaderAddress: addr}, nil)
+		}
+	})
+	defer trans.Close()
+
+	session, err := CreateClientSession(client, []ServerAddress{addr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var resp ClientResponse
+	if err := session.SendFastRequest([]byte("a"), []Key{Key("a")}, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(syncs) != 1 {
+		t.Fatalf("expected one sync request, got %d", len(syncs))
+	}
+	if session.term != 2 {
+		t.Fatalf("bad term: %d", session.term)
+	}
+}
+
+func TestSession_WitnessLeaderHint(t *testing.T) {
+	_, client := NewInmemTransport("")
+	defer client.Close()
+
+	// The witness follows addrs[2] in term 3
+	var addrs []ServerAddress
+	for i := 0; i < 3; i++ {
+		addr, trans := startStubServer(client, func(rpc RPC) {
+			req := rpc.Command.(*RecordRequest)
+			rpc.Respond(&RecordResponse{LeaderAddress: addrs[2], Term: req.Term}, ErrStaleLeader)
+		})
+		defer trans.Close()
+		addrs = append(addrs, addr)
+	}
+	session := &Session{trans: client, addrs: addrs, leader: 0, term: 3}
+	req := &RecordRequest{
+		Entry:         &Log{Type: LogCommand, ClientID: 1, Keys: []Key{Key("a")}},
+		Term:          2,
+		LeaderAddress: addrs[0],
+	}
+
+	// A witness in an older term is not followed
+	if session.sendToWitness(1, req) {
+		t.Fatalf("record should be rejected")
+	}
+	if session.leader != 0 {
+		t.Fatalf("followed hint from stale witness: %d", session.leader)
+	}
+
+	// A witness in the current term is
+	req.Term = 3
+	if session.sendToWitness(1, req) {
+		t.Fatalf("record should be rejected")
+	}
+	if session.leader != 2 {
+		t.Fatalf("leader hint not followed: %d", session.leader)
+	}
+}
+
+func TestSession_FastPathOverloaded(t *testing.T) {
+	_, client := NewInmemTransport("")
+	defer client.Close()
+
+	// The witness is overloaded, and so is the leader when asked to sync
+	var syncs int
+	var addr ServerAddress
+	addr, trans := startStubServer(client, func(rpc RPC) {
+		switch rpc.Command.(type) {
+		case *ClientIdRequest:
+			rpc.Respond(&ClientIdResponse{ClientID: 1, LeaderAddress: addr, Term: 1}, nil)
+		case *ClientRequest:
+			rpc.Respond(&ClientResponse{Success: true, LeaderAddress: addr, Term: 1}, nil)
+		case *RecordRequest:
+			rpc.Respond(&RecordResponse{LeaderAddress: addr, Term: 1}, ErrOverloaded)
+		case *SyncRequest:
+			syncs++
+			rpc.Respond(&SyncResponse{LeaderAddress: addr}, ErrOverloaded)
+		}
+	})
+	defer trans.Close()
+
+	session, err := CreateClientSession(client, []ServerAddress{addr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The request fails once the sync retries are spent
+	var resp ClientResponse
+	if err := session.SendFastRequest([]byte("a"), []Key{Key("a")}, &resp); err != ErrOverloaded {
+		t.Fatalf("expected overloaded, got: %v", err)
+	}
+	if syncs != maxOverloadRetries+1 {
+		t.Fatalf("bad sync attempts: %d", syncs)
+	}
+}
@@ -22,12 +22,22 @@ type SnapshotMeta struct {
 	Index uint64
 	Term  uint64
 
+	// Base is the ID of the snapshot this snapshot holds the changes since,
+	// if it is a delta taken by an IncrementalFSM. Empty for a full
+	// snapshot.
+	Base string
+
 	// Next Client ID to use. Used with RIFL.
 	NextClientId uint64
 
 	// Responses to client RPCs. Used with RIFL.
 	ClientResponseCache map[uint64]map[uint64]clientResponseEntry
 
+	// Operations recorded at this server as a witness when the snapshot was
+	// taken. Used with CURP to restore witness state if it is lost from
+	// stable storage.
+	WitnessRecords []Log
+
 	// Peers is deprecated and used to support version 0 snapshots, but will
 	// be populated in version 1 snapshots as well to help with upgrades.
 	Peers []byte
@@ -50,7 +60,7 @@ type SnapshotStore interface {
 	// the given committed configuration. The version parameter controls
 	// which snapshot version to create.
 	Create(version SnapshotVersion, index, term uint64, configuration Configuration,
-		configurationIndex uint64, nextClientId uint64, clientRequestCache map[uint64]map[uint64]clientResponseEntry, trans Transport) (SnapshotSink, error)
+		configurationIndex uint64, nextClientId uint64, clientRequestCache map[uint64]map[uint64]clientResponseEntry, witnessRecords []Log, trans Transport) (SnapshotSink, error)
 
 	// List is used to list the available snapshots in the store.
 	// It should return then in descending order, with the highest index first.
@@ -82,13 +92,21 @@ func (r *Raft) runSnapshots() {
 			}
 
 			// Trigger a snapshot
-			if _, err := r.takeSnapshot(); err != nil {
+			if _, err := r.takeSnapshot(true); err != nil {
+				r.logger.Printf("[ERR] raft: Failed to take snapshot: %v", err)
+			}
+
+		case <-r.logFullCh:
+			// The log reached MaxLogBytes, run immediately. There may be
+			// nothing new to snapshot until more entries are applied.
+			if _, err := r.takeSnapshot(true); err != nil && err != ErrNothingNewToSnapshot {
 				r.logger.Printf("[ERR] raft: Failed to take snapshot: %v", err)
 			}
 
 		case future := <-r.userSnapshotCh:
-			// User-triggered, run immediately
-			id, err := r.takeSnapshot()
+			// User-triggered, run immediately. The snapshot is full, so
+			// that it can be opened on its own.
+			id, err := r.takeSnapshot(false)
 			if err != nil {
 				r.logger.Printf("[ERR] raft: Failed to take snapshot: %v", err)
 			} else {
@@ -119,18 +137,25 @@ func (r *Raft) shouldSnapshot() bool {
 
 	// Compare the delta to the threshold
 	delta := lastIdx - lastSnap
-	return delta >= r.conf.SnapshotThreshold
+	if delta == 0 {
+		return false
+	}
+	return delta >= r.conf.SnapshotThreshold || r.logBytesExceeded()
 }
 
 // takeSnapshot is used to take a new snapshot. This must only be called from
 // the snapshot thread, never the main thread. This returns the ID of the new
-// snapshot, along with an error.
-func (r *Raft) takeSnapshot() (string, error) {
+// snapshot, along with an error. If incremental is set, the snapshot may be a
+// delta of the latest one.
+func (r *Raft) takeSnapshot(incremental bool) (string, error) {
 	defer metrics.MeasureSince([]string{"raft", "snapshot", "takeSnapshot"}, time.Now())
 
 	// Create a request for the FSM to perform a snapshot.
 	snapReq := &reqSnapshotFuture{}
 	snapReq.init()
+	if incremental {
+		snapReq.base = r.snapshotBase()
+	}
 
 	// Wait for dispatch or shutdown.
 	select {
@@ -158,7 +183,7 @@ func (r *Raft) takeSnapshot() (string, error) {
 	case <-r.shutdownCh:
 		return "", ErrRaftShutdown
 	}
-	if err := configReq.Error(); err != nil {
+	if err := configReq.waitOrShutdown(r.shutdownCh); err != nil {
 		return "", err
 	}
 	committed := configReq.configurations.committed
@@ -177,11 +202,19 @@ func (r *Raft) takeSnapshot() (string, error) {
 			committedIndex, snapReq.index)
 	}
 
-	// Create a new snapshot.
-	r.logger.Printf("[INFO] raft: Starting snapshot up to %d", snapReq.index)
+	// Create a new snapshot, or a delta if the FSM took one.
 	start := time.Now()
 	version := getSnapshotVersion(r.protocolVersion)
-	sink, err := r.snapshots.Create(version, snapReq.index, snapReq.term, committed, committedIndex, r.nextClientId, r.clientResponseCache, r.trans)
+	var sink SnapshotSink
+	var err error
+	if base := snapReq.base; base != nil {
+		r.logger.Printf("[INFO] raft: Starting snapshot up to %d as a delta of %v", snapReq.index, base.ID)
+		sink, err = r.snapshots.(IncrementalSnapshotStore).CreateDelta(base.ID, version, snapReq.index, snapReq.term,
+			committed, committedIndex, r.nextClientId, r.copyClientResponseCache(), r.witnessRecords(), r.trans)
+	} else {
+		r.logger.Printf("[INFO] raft: Starting snapshot up to %d", snapReq.index)
+		sink, err = r.snapshots.Create(version, snapReq.index, snapReq.term, committed, committedIndex, r.nextClientId, r.copyClientResponseCache(), r.witnessRecords(), r.trans)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to create snapshot: %v", err)
 	}
@@ -202,6 +235,7 @@ func (r *Raft) takeSnapshot() (string, error) {
 
 	// Update the last stable snapshot info.
 	r.setLastSnapshot(snapReq.index, snapReq.term)
+	r.logSizes.compacted(snapReq.index)
 
 	// Compact the logs.
 	if err := r.compactLogs(snapReq.index); err != nil {
@@ -0,0 +1,130 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+/*
+
+Chunked snapshot transfer. With Config.SnapshotChunkSize, the leader sends a
+snapshot to a follower as a series of InstallSnapshot requests, each carrying
+the offset of its data in the snapshot and a CRC64 of the data. The follower
+keeps the snapshot it is receiving open across requests, and answers each
+with the offset it expects next. A chunk with a bad checksum or an unexpected
+offset is rejected, and a transfer that fails is resumed by the leader from
+the offset the follower last acknowledged rather than from the start. The
+follower installs the snapshot once it has received all of it.
+Config.SnapshotBandwidth paces the chunks the leader sends to each follower.
+
+Requests keep Size as the size of the whole snapshot and give the size of
+their chunk in ChunkSize, so a server that predates chunking fails the
+transfer rather than installing the first chunk as the whole snapshot.
+Chunking is off by default until every server supports it.
+
+*/
+
+// snapshotInstall is a snapshot a follower is receiving in chunks.
+type snapshotInstall struct {
+	sink  SnapshotSink
+	index uint64
+	term  uint64
+	size  int64
+	// Offset of the next chunk expected.
+	offset int64
+}
+
+// matches returns whether a request is for the snapshot being received.
+func (s *snapshotInstall) matches(req *InstallSnapshotRequest) bool {
+	return s.index == req.LastLogIndex && s.term == req.LastLogTerm && s.size == req.Size
+}
+
+// cancelSnapshotInstall abandons the snapshot being received, if any. This
+// must only be called from the main thread.
+func (r *Raft) cancelSnapshotInstall() {
+	if r.snapshotInstall == nil {
+		return
+	}
+	r.snapshotInstall.sink.Cancel()
+	r.snapshotInstall = nil
+}
+
+// chunkChecksum returns the checksum of a chunk of snapshot data.
+func chunkChecksum(data []byte) []byte {
+	checksum := make([]byte, 8)
+	binary.BigEndian.PutUint64(checksum, crc64.Checksum(data, logChecksumTable))
+	return checksum
+}
+
+// receiveSnapshotChunk writes the data of an InstallSnapshot request to the
+// snapshot being received. A chunk with a checksum is read whole and checked
+// before it is written, so a corrupt or incomplete chunk leaves the snapshot
+// as it was. If a write fails partway, the snapshot is abandoned. This must
+// only be called from the main thread.
+// Params:
+//   - in: snapshot being received.
+//   - req: InstallSnapshot request.
+//   - data: the request's snapshot data.
+// Returns: an error if the chunk was not written.
+func (r *Raft) receiveSnapshotChunk(in *snapshotInstall, req *InstallSnapshotRequest, data io.Reader) error {
+	n := req.DataSize()
+	if req.Checksum == nil {
+		if _, err := io.CopyN(in.sink, data, n); err != nil {
+			r.cancelSnapshotInstall()
+			return err
+		}
+		in.offset += n
+		return nil
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(data, buf); err != nil {
+		return err
+	}
+	if !bytes.Equal(chunkChecksum(buf), req.Checksum) {
+		metrics.IncrCounter([]string{"raft", "snapshot", "chunkChecksumMismatch"}, 1)
+		return fmt.Errorf("checksum mismatch for snapshot chunk at offset %d", req.Offset)
+	}
+	if _, err := in.sink.Write(buf); err != nil {
+		r.cancelSnapshotInstall()
+		return err
+	}
+	in.offset += n
+	return nil
+}
+
+// snapshotThrottle paces the snapshot data sent to a follower.
+type snapshotThrottle struct {
+	// Bytes per second, or zero for unlimited.
+	bandwidth int
+	start     time.Time
+	sent      int64
+}
+
+func newSnapshotThrottle(bandwidth int) *snapshotThrottle {
+	return &snapshotThrottle{bandwidth: bandwidth, start: time.Now()}
+}
+
+// delay returns how long to wait before sending a chunk, so that the data
+// sent so far has not exceeded the bandwidth, and counts the chunk as sent.
+// Params:
+//   - n: size of the chunk.
+//   - now: current time.
+func (t *snapshotThrottle) delay(n int64, now time.Time) time.Duration {
+	sent := t.sent
+	t.sent += n
+	if t.bandwidth == 0 {
+		return 0
+	}
+	due := t.start.Add(time.Duration(float64(sent) / float64(t.bandwidth) * float64(time.Second)))
+	if wait := due.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
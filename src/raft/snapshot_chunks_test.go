@@ -0,0 +1,301 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunkRecorder wraps a Transport, recording the offsets, sizes and bases of
+// the snapshot chunks it sends, and corrupting the n-th chunk.
+type chunkRecorder struct {
+	Transport
+	lock        sync.Mutex
+	offsets     []int64
+	sizes       []int64
+	chunkSizes  []int64
+	baseIndexes []uint64
+	checksums   int
+	corruptAt   int
+}
+
+func (c *chunkRecorder) InstallSnapshot(id ServerID, target ServerAddress, args *InstallSnapshotRequest, resp *InstallSnapshotResponse, data io.Reader) error {
+	c.lock.Lock()
+	c.offsets = append(c.offsets, args.Offset)
+	c.sizes = append(c.sizes, args.Size)
+	c.chunkSizes = append(c.chunkSizes, args.ChunkSize)
+	c.baseIndexes = append(c.baseIndexes, args.BaseIndex)
+	if args.Checksum != nil {
+		c.checksums++
+	}
+	corrupt := len(c.offsets) == c.corruptAt
+	c.lock.Unlock()
+	if corrupt {
+		buf, err := ioutil.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		buf[0] ^= 0xff
+		data = bytes.NewReader(buf)
+	}
+	return c.Transport.InstallSnapshot(id, target, args, resp, data)
+}
+
+func (c *chunkRecorder) getOffsets() []int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int64(nil), c.offsets...)
+}
+
+func (c *chunkRecorder) getBaseIndexes() []uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]uint64(nil), c.baseIndexes...)
+}
+
+func TestSnapshotThrottle(t *testing.T) {
+	start := time.Now()
+	unlimited := &snapshotThrottle{start: start}
+	for i := 0; i < 3; i++ {
+		if wait := unlimited.delay(1<<20, start); wait != 0 {
+			t.Fatalf("unlimited throttle waited %v", wait)
+		}
+	}
+
+	throttle := &snapshotThrottle{bandwidth: 1000, start: start}
+	if wait := throttle.delay(500, start); wait != 0 {
+		t.Fatalf("first chunk waited %v", wait)
+	}
+	if wait := throttle.delay(500, start); wait != 500*time.Millisecond {
+		t.Fatalf("bad wait: %v", wait)
+	}
+	if wait := throttle.delay(500, start.Add(2*time.Second)); wait != 0 {
+		t.Fatalf("late chunk waited %v", wait)
+	}
+	if wait := throttle.delay(500, start.Add(time.Second)); wait != 500*time.Millisecond {
+		t.Fatalf("bad wait: %v", wait)
+	}
+}
+
+func TestConfig_SnapshotChunks(t *testing.T) {
+	conf := DefaultConfig()
+	if conf.SnapshotChunkSize != 0 {
+		t.Fatalf("chunking is on by default")
+	}
+	conf.LocalID = "id"
+	conf.SnapshotChunkSize = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("expected error for negative chunk size")
+	}
+	conf.SnapshotChunkSize = 0
+	conf.SnapshotBandwidth = -1
+	if err := ValidateConfig(conf); err == nil {
+		t.Fatalf("expected error for negative bandwidth")
+	}
+}
+
+func TestRaft_ChunkedInstallSnapshot(t *testing.T) {
+	conf := inmemConfig(t)
+	conf.TrailingLogs = 10
+	conf.SnapshotChunkSize = 64
+	addr1, trans1 := NewInmemTransport("")
+	addr2, trans2 := NewInmemTransport("")
+	trans1.Connect(addr2, trans2)
+	trans2.Connect(addr1, trans1)
+
+	// Drop the third chunk, and corrupt the fifth
+	faulty := NewFaultyTransport(trans1)
+	faulty.AddRule(FaultRule{Fault: FaultDrop, RPCs: []RPCType{RPCInstallSnapshot}, Skip: 2, Count: 1})
+	recorder := &chunkRecorder{Transport: faulty, corruptAt: 5}
+
+	// Start a single server with a snapshot
+	leaderConf := *conf
+	leaderConf.LocalID = ServerID(fmt.Sprintf("server-%s", addr1))
+	leaderConf.Logger = newTestLoggerWithPrefix(t, string(leaderConf.LocalID))
+	store1 := NewInmemStore()
+	dir1, snaps1 := FileSnapTest(t)
+	defer os.RemoveAll(dir1)
+	configuration := Configuration{Servers: []Server{{Suffrage: Voter, ID: leaderConf.LocalID, Address: addr1}}}
+	if err := BootstrapCluster(&leaderConf, store1, store1, snaps1, recorder, configuration); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	leader, err := NewRaft(&leaderConf, &MockFSM{}, store1, store1, snaps1, recorder)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer leader.Shutdown()
+	waitForLeader(t, []*Raft{leader})
+	for i := 0; i < 100; i++ {
+		future := leader.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i)), ClientID: 1, SeqNo: uint64(i)}, 0)
+		if i == 99 {
+			if err := future.Error(); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	if err := leader.Snapshot().Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Add a server, which must be sent the snapshot
+	followerConf := *conf
+	followerConf.LocalID = ServerID(fmt.Sprintf("server-%s", addr2))
+	followerConf.Logger = newTestLoggerWithPrefix(t, string(followerConf.LocalID))
+	store2 := NewInmemStore()
+	dir2, snaps2 := FileSnapTest(t)
+	defer os.RemoveAll(dir2)
+	fsm := &MockFSM{}
+	follower, err := NewRaft(&followerConf, fsm, store2, store2, snaps2, trans2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer follower.Shutdown()
+	if err := leader.AddVoter(followerConf.LocalID, addr2, 0, 5*time.Second).Error(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The follower received the whole snapshot
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fsm.Lock()
+		n := len(fsm.logs)
+		fsm.Unlock()
+		if n == 100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower has %d logs, expected 100", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The transfer resumed after the dropped and the corrupt chunks,
+	// rather than starting over
+	offsets := recorder.getOffsets()
+	expect := []int64{0, 64, 128, 128, 192, 192, 256}
+	if len(offsets) < len(expect) || !reflect.DeepEqual(offsets[:len(expect)], expect) {
+		t.Fatalf("bad chunk offsets: %v", offsets)
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			t.Fatalf("transfer restarted: %v", offsets)
+		}
+	}
+	recorder.lock.Lock()
+	checksums := recorder.checksums
+	sizes, chunkSizes := recorder.sizes, recorder.chunkSizes
+	recorder.lock.Unlock()
+	if checksums != len(offsets) {
+		t.Fatalf("chunks without checksums: %d / %d", checksums, len(offsets))
+	}
+
+	// Each request gave the size of the whole snapshot, and its chunk's
+	for i := range sizes {
+		if sizes[i] != sizes[0] || sizes[i] <= 64 || chunkSizes[i] == 0 || chunkSizes[i] > 64 {
+			t.Fatalf("bad sizes: %v, chunk sizes: %v", sizes, chunkSizes)
+		}
+	}
+}
+
+func TestRaft_InstallSnapshotChunkOffsets(t *testing.T) {
+	conf := inmemConfig(t)
+	c := MakeCluster(1, t, conf)
+	defer c.Close()
+	r := c.Leader()
+	for i := 0; i < 10; i++ {
+		if err := r.Apply(&Log{Data: []byte(fmt.Sprintf("test%d", i)), ClientID: 1, SeqNo: uint64(i)}, 0).Error(); err != nil {
+			c.FailNowf("[ERR] err: %v", err)
+		}
+	}
+	if err := r.Snapshot().Error(); err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	_, snapshot, err := r.snapshots.Open(mustListSnapshots(t, r)[0].ID)
+	if err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	data, err := ioutil.ReadAll(snapshot)
+	snapshot.Close()
+	if err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+
+	// Install the snapshot on a separate server in two chunks
+	_, trans := NewInmemTransport("")
+	store := NewInmemStore()
+	dir, snaps := FileSnapTest(t)
+	defer os.RemoveAll(dir)
+	followerConf := *conf
+	followerConf.LocalID = "follower"
+	follower, err := NewRaft(&followerConf, &MockFSM{}, store, store, snaps, trans)
+	if err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	defer follower.Shutdown()
+	_, client := NewInmemTransport("")
+	client.Connect(trans.LocalAddr(), trans)
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		c.FailNowf("[ERR] err: %v", err)
+	}
+	send := func(offset, size int64, checksum []byte) InstallSnapshotResponse {
+		var resp InstallSnapshotResponse
+		chunk := data[offset : offset+size]
+		err := client.InstallSnapshot(follower.localID, trans.LocalAddr(), &InstallSnapshotRequest{
+			RPCHeader:          r.getRPCHeader(),
+			SnapshotVersion:    SnapshotVersionMax,
+			Term:               r.getCurrentTerm(),
+			Leader:             r.trans.EncodePeer(r.localID, r.localAddr),
+			LastLogIndex:       r.getLastIndex(),
+			LastLogTerm:        r.getCurrentTerm(),
+			Configuration:      encodeConfiguration(future.Configuration()),
+			ConfigurationIndex: 1,
+			Size:               int64(len(data)),
+			ChunkSize:          size,
+			Offset:             offset,
+			Checksum:           checksum,
+		}, &resp, bytes.NewReader(chunk))
+		if err != nil {
+			c.FailNowf("[ERR] err: %v", err)
+		}
+		return resp
+	}
+	half := int64(len(data) / 2)
+	rest := int64(len(data)) - half
+
+	// A chunk past the start is rejected before the first
+	if resp := send(half, rest, chunkChecksum(data[half:])); resp.Success || resp.NextOffset != 0 {
+		c.FailNowf("[ERR] bad response: %#v", resp)
+	}
+	if resp := send(0, half, chunkChecksum(data[:half])); !resp.Success || resp.NextOffset != half {
+		c.FailNowf("[ERR] bad response: %#v", resp)
+	}
+
+	// A chunk with a bad checksum is rejected, and can be sent again
+	if resp := send(half, rest, chunkChecksum(data[:half])); resp.Success || resp.NextOffset != half {
+		c.FailNowf("[ERR] bad response: %#v", resp)
+	}
+	if resp := send(half, rest, chunkChecksum(data[half:])); !resp.Success || resp.NextOffset != int64(len(data)) {
+		c.FailNowf("[ERR] bad response: %#v", resp)
+	}
+	if last, _ := follower.getLastSnapshot(); last != r.getLastIndex() {
+		c.FailNowf("[ERR] snapshot not installed: %d", last)
+	}
+}
+
+func mustListSnapshots(t *testing.T, r *Raft) []*SnapshotMeta {
+	snapshots, err := r.snapshots.List()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(snapshots) == 0 {
+		t.Fatalf("no snapshots")
+	}
+	return snapshots
+}
@@ -0,0 +1,185 @@
+package raft
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Snapshot retention. A FileSnapshotStore decides which snapshots to keep with a
+SnapshotRetentionPolicy, applied by ReapSnapshots after each new snapshot.
+Policies keep the newest N snapshots, snapshots newer than a duration,
+the newest snapshots that fit in a number of bytes, or the newest snapshot of
+each of the last D days. RetainAny and RetainEach combine them, for example
+to keep a snapshot per day for a week within a disk budget:
+
+	RetainEach(RetainAny(KeepSnapshotCount(3), KeepDailySnapshots(7)), KeepSnapshotBytes(10<<30))
+
+The newest snapshot is always kept, whatever the policy.
+
+*/
+
+// SnapshotRetentionInfo describes a snapshot to a retention policy.
+type SnapshotRetentionInfo struct {
+	ID    string
+	Index uint64
+	Term  uint64
+	// Created is when the snapshot was started.
+	Created time.Time
+	// Size of the snapshot on disk.
+	Size int64
+}
+
+// SnapshotRetentionPolicy decides which snapshots to keep.
+type SnapshotRetentionPolicy interface {
+	// Retain returns whether to keep each of the given snapshots, which
+	// are ordered from newest to oldest. It must return one value per
+	// snapshot, or ReapSnapshots fails without reaping any.
+	Retain(snapshots []*SnapshotRetentionInfo, now time.Time) []bool
+}
+
+// SnapshotRetentionFunc implements SnapshotRetentionPolicy with a function.
+type SnapshotRetentionFunc func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool
+
+// Retain implements the SnapshotRetentionPolicy interface.
+func (f SnapshotRetentionFunc) Retain(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+	return f(snapshots, now)
+}
+
+// KeepSnapshotCount keeps the newest n snapshots.
+func KeepSnapshotCount(n int) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		for i := range snapshots {
+			keep[i] = i < n
+		}
+		return keep
+	})
+}
+
+// KeepSnapshotsNewerThan keeps snapshots created less than age ago.
+func KeepSnapshotsNewerThan(age time.Duration) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		for i, snap := range snapshots {
+			keep[i] = now.Sub(snap.Created) < age
+		}
+		return keep
+	})
+}
+
+// KeepSnapshotBytes keeps the newest snapshots whose total size is at most
+// maxBytes.
+func KeepSnapshotBytes(maxBytes int64) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		var total int64
+		for i, snap := range snapshots {
+			total += snap.Size
+			if total > maxBytes {
+				break
+			}
+			keep[i] = true
+		}
+		return keep
+	})
+}
+
+// KeepDailySnapshots keeps the newest snapshot of each of the last days
+// days, counting today, in UTC.
+func KeepDailySnapshots(days int) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		today := now.UTC().Truncate(24 * time.Hour)
+		first := today.AddDate(0, 0, 1-days)
+		seen := make(map[time.Time]bool)
+		for i, snap := range snapshots {
+			day := snap.Created.UTC().Truncate(24 * time.Hour)
+			if day.Before(first) || seen[day] {
+				continue
+			}
+			seen[day] = true
+			keep[i] = true
+		}
+		return keep
+	})
+}
+
+// RetainAny keeps the snapshots that any of the policies keeps. It returns
+// nil if a policy does not decide on every snapshot.
+func RetainAny(policies ...SnapshotRetentionPolicy) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		for _, policy := range policies {
+			retained := policy.Retain(snapshots, now)
+			if len(retained) != len(snapshots) {
+				return nil
+			}
+			for i, k := range retained {
+				keep[i] = keep[i] || k
+			}
+		}
+		return keep
+	})
+}
+
+// RetainEach applies the policies in turn, each to the snapshots kept by the
+// ones before it, and keeps the snapshots that all of them keep. It returns
+// nil if a policy does not decide on every snapshot.
+func RetainEach(policies ...SnapshotRetentionPolicy) SnapshotRetentionPolicy {
+	return SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		keep := make([]bool, len(snapshots))
+		indexes := make([]int, len(snapshots))
+		for i := range snapshots {
+			keep[i] = true
+			indexes[i] = i
+		}
+		for _, policy := range policies {
+			var candidates []*SnapshotRetentionInfo
+			for _, i := range indexes {
+				candidates = append(candidates, snapshots[i])
+			}
+			retained := policy.Retain(candidates, now)
+			if len(retained) != len(candidates) {
+				return nil
+			}
+			var kept []int
+			for j, k := range retained {
+				if k {
+					kept = append(kept, indexes[j])
+				} else {
+					keep[indexes[j]] = false
+				}
+			}
+			indexes = kept
+		}
+		return keep
+	})
+}
+
+// retentionInfo describes a snapshot in the store to retention policies.
+func (m *fileSnapshotMeta) retentionInfo() *SnapshotRetentionInfo {
+	info := &SnapshotRetentionInfo{
+		ID:      m.ID,
+		Index:   m.Index,
+		Term:    m.Term,
+		Created: m.Created,
+		Size:    m.StoredSize,
+	}
+	if info.Size == 0 {
+		info.Size = m.Size
+	}
+
+	// Snapshots written before the creation time was recorded have it in
+	// their name
+	if info.Created.IsZero() {
+		if i := strings.LastIndex(m.ID, "-"); i >= 0 {
+			if msec, err := strconv.ParseInt(m.ID[i+1:], 10, 64); err == nil {
+				info.Created = time.Unix(0, msec*int64(time.Millisecond))
+			}
+		}
+	}
+	return info
+}
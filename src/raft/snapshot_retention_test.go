@@ -0,0 +1,180 @@
+package raft
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRetentionInfos returns snapshots of the given ages and sizes, newest
+// first.
+func testRetentionInfos(now time.Time, ages []time.Duration, size int64) []*SnapshotRetentionInfo {
+	var infos []*SnapshotRetentionInfo
+	for i, age := range ages {
+		infos = append(infos, &SnapshotRetentionInfo{
+			ID:      snapshotName(1, uint64(100-i), now.Add(-age)),
+			Index:   uint64(100 - i),
+			Term:    1,
+			Created: now.Add(-age),
+			Size:    size,
+		})
+	}
+	return infos
+}
+
+func TestSnapshotRetentionPolicies(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	hours := func(h ...int) []time.Duration {
+		var ages []time.Duration
+		for _, x := range h {
+			ages = append(ages, time.Duration(x)*time.Hour)
+		}
+		return ages
+	}
+	infos := testRetentionInfos(now, hours(0, 1, 6, 13, 20, 30, 50, 80), 100)
+
+	cases := []struct {
+		name   string
+		policy SnapshotRetentionPolicy
+		expect []bool
+	}{
+		{"count", KeepSnapshotCount(3),
+			[]bool{true, true, true, false, false, false, false, false}},
+		{"newer than", KeepSnapshotsNewerThan(13 * time.Hour),
+			[]bool{true, true, true, false, false, false, false, false}},
+		{"bytes", KeepSnapshotBytes(450),
+			[]bool{true, true, true, true, false, false, false, false}},
+		// Days are 03-10 (ages 0-6), 03-09 (13-30), 03-08 (50), 03-07 (80)
+		{"daily", KeepDailySnapshots(3),
+			[]bool{true, false, false, true, false, false, true, false}},
+		{"any", RetainAny(KeepSnapshotCount(2), KeepDailySnapshots(4)),
+			[]bool{true, true, false, true, false, false, true, true}},
+		{"each", RetainEach(RetainAny(KeepSnapshotCount(2), KeepDailySnapshots(4)), KeepSnapshotBytes(300)),
+			[]bool{true, true, false, true, false, false, false, false}},
+		{"each count", RetainEach(KeepDailySnapshots(4), KeepSnapshotCount(2)),
+			[]bool{true, false, false, true, false, false, false, false}},
+	}
+	for _, tc := range cases {
+		if keep := tc.policy.Retain(infos, now); !reflect.DeepEqual(keep, tc.expect) {
+			t.Fatalf("%s: got %v, expected %v", tc.name, keep, tc.expect)
+		}
+	}
+}
+
+func TestFileSnapshotMeta_RetentionInfo(t *testing.T) {
+	created := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	meta := &fileSnapshotMeta{SnapshotMeta: SnapshotMeta{ID: snapshotName(2, 10, created), Size: 50}}
+	info := meta.retentionInfo()
+	if !info.Created.Equal(created) || info.Size != 50 {
+		t.Fatalf("bad info: %#v", info)
+	}
+	meta.StoredSize = 20
+	meta.Created = created.Add(time.Hour)
+	info = meta.retentionInfo()
+	if !info.Created.Equal(meta.Created) || info.Size != 20 {
+		t.Fatalf("bad info: %#v", info)
+	}
+}
+
+func TestFileSS_RetentionPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Keep what fits in 20 bytes
+	snap, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{
+		Logger:    newTestLogger(t),
+		Retention: KeepSnapshotBytes(20),
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, trans := NewInmemTransport(NewInmemAddr())
+	create := func(index uint64, state string) {
+		sink, err := snap.Create(SnapshotVersionMax, index, 3, Configuration{}, 0, 0, nil, nil, trans)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := sink.Write([]byte(state)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	indexes := func() []uint64 {
+		snaps, err := snap.List()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var indexes []uint64
+		for _, s := range snaps {
+			indexes = append(indexes, s.Index)
+		}
+		return indexes
+	}
+
+	for i := uint64(10); i < 15; i++ {
+		create(i, "0123456789")
+	}
+	if got := indexes(); !reflect.DeepEqual(got, []uint64{14, 13}) {
+		t.Fatalf("bad snapshots: %v", got)
+	}
+
+	// The newest snapshot is kept even if it doesn't fit
+	create(15, "0123456789012345678901234")
+	if got := indexes(); !reflect.DeepEqual(got, []uint64{15}) {
+		t.Fatalf("bad snapshots: %v", got)
+	}
+
+	// A store needs a retention
+	if _, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{}); err == nil {
+		t.Fatalf("expected error without retention")
+	}
+}
+
+func TestFileSS_RetentionPolicyBadLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A policy deciding on only the newest snapshot
+	short := SnapshotRetentionFunc(func(snapshots []*SnapshotRetentionInfo, now time.Time) []bool {
+		return []bool{true}
+	})
+	for _, policy := range []SnapshotRetentionPolicy{short, RetainAny(short), RetainEach(KeepSnapshotCount(5), short)} {
+		snap, err := NewFileSnapshotStoreWithConfig(dir, &FileSnapshotStoreConfig{
+			Logger:    newTestLogger(t),
+			Retention: policy,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		_, trans := NewInmemTransport(NewInmemAddr())
+		for i := uint64(10); i < 12; i++ {
+			sink, err := snap.Create(SnapshotVersionMax, i, 3, Configuration{}, 0, 0, nil, nil, trans)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			sink.Close()
+		}
+
+		// Reaping fails rather than guessing
+		if err := snap.ReapSnapshots(); err == nil {
+			t.Fatalf("expected error for a short retention result")
+		}
+		snaps, err := snap.List()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(snaps) < 2 {
+			t.Fatalf("snapshots reaped: %v", snaps)
+		}
+	}
+}
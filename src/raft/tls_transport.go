@@ -0,0 +1,340 @@
+package raft
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPeerIDMismatch is returned when the identity in a peer's certificate
+	// does not match the ServerID expected at the dialed address.
+	ErrPeerIDMismatch = errors.New("peer certificate does not match server ID")
+
+	errNoCACerts = errors.New("no certificates found in CA file")
+)
+
+// TLSConfig configures the certificates used by a TLSStreamLayer. The
+// identity of a certificate is its subject common name, which should be set
+// to the ServerID of the server (or a client name for clients).
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key
+	// presented to peers, both when accepting and when dialing. May be empty
+	// for clients when servers do not require client certificates.
+	CertFile string
+	KeyFile  string
+
+	// CAFile holds PEM encoded certificates used to verify peers. If empty,
+	// the host's root CA set is used.
+	CAFile string
+
+	// RequireClientCert enables mutual authentication: incoming connections
+	// must present a certificate signed by a CA in CAFile.
+	RequireClientCert bool
+
+	// ServerName is used to verify the hostname in dialed servers'
+	// certificates. Defaults to the host of the dialed address.
+	ServerName string
+
+	// PeerID returns the ServerID expected at an address. If it returns true,
+	// dialed servers must present a certificate with that identity.
+	PeerID func(address ServerAddress) (ServerID, bool)
+
+	// AuthorizePeer is called with the identity of every verified peer
+	// certificate, on both accepted and dialed connections. The connection
+	// is rejected if it returns an error.
+	AuthorizePeer func(id ServerID) error
+
+	// ReloadInterval controls how often the certificate files are checked
+	// for changes and reloaded. Zero disables automatic reloading; Reload
+	// can still be called directly.
+	ReloadInterval time.Duration
+}
+
+// TLSStreamLayer implements StreamLayer interface for TLS over TCP.
+// Certificates can be reloaded without closing the listener; existing
+// connections keep the certificates they were established with.
+type TLSStreamLayer struct {
+	advertise net.Addr
+	listener  *net.TCPListener
+	config    *TLSConfig
+
+	// Currently loaded certificate, CA pool and latest file modification time.
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+	// Lock protecting cert, pool and modTime.
+	lock sync.RWMutex
+
+	shutdownCh chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewTLSTransport returns a NetworkTransport that is built on top of
+// a TLS streaming transport layer.
+func NewTLSTransport(
+	bindAddr string,
+	advertise net.Addr,
+	tlsConfig *TLSConfig,
+	maxPool int,
+	timeout time.Duration,
+	logOutput io.Writer,
+) (*NetworkTransport, error) {
+	return newTLSTransport(bindAddr, advertise, tlsConfig, func(stream StreamLayer) *NetworkTransport {
+		return NewNetworkTransport(stream, maxPool, timeout, logOutput)
+	})
+}
+
+// NewTLSTransportWithLogger returns a NetworkTransport that is built on top of
+// a TLS streaming transport layer, with log output going to the supplied Logger
+func NewTLSTransportWithLogger(
+	bindAddr string,
+	advertise net.Addr,
+	tlsConfig *TLSConfig,
+	maxPool int,
+	timeout time.Duration,
+	logger *log.Logger,
+) (*NetworkTransport, error) {
+	return newTLSTransport(bindAddr, advertise, tlsConfig, func(stream StreamLayer) *NetworkTransport {
+		return NewNetworkTransportWithLogger(stream, maxPool, timeout, logger)
+	})
+}
+
+// NewTLSTransportWithConfig returns a NetworkTransport that is built on top of
+// a TLS streaming transport layer, using the given network transport config.
+func NewTLSTransportWithConfig(
+	bindAddr string,
+	advertise net.Addr,
+	tlsConfig *TLSConfig,
+	config *NetworkTransportConfig,
+) (*NetworkTransport, error) {
+	return newTLSTransport(bindAddr, advertise, tlsConfig, func(stream StreamLayer) *NetworkTransport {
+		config.Stream = stream
+		return NewNetworkTransportWithConfig(config)
+	})
+}
+
+func newTLSTransport(bindAddr string,
+	advertise net.Addr,
+	tlsConfig *TLSConfig,
+	transportCreator func(stream StreamLayer) *NetworkTransport) (*NetworkTransport, error) {
+	stream, err := NewTLSStreamLayer(bindAddr, advertise, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return transportCreator(stream), nil
+}
+
+// NewTLSStreamLayer binds to bindAddr and returns a TLS stream layer using
+// the certificates in tlsConfig. Use it with NewNetworkTransport when the
+// caller needs to call Reload directly.
+func NewTLSStreamLayer(bindAddr string, advertise net.Addr, tlsConfig *TLSConfig) (*TLSStreamLayer, error) {
+	stream := &TLSStreamLayer{
+		advertise:  advertise,
+		config:     tlsConfig,
+		shutdownCh: make(chan struct{}),
+	}
+	if err := stream.Reload(); err != nil {
+		return nil, err
+	}
+
+	// Try to bind
+	list, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	stream.listener = list.(*net.TCPListener)
+
+	// Verify that we have a usable advertise address
+	addr, ok := stream.Addr().(*net.TCPAddr)
+	if !ok {
+		list.Close()
+		return nil, errNotTCP
+	}
+	if addr.IP.IsUnspecified() {
+		list.Close()
+		return nil, errNotAdvertisable
+	}
+
+	if tlsConfig.ReloadInterval > 0 {
+		go stream.watch()
+	}
+	return stream, nil
+}
+
+// Reload reads the certificate, key and CA files again. New connections use
+// the reloaded certificates. On error the previous certificates are kept.
+func (t *TLSStreamLayer) Reload() error {
+	modTime, err := t.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if t.config.CertFile != "" || t.config.KeyFile != "" {
+		loaded, err := tls.LoadX509KeyPair(t.config.CertFile, t.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load key pair: %v", err)
+		}
+		cert = &loaded
+	}
+
+	var pool *x509.CertPool
+	if t.config.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errNoCACerts
+		}
+	}
+
+	t.lock.Lock()
+	t.cert = cert
+	t.pool = pool
+	t.modTime = modTime
+	t.lock.Unlock()
+	return nil
+}
+
+// filesModTime returns the latest modification time of the configured files.
+func (t *TLSStreamLayer) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{t.config.CertFile, t.config.KeyFile, t.config.CAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// watch reloads certificates whenever the files change, until closed.
+func (t *TLSStreamLayer) watch() {
+	ticker := time.NewTicker(t.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			modTime, err := t.filesModTime()
+			if err != nil {
+				continue
+			}
+			t.lock.RLock()
+			changed := !modTime.Equal(t.modTime)
+			t.lock.RUnlock()
+			if changed {
+				// Errors keep the previous certificates, and are retried on
+				// the next change.
+				t.Reload()
+			}
+		case <-t.shutdownCh:
+			return
+		}
+	}
+}
+
+// baseConfig returns a tls.Config with the currently loaded certificates.
+func (t *TLSStreamLayer) baseConfig() *tls.Config {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    t.pool,
+		ClientCAs:  t.pool,
+	}
+	if t.cert != nil {
+		conf.Certificates = []tls.Certificate{*t.cert}
+	}
+	return conf
+}
+
+// authorize checks the identity of a verified peer certificate.
+func (t *TLSStreamLayer) authorize(cs tls.ConnectionState, expected ServerID, checkExpected bool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	id := ServerID(cs.PeerCertificates[0].Subject.CommonName)
+	if checkExpected && id != expected {
+		return ErrPeerIDMismatch
+	}
+	if t.config.AuthorizePeer != nil {
+		return t.config.AuthorizePeer(id)
+	}
+	return nil
+}
+
+// Dial implements the StreamLayer interface.
+func (t *TLSStreamLayer) Dial(address ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conf := t.baseConfig()
+	conf.ServerName = t.config.ServerName
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(string(address))
+		if err != nil {
+			return nil, err
+		}
+		conf.ServerName = host
+	}
+	var expected ServerID
+	var checkExpected bool
+	if t.config.PeerID != nil {
+		expected, checkExpected = t.config.PeerID(address)
+	}
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		return t.authorize(cs, expected, checkExpected)
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), conf)
+}
+
+// Accept implements the net.Listener interface. The TLS handshake is
+// performed on the first read or write of the returned connection.
+func (t *TLSStreamLayer) Accept() (c net.Conn, err error) {
+	conn, err := t.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conf := t.baseConfig()
+	if t.config.RequireClientCert {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		return t.authorize(cs, "", false)
+	}
+	return tls.Server(conn, conf), nil
+}
+
+// Close implements the net.Listener interface.
+func (t *TLSStreamLayer) Close() (err error) {
+	t.closeOnce.Do(func() {
+		close(t.shutdownCh)
+	})
+	return t.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (t *TLSStreamLayer) Addr() net.Addr {
+	// Use an advertise addr if provided
+	if t.advertise != nil {
+		return t.advertise
+	}
+	return t.listener.Addr()
+}
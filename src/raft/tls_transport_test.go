@@ -0,0 +1,341 @@
+package raft
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a self-signed certificate authority generated at test time.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ca := &testCA{cert: cert, key: key, dir: dir}
+	writePEM(t, ca.caFile(name), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) caFile(name string) string {
+	return filepath.Join(ca.dir, name+"-ca.pem")
+}
+
+// issue writes a certificate for id signed by the CA, returning the
+// certificate and key file paths.
+func (ca *testCA) issue(t *testing.T, id string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	certFile := filepath.Join(ca.dir, id+".pem")
+	keyFile := filepath.Join(ca.dir, id+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func makeTLSConfig(t *testing.T, ca *testCA, caName string, id string) *TLSConfig {
+	certFile, keyFile := ca.issue(t, id)
+	return &TLSConfig{
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		CAFile:            ca.caFile(caName),
+		RequireClientCert: true,
+	}
+}
+
+// Serve AppendEntries requests on a transport until it is closed.
+func serveAppendEntries(trans *NetworkTransport) {
+	go func() {
+		for rpc := range trans.Consumer() {
+			rpc.Respond(&AppendEntriesResponse{Term: 4, Success: true}, nil)
+		}
+	}()
+}
+
+func TestTLSTransport_MutualAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+
+	trans1, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, makeTLSConfig(t, ca, "ca", "id1"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	serveAppendEntries(trans1)
+
+	conf := makeTLSConfig(t, ca, "ca", "id2")
+	conf.PeerID = func(address ServerAddress) (ServerID, bool) {
+		return "id1", true
+	}
+	trans2, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, conf, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var resp AppendEntriesResponse
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success || resp.Term != 4 {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+}
+
+func TestTLSTransport_RejectUntrusted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other")
+
+	trans1, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, makeTLSConfig(t, ca, "ca", "id1"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	serveAppendEntries(trans1)
+
+	// Client certificate signed by an untrusted CA, trusting the server's CA.
+	conf := makeTLSConfig(t, other, "other", "id2")
+	conf.CAFile = ca.caFile("ca")
+	trans2, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, conf, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	// No client certificate at all.
+	trans3, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, &TLSConfig{CAFile: ca.caFile("ca")}, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	for _, trans := range []*NetworkTransport{trans2, trans3} {
+		var resp AppendEntriesResponse
+		if err := trans.AppendEntries("id1", trans1.LocalAddr(), &args, &resp); err == nil {
+			t.Fatalf("expected untrusted client to be rejected")
+		}
+	}
+}
+
+func TestTLSTransport_PeerID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+
+	trans1, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, makeTLSConfig(t, ca, "ca", "id1"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	serveAppendEntries(trans1)
+
+	conf := makeTLSConfig(t, ca, "ca", "id2")
+	conf.PeerID = func(address ServerAddress) (ServerID, bool) {
+		return "id3", true
+	}
+	trans2, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, conf, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var resp AppendEntriesResponse
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	if err := trans2.AppendEntries("id3", trans1.LocalAddr(), &args, &resp); err == nil {
+		t.Fatalf("expected server ID mismatch")
+	}
+
+	// Server only authorizes known peers.
+	conf = makeTLSConfig(t, ca, "ca", "id4")
+	conf.AuthorizePeer = func(id ServerID) error {
+		if id != "id1" {
+			return fmt.Errorf("unknown peer %v", id)
+		}
+		return nil
+	}
+	trans3, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, conf, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+	serveAppendEntries(trans3)
+	if err := trans2.AppendEntries("id4", trans3.LocalAddr(), &args, &resp); err == nil {
+		t.Fatalf("expected unauthorized peer to be rejected")
+	}
+}
+
+func TestTLSTransport_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+
+	serverConf := makeTLSConfig(t, ca, "ca", "id1")
+	stream, err := NewTLSStreamLayer("127.0.0.1:0", nil, serverConf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	trans1 := NewNetworkTransportWithLogger(stream, 2, time.Second, newTestLogger(t))
+	defer trans1.Close()
+	serveAppendEntries(trans1)
+
+	var seen ServerID
+	clientConf := makeTLSConfig(t, ca, "ca", "id2")
+	clientConf.AuthorizePeer = func(id ServerID) error {
+		seen = id
+		return nil
+	}
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	send := func() {
+		// Use a fresh transport so that a new connection is dialed.
+		trans, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, clientConf, 2, time.Second, newTestLogger(t))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer trans.Close()
+		var resp AppendEntriesResponse
+		if err := trans.AppendEntries("id1", trans1.LocalAddr(), &args, &resp); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	send()
+	if seen != "id1" {
+		t.Fatalf("bad peer: %v", seen)
+	}
+
+	// Replace the server's certificate on disk and reload it.
+	certFile, keyFile := ca.issue(t, "id1-renewed")
+	if err := os.Rename(certFile, serverConf.CertFile); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Rename(keyFile, serverConf.KeyFile); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := stream.Reload(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	send()
+	if seen != "id1-renewed" {
+		t.Fatalf("certificate not reloaded: %v", seen)
+	}
+}
+
+func TestTLSTransport_Session(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+
+	trans1, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, makeTLSConfig(t, ca, "ca", "id1"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	go func() {
+		for rpc := range trans1.Consumer() {
+			if _, ok := rpc.Command.(*ClientIdRequest); !ok {
+				rpc.Respond(nil, fmt.Errorf("unexpected command"))
+				continue
+			}
+			rpc.Respond(&ClientIdResponse{ClientID: 7, Term: 2}, nil)
+		}
+	}()
+
+	clientTrans, err := NewTLSTransportWithLogger("127.0.0.1:0", nil, makeTLSConfig(t, ca, "ca", "client"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer clientTrans.Close()
+
+	session, err := CreateClientSession(clientTrans, []ServerAddress{trans1.LocalAddr()})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if session.clientID != 7 {
+		t.Fatalf("bad client ID: %v", session.clientID)
+	}
+}
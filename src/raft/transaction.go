@@ -0,0 +1,99 @@
+package raft
+
+// Multi-key conditional transactions. A transaction carries a set of read
+// preconditions and a set of writes, and is replicated as a single
+// LogTransaction entry. The FSM validates the preconditions against its
+// current key versions when the entry is applied, so the check and the writes
+// happen atomically at the same point in the log on every server.
+
+// KeyVersion is a precondition on the version of a key. Versions are
+// maintained by the FSM.
+type KeyVersion struct {
+	// Key to check.
+	Key Key
+	// Version the key is expected to be at.
+	Version uint64
+}
+
+// KeyWrite is a write performed by a transaction.
+type KeyWrite struct {
+	// Key to write.
+	Key Key
+	// Value to write to key.
+	Value []byte
+}
+
+// Transaction is a set of writes that is applied only if every read
+// precondition still holds.
+type Transaction struct {
+	// Versions that must match the FSM's versions for the writes to be applied.
+	Reads []KeyVersion
+	// Writes to apply if all reads match.
+	Writes []KeyWrite
+}
+
+// TransactionResponse is the result of applying a Transaction.
+type TransactionResponse struct {
+	// True if all preconditions held and the writes were applied.
+	Committed bool
+	// Keys whose preconditions did not hold. Empty if Committed.
+	Conflicts []Key
+}
+
+// TransactionFSM is implemented by FSMs that support transactions sent with
+// Session.Transaction. Entries of type LogTransaction are passed to
+// ApplyTransaction instead of Apply.
+type TransactionFSM interface {
+	FSM
+
+	// ApplyTransaction is invoked once a transaction is committed, or when
+	// the leader executes it on the fast path. It must check every read
+	// precondition against the current key versions and, only if all of them
+	// hold, apply all writes. Must be deterministic.
+	ApplyTransaction(*Log, *Transaction) *TransactionResponse
+}
+
+// Get all keys read or written by a transaction, used in commutativity
+// checks so that witnesses record every key the transaction touches.
+// Returns: array of keys, without duplicates.
+func (t *Transaction) keys() []Key {
+	seen := make(map[string]bool)
+	var keys []Key
+	add := func(key Key) {
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, read := range t.Reads {
+		add(read.Key)
+	}
+	for _, write := range t.Writes {
+		add(write.Key)
+	}
+	return keys
+}
+
+// Apply a committed or commutative log entry to the user FSM. Commands are
+// passed to Apply and transactions to ApplyTransaction.
+// Params:
+//   - fsm: FSM to apply log entry to.
+//   - log: Log entry to apply, type LogCommand or LogTransaction.
+// Returns: response from the FSM, or ErrBadTransaction if the data of a
+// transaction cannot be decoded.
+func applyToFSM(fsm FSM, log *Log) interface{} {
+	if log.Type != LogTransaction {
+		return fsm.Apply(log)
+	}
+	txnFSM, ok := fsm.(TransactionFSM)
+	if !ok {
+		// Transactions are not supported, so never apply the writes.
+		return &TransactionResponse{Committed: false}
+	}
+	var txn Transaction
+	if err := decodeMsgPack(log.Data, &txn); err != nil {
+		// The entry came from a client, so don't let bad data stop the FSM.
+		return ErrBadTransaction
+	}
+	return txnFSM.ApplyTransaction(log, &txn)
+}
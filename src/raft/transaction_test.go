@@ -0,0 +1,206 @@
+package raft
+
+import (
+	"bytes"
+	"testing"
+)
+
+// versionedFSM is a TransactionFSM that keeps a value and a version for
+// every key, and bumps the version of each key it writes.
+type versionedFSM struct {
+	MockFSM
+	values   map[string][]byte
+	versions map[string]uint64
+}
+
+func newVersionedFSM() *versionedFSM {
+	return &versionedFSM{
+		values:   make(map[string][]byte),
+		versions: make(map[string]uint64),
+	}
+}
+
+func (m *versionedFSM) ApplyTransaction(log *Log, txn *Transaction) *TransactionResponse {
+	m.Lock()
+	defer m.Unlock()
+	resp := &TransactionResponse{}
+	for _, read := range txn.Reads {
+		if m.versions[string(read.Key)] != read.Version {
+			resp.Conflicts = append(resp.Conflicts, read.Key)
+		}
+	}
+	if len(resp.Conflicts) > 0 {
+		return resp
+	}
+	for _, write := range txn.Writes {
+		m.values[string(write.Key)] = write.Value
+		m.versions[string(write.Key)]++
+	}
+	resp.Committed = true
+	return resp
+}
+
+// transactionLog encodes txn into a LogTransaction entry.
+func transactionLog(t *testing.T, txn *Transaction, seqNo uint64) *Log {
+	data, err := encodeMsgPack(txn)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return &Log{
+		Type:     LogTransaction,
+		Data:     data.Bytes(),
+		Keys:     txn.keys(),
+		ClientID: 1,
+		SeqNo:    seqNo,
+	}
+}
+
+func TestTransaction_Keys(t *testing.T) {
+	txn := &Transaction{
+		Reads:  []KeyVersion{{Key: Key("a")}, {Key: Key("b")}},
+		Writes: []KeyWrite{{Key: Key("b")}, {Key: Key("c")}},
+	}
+	keys := txn.keys()
+	if len(keys) != 3 || string(keys[0]) != "a" || string(keys[1]) != "b" || string(keys[2]) != "c" {
+		t.Fatalf("bad keys: %q", keys)
+	}
+}
+
+func TestApplyToFSM_Transaction(t *testing.T) {
+	fsm := newVersionedFSM()
+
+	// All preconditions hold, so every write is applied
+	txn := &Transaction{
+		Reads:  []KeyVersion{{Key: Key("a"), Version: 0}, {Key: Key("b"), Version: 0}},
+		Writes: []KeyWrite{{Key: Key("a"), Value: []byte("1")}, {Key: Key("b"), Value: []byte("1")}},
+	}
+	resp, ok := applyToFSM(fsm, transactionLog(t, txn, 1)).(*TransactionResponse)
+	if !ok || !resp.Committed || len(resp.Conflicts) != 0 {
+		t.Fatalf("bad response: %#v", resp)
+	}
+	if fsm.versions["a"] != 1 || fsm.versions["b"] != 1 {
+		t.Fatalf("bad versions: %v", fsm.versions)
+	}
+
+	// One precondition fails, so no write is applied
+	txn = &Transaction{
+		Reads:  []KeyVersion{{Key: Key("a"), Version: 1}, {Key: Key("b"), Version: 0}},
+		Writes: []KeyWrite{{Key: Key("a"), Value: []byte("2")}, {Key: Key("c"), Value: []byte("2")}},
+	}
+	resp, ok = applyToFSM(fsm, transactionLog(t, txn, 2)).(*TransactionResponse)
+	if !ok || resp.Committed {
+		t.Fatalf("bad response: %#v", resp)
+	}
+	if len(resp.Conflicts) != 1 || string(resp.Conflicts[0]) != "b" {
+		t.Fatalf("bad conflicts: %q", resp.Conflicts)
+	}
+	if !bytes.Equal(fsm.values["a"], []byte("1")) || fsm.versions["a"] != 1 {
+		t.Fatalf("write applied despite conflict: %q", fsm.values["a"])
+	}
+	if _, ok := fsm.values["c"]; ok {
+		t.Fatalf("write applied despite conflict")
+	}
+
+	// Commands are still passed to Apply
+	applyToFSM(fsm, &Log{Type: LogCommand, Data: []byte("cmd")})
+	if len(fsm.logs) != 1 {
+		t.Fatalf("command not applied: %v", fsm.logs)
+	}
+}
+
+func TestApplyToFSM_TransactionUnsupported(t *testing.T) {
+	fsm := &MockFSM{}
+	txn := &Transaction{Writes: []KeyWrite{{Key: Key("a"), Value: []byte("1")}}}
+	resp, ok := applyToFSM(fsm, transactionLog(t, txn, 1)).(*TransactionResponse)
+	if !ok || resp.Committed {
+		t.Fatalf("bad response: %#v", resp)
+	}
+	if len(fsm.logs) != 0 {
+		t.Fatalf("transaction passed to Apply: %v", fsm.logs)
+	}
+}
+
+func TestApplyToFSM_BadTransaction(t *testing.T) {
+	fsm := newVersionedFSM()
+	log := &Log{Type: LogTransaction, Data: []byte("not msgpack"), ClientID: 1, SeqNo: 1}
+	if resp := applyToFSM(fsm, log); resp != ErrBadTransaction {
+		t.Fatalf("expected bad transaction, got: %v", resp)
+	}
+}
+
+func TestSession_TransactionUnsupported(t *testing.T) {
+	c := MakeCluster(1, t, nil)
+	defer c.Close()
+	leader := c.Leader()
+
+	_, trans := NewInmemTransport("")
+	trans.Connect(leader.localAddr, c.trans[c.IndexOf(leader)])
+	session, err := CreateClientSession(trans, []ServerAddress{leader.localAddr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// MockFSM does not implement TransactionFSM
+	var resp TransactionResponse
+	txn := &Transaction{Writes: []KeyWrite{{Key: Key("a"), Value: []byte("1")}}}
+	if err := session.Transaction(txn, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Committed {
+		t.Fatalf("transaction committed without a TransactionFSM")
+	}
+}
+
+func TestRaft_TransactionCommutativity(t *testing.T) {
+	c := MakeCluster(3, t, nil)
+	defer c.Close()
+	leader := c.Leader()
+	follower := c.Followers()[0]
+
+	// Keep the first transaction unsynced at the leader
+	c.Disconnect(leader.localAddr)
+
+	first := transactionLog(t, &Transaction{
+		Reads:  []KeyVersion{{Key: Key("a")}},
+		Writes: []KeyWrite{{Key: Key("b"), Value: []byte("1")}},
+	}, 1)
+	// Conflicts with the first transaction on a key it only reads
+	readConflict := transactionLog(t, &Transaction{
+		Writes: []KeyWrite{{Key: Key("a"), Value: []byte("1")}},
+	}, 2)
+	// Conflicts with the first transaction on a key it only writes
+	writeConflict := transactionLog(t, &Transaction{
+		Reads: []KeyVersion{{Key: Key("b")}},
+	}, 3)
+	disjoint := transactionLog(t, &Transaction{
+		Reads:  []KeyVersion{{Key: Key("c")}},
+		Writes: []KeyWrite{{Key: Key("d"), Value: []byte("1")}},
+	}, 4)
+
+	// The leader executes commutative transactions on the fast path
+	var rpcErr error
+	if _, ok := leader.applyCommutativeCommand(first, &rpcErr); !ok {
+		c.FailNowf("[ERR] first transaction should be commutative")
+	}
+	for _, log := range []*Log{readConflict, writeConflict} {
+		if _, ok := leader.applyCommutativeCommand(log, &rpcErr); ok {
+			c.FailNowf("[ERR] transaction %d should need a sync", log.SeqNo)
+		}
+	}
+	if _, ok := leader.applyCommutativeCommand(disjoint, &rpcErr); !ok {
+		c.FailNowf("[ERR] disjoint transaction should be commutative")
+	}
+
+	// Witnesses record them under every key they read or write
+	if !follower.storeIfCommutative(first) {
+		c.FailNowf("[ERR] witness should record first transaction")
+	}
+	for _, log := range []*Log{readConflict, writeConflict} {
+		if follower.storeIfCommutative(log) {
+			c.FailNowf("[ERR] witness recorded conflicting transaction %d", log.SeqNo)
+		}
+	}
+	if !follower.storeIfCommutative(disjoint) {
+		c.FailNowf("[ERR] witness should record disjoint transaction")
+	}
+}
@@ -67,6 +67,28 @@ type Transport interface {
 	SetHeartbeatHandler(cb func(rpc RPC))
 }
 
+// ClientTransport provides an interface for network transports to allow
+// a client Session to communicate with Raft servers. Each method returns
+// whether the server responded, and the error returned by the server or
+// the transport.
+type ClientTransport interface {
+	// OpenClientConn opens a connection to the target server ahead of
+	// sending requests, if the transport uses connections.
+	OpenClientConn(target ServerAddress) error
+
+	// SendClientIdRequest sends the appropriate RPC to the target server.
+	SendClientIdRequest(target ServerAddress, args *ClientIdRequest, resp *ClientIdResponse) (bool, error)
+
+	// SendClientRequest sends the appropriate RPC to the target server.
+	SendClientRequest(target ServerAddress, args *ClientRequest, resp *ClientResponse) (bool, error)
+
+	// SendRecordRequest sends the appropriate RPC to the target witness.
+	SendRecordRequest(target ServerAddress, args *RecordRequest, resp *RecordResponse) (bool, error)
+
+	// SendSyncRequest sends the appropriate RPC to the target server.
+	SendSyncRequest(target ServerAddress, args *SyncRequest, resp *SyncResponse) (bool, error)
+}
+
 // WithClose is an interface that a transport may provide which
 // allows a transport to be shut down cleanly when a Raft instance
 // shuts down.
@@ -79,6 +101,18 @@ type WithClose interface {
 	Close() error
 }
 
+// WithWitnessHandler is an interface that a transport may provide which
+// allows RecordRequest RPCs to skip the Consumer channel, so that witnesses
+// record client operations without waiting behind RPCs handled by the main
+// thread, such as AppendEntries writing to disk or InstallSnapshot.
+type WithWitnessHandler interface {
+	// SetWitnessHandler is used to setup a handler of RecordRequest RPCs,
+	// called from the goroutine serving the RPC. It must not block for
+	// long. If no handler is set, RecordRequests are pushed onto the
+	// Consumer channel.
+	SetWitnessHandler(cb func(rpc RPC))
+}
+
 // LoopbackTransport is an interface that provides a loopback transport suitable for testing
 // e.g. InmemTransport. It's there so we don't have to rewrite tests.
 type LoopbackTransport interface {
@@ -9,6 +9,7 @@ import (
 
 const (
 	TT_Inmem = iota
+	TT_Grpc
 
 	// NOTE: must be last
 	numTestTransports
@@ -19,6 +20,12 @@ func NewTestTransport(ttype int, addr ServerAddress) (ServerAddress, LoopbackTra
 	case TT_Inmem:
 		addr, lt := NewInmemTransport(addr)
 		return addr, lt
+	case TT_Grpc:
+		trans, err := NewGRPCTransport("127.0.0.1:0", &GRPCTransportConfig{Timeout: time.Second})
+		if err != nil {
+			panic(err)
+		}
+		return trans.LocalAddr(), trans
 	default:
 		panic("Unknown transport type")
 	}
@@ -0,0 +1,468 @@
+// Package transporttest provides a conformance suite which can be run
+// against anything which implements the raft.Transport interface, and the
+// raft.ClientTransport interface if it does. It checks that every RPC type
+// is delivered and answered with requests, responses, headers and errors
+// intact, so that new transports can be checked the same way as the
+// built-in ones.
+package transporttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// rpcTimeout bounds how long a server waits for a request.
+const rpcTimeout = 5 * time.Second
+
+// errConformance is returned by servers to check that errors arrive intact.
+var errConformance = errors.New("transport conformance error")
+
+// Factory creates a transport accepting RPCs. Transports created by the same
+// factory must be able to reach each other at their LocalAddr. Transports
+// that implement raft.WithPeers are connected to each other first, and those
+// that implement raft.WithClose are closed at the end of each check.
+type Factory func(t *testing.T) raft.Transport
+
+// rpcCase describes a request/response RPC.
+type rpcCase struct {
+	name string
+	// Request sent and response returned by the server.
+	req  interface{}
+	resp interface{}
+	// Returns an empty response to decode into.
+	newResp func() interface{}
+	// Sends the request.
+	// Returns: whether the server responded (true if the method does not
+	// report it), and the RPC error.
+	send func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error)
+	// True if the RPC is sent using raft.ClientTransport.
+	client bool
+}
+
+// Conformance runs every check against transports created by factory.
+// Client RPCs are checked only if the transports implement
+// raft.ClientTransport.
+func Conformance(t *testing.T, factory Factory) {
+	for _, respErr := range []error{nil, errConformance} {
+		suffix := ""
+		if respErr != nil {
+			suffix = "_Error"
+		}
+		for _, c := range rpcCases() {
+			c := c
+			respErr := respErr
+			t.Run(c.name+suffix, func(t *testing.T) {
+				checkRPC(t, factory, c, respErr)
+			})
+		}
+		respErr := respErr
+		t.Run("InstallSnapshot"+suffix, func(t *testing.T) {
+			checkInstallSnapshot(t, factory, respErr)
+		})
+	}
+	t.Run("AppendEntriesPipeline", func(t *testing.T) {
+		checkAppendEntriesPipeline(t, factory)
+	})
+	t.Run("EncodeDecodePeer", func(t *testing.T) {
+		checkEncodeDecodePeer(t, factory)
+	})
+	t.Run("WitnessHandler", func(t *testing.T) {
+		checkWitnessHandler(t, factory)
+	})
+}
+
+// header is sent in every request and response.
+func header() raft.RPCHeader {
+	return raft.RPCHeader{ProtocolVersion: raft.ProtocolVersionMax}
+}
+
+func testLog(index uint64) *raft.Log {
+	return &raft.Log{
+		Index:    index,
+		Term:     4,
+		Type:     raft.LogCommand,
+		Data:     []byte("data"),
+		ClientID: 7,
+		SeqNo:    3,
+		Keys:     []raft.Key{raft.Key("key")},
+		Identity: "tenant",
+	}
+}
+
+func appendEntriesRequest(index uint64) *raft.AppendEntriesRequest {
+	return &raft.AppendEntriesRequest{
+		RPCHeader:         header(),
+		Term:              10,
+		Leader:            []byte("cartman"),
+		PrevLogEntry:      index - 1,
+		PrevLogTerm:       4,
+		Entries:           []*raft.Log{testLog(index)},
+		LeaderCommitIndex: 90,
+		CommittedOps:      []raft.ClientSeqNo{{ClientID: 7, SeqNo: 2}},
+	}
+}
+
+func appendEntriesResponse(index uint64) *raft.AppendEntriesResponse {
+	return &raft.AppendEntriesResponse{
+		RPCHeader:      header(),
+		Term:           10,
+		LastLog:        index,
+		Success:        true,
+		NoRetryBackoff: true,
+		WitnessTerm:    3,
+	}
+}
+
+func rpcCases() []rpcCase {
+	return []rpcCase{
+		{
+			name:    "AppendEntries",
+			req:     appendEntriesRequest(101),
+			resp:    appendEntriesResponse(101),
+			newResp: func() interface{} { return new(raft.AppendEntriesResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				return true, trans.AppendEntries("id", target, appendEntriesRequest(101), resp.(*raft.AppendEntriesResponse))
+			},
+		},
+		{
+			name: "RequestVote",
+			req: &raft.RequestVoteRequest{
+				RPCHeader:    header(),
+				Term:         20,
+				Candidate:    []byte("butters"),
+				LastLogIndex: 100,
+				LastLogTerm:  19,
+			},
+			resp: &raft.RequestVoteResponse{
+				RPCHeader: header(),
+				Term:      20,
+				Peers:     []byte("peers"),
+				Granted:   true,
+			},
+			newResp: func() interface{} { return new(raft.RequestVoteResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.RequestVoteRequest{
+					RPCHeader:    header(),
+					Term:         20,
+					Candidate:    []byte("butters"),
+					LastLogIndex: 100,
+					LastLogTerm:  19,
+				}
+				return true, trans.RequestVote("id", target, req, resp.(*raft.RequestVoteResponse))
+			},
+		},
+		{
+			name: "RecoverData",
+			req:  &raft.RecoveryDataRequest{RPCHeader: header()},
+			resp: &raft.RecoveryDataResponse{
+				RPCHeader: header(),
+				Entries:   []raft.Log{*testLog(5), *testLog(6)},
+			},
+			newResp: func() interface{} { return new(raft.RecoveryDataResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.RecoveryDataRequest{RPCHeader: header()}
+				return true, trans.RecoverData("id", target, req, resp.(*raft.RecoveryDataResponse))
+			},
+		},
+		{
+			name:    "UnfreezeWitness",
+			req:     &raft.UnfreezeRequest{RPCHeader: header(), Term: 5},
+			resp:    &raft.UnfreezeResponse{RPCHeader: header()},
+			newResp: func() interface{} { return new(raft.UnfreezeResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.UnfreezeRequest{RPCHeader: header(), Term: 5}
+				return true, trans.UnfreezeWitness("id", target, req, resp.(*raft.UnfreezeResponse))
+			},
+		},
+		{
+			name: "ClientIdRequest",
+			req:  &raft.ClientIdRequest{RPCHeader: header(), Credentials: []byte("token")},
+			resp: &raft.ClientIdResponse{
+				RPCHeader:     header(),
+				ClientID:      7,
+				LeaderAddress: "leader",
+				Term:          2,
+			},
+			newResp: func() interface{} { return new(raft.ClientIdResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.ClientIdRequest{RPCHeader: header(), Credentials: []byte("token")}
+				return trans.(raft.ClientTransport).SendClientIdRequest(target, req, resp.(*raft.ClientIdResponse))
+			},
+			client: true,
+		},
+		{
+			name: "ClientRequest",
+			req:  &raft.ClientRequest{RPCHeader: header(), Entry: testLog(0), Credentials: []byte("token")},
+			resp: &raft.ClientResponse{
+				RPCHeader:     header(),
+				Success:       true,
+				LeaderAddress: "leader",
+				ResponseData:  []byte("result"),
+				Synced:        true,
+				Term:          2,
+			},
+			newResp: func() interface{} { return new(raft.ClientResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.ClientRequest{RPCHeader: header(), Entry: testLog(0), Credentials: []byte("token")}
+				return trans.(raft.ClientTransport).SendClientRequest(target, req, resp.(*raft.ClientResponse))
+			},
+			client: true,
+		},
+		{
+			name: "RecordRequest",
+			req: &raft.RecordRequest{
+				RPCHeader:     header(),
+				Entry:         testLog(0),
+				Term:          2,
+				LeaderAddress: "leader",
+			},
+			resp: &raft.RecordResponse{
+				RPCHeader:     header(),
+				Success:       true,
+				Term:          2,
+				LeaderAddress: "leader",
+			},
+			newResp: func() interface{} { return new(raft.RecordResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.RecordRequest{
+					RPCHeader:     header(),
+					Entry:         testLog(0),
+					Term:          2,
+					LeaderAddress: "leader",
+				}
+				return trans.(raft.ClientTransport).SendRecordRequest(target, req, resp.(*raft.RecordResponse))
+			},
+			client: true,
+		},
+		{
+			name: "SyncRequest",
+			req:  &raft.SyncRequest{RPCHeader: header(), Entry: testLog(0)},
+			resp: &raft.SyncResponse{
+				RPCHeader:     header(),
+				Success:       true,
+				LeaderAddress: "leader",
+				ResponseData:  []byte("result"),
+			},
+			newResp: func() interface{} { return new(raft.SyncResponse) },
+			send: func(trans raft.Transport, target raft.ServerAddress, resp interface{}) (bool, error) {
+				req := &raft.SyncRequest{RPCHeader: header(), Entry: testLog(0)}
+				return trans.(raft.ClientTransport).SendSyncRequest(target, req, resp.(*raft.SyncResponse))
+			},
+			client: true,
+		},
+	}
+}
+
+// newPair creates a server and a client transport connected to each other.
+// Returns: the server, the client, and a function closing both.
+func newPair(t *testing.T, factory Factory) (raft.Transport, raft.Transport, func()) {
+	server := factory(t)
+	client := factory(t)
+	if peers, ok := server.(raft.WithPeers); ok {
+		peers.Connect(client.LocalAddr(), client)
+	}
+	if peers, ok := client.(raft.WithPeers); ok {
+		peers.Connect(server.LocalAddr(), server)
+	}
+	return server, client, func() {
+		for _, trans := range []raft.Transport{client, server} {
+			if closer, ok := trans.(raft.WithClose); ok {
+				closer.Close()
+			}
+		}
+	}
+}
+
+// serve answers RPCs on trans with resp and respErr, checking each command
+// against the next request in reqs and the snapshot data read against data.
+func serve(t *testing.T, trans raft.Transport, reqs []interface{}, resps []interface{}, respErrs []error, data []byte) {
+	go func() {
+		for i, req := range reqs {
+			select {
+			case rpc := <-trans.Consumer():
+				if !reflect.DeepEqual(rpc.Command, req) {
+					t.Errorf("command mismatch: %#v %#v", rpc.Command, req)
+				}
+				if data != nil {
+					received, err := ioutil.ReadAll(rpc.Reader)
+					if err != nil {
+						t.Errorf("failed to read snapshot: %v", err)
+					} else if !bytes.Equal(received, data) {
+						t.Errorf("snapshot mismatch: read %d bytes, sent %d", len(received), len(data))
+					}
+				}
+				rpc.Respond(resps[i], respErrs[i])
+			case <-time.After(rpcTimeout):
+				t.Errorf("timeout waiting for %T", req)
+				return
+			}
+		}
+	}()
+}
+
+// checkResult checks that the response and error received match those the
+// server sent.
+func checkResult(t *testing.T, got interface{}, gotErr error, want interface{}, wantErr error) {
+	if wantErr == nil && gotErr != nil {
+		t.Fatalf("err: %v", gotErr)
+	}
+	if wantErr != nil && (gotErr == nil || gotErr.Error() != wantErr.Error()) {
+		t.Fatalf("error mismatch: %v %v", gotErr, wantErr)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("response mismatch: %#v %#v", got, want)
+	}
+}
+
+func checkRPC(t *testing.T, factory Factory, c rpcCase, respErr error) {
+	server, client, closeFn := newPair(t, factory)
+	defer closeFn()
+	if c.client {
+		clientTrans, ok := client.(raft.ClientTransport)
+		if !ok {
+			t.Skipf("%T does not implement raft.ClientTransport", client)
+		}
+		if err := clientTrans.OpenClientConn(server.LocalAddr()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	serve(t, server, []interface{}{c.req}, []interface{}{c.resp}, []error{respErr}, nil)
+	resp := c.newResp()
+	responded, err := c.send(client, server.LocalAddr(), resp)
+	if !responded {
+		t.Fatalf("server response not reported: %v", err)
+	}
+	checkResult(t, resp, err, c.resp, respErr)
+}
+
+func checkInstallSnapshot(t *testing.T, factory Factory, respErr error) {
+	server, client, closeFn := newPair(t, factory)
+	defer closeFn()
+
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+	newReq := func() *raft.InstallSnapshotRequest {
+		return &raft.InstallSnapshotRequest{
+			RPCHeader:          header(),
+			SnapshotVersion:    raft.SnapshotVersionMax,
+			Term:               10,
+			Leader:             []byte("kyle"),
+			LastLogIndex:       100,
+			LastLogTerm:        9,
+			Peers:              []byte("peers"),
+			Configuration:      []byte("configuration"),
+			ConfigurationIndex: 50,
+			Size:               int64(len(data)),
+		}
+	}
+	want := &raft.InstallSnapshotResponse{RPCHeader: header(), Term: 10, Success: true}
+
+	serve(t, server, []interface{}{newReq()}, []interface{}{want}, []error{respErr}, data)
+	resp := new(raft.InstallSnapshotResponse)
+	err := client.InstallSnapshot("id", server.LocalAddr(), newReq(), resp, bytes.NewReader(data))
+	checkResult(t, resp, err, want, respErr)
+}
+
+func checkAppendEntriesPipeline(t *testing.T, factory Factory) {
+	server, client, closeFn := newPair(t, factory)
+	defer closeFn()
+
+	// Odd requests are answered with an error
+	const count = 10
+	var reqs, resps []interface{}
+	var respErrs []error
+	for i := 0; i < count; i++ {
+		reqs = append(reqs, appendEntriesRequest(uint64(100+i)))
+		resps = append(resps, appendEntriesResponse(uint64(100+i)))
+		var respErr error
+		if i%2 == 1 {
+			respErr = fmt.Errorf("%v %d", errConformance, i)
+		}
+		respErrs = append(respErrs, respErr)
+	}
+	serve(t, server, reqs, resps, respErrs, nil)
+
+	pipeline, err := client.AppendEntriesPipeline("id", server.LocalAddr())
+	if err == raft.ErrPipelineReplicationNotSupported {
+		t.Skipf("%T does not support pipelining", client)
+	}
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer pipeline.Close()
+	for i := 0; i < count; i++ {
+		if _, err := pipeline.AppendEntries(appendEntriesRequest(uint64(100+i)), new(raft.AppendEntriesResponse)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Responses arrive in order
+	for i := 0; i < count; i++ {
+		select {
+		case future := <-pipeline.Consumer():
+			checkResult(t, future.Response(), future.Error(), resps[i], respErrs[i])
+		case <-time.After(rpcTimeout):
+			t.Fatalf("timeout waiting for response %d", i)
+		}
+	}
+}
+
+func checkWitnessHandler(t *testing.T, factory Factory) {
+	server, client, closeFn := newPair(t, factory)
+	defer closeFn()
+	witness, ok := server.(raft.WithWitnessHandler)
+	if !ok {
+		t.Skipf("%T does not implement raft.WithWitnessHandler", server)
+	}
+	clientTrans, ok := client.(raft.ClientTransport)
+	if !ok {
+		t.Skipf("%T does not implement raft.ClientTransport", client)
+	}
+	if err := clientTrans.OpenClientConn(server.LocalAddr()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	newReq := func() *raft.RecordRequest {
+		return &raft.RecordRequest{
+			RPCHeader:     header(),
+			Entry:         testLog(0),
+			Term:          2,
+			LeaderAddress: "leader",
+		}
+	}
+	want := &raft.RecordResponse{RPCHeader: header(), Success: true, Term: 2}
+	witness.SetWitnessHandler(func(rpc raft.RPC) {
+		if !reflect.DeepEqual(rpc.Command, newReq()) {
+			t.Errorf("command mismatch: %#v", rpc.Command)
+		}
+		rpc.Respond(want, nil)
+	})
+
+	// Records skip the consumer
+	resp := new(raft.RecordResponse)
+	_, err := clientTrans.SendRecordRequest(server.LocalAddr(), newReq(), resp)
+	checkResult(t, resp, err, want, nil)
+	select {
+	case rpc := <-server.Consumer():
+		t.Fatalf("unexpected command on consumer: %#v", rpc.Command)
+	default:
+	}
+}
+
+func checkEncodeDecodePeer(t *testing.T, factory Factory) {
+	trans := factory(t)
+	if closer, ok := trans.(raft.WithClose); ok {
+		defer closer.Close()
+	}
+	local := trans.LocalAddr()
+	if decoded := trans.DecodePeer(trans.EncodePeer("id", local)); decoded != local {
+		t.Fatalf("enc/dec fail: %v %v", decoded, local)
+	}
+}
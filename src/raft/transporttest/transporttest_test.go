@@ -0,0 +1,57 @@
+package transporttest
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestInmemTransport(t *testing.T) {
+	Conformance(t, func(t *testing.T) raft.Transport {
+		_, trans := raft.NewInmemTransport("")
+		return trans
+	})
+}
+
+func TestNetworkTransport(t *testing.T) {
+	Conformance(t, func(t *testing.T) raft.Transport {
+		trans, err := raft.NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, ioutil.Discard)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return trans
+	})
+}
+
+func TestNetworkTransport_Multiplex(t *testing.T) {
+	Conformance(t, func(t *testing.T) raft.Transport {
+		config := &raft.NetworkTransportConfig{
+			MaxPool:     2,
+			Timeout:     time.Second,
+			Logger:      log.New(ioutil.Discard, "", 0),
+			Multiplex:   true,
+			Compression: raft.CompressionFlate,
+		}
+		trans, err := raft.NewTCPTransportWithConfig("127.0.0.1:0", nil, config)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return trans
+	})
+}
+
+func TestGRPCTransport(t *testing.T) {
+	Conformance(t, func(t *testing.T) raft.Transport {
+		trans, err := raft.NewGRPCTransport("127.0.0.1:0", &raft.GRPCTransportConfig{
+			Logger:  log.New(ioutil.Discard, "", 0),
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return trans
+	})
+}
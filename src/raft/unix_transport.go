@@ -0,0 +1,99 @@
+package raft
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// UnixStreamLayer implements StreamLayer interface over Unix domain sockets.
+// Server addresses are socket paths. Used for servers and clients on the
+// same host, avoiding the cost of the TCP stack.
+type UnixStreamLayer struct {
+	listener *net.UnixListener
+}
+
+// NewUnixTransport returns a NetworkTransport that is built on top of
+// a Unix domain socket streaming transport layer listening at path.
+func NewUnixTransport(
+	path string,
+	maxPool int,
+	timeout time.Duration,
+	logOutput io.Writer,
+) (*NetworkTransport, error) {
+	return newUnixTransport(path, func(stream StreamLayer) *NetworkTransport {
+		return NewNetworkTransport(stream, maxPool, timeout, logOutput)
+	})
+}
+
+// NewUnixTransportWithLogger returns a NetworkTransport that is built on top of
+// a Unix domain socket streaming transport layer, with log output going to the
+// supplied Logger
+func NewUnixTransportWithLogger(
+	path string,
+	maxPool int,
+	timeout time.Duration,
+	logger *log.Logger,
+) (*NetworkTransport, error) {
+	return newUnixTransport(path, func(stream StreamLayer) *NetworkTransport {
+		return NewNetworkTransportWithLogger(stream, maxPool, timeout, logger)
+	})
+}
+
+// NewUnixTransportWithConfig returns a NetworkTransport that is built on top of
+// a Unix domain socket streaming transport layer, using the given network
+// transport config.
+func NewUnixTransportWithConfig(
+	path string,
+	config *NetworkTransportConfig,
+) (*NetworkTransport, error) {
+	return newUnixTransport(path, func(stream StreamLayer) *NetworkTransport {
+		config.Stream = stream
+		return NewNetworkTransportWithConfig(config)
+	})
+}
+
+func newUnixTransport(path string,
+	transportCreator func(stream StreamLayer) *NetworkTransport) (*NetworkTransport, error) {
+	// Remove a socket left behind by a previous process at this path.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	// Try to bind
+	list, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create stream
+	stream := &UnixStreamLayer{
+		listener: list,
+	}
+
+	// Create the network transport
+	trans := transportCreator(stream)
+	return trans, nil
+}
+
+// Dial implements the StreamLayer interface.
+func (u *UnixStreamLayer) Dial(address ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", string(address), timeout)
+}
+
+// Accept implements the net.Listener interface.
+func (u *UnixStreamLayer) Accept() (c net.Conn, err error) {
+	return u.listener.Accept()
+}
+
+// Close implements the net.Listener interface. The socket file is removed.
+func (u *UnixStreamLayer) Close() (err error) {
+	return u.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (u *UnixStreamLayer) Addr() net.Addr {
+	return u.listener.Addr()
+}
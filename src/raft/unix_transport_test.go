@@ -0,0 +1,70 @@
+package raft
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixTransport_AppendEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.sock")
+	trans1, err := NewUnixTransportWithLogger(path, 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	if trans1.LocalAddr() != ServerAddress(path) {
+		t.Fatalf("bad: %v", trans1.LocalAddr())
+	}
+	serveAppendEntries(trans1)
+
+	trans2, err := NewUnixTransportWithLogger(filepath.Join(dir, "client.sock"), 2, time.Second, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var resp AppendEntriesResponse
+	args := AppendEntriesRequest{Term: 10, Leader: []byte("cartman")}
+	if err := trans2.AppendEntries("id1", trans1.LocalAddr(), &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.Success || resp.Term != 4 {
+		t.Fatalf("bad resp: %#v", resp)
+	}
+}
+
+func TestUnixTransport_StaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Leave a socket file behind, as a crashed process would.
+	path := filepath.Join(dir, "server.sock")
+	list, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	list.SetUnlinkOnClose(false)
+	list.Close()
+
+	trans, err := NewUnixTransport(path, 2, time.Second, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	trans.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket should be removed on close: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package raft
+
+import (
+	"sync"
+)
+
+// Tracks operations the leader has executed on the CURP fast path but that
+// have not yet committed. Used by the leader to decide commutativity without
+// touching its witness state in stable storage. Entries are added when a
+// commutative command is applied locally and removed once it commits.
+
+// unsyncedOps is the leader's in-memory set of unsynced operations.
+type unsyncedOps struct {
+	// Unsynced operations by client ID and sequence number.
+	ops map[ClientSeqNo][]Key
+	// Operation holding each key.
+	keys map[string]ClientSeqNo
+	// Closed and replaced whenever operations are removed.
+	removedCh chan struct{}
+	// Lock protecting ops, keys and removedCh.
+	lock sync.Mutex
+}
+
+// Create empty set of unsynced operations.
+func newUnsyncedOps() *unsyncedOps {
+	return &unsyncedOps{
+		ops:       make(map[ClientSeqNo][]Key),
+		keys:      make(map[string]ClientSeqNo),
+		removedCh: make(chan struct{}),
+	}
+}
+
+// Add an operation if it is commutative with all unsynced operations.
+// Params:
+//   - log: Log entry to add.
+// Returns: true if added, false if it shares a key with an unsynced operation.
+func (u *unsyncedOps) addIfCommutative(log *Log) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, key := range log.Keys {
+		if _, ok := u.keys[string(key)]; ok {
+			return false
+		}
+	}
+	clientSeqNo := ClientSeqNo{
+		ClientID: log.ClientID,
+		SeqNo:    log.SeqNo,
+	}
+	for _, key := range log.Keys {
+		u.keys[string(key)] = clientSeqNo
+	}
+	u.ops[clientSeqNo] = log.Keys
+	return true
+}
+
+// Check if any of a set of keys is held by an unsynced operation.
+// Params:
+//   - keys: keys to check.
+// Returns: true if all keys are synced.
+func (u *unsyncedOps) synced(keys []Key) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, key := range keys {
+		if _, ok := u.keys[string(key)]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove an operation once it has committed. Does nothing if the operation
+// is not unsynced.
+// Params:
+//   - clientSeqNo: client ID and sequence number of committed operation.
+func (u *unsyncedOps) remove(clientSeqNo ClientSeqNo) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	keys, ok := u.ops[clientSeqNo]
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		if u.keys[string(key)] == clientSeqNo {
+			delete(u.keys, string(key))
+		}
+	}
+	delete(u.ops, clientSeqNo)
+	u.notifyRemoved()
+}
+
+// Remove all operations. Used when leadership is lost.
+func (u *unsyncedOps) clear() {
+	u.lock.Lock()
+	u.ops = make(map[ClientSeqNo][]Key)
+	u.keys = make(map[string]ClientSeqNo)
+	u.notifyRemoved()
+	u.lock.Unlock()
+}
+
+// Wake up goroutines waiting for operations to be removed. Must be called
+// with lock held.
+func (u *unsyncedOps) notifyRemoved() {
+	close(u.removedCh)
+	u.removedCh = make(chan struct{})
+}
+
+// Wait until every operation that is unsynced at the time of the call has
+// been removed. Operations added after the call are not waited for.
+// Params:
+//   - stopCh: channel that aborts the wait when closed.
+// Returns: true if all operations were synced, false if aborted.
+func (u *unsyncedOps) waitSynced(stopCh chan struct{}) bool {
+	u.lock.Lock()
+	pending := make([]ClientSeqNo, 0, len(u.ops))
+	for clientSeqNo := range u.ops {
+		pending = append(pending, clientSeqNo)
+	}
+	u.lock.Unlock()
+
+	for {
+		u.lock.Lock()
+		for len(pending) > 0 {
+			if _, ok := u.ops[pending[0]]; !ok {
+				pending = pending[1:]
+				continue
+			}
+			break
+		}
+		removedCh := u.removedCh
+		u.lock.Unlock()
+		if len(pending) == 0 {
+			return true
+		}
+
+		select {
+		case <-removedCh:
+		case <-stopCh:
+			return false
+		}
+	}
+}
+
+// Number of unsynced operations.
+func (u *unsyncedOps) len() int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return len(u.ops)
+}
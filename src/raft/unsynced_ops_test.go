@@ -0,0 +1,118 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnsyncedOps_AddIfCommutative(t *testing.T) {
+	u := newUnsyncedOps()
+	a := &Log{ClientID: 1, SeqNo: 1, Keys: []Key{Key("a"), Key("b")}}
+	b := &Log{ClientID: 1, SeqNo: 2, Keys: []Key{Key("b")}}
+	c := &Log{ClientID: 2, SeqNo: 1, Keys: []Key{Key("c")}}
+
+	if !u.addIfCommutative(a) {
+		t.Fatalf("should add to empty set")
+	}
+	if u.addIfCommutative(b) {
+		t.Fatalf("should not add op sharing key b")
+	}
+	if !u.addIfCommutative(c) {
+		t.Fatalf("should add op with disjoint keys")
+	}
+	if u.len() != 2 {
+		t.Fatalf("expected 2 unsynced ops, got %d", u.len())
+	}
+	if u.synced([]Key{Key("a")}) {
+		t.Fatalf("key a should be unsynced")
+	}
+	if !u.synced([]Key{Key("d")}) {
+		t.Fatalf("key d should be synced")
+	}
+}
+
+func TestUnsyncedOps_Remove(t *testing.T) {
+	u := newUnsyncedOps()
+	a := &Log{ClientID: 1, SeqNo: 1, Keys: []Key{Key("a"), Key("a")}}
+	if !u.addIfCommutative(a) {
+		t.Fatalf("should add to empty set")
+	}
+
+	// Removing an unknown op is a no-op.
+	u.remove(ClientSeqNo{ClientID: 3, SeqNo: 3})
+	if u.synced([]Key{Key("a")}) {
+		t.Fatalf("key a should still be unsynced")
+	}
+
+	u.remove(ClientSeqNo{ClientID: 1, SeqNo: 1})
+	if !u.synced([]Key{Key("a")}) || u.len() != 0 {
+		t.Fatalf("op should be removed")
+	}
+	b := &Log{ClientID: 1, SeqNo: 2, Keys: []Key{Key("a")}}
+	if !u.addIfCommutative(b) {
+		t.Fatalf("should add once conflicting op committed")
+	}
+
+	u.clear()
+	if u.len() != 0 || !u.synced([]Key{Key("a")}) {
+		t.Fatalf("clear should remove all ops")
+	}
+}
+
+func TestUnsyncedOps_WaitSynced(t *testing.T) {
+	u := newUnsyncedOps()
+	a := &Log{ClientID: 1, SeqNo: 1, Keys: []Key{Key("a")}}
+	b := &Log{ClientID: 1, SeqNo: 2, Keys: []Key{Key("b")}}
+	u.addIfCommutative(a)
+
+	doneCh := make(chan bool, 1)
+	go func() {
+		doneCh <- u.waitSynced(make(chan struct{}))
+	}()
+
+	select {
+	case <-doneCh:
+		t.Fatalf("should wait for op a")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Operations added after the wait started are not waited for.
+	u.addIfCommutative(b)
+
+	u.remove(ClientSeqNo{ClientID: 1, SeqNo: 1})
+	select {
+	case ok := <-doneCh:
+		if !ok {
+			t.Fatalf("wait should succeed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for sync")
+	}
+
+	// Aborted by stopCh.
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if u.waitSynced(stopCh) {
+		t.Fatalf("wait should be aborted")
+	}
+}
+
+func TestRaft_CommutativeCommandNotCommitted(t *testing.T) {
+	c := MakeCluster(3, t, nil)
+	defer c.Close()
+
+	// A follower cannot commit the command, so its keys are released
+	follower := c.Followers()[0]
+	log := &Log{Type: LogCommand, Data: []byte("a"), ClientID: 1, SeqNo: 1, Keys: []Key{Key("a")}}
+	var rpcErr error
+	if _, ok := follower.applyCommutativeCommand(log, &rpcErr); !ok {
+		c.FailNowf("[ERR] command should be commutative")
+	}
+	deadline := time.Now().Add(time.Second)
+	for !follower.unsyncedOps.synced([]Key{Key("a")}) {
+		if time.Now().After(deadline) {
+			c.FailNowf("[ERR] keys of the failed command still unsynced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -12,11 +12,12 @@ import (
 
 // Arguments:
 //   - config: path name of config
-//   - addr: IP address
+//   - addr: IP address, or socket path if transport is unix
 //   - comm: x/100 requests are commutative
 //   - n: number of total requests
 //   - t: number of threads
 //   - parallel: are requests parallelized?
+//   - transport: tcp or unix, addresses in config are socket paths for unix
 // Prints all latencies to stdout in microseconds, 1 per line
 func main() {
     addrPtr := flag.String("addr", "127.0.0.1", "IP address and port number of client")
@@ -24,6 +25,7 @@ func main() {
     commPercentPtr := flag.Int("comm", 100, "x/100 requests are commutative")
     nPtr := flag.Int("n", 100, "total number of requests")
     parallelPtr := flag.Bool("parallel", false, "true if requests are parallelized, false if serial")
+    transportPtr := flag.String("transport", "tcp", "Transport to use: tcp or unix")
 
     flag.Parse()
 
@@ -37,7 +39,7 @@ func main() {
     results := make(chan int64, n)
 
     start := time.Now()
-    go runClient(n, *commPercentPtr, *addrPtr, servers, *parallelPtr, &results)
+    go runClient(n, *commPercentPtr, *addrPtr, servers, *parallelPtr, *transportPtr, &results)
 
     resultList := make([]int64, n)
     for i := 0; i < n; i++ {
@@ -57,10 +59,10 @@ func main() {
 
 }
 
-func runClient(n int, commPercent int, addr string, servers []raft.ServerAddress, parallel bool, results *chan int64) {
-    trans, err := raft.NewTCPTransport(addr, nil, 2, time.Second, nil)
+func runClient(n int, commPercent int, addr string, servers []raft.ServerAddress, parallel bool, transport string, results *chan int64) {
+    trans, err := keyValStore.NewTransport(transport, addr)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error creating TCP transport: %s", err)
+        fmt.Fprintf(os.Stderr, "Error creating %s transport: %s", transport, err)
         return
     }
 
@@ -12,9 +12,11 @@ import(
 // Arguments:
 //   - i: replica number
 //   - config: path name of config
+//   - transport: tcp or unix, addresses in config are socket paths for unix
 func main() {
     configPathPtr := flag.String("config", "config", "Path to config file")
     iPtr := flag.Int("i", 0, "Replica number, indexed by line in config file")
+    transportPtr := flag.String("transport", "tcp", "Transport to use: tcp or unix")
 
     flag.Parse()
 
@@ -28,7 +30,7 @@ func main() {
     }
 
     servers := config.Servers
-    keyValStore.StartNode(keyValStore.CreateWorkers(1)[0], servers, i)
+    keyValStore.StartNodeWithTransport(keyValStore.CreateWorkers(1)[0], servers, i, *transportPtr)
 
     // Wait for CTRL-C
     c := make(chan os.Signal, 1)
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "test/keyValStore"
+    "raft"
+    "fmt"
+    "time"
+    "test/utils"
+)
+
+// Tests multi-key compare-and-swap transactions: a CAS with current versions
+// applies all writes, and a CAS with a stale version applies none of them.
+
+var c *keyValStore.Client
+
+func main() {
+    trans, err := raft.NewTCPTransport("127.0.0.1:5000", nil, 2, time.Second, nil)
+    if err != nil {
+        fmt.Println("Error with creating TCP transport: ", err)
+        return
+    }
+    servers := []raft.ServerAddress{"127.0.0.1:8000","127.0.0.1:8001","127.0.0.1:8002"}
+    c, err = keyValStore.CreateClient(trans, servers)
+    if err != nil {
+        fmt.Println("Can't create client session", err)
+        return
+    }
+
+    testsFailed := utils.RunTestSuite(testCasSuccess, testCasConflict)
+    fmt.Println(testsFailed)
+}
+
+func testCasSuccess() (error) {
+    c.Set("casA", "a1")
+    c.Set("casB", "b1")
+    _, versionA, errA := c.GetWithVersion("casA")
+    _, versionB, errB := c.GetWithVersion("casB")
+    if errA != nil || errB != nil {
+        return fmt.Errorf("Error sending Get RPC: %v %v", errA, errB)
+    }
+    reads := map[string]uint64{"casA": versionA, "casB": versionB}
+    writes := map[string]string{"casA": "a2", "casB": "b2"}
+    committed, err := c.CompareAndSwap(reads, writes)
+    if err != nil {
+        return fmt.Errorf("Error sending CAS: %v", err)
+    }
+    if !committed {
+        return fmt.Errorf("CAS with current versions was not committed")
+    }
+    valA, _ := c.Get("casA")
+    valB, _ := c.Get("casB")
+    if valA != "a2" || valB != "b2" {
+        return fmt.Errorf("Expected a2, b2 after CAS but got %v, %v", valA, valB)
+    }
+    return nil
+}
+
+func testCasConflict() (error) {
+    c.Set("casC", "c1")
+    c.Set("casD", "d1")
+    _, versionC, _ := c.GetWithVersion("casC")
+    _, versionD, _ := c.GetWithVersion("casD")
+    // Concurrent writer bumps version of casD.
+    c.Set("casD", "d2")
+    reads := map[string]uint64{"casC": versionC, "casD": versionD}
+    writes := map[string]string{"casC": "c3", "casD": "d3"}
+    committed, err := c.CompareAndSwap(reads, writes)
+    if err != nil {
+        return fmt.Errorf("Error sending CAS: %v", err)
+    }
+    if committed {
+        return fmt.Errorf("CAS with stale version was committed")
+    }
+    valC, _ := c.Get("casC")
+    valD, _ := c.Get("casD")
+    if valC != "c1" || valD != "d2" {
+        return fmt.Errorf("Expected c1, d2 after failed CAS but got %v, %v", valC, valD)
+    }
+    return nil
+}
@@ -11,6 +11,7 @@ echo ""
 sleep .1
 FAILED=$(go run sanity_check.go)
 FAILED=$(expr $(go run rifl_client.go) + $FAILED)
+FAILED=$(expr $(go run cas_client.go) + $FAILED)
 CLUSTER_JOB=$(ps aux | grep "run_cluster" | grep -v grep | awk '{print $2}') &> /dev/null
 kill $CLUSTER_JOB &> /dev/null
 wait $CLUSTER_JOB &> /dev/null
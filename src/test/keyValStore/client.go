@@ -51,7 +51,9 @@ func (c *Client) Inc() (uint64, error) {
     }
     resp := raft.ClientResponse{}
     keys := []raft.Key{raft.Key([]byte{1})}
-    c.session.SendFastRequest(data, keys, &resp)
+    if err := c.session.SendFastRequest(data, keys, &resp); err != nil {
+        return 0, err
+    }
     var response IncResponse
     recvErr := json.Unmarshal(resp.ResponseData, &response)
     if recvErr != nil {
@@ -74,7 +76,9 @@ func (c *Client) IncWithSeqno(seqno uint64) (uint64, error) {
     }
     resp := raft.ClientResponse{}
     keys := []raft.Key{raft.Key([]byte{1})}
-    c.session.SendFastRequestWithSeqNo(data, keys, &resp, seqno)
+    if err := c.session.SendFastRequestWithSeqNo(data, keys, &resp, seqno); err != nil {
+        return 0, err
+    }
     var response IncResponse
     recvErr := json.Unmarshal(resp.ResponseData, &response)
     if recvErr != nil {
@@ -98,8 +102,7 @@ func (c *Client) Set(key string, value string) error {
         return marshal_err
     }
     keys := []raft.Key{raft.Key([]byte(key))}
-    c.session.SendFastRequest(data, keys, &raft.ClientResponse{})
-    return nil
+    return c.session.SendFastRequest(data, keys, &raft.ClientResponse{})
 }
 
 // Send RPC to get the value of a key. 
@@ -107,20 +110,53 @@ func (c *Client) Set(key string, value string) error {
 //   - key: Key to get value of.
 // Returns: value of key, empty string if error not nil.
 func (c *Client) Get(key string) (string, error) {
+    value, _, err := c.GetWithVersion(key)
+    return value, err
+}
+
+// Send RPC to get the value and version of a key. Use the version as a
+// precondition in CompareAndSwap.
+// Params:
+//   - key: Key to get value of.
+// Returns: value and version of key, empty string and 0 if error not nil.
+func (c *Client) GetWithVersion(key string) (string, uint64, error) {
     args := make(map[string]string)
     args[FunctionArg] = GetCommand
     args[KeyArg] = key
     data, marshal_err := json.Marshal(args)
     if marshal_err != nil {
-        return "", marshal_err
+        return "", 0, marshal_err
     }
     resp := raft.ClientResponse{}
     keys := []raft.Key{raft.Key([]byte(key))}
-    c.session.SendFastRequest(data, keys, &resp)
+    if err := c.session.SendFastRequest(data, keys, &resp); err != nil {
+        return "", 0, err
+    }
     var response GetResponse
     recvErr := json.Unmarshal(resp.ResponseData, &response)
     if recvErr != nil {
-        return "", recvErr
+        return "", 0, recvErr
     }
-    return response.Value, nil
+    return response.Value, response.Version, nil
+}
+
+// Send multi-key compare-and-swap. Sets every key in writes only if every
+// key in reads is still at the given version, all in a single transaction.
+// Params:
+//   - reads: versions of keys read, as returned by GetWithVersion.
+//   - writes: values to set for keys.
+// Returns: true if the writes were applied, false if a version changed.
+func (c *Client) CompareAndSwap(reads map[string]uint64, writes map[string]string) (bool, error) {
+    txn := raft.Transaction{}
+    for key, version := range reads {
+        txn.Reads = append(txn.Reads, raft.KeyVersion{Key: raft.Key([]byte(key)), Version: version})
+    }
+    for key, value := range writes {
+        txn.Writes = append(txn.Writes, raft.KeyWrite{Key: raft.Key([]byte(key)), Value: []byte(value)})
+    }
+    var resp raft.TransactionResponse
+    if err := c.session.Transaction(&txn, &resp); err != nil {
+        return false, err
+    }
+    return resp.Committed, nil
 }
@@ -24,7 +24,9 @@ func MakeNewCluster(n int, fsms []raft.FSM, addrs []raft.ServerAddress, gcInterv
     return MakeCluster(n, fsms, addrs, gcInterval, gcRemoveTime, nil)
 }
 
-// Given a cluster that has been stopped, restart it. 
+// Given a cluster that has been stopped, restart it. Servers recover their
+// logs, terms, votes and witness state from the files written before they
+// stopped.
 // Params:
 //   - c : cluster to restart.
 func RestartCluster(c *cluster) {
@@ -34,6 +36,13 @@ func RestartCluster(c *cluster) {
             fmt.Println("[ERR] err creating transport: ", err)
         }
         c.trans[i] = trans
+
+        // Reopen the stores, as a restarted process would
+        c.logs[i].Close()
+        c.logs[i], c.stores[i], err = openStores(c.dirs[i])
+        if err != nil {
+            fmt.Println("[ERR] err opening stores: ", err)
+        }
     }
 
     for i := range c.fsms {
@@ -41,17 +50,31 @@ func RestartCluster(c *cluster) {
 		peerConf.LocalID = c.configuration.Servers[i].ID
         peerConf.Logger = log.New(os.Stdout, string(peerConf.LocalID) + " : ", log.Lmicroseconds)
 
-       err := raft.RecoverCluster(peerConf, c.fsms[i], c.stores[i], c.stores[i], c.snaps[i], c.trans[i], c.configuration)
-        if err != nil {
-            fmt.Println("[ERR] err: %v", err)
-        }
-        raft, err := raft.NewRaft(peerConf, c.fsms[i], c.stores[i], c.stores[i], c.snaps[i], c.trans[i])
+        raft, err := raft.NewRaft(peerConf, c.fsms[i], c.logs[i], c.stores[i], c.snaps[i], c.trans[i])
 		if err != nil {
 		    fmt.Println("[ERR] NewRaft failed: %v", err)
 		}
 
 		raft.AddVoter(peerConf.LocalID, c.trans[i].LocalAddr(), 0, 0)
+		c.Rafts[i] = raft
+    }
+}
+
+// Open the durable log and stable stores of a server.
+// Params:
+//   - dir: directory of the server's files.
+// Returns: log store, stable store, and an error if either cannot be opened.
+func openStores(dir string) (*raft.FileLogStore, *raft.FileStableStore, error) {
+    logs, err := raft.NewFileLogStore(dir, nil)
+    if err != nil {
+        return nil, nil, err
+    }
+    stable, err := raft.NewFileStableStore(dir)
+    if err != nil {
+        logs.Close()
+        return nil, nil, err
     }
+    return logs, stable, nil
 }
 
 // Shutdown a set of running Raft servers.
@@ -98,8 +121,12 @@ func MakeCluster(n int, fsms []raft.FSM, addrs []raft.ServerAddress, gcInterval
 			fmt.Println("[ERR] err: %v ", err)
 		}
 
-        store := raft.NewInmemStore()
+        logs, store, err := openStores(dir)
+        if err != nil {
+            fmt.Println("[ERR] err opening stores: ", err)
+        }
         c.dirs = append(c.dirs, dir)
+		c.logs = append(c.logs, logs)
 		c.stores = append(c.stores, store)
         c.fsms = append(c.fsms, fsms[i])
 
@@ -122,7 +149,7 @@ func MakeCluster(n int, fsms []raft.FSM, addrs []raft.ServerAddress, gcInterval
 	// Create all the rafts
 	c.startTime = time.Now()
 	for i := 0; i < n; i++ {
-		logs := c.stores[i]
+		logs := c.logs[i]
 		store := c.stores[i]
 		snap := c.snaps[i]
 		trans := c.trans[i]
@@ -150,9 +177,32 @@ func MakeCluster(n int, fsms []raft.FSM, addrs []raft.ServerAddress, gcInterval
     return c
 }
 
+// Create a network transport for a client or server.
+// Params:
+//   - transport: "tcp" or "unix".
+//   - addr: IP address and port for TCP, socket path for Unix domain sockets.
+// Returns: created transport.
+func NewTransport(transport string, addr string) (*raft.NetworkTransport, error) {
+    switch transport {
+    case "tcp":
+        return raft.NewTCPTransport(addr, nil, 2, time.Second, nil)
+    case "unix":
+        return raft.NewUnixTransport(addr, 2, time.Second, nil)
+    default:
+        return nil, fmt.Errorf("unknown transport: %s", transport)
+    }
+}
+
 // Start single node
 // Used for perf metrics, so don't write logging messages.
 func StartNode(fsm raft.FSM, addrs []raft.ServerAddress, i int) {
+    StartNodeWithTransport(fsm, addrs, i, "tcp")
+}
+
+// Start single node using the given transport, "tcp" or "unix". With Unix
+// domain sockets, addrs are socket paths.
+// Used for perf metrics, so don't write logging messages.
+func StartNodeWithTransport(fsm raft.FSM, addrs []raft.ServerAddress, i int, transport string) {
     conf := raft.DefaultConfig()
     bootstrap := true
 
@@ -162,11 +212,14 @@ func StartNode(fsm raft.FSM, addrs []raft.ServerAddress, i int) {
 		fmt.Println("[ERR] err: %v ", err)
 	}
 
-	store := raft.NewInmemStore()
+    logs, store, err := openStores(dir)
+    if err != nil {
+        fmt.Println("[ERR] err opening stores: ", err)
+    }
 
     snap, err := raft.NewFileSnapshotStore(dir, 3, nil)
 
-    trans, err := raft.NewTCPTransport(string(addrs[i]), nil, 2, time.Second, nil)
+    trans, err := NewTransport(transport, string(addrs[i]))
     if err != nil {
         fmt.Println("[ERR] err creating transport: ", err)
     }
@@ -181,8 +234,6 @@ func StartNode(fsm raft.FSM, addrs []raft.ServerAddress, i int) {
     }
 
 	// Create all the rafts
-	logs := store
-
 	conf.LocalID = configuration.Servers[i].ID
     //conf.Logger = log.SetOutput(ioutil.Discard) 
     conf.Logger = log.New(os.Stdout, string(conf.LocalID) + " : ", log.Lmicroseconds)
@@ -207,7 +258,8 @@ func StartNode(fsm raft.FSM, addrs []raft.ServerAddress, i int) {
 // Representation of cluster.
 type cluster struct {
 	dirs             []string
-	stores           []*raft.InmemStore
+	logs             []*raft.FileLogStore
+	stores           []*raft.FileStableStore
 	fsms             []raft.FSM
 	snaps            []*raft.FileSnapshotStore
 	trans            []raft.Transport
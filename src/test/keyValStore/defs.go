@@ -11,6 +11,8 @@ const ValueArg string = "value"
 // Response to Get RPC. 
 type GetResponse struct {
     Value string
+    // Version of key, incremented on every write. Used in CAS preconditions.
+    Version uint64
 }
 
 // Response to Inc RPC.
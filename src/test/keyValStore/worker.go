@@ -9,10 +9,13 @@ import(
 
 // FSM running on Raft servers to implement key-val store.
 // *WorkerFSM implements raft.FSM by implementing Apply,
-// Snapshot, Restore.
+// Snapshot, Restore, and raft.TransactionFSM by implementing
+// ApplyTransaction.
 type WorkerFSM struct {
     // Map representing key-value store.
     KeyValMap       map[string]string
+    // Version of each key, incremented on every write.
+    VersionMap      map[string]uint64
     counter         uint64
 }
 
@@ -27,6 +30,7 @@ func CreateWorkers(n int) ([]raft.FSM) {
     for i := range workers {
         workers[i] = &WorkerFSM{
             KeyValMap:  make(map[string]string),
+            VersionMap: make(map[string]uint64),
             counter:    0,
         }
     }
@@ -51,9 +55,10 @@ func (w *WorkerFSM) Apply(log *raft.Log)(interface{}) {
     function := args[FunctionArg]
     switch function {
         case GetCommand:
-            return GetResponse{Value: w.KeyValMap[args[KeyArg]]}
+            key := args[KeyArg]
+            return GetResponse{Value: w.KeyValMap[key], Version: w.VersionMap[key]}
         case SetCommand:
-            w.KeyValMap[args[KeyArg]] = args[ValueArg]
+            w.set(args[KeyArg], args[ValueArg])
             return nil
         case IncCommand:
             w.counter += 1
@@ -62,6 +67,38 @@ func (w *WorkerFSM) Apply(log *raft.Log)(interface{}) {
     return nil
 }
 
+// Apply multi-key CAS to FSM. Sets all keys only if every key read is
+// still at the version the client read.
+// Params:
+//   - log: log entry containing transaction.
+//   - txn: decoded transaction.
+// Returns: whether the writes were applied, and conflicting keys if not.
+func (w *WorkerFSM) ApplyTransaction(log *raft.Log, txn *raft.Transaction) *raft.TransactionResponse {
+    resp := &raft.TransactionResponse{Committed: true}
+    for _, read := range txn.Reads {
+        if w.VersionMap[string(read.Key)] != read.Version {
+            resp.Committed = false
+            resp.Conflicts = append(resp.Conflicts, read.Key)
+        }
+    }
+    if !resp.Committed {
+        return resp
+    }
+    for _, write := range txn.Writes {
+        w.set(string(write.Key), string(write.Value))
+    }
+    return resp
+}
+
+// Set the value of a key and bump its version.
+// Params:
+//   - key: key to set.
+//   - value: new value of key.
+func (w *WorkerFSM) set(key string, value string) {
+    w.KeyValMap[key] = value
+    w.VersionMap[key] += 1
+}
+
 // Don't need full implementation for testing.
 func (w *WorkerFSM) Snapshot() (raft.FSMSnapshot, error) {
     return WorkerSnapshot{}, nil